@@ -6,7 +6,7 @@ import (
 	"encoding/hex"
 	"time"
 
-	"github.com/globalsign/mgo/bson"
+	"github.com/kinfkong/modern-mgo/bson"
 	. "gopkg.in/check.v1"
 )
 
@@ -1,7 +1,7 @@
 package bson_test
 
 import (
-	"github.com/globalsign/mgo/bson"
+	"github.com/kinfkong/modern-mgo/bson"
 	. "gopkg.in/check.v1"
 )
 
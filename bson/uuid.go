@@ -0,0 +1,136 @@
+package bson
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// UUID is a universally unique identifier, stored as BSON binary data of
+// subtype 4 (BinaryUUID). It can be used as a struct field, map value or
+// document _id in place of an ObjectId:
+//
+//	type Account struct {
+//		Id   bson.UUID `bson:"_id"`
+//		Name string    `bson:"name"`
+//	}
+//
+// The zero UUID is stored as sixteen zero bytes; fields that may be unset
+// can use a *UUID, stored as null when nil.
+type UUID [16]byte
+
+// NewUUID returns a new random (version 4) UUID. It panics if the system's
+// random number generator fails.
+func NewUUID() UUID {
+	var u UUID
+	if _, err := rand.Read(u[:]); err != nil {
+		panic(fmt.Errorf("cannot generate UUID: %v", err))
+	}
+	u[6] = u[6]&0x0f | 0x40 // Version 4
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+	return u
+}
+
+// ParseUUID returns the UUID represented by s, in the canonical form
+// "xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx" or as 32 hex digits without
+// hyphens.
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	var digits string
+	switch len(s) {
+	case 32:
+		digits = s
+	case 36:
+		if s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+			return u, fmt.Errorf("invalid UUID: %q", s)
+		}
+		digits = s[:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	default:
+		return u, fmt.Errorf("invalid UUID: %q", s)
+	}
+	if _, err := hex.Decode(u[:], []byte(digits)); err != nil {
+		return u, fmt.Errorf("invalid UUID: %q", s)
+	}
+	return u, nil
+}
+
+// UUIDFromBinary returns the UUID held by b, which must be binary data of
+// subtype 4 and 16 bytes long.
+func UUIDFromBinary(b Binary) (UUID, error) {
+	var u UUID
+	if b.Kind != BinaryUUID || len(b.Data) != len(u) {
+		return u, fmt.Errorf("binary subtype 0x%02x of %d bytes is not a UUID", b.Kind, len(b.Data))
+	}
+	copy(u[:], b.Data)
+	return u, nil
+}
+
+// String returns the canonical representation of the UUID.
+// Example: "f47ac10b-58cc-4372-a567-0e02b2c3d479".
+func (u UUID) String() string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+// IsZero reports whether u is the zero UUID.
+func (u UUID) IsZero() bool {
+	return u == UUID{}
+}
+
+// Binary returns the UUID as binary data of subtype 4.
+func (u UUID) Binary() Binary {
+	return Binary{Kind: BinaryUUID, Data: append([]byte(nil), u[:]...)}
+}
+
+// GetBSON implements Getter, storing the UUID as binary data of subtype 4.
+func (u UUID) GetBSON() (interface{}, error) {
+	return u.Binary(), nil
+}
+
+// SetBSON implements Setter, loading binary data of subtype 4. A null value
+// loads the zero UUID.
+func (u *UUID) SetBSON(raw Raw) error {
+	switch raw.Kind {
+	case ElementNil:
+		return ErrSetZero
+	case ElementBinary:
+		// int32 length, subtype, data
+		if len(raw.Data) < 5 || int(binary.LittleEndian.Uint32(raw.Data)) != len(raw.Data)-5 {
+			return fmt.Errorf("invalid binary data for UUID")
+		}
+		id, err := UUIDFromBinary(Binary{Kind: raw.Data[4], Data: raw.Data[5:]})
+		if err != nil {
+			return err
+		}
+		*u = id
+		return nil
+	}
+	return fmt.Errorf("cannot load BSON kind 0x%02x into a UUID", raw.Kind)
+}
+
+// MarshalText implements encoding.TextMarshaler, so that UUIDs appear in
+// their canonical form in JSON documents and as map keys.
+func (u UUID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, accepting the forms
+// accepted by ParseUUID.
+func (u *UUID) UnmarshalText(data []byte) error {
+	id, err := ParseUUID(string(data))
+	if err != nil {
+		return err
+	}
+	*u = id
+	return nil
+}
@@ -0,0 +1,75 @@
+package bson_test
+
+import (
+	"encoding/json"
+
+	"github.com/kinfkong/modern-mgo/bson"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestUUIDParseAndFormat(c *C) {
+	const text = "f47ac10b-58cc-4372-a567-0e02b2c3d479"
+	u, err := bson.ParseUUID(text)
+	c.Assert(err, IsNil)
+	c.Assert(u.String(), Equals, text)
+
+	bare, err := bson.ParseUUID("f47ac10b58cc4372a5670e02b2c3d479")
+	c.Assert(err, IsNil)
+	c.Assert(bare, Equals, u)
+
+	for _, invalid := range []string{"", "f47ac10b", "f47ac10b-58cc-4372-a567_0e02b2c3d479", "g47ac10b-58cc-4372-a567-0e02b2c3d479"} {
+		_, err := bson.ParseUUID(invalid)
+		c.Assert(err, NotNil, Commentf("input %q", invalid))
+	}
+}
+
+func (s *S) TestNewUUID(c *C) {
+	u := bson.NewUUID()
+	c.Assert(u.IsZero(), Equals, false)
+	c.Assert(u[6]>>4, Equals, byte(4))
+	c.Assert(u[8]>>6, Equals, byte(2))
+	c.Assert(bson.NewUUID(), Not(Equals), u)
+}
+
+type uuidDoc struct {
+	Id     bson.UUID  `bson:"_id"`
+	Parent *bson.UUID `bson:"parent"`
+}
+
+func (s *S) TestUUIDMarshal(c *C) {
+	u := bson.NewUUID()
+	data, err := bson.Marshal(uuidDoc{Id: u})
+	c.Assert(err, IsNil)
+
+	var raw bson.M
+	c.Assert(bson.Unmarshal(data, &raw), IsNil)
+	c.Assert(raw["_id"], DeepEquals, bson.Binary{Kind: bson.BinaryUUID, Data: u[:]})
+	c.Assert(raw["parent"], IsNil)
+
+	var doc uuidDoc
+	c.Assert(bson.Unmarshal(data, &doc), IsNil)
+	c.Assert(doc.Id, Equals, u)
+	c.Assert(doc.Parent, IsNil)
+
+	back, err := bson.UUIDFromBinary(raw["_id"].(bson.Binary))
+	c.Assert(err, IsNil)
+	c.Assert(back, Equals, u)
+
+	// Binary data of other subtypes is not a UUID
+	data, err = bson.Marshal(bson.M{"_id": bson.Binary{Kind: bson.BinaryMD5, Data: u[:]}})
+	c.Assert(err, IsNil)
+	c.Assert(bson.Unmarshal(data, &doc), ErrorMatches, ".*not a UUID")
+}
+
+func (s *S) TestUUIDJSON(c *C) {
+	u, err := bson.ParseUUID("f47ac10b-58cc-4372-a567-0e02b2c3d479")
+	c.Assert(err, IsNil)
+	data, err := json.Marshal(map[string]bson.UUID{"id": u})
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `{"id":"f47ac10b-58cc-4372-a567-0e02b2c3d479"}`)
+
+	var back map[string]bson.UUID
+	c.Assert(json.Unmarshal(data, &back), IsNil)
+	c.Assert(back["id"], Equals, u)
+}
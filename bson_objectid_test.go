@@ -3,7 +3,7 @@ package mgo_test
 import (
 	"testing"
 
-	"github.com/globalsign/mgo/bson"
+	"github.com/kinfkong/modern-mgo/bson"
 )
 
 // TestBsonObjectIdHexConversion tests ObjectIdHex conversion functionality
@@ -2,11 +2,14 @@ package mgo
 
 import (
 	"context"
-	"net/url"
-	"strings"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
 	"time"
 
-	mongodrv "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -24,49 +27,340 @@ import (
 type Session = ModernMGO
 
 // Dial is a thin wrapper around DialModernMGO that preserves the original mgo
-// function signature. It disables retryable writes in the same way that
-// DialModernMGO does and returns a *mgo.Session (which is an alias for
-// *mgo.ModernMGO).
+// function signature. It disables retryable writes unless the URI sets
+// retryWrites, in the same way that DialModernMGO does, and returns a
+// *mgo.Session (which is an alias for *mgo.ModernMGO).
 func Dial(mongoURL string) (*Session, error) {
 	return DialModernMGO(mongoURL)
 }
 
 // DialWithTimeout replicates the original mgo.DialWithTimeout behaviour using
-// the modern MongoDB driver underneath. It establishes a connection to the
-// given MongoDB URI but enforces the provided timeout for the initial
-// connection handshake.
+// the modern MongoDB driver underneath. It connects to the given MongoDB URI
+// within the provided timeout, which also bounds the connection handshake
+// with each server.
 func DialWithTimeout(mongoURL string, timeout time.Duration) (*Session, error) {
 	// Honour zero or negative timeouts by falling back to the default of 10s
 	if timeout <= 0 {
 		timeout = 10 * time.Second
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+	clientOptions := options.Client().
+		ApplyURI(mongoURL).
+		SetConnectTimeout(timeout)
 
-	clientOptions := options.Client().ApplyURI(mongoURL).SetRetryWrites(false)
+	return newModernMGO(mongoURL, clientOptions, &Safe{W: 1})
+}
+
+// Authentication mechanisms accepted in DialInfo.Mechanism and
+// Credential.Mechanism besides the default SCRAM negotiation
+const (
+	MechanismX509 = "MONGODB-X509"
+	MechanismAWS  = "MONGODB-AWS"
+
+	// MechanismGSSAPI authenticates with Kerberos. It requires building with
+	// the gssapi tag, which links the driver against the system GSSAPI
+	// libraries.
+	MechanismGSSAPI = "GSSAPI"
+
+	// MechanismPLAIN sends the username and password in clear text, for
+	// LDAP-proxied authentication. Use it together with TLS.
+	MechanismPLAIN = "PLAIN"
+)
+
+// externalMechanisms holds the mechanisms authenticating against "$external"
+var externalMechanisms = map[string]bool{
+	MechanismX509:   true,
+	MechanismAWS:    true,
+	MechanismGSSAPI: true,
+	MechanismPLAIN:  true,
+}
+
+// DialInfo holds the options for establishing a session with DialWithInfo,
+// mirroring the fields of mgo.DialInfo supported by the wrapper.
+type DialInfo struct {
+	// Addrs holds the addresses of the seed servers.
+	Addrs []string
+
+	// Direct informs whether to establish a connection only with the
+	// specified seed servers, or to obtain information for the whole
+	// cluster and establish connections with further servers too.
+	Direct bool
+
+	// Timeout is the amount of time to wait for a server to respond when
+	// first connecting. Zero uses the default of 10s.
+	Timeout time.Duration
+
+	// Database is the default database name used when the Session.DB method
+	// is called with an empty name, and is also used during the initial
+	// authentication if Source is unset.
+	Database string
+
+	// ReplicaSetName, if specified, will prevent the obtained session from
+	// communicating with any server which is not part of a replica set
+	// with the given name.
+	ReplicaSetName string
+
+	// Source is the database used to establish credentials and privileges
+	// with a MongoDB server. Defaults to "$external" for mechanisms whose
+	// credentials live outside of the deployment, such as MONGODB-X509, and
+	// otherwise to the value of Database, if that is set, or "admin".
+	Source string
+
+	// Mechanism defines the protocol for credential negotiation.
+	// Defaults to the server's negotiated SCRAM mechanism. MONGODB-X509
+	// authenticates with the client certificate of TLSConfig, and Username
+	// may then be left empty to use the certificate subject.
+	Mechanism string
+
+	// Service defines the service name to use when authenticating with the
+	// GSSAPI mechanism. Defaults to "mongodb".
+	Service string
+
+	// ServiceHost defines which hostname to use when authenticating with the
+	// GSSAPI mechanism. If not specified, defaults to the MongoDB server's
+	// address.
+	ServiceHost string
+
+	// CanonicalizeHostName makes the GSSAPI mechanism resolve the server's
+	// hostname to its canonical name before building the service principal.
+	CanonicalizeHostName bool
+
+	// Username and Password inform the credentials for the initial
+	// authentication done on the database defined by the Source field.
+	// With MONGODB-AWS they hold the access key ID and secret access key,
+	// and may be left empty to take the credentials from the environment.
+	Username string
+	Password string
+
+	// AppName identifies the application to the servers, which record it in
+	// their logs and profiling data.
+	AppName string
+
+	// PoolLimit defines the per-server socket pool limit. Zero keeps the
+	// driver default.
+	PoolLimit int
+
+	// TLSConfig enables TLS for connections to the servers when set. See
+	// LoadTLSConfig for building one from PEM files.
+	TLSConfig *tls.Config
 
-	client, err := mongodrv.Connect(ctx, clientOptions)
+	// DialServer optionally specifies the dial function for establishing
+	// connections with the MongoDB servers, for example to route them
+	// through an SSH tunnel or a SOCKS proxy.
+	DialServer func(addr *ServerAddr) (net.Conn, error)
+
+	// Dial is the legacy form of DialServer, ignored if DialServer is set.
+	Dial func(addr net.Addr) (net.Conn, error)
+}
+
+// ServerAddr represents the address for establishing a connection to an
+// individual MongoDB server.
+type ServerAddr struct {
+	str string
+	tcp *net.TCPAddr
+}
+
+// String returns the address that was provided for the server before resolution.
+func (addr *ServerAddr) String() string {
+	return addr.str
+}
+
+// TCPAddr returns the resolved TCP address for the server.
+func (addr *ServerAddr) TCPAddr() *net.TCPAddr {
+	return addr.tcp
+}
+
+// DialWithInfo establishes a session with the cluster described by info,
+// the same way DialModernMGO does for a MongoDB URI.
+func DialWithInfo(info *DialInfo) (*Session, error) {
+	if info.Mechanism == MechanismX509 && !hasClientCertificate(info.TLSConfig) {
+		return nil, errors.New("MONGODB-X509 authentication requires a TLS client certificate")
+	}
+	session, err := newModernMGO("", info.clientOptions(), &Safe{W: 1})
 	if err != nil {
 		return nil, err
 	}
+	if info.Database != "" {
+		session.dbName = info.Database
+	}
+	return session, nil
+}
+
+// clientOptions maps the dial information onto driver client options
+func (info *DialInfo) clientOptions() *options.ClientOptions {
+	timeout := info.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
 
-	// Extract default database name from URI path (mirrors legacy behaviour).
-	dbName := "test"
-	if parsedURL, err := url.Parse(mongoURL); err == nil && parsedURL.Path != "" {
-		dbName = strings.TrimPrefix(parsedURL.Path, "/")
-		if dbName == "" {
-			dbName = "test"
+	clientOptions := options.Client().
+		SetHosts(info.Addrs).
+		SetConnectTimeout(timeout)
+	if info.Direct {
+		clientOptions.SetDirect(true)
+	}
+	if info.ReplicaSetName != "" {
+		clientOptions.SetReplicaSet(info.ReplicaSetName)
+	}
+	if info.AppName != "" {
+		clientOptions.SetAppName(info.AppName)
+	}
+	if info.PoolLimit > 0 {
+		clientOptions.SetMaxPoolSize(uint64(info.PoolLimit))
+	}
+	if info.Username != "" || info.Mechanism != "" {
+		cred := &Credential{
+			Username:             info.Username,
+			Password:             info.Password,
+			Source:               info.Source,
+			Mechanism:            info.Mechanism,
+			Service:              info.Service,
+			ServiceHost:          info.ServiceHost,
+			CanonicalizeHostName: info.CanonicalizeHostName,
 		}
+		clientOptions.SetAuth(cred.clientCredential(info.defaultSource()))
+	}
+	if info.TLSConfig != nil {
+		clientOptions.SetTLSConfig(info.TLSConfig)
 	}
+	if info.DialServer != nil || info.Dial != nil {
+		clientOptions.SetDialer(infoDialer{info})
+	}
+	return clientOptions
+}
+
+// hasClientCertificate reports whether config presents a client certificate
+func hasClientCertificate(config *tls.Config) bool {
+	return config != nil && (len(config.Certificates) > 0 || config.GetClientCertificate != nil)
+}
+
+// LoadTLSConfig builds a TLS configuration from PEM files. caFile holds the
+// certificate authorities trusted to sign server certificates, used instead
+// of the system certificate pool; certFile and keyFile hold the client
+// certificate and its private key, and may name the same file when both are
+// stored together. Empty file names leave the corresponding setting unset.
+func LoadTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("client certificate and key files must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// defaultSource returns the database credentials are established with when
+// Source is unset
+func (info *DialInfo) defaultSource() string {
+	if info.Database != "" {
+		return info.Database
+	}
+	return "admin"
+}
+
+// Credential holds details to authenticate with a MongoDB server, for use
+// with Session.Login.
+type Credential struct {
+	// Username and Password hold the user's credentials. With MONGODB-AWS
+	// they hold the access key ID and secret access key, and may be left
+	// empty to take the credentials from the environment.
+	Username string
+	Password string
+
+	// Source is the database used to establish credentials and privileges
+	// with a MongoDB server. Defaults to "$external" for mechanisms whose
+	// credentials live outside of the deployment and to "admin" otherwise.
+	Source string
+
+	// Mechanism defines the protocol for credential negotiation.
+	// Defaults to the server's negotiated SCRAM mechanism.
+	Mechanism string
 
-	return &ModernMGO{
-		client:     client,
-		dbName:     dbName,
-		mode:       Primary,
-		safe:       &Safe{W: 1},
-		isOriginal: true,
-	}, nil
+	// Service, ServiceHost and CanonicalizeHostName configure the service
+	// principal used with GSSAPI, as documented on DialInfo.
+	Service              string
+	ServiceHost          string
+	CanonicalizeHostName bool
+
+	// SessionToken is the AWS session token of temporary credentials used
+	// with MONGODB-AWS.
+	SessionToken string
+}
+
+// clientCredential maps the credential onto the driver's credential, using
+// defaultSource when neither Source nor the mechanism selects a database
+func (cred *Credential) clientCredential(defaultSource string) options.Credential {
+	source := cred.Source
+	if source == "" {
+		source = defaultSource
+		if externalMechanisms[cred.Mechanism] {
+			source = "$external"
+		}
+	}
+
+	clientCred := options.Credential{
+		AuthMechanism: cred.Mechanism,
+		AuthSource:    source,
+		Username:      cred.Username,
+		Password:      cred.Password,
+		PasswordSet:   cred.Password != "",
+	}
+	props := make(map[string]string)
+	if cred.SessionToken != "" {
+		props["AWS_SESSION_TOKEN"] = cred.SessionToken
+	}
+	if cred.Service != "" {
+		props["SERVICE_NAME"] = cred.Service
+	}
+	if cred.ServiceHost != "" {
+		props["SERVICE_HOST"] = cred.ServiceHost
+	}
+	if cred.CanonicalizeHostName {
+		props["CANONICALIZE_HOST_NAME"] = "true"
+	}
+	if len(props) > 0 {
+		clientCred.AuthMechanismProperties = props
+	}
+	return clientCred
+}
+
+// infoDialer adapts the DialServer and Dial hooks of a DialInfo to the
+// driver's ContextDialer
+type infoDialer struct {
+	info *DialInfo
+}
+
+// DialContext resolves the server address and hands it to the dial hook.
+// DialServer is still called when the address cannot be resolved locally, as
+// happens with hosts only reachable through a tunnel; TCPAddr is nil then.
+func (d infoDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", address)
+	if d.info.DialServer != nil {
+		return d.info.DialServer(&ServerAddr{str: address, tcp: tcpAddr})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.info.Dial(tcpAddr)
 }
 
 type Collection = ModernColl
@@ -0,0 +1,254 @@
+package mgo
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestDialInfo checks the mapping from DialInfo to client options and the dial hooks
+func TestDialInfo(t *testing.T) {
+	var dialed *ServerAddr
+	info := &DialInfo{
+		Addrs:          []string{"localhost:27017"},
+		Database:       "dialinfo_test",
+		ReplicaSetName: "rs0",
+		Username:       "user",
+		Password:       "pass",
+		PoolLimit:      5,
+		DialServer: func(addr *ServerAddr) (net.Conn, error) {
+			dialed = addr
+			client, server := net.Pipe()
+			server.Close()
+			return client, nil
+		},
+	}
+
+	opts := info.clientOptions()
+	if len(opts.Hosts) != 1 || opts.Hosts[0] != "localhost:27017" {
+		t.Errorf("Expected seed hosts to be set, got %v", opts.Hosts)
+	}
+	if opts.ReplicaSet == nil || *opts.ReplicaSet != "rs0" {
+		t.Errorf("Expected replica set rs0, got %v", opts.ReplicaSet)
+	}
+	if opts.MaxPoolSize == nil || *opts.MaxPoolSize != 5 {
+		t.Errorf("Expected max pool size 5, got %v", opts.MaxPoolSize)
+	}
+	if opts.Auth == nil || opts.Auth.Username != "user" || opts.Auth.AuthSource != "dialinfo_test" {
+		t.Errorf("Expected credentials against dialinfo_test, got %+v", opts.Auth)
+	}
+
+	// The driver dials through the DialServer hook
+	conn, err := opts.Dialer.DialContext(context.Background(), "tcp", "localhost:27017")
+	if err != nil {
+		t.Fatalf("Failed to dial through DialServer: %v", err)
+	}
+	conn.Close()
+	if dialed == nil || dialed.String() != "localhost:27017" || dialed.TCPAddr() == nil {
+		t.Errorf("Expected DialServer to receive the resolved server address, got %+v", dialed)
+	}
+
+	session, err := DialWithInfo(info)
+	if err != nil {
+		t.Fatalf("Failed to dial with info: %v", err)
+	}
+	defer session.Close()
+	if session.dbName != "dialinfo_test" {
+		t.Errorf("Expected default database dialinfo_test, got %s", session.dbName)
+	}
+}
+
+// TestLoadTLSConfig checks TLS configurations built from PEM files
+func TestLoadTLSConfig(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mgo-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	// Certificate and key stored together, as MongoDB tools expect
+	dir := t.TempDir()
+	pemFile := filepath.Join(dir, "client.pem")
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})...)
+	if err := os.WriteFile(pemFile, data, 0600); err != nil {
+		t.Fatalf("Failed to write PEM file: %v", err)
+	}
+
+	config, err := LoadTLSConfig(pemFile, pemFile, pemFile)
+	if err != nil {
+		t.Fatalf("Failed to load TLS config: %v", err)
+	}
+	if config.RootCAs == nil || len(config.Certificates) != 1 {
+		t.Errorf("Expected CA pool and client certificate, got %+v", config)
+	}
+
+	if _, err := LoadTLSConfig("", pemFile, ""); err == nil {
+		t.Error("Expected error for certificate without key")
+	}
+	if _, err := LoadTLSConfig(filepath.Join(dir, "missing.pem"), "", ""); err == nil {
+		t.Error("Expected error for missing CA file")
+	}
+
+	// The configuration reaches the driver through DialInfo and the session
+	info := &DialInfo{Addrs: []string{"localhost:27017"}, TLSConfig: config}
+	if info.clientOptions().TLSConfig != config {
+		t.Error("Expected DialInfo TLS config to be applied")
+	}
+	m, err := DialModernMGO("mongodb://localhost:27017/tls_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+	insecure := &tls.Config{InsecureSkipVerify: true}
+	if err := m.SetTLSConfig(insecure); err != nil {
+		t.Fatalf("Failed to set TLS config: %v", err)
+	}
+	if m.clientOptions.TLSConfig != insecure {
+		t.Error("Expected session TLS config to be applied")
+	}
+}
+
+// TestDialInfoX509 checks MONGODB-X509 credentials built from DialInfo
+func TestDialInfoX509(t *testing.T) {
+	info := &DialInfo{
+		Addrs:     []string{"localhost:27017"},
+		Database:  "x509_test",
+		Mechanism: MechanismX509,
+	}
+	if _, err := DialWithInfo(info); err == nil {
+		t.Error("Expected error for MONGODB-X509 without a client certificate")
+	}
+
+	info.TLSConfig = &tls.Config{Certificates: []tls.Certificate{{}}}
+	opts := info.clientOptions()
+	if opts.Auth == nil || opts.Auth.AuthMechanism != MechanismX509 {
+		t.Fatalf("Expected MONGODB-X509 credentials, got %+v", opts.Auth)
+	}
+	if opts.Auth.AuthSource != "$external" || opts.Auth.PasswordSet {
+		t.Errorf("Expected passwordless credentials against $external, got %+v", opts.Auth)
+	}
+
+	session, err := DialWithInfo(info)
+	if err != nil {
+		t.Fatalf("Failed to dial with X.509 credentials: %v", err)
+	}
+	session.Close()
+}
+
+// TestCredentialAWS checks MONGODB-AWS credentials from DialInfo and Login
+func TestCredentialAWS(t *testing.T) {
+	// Environment-based credentials only name the mechanism
+	info := &DialInfo{Addrs: []string{"localhost:27017"}, Mechanism: MechanismAWS}
+	opts := info.clientOptions()
+	if opts.Auth == nil || opts.Auth.AuthSource != "$external" || opts.Auth.Username != "" {
+		t.Errorf("Expected environment AWS credentials against $external, got %+v", opts.Auth)
+	}
+
+	m, err := DialModernMGO("mongodb://localhost:27017/aws_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+
+	err = m.Login(&Credential{
+		Username:     "AKIDEXAMPLE",
+		Password:     "secret",
+		Mechanism:    MechanismAWS,
+		SessionToken: "token",
+	})
+	if err != nil {
+		t.Fatalf("Failed to login: %v", err)
+	}
+	auth := m.clientOptions.Auth
+	if auth == nil || auth.Username != "AKIDEXAMPLE" || auth.AuthSource != "$external" {
+		t.Fatalf("Expected explicit AWS credentials, got %+v", auth)
+	}
+	if auth.AuthMechanismProperties["AWS_SESSION_TOKEN"] != "token" {
+		t.Errorf("Expected session token property, got %v", auth.AuthMechanismProperties)
+	}
+
+	// Other mechanisms default to the admin database
+	if err := m.Login(&Credential{Username: "user", Password: "pass"}); err != nil {
+		t.Fatalf("Failed to login: %v", err)
+	}
+	if m.clientOptions.Auth.AuthSource != "admin" {
+		t.Errorf("Expected admin source, got %s", m.clientOptions.Auth.AuthSource)
+	}
+}
+
+// TestCredentialGSSAPI checks the Kerberos service settings reach the driver
+func TestCredentialGSSAPI(t *testing.T) {
+	info := &DialInfo{
+		Addrs:                []string{"localhost:27017"},
+		Username:             "user@EXAMPLE.COM",
+		Mechanism:            MechanismGSSAPI,
+		Service:              "mongosvc",
+		ServiceHost:          "db.example.com",
+		CanonicalizeHostName: true,
+	}
+	auth := info.clientOptions().Auth
+	if auth == nil || auth.AuthSource != "$external" || auth.AuthMechanism != MechanismGSSAPI {
+		t.Fatalf("Expected GSSAPI credentials against $external, got %+v", auth)
+	}
+	props := auth.AuthMechanismProperties
+	if props["SERVICE_NAME"] != "mongosvc" || props["SERVICE_HOST"] != "db.example.com" || props["CANONICALIZE_HOST_NAME"] != "true" {
+		t.Errorf("Expected GSSAPI service properties, got %v", props)
+	}
+}
+
+// TestCredentialPLAIN checks LDAP credentials authenticate against $external
+func TestCredentialPLAIN(t *testing.T) {
+	info := &DialInfo{
+		Addrs:     []string{"localhost:27017"},
+		Database:  "plain_test",
+		Username:  "ldapuser",
+		Password:  "ldappass",
+		Mechanism: MechanismPLAIN,
+	}
+	auth := info.clientOptions().Auth
+	if auth == nil || auth.AuthSource != "$external" || auth.AuthMechanism != MechanismPLAIN {
+		t.Fatalf("Expected PLAIN credentials against $external, got %+v", auth)
+	}
+	if auth.Username != "ldapuser" || auth.Password != "ldappass" {
+		t.Errorf("Expected LDAP username and password, got %+v", auth)
+	}
+
+	session, err := DialWithInfo(info)
+	if err != nil {
+		t.Fatalf("Failed to dial with PLAIN credentials: %v", err)
+	}
+	session.Close()
+
+	// An explicit source still takes precedence
+	info.Source = "ldapdb"
+	if auth := info.clientOptions().Auth; auth.AuthSource != "ldapdb" {
+		t.Errorf("Expected explicit source ldapdb, got %s", auth.AuthSource)
+	}
+}
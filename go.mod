@@ -1,4 +1,4 @@
-module github.com/globalsign/mgo
+module github.com/kinfkong/modern-mgo
 
 go 1.23.0
 
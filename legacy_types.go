@@ -2,14 +2,18 @@ package mgo
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"net"
 	"strconv"
 	"strings"
 	"time"
 
+	officialBson "go.mongodb.org/mongo-driver/bson"
 	mongodrv "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 
-	"github.com/globalsign/mgo/bson"
+	"github.com/kinfkong/modern-mgo/bson"
 )
 
 // Mode specifies the replica-set read preference mode (compatibility with mgo).
@@ -35,8 +39,10 @@ const (
 	// Nearest – read from the node with lowest network latency regardless of role.
 	Nearest Mode = 6
 
-	// Eventual, Monotonic and Strong are mgo-specific legacy aliases kept for
-	// API compatibility. They map internally onto the standard modes above.
+	// Eventual, Monotonic and Strong are mgo-specific legacy modes kept for
+	// API compatibility. Eventual reads from secondaries when available,
+	// Monotonic does the same until the session writes and then reads from
+	// the primary, and Strong is the same as Primary.
 	Eventual  Mode = 0
 	Monotonic Mode = 1
 	Strong    Mode = 2
@@ -55,7 +61,9 @@ type Safe struct {
 }
 
 // ErrNotFound is returned when a requested document is not present. Many
-// higher-level helper methods rely on comparing against this sentinel value.
+// higher-level helper methods rely on comparing against this sentinel value,
+// which is returned as is; use errors.Is to also match it once wrapped with
+// additional context.
 var ErrNotFound = errors.New("not found")
 
 // -------------------------- Index & Collation --------------------------
@@ -63,12 +71,13 @@ var ErrNotFound = errors.New("not found")
 // Index mirrors the original mgo Index definition but only exposes the fields
 // required by the modern compatibility layer.
 type Index struct {
-	Key           []string // Index key specification ("field" or "-field" for desc)
+	Key           []string // Index key specification ("field", "-field" for desc, "$<kind>:field")
 	Unique        bool     // Enforce uniqueness
 	DropDups      bool     // Drop duplicates when creating a unique index (legacy)
 	Background    bool     // Build index in the background
 	Sparse        bool     // Only index documents containing the key
 	PartialFilter bson.M   // Partial index filter expression
+	Hidden        bool     // Hide the index from the query planner (MongoDB 4.4+)
 
 	// TTL index: documents older than ExpireAfter will be automatically removed.
 	ExpireAfter time.Duration
@@ -76,12 +85,16 @@ type Index struct {
 	// Name explicitly sets the index name; if empty the server auto-generates it.
 	Name string
 
-	// Geo / text specific options (kept for completeness – unused by wrapper).
+	// Geospatial index options, for indexes keyed by "$2d:field" entries.
+	// Minf and Maxf take precedence over Min and Max when set. BucketSize is
+	// kept for completeness but unused, as geoHaystack indexes were removed in
+	// MongoDB 5.0.
 	Min, Max   int
 	Minf, Maxf float64
 	BucketSize float64
 	Bits       int
 
+	// Text index options, for indexes keyed by "$text:field" entries.
 	DefaultLanguage  string
 	LanguageOverride string
 
@@ -106,6 +119,21 @@ type Collation struct {
 	Backwards       bool   `bson:"backwards,omitempty"`
 }
 
+// ----------------------------- DBRef -----------------------------
+
+// DBRef is a reference to a document living in a collection that may be in
+// a different database. The field layout and tags mirror mgo.DBRef so that
+// references written by the legacy driver decode without changes.
+//
+// Relevant MongoDB documentation:
+//
+//	https://docs.mongodb.com/manual/reference/database-references/#dbrefs
+type DBRef struct {
+	Collection string      `bson:"$ref"`
+	Id         interface{} `bson:"$id"`
+	Database   string      `bson:"$db,omitempty"`
+}
+
 // --------------------------- ChangeInfo ---------------------------
 
 // ChangeInfo captures the outcome of update/delete operations returning exact
@@ -119,16 +147,30 @@ type ChangeInfo struct {
 
 // ----------------------- Bulk operation results -----------------------
 
+// BulkResult holds the outcome of a bulk run. Matched and Modified only count
+// documents selected by update operations.
 type BulkResult struct {
 	Matched  int // Number of documents matched by the operation
 	Modified int // Number of documents actually modified (MongoDB 2.6+ only)
 
+	InsertedCount int          // Number of documents inserted by Insert operations
+	RemovedCount  int          // Number of documents removed by Remove and RemoveAll operations
+	UpsertedCount int          // Number of documents inserted by Upsert operations
+	Upserted      []BulkUpsert // Documents inserted by Upsert operations, in queue order
+
 	// Additional fields present in the original implementation are omitted
 	// as the modern wrapper does not rely on them. The struct layout is kept
 	// compatible so client code can embed it without changes.
 	private bool
 }
 
+// BulkUpsert identifies a document inserted by an Upsert operation of a bulk
+// run.
+type BulkUpsert struct {
+	Index int         // Position of the upsert among the queued operations
+	Id    interface{} // _id of the inserted document
+}
+
 // BulkErrorCase stores the error and the index (position) within a bulk
 // operation that generated it.
 type BulkErrorCase struct {
@@ -209,6 +251,10 @@ type Change struct {
 	Upsert    bool        // Insert the document if it doesn't exist
 	Remove    bool        // Remove the matched document instead of updating
 	ReturnNew bool        // Return the modified rather than the original doc
+
+	// ArrayFilters select the array elements modified through $[identifier]
+	// in Update, one filter document per identifier (MongoDB 3.6+).
+	ArrayFilters []interface{}
 }
 
 // -------------------------- QueryError --------------------------
@@ -218,6 +264,8 @@ type QueryError struct {
 	Code      int
 	Message   string
 	Assertion bool
+
+	err error // Driver error the QueryError was translated from
 }
 
 func (err *QueryError) Error() string {
@@ -230,29 +278,92 @@ func (err *QueryError) Error() string {
 	return err.Message
 }
 
+// Unwrap returns the official driver error the QueryError was translated
+// from, if any, so errors.As can still reach the driver's error types.
+func (err *QueryError) Unwrap() error {
+	return err.err
+}
+
+// -------------------------- LastError --------------------------
+
+// LastError mirrors mgo.LastError, reporting the failure of a write. For
+// updates and removals failing after documents were affected, such as when
+// the write concern cannot be satisfied, it also reports their outcome. The
+// bson tags match the getLastError reply, so it may be used with Run too.
+type LastError struct {
+	Err             string
+	Code            int
+	N               int         // Number of documents matched by an update or removed
+	WTimeout        bool        // Whether the write concern timed out waiting for acknowledgement
+	UpdatedExisting bool        `bson:"updatedExisting"` // Whether an update modified an existing document
+	UpsertedId      interface{} `bson:"upserted"`        // _id of the document inserted by an upsert
+
+	err error // Driver error the LastError was translated from
+}
+
+func (err *LastError) Error() string {
+	return err.Err
+}
+
+// Unwrap returns the official driver error the LastError was translated
+// from, if any, so errors.As can still reach the driver's error types.
+func (err *LastError) Unwrap() error {
+	return err.err
+}
+
 // ---------------------- update helpers ----------------------
 
 // hasUpdateOperators returns true if the provided document already contains a
 // top-level MongoDB update operator (keys starting with "$").
 func hasUpdateOperators(doc interface{}) bool {
-	if doc == nil {
-		return false
+	for _, k := range topLevelKeys(doc) {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
 	}
+	return false
+}
+
+// topLevelKeys returns the keys of a document given as a map, an ordered
+// document or raw BSON, and nil for other values
+func topLevelKeys(doc interface{}) []string {
+	var keys []string
 	switch d := doc.(type) {
 	case bson.M:
 		for k := range d {
-			if strings.HasPrefix(k, "$") {
-				return true
-			}
+			keys = append(keys, k)
 		}
 	case map[string]interface{}:
 		for k := range d {
-			if strings.HasPrefix(k, "$") {
-				return true
-			}
+			keys = append(keys, k)
+		}
+	case officialBson.M:
+		for k := range d {
+			keys = append(keys, k)
+		}
+	case bson.D:
+		for _, elem := range d {
+			keys = append(keys, elem.Name)
+		}
+	case bson.RawD:
+		for _, elem := range d {
+			keys = append(keys, elem.Name)
+		}
+	case officialBson.D:
+		for _, elem := range d {
+			keys = append(keys, elem.Key)
+		}
+	case bson.Raw:
+		if d.Kind == bson.ElementDocument {
+			return topLevelKeys(officialBson.Raw(d.Data))
+		}
+	case officialBson.Raw:
+		elems, _ := d.Elements()
+		for _, elem := range elems {
+			keys = append(keys, elem.Key())
 		}
 	}
-	return false
+	return keys
 }
 
 // wrapInSetOperator ensures plain replacement documents are converted into a
@@ -284,51 +395,142 @@ func IsDup(err error) bool {
 		return false
 	}
 
-	switch e := err.(type) {
-	case *BulkError:
+	var bulkErr *BulkError
+	if errors.As(err, &bulkErr) {
 		// A BulkError is considered duplicate only if *all* individual cases are
 		// duplicate-key errors, mirroring the behaviour of the legacy driver.
-		for _, c := range e.Cases() {
+		for _, c := range bulkErr.Cases() {
 			if !IsDup(c.Err) {
 				return false
 			}
 		}
-		return len(e.Cases()) > 0
-	case *QueryError:
-		return isDupCode(e.Code)
+		return len(bulkErr.Cases()) > 0
+	}
+	var lastErr *LastError
+	if errors.As(err, &lastErr) {
+		return isDupCode(lastErr.Code)
+	}
+	var queryErr *QueryError
+	if errors.As(err, &queryErr) {
+		return isDupCode(queryErr.Code)
 	}
 
-	// Handle official MongoDB driver error varieties.
-	// NOTE: These assertions use the mongo package types via the local alias
-	// "mongodrv" which is already imported elsewhere in the project.
-	if we, ok := err.(mongodrv.WriteException); ok {
-		if we.WriteConcernError != nil && isDupCode(int(we.WriteConcernError.Code)) {
+	// Handle official MongoDB driver error varieties, which may be returned
+	// by value or wrapped with additional context.
+	var we mongodrv.WriteException
+	if errors.As(err, &we) {
+		if we.WriteConcernError != nil && isDupCode(we.WriteConcernError.Code) {
 			return true
 		}
-		if len(we.WriteErrors) == 0 {
-			return false
-		}
-		for _, w := range we.WriteErrors {
-			if !isDupCode(int(w.Code)) {
-				return false
-			}
-		}
-		return true
+		return allDupWriteErrors(we.WriteErrors)
 	}
-	if bwe, ok := err.(mongodrv.BulkWriteException); ok {
-		if bwe.WriteConcernError != nil && isDupCode(int(bwe.WriteConcernError.Code)) {
+	var bwe mongodrv.BulkWriteException
+	if errors.As(err, &bwe) {
+		if bwe.WriteConcernError != nil && isDupCode(bwe.WriteConcernError.Code) {
 			return true
 		}
-		if len(bwe.WriteErrors) == 0 {
-			return false
+		writeErrors := make(mongodrv.WriteErrors, len(bwe.WriteErrors))
+		for i, w := range bwe.WriteErrors {
+			writeErrors[i] = w.WriteError
 		}
-		for _, w := range bwe.WriteErrors {
-			if !isDupCode(int(w.Code)) {
-				return false
-			}
+		return allDupWriteErrors(writeErrors)
+	}
+	var ce mongodrv.CommandError
+	if errors.As(err, &ce) {
+		return isDupCode(int(ce.Code))
+	}
+
+	return false
+}
+
+// allDupWriteErrors reports whether writeErrors is non-empty and holds only
+// duplicate-key errors
+func allDupWriteErrors(writeErrors mongodrv.WriteErrors) bool {
+	if len(writeErrors) == 0 {
+		return false
+	}
+	for _, w := range writeErrors {
+		if !isDupCode(w.Code) {
+			return false
 		}
+	}
+	return true
+}
+
+// -------------------------- Error classification --------------------------
+
+// retryableCodes holds the server error codes reported while a replica set
+// changes state or a member cannot be reached
+var retryableCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	9001,  // SocketException
+	10058, // LegacyNotPrimary
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// retryableMessages holds the messages of replica set state errors reported
+// without a code by older servers
+var retryableMessages = []string{"not master", "node is recovering"}
+
+// IsNetworkError reports whether err was caused by a failure to reach a
+// server: a connection that could not be established or was reset, or a
+// server selection that found no suitable server in time.
+func IsNetworkError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if mongodrv.IsNetworkError(err) {
+		return true
+	}
+	var selErr topology.ServerSelectionError
+	if errors.As(err, &selErr) {
 		return true
 	}
+	// Context errors satisfy net.Error but report the caller's deadline
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	// Connections closed by the server reach callers as driver errors
+	// labeled as network errors; a bare io.EOF comes from a reader, such as
+	// a GridFS file, not from the connection
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
 
+// IsRetryableError reports whether an operation failing with err may
+// succeed when attempted again, as after network errors and while a replica
+// set elects a new primary ("not master" and similar errors).
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if IsNetworkError(err) {
+		return true
+	}
+	var serverErr mongodrv.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	if serverErr.HasErrorLabel("RetryableWriteError") || serverErr.HasErrorLabel("TransientTransactionError") {
+		return true
+	}
+	for _, code := range retryableCodes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	for _, msg := range retryableMessages {
+		if serverErr.HasErrorMessage(msg) {
+			return true
+		}
+	}
 	return false
 }
@@ -0,0 +1,124 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// TestIsDup checks duplicate-key detection across wrapper and driver errors
+func TestIsDup(t *testing.T) {
+	dupWrite := mongodrv.WriteException{
+		WriteErrors: mongodrv.WriteErrors{{Code: 11000, Message: "E11000 duplicate key error"}},
+	}
+	dupBulk := mongodrv.BulkWriteException{
+		WriteErrors: []mongodrv.BulkWriteError{{WriteError: mongodrv.WriteError{Code: 11001}}},
+	}
+	mixedBulk := mongodrv.BulkWriteException{
+		WriteErrors: []mongodrv.BulkWriteError{
+			{WriteError: mongodrv.WriteError{Code: 11000}},
+			{WriteError: mongodrv.WriteError{Code: 121}},
+		},
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		dup  bool
+	}{
+		{"nil", nil, false},
+		{"plain error", errors.New("boom"), false},
+		{"query error", &QueryError{Code: 11000}, true},
+		{"other query error", &QueryError{Code: 2}, false},
+		{"bulk error", &BulkError{ecases: []BulkErrorCase{{Err: &QueryError{Code: 11000}}}}, true},
+		{"write exception", dupWrite, true},
+		{"wrapped write exception", fmt.Errorf("insert user: %w", dupWrite), true},
+		{"bulk write exception", dupBulk, true},
+		{"mixed bulk write exception", mixedBulk, false},
+		{"command error", mongodrv.CommandError{Code: 12582}, true},
+		{"other command error", mongodrv.CommandError{Code: 13}, false},
+	}
+	for _, tt := range tests {
+		if got := IsDup(tt.err); got != tt.dup {
+			t.Errorf("%s: expected IsDup %v, got %v", tt.name, tt.dup, got)
+		}
+	}
+}
+
+// TestErrorClassification checks network and retryable error detection
+func TestErrorClassification(t *testing.T) {
+	netErr := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	selErr := topology.ServerSelectionError{Wrapped: context.DeadlineExceeded}
+	labeled := mongodrv.CommandError{Code: 0, Labels: []string{"NetworkError"}}
+	notMaster := mongodrv.CommandError{Code: 10107, Message: "not primary"}
+	legacyNotMaster := mongodrv.CommandError{Message: "not master and slaveOk=false"}
+	writeNotMaster := mongodrv.WriteException{WriteConcernError: &mongodrv.WriteConcernError{Code: 91}}
+
+	tests := []struct {
+		name      string
+		err       error
+		network   bool
+		retryable bool
+	}{
+		{"nil", nil, false, false},
+		{"plain error", errors.New("boom"), false, false},
+		{"connection reset", netErr, true, true},
+		{"wrapped connection reset", fmt.Errorf("find: %w", netErr), true, true},
+		{"eof", io.EOF, false, false},
+		{"unexpected eof", io.ErrUnexpectedEOF, false, false},
+		{"server selection", selErr, true, true},
+		{"network label", labeled, true, true},
+		{"not primary", notMaster, false, true},
+		{"legacy not master", legacyNotMaster, false, true},
+		{"shutdown write concern", writeNotMaster, false, true},
+		{"duplicate key", mongodrv.CommandError{Code: 11000}, false, false},
+		{"not found", ErrNotFound, false, false},
+		{"operation deadline", context.DeadlineExceeded, false, false},
+	}
+	for _, tt := range tests {
+		if got := IsNetworkError(tt.err); got != tt.network {
+			t.Errorf("%s: expected IsNetworkError %v, got %v", tt.name, tt.network, got)
+		}
+		if got := IsRetryableError(tt.err); got != tt.retryable {
+			t.Errorf("%s: expected IsRetryableError %v, got %v", tt.name, tt.retryable, got)
+		}
+	}
+}
+
+// TestBulkErrorIndexes checks bulk error cases are reported at the queue
+// positions given by the driver, ordered by position
+func TestBulkErrorIndexes(t *testing.T) {
+	b := &ModernBulk{}
+	bwe := &mongodrv.BulkWriteException{
+		WriteErrors: []mongodrv.BulkWriteError{
+			{WriteError: mongodrv.WriteError{Index: 2, Code: 11000, Message: "dup"}},
+			{WriteError: mongodrv.WriteError{Index: 0, Code: 121, Message: "invalid"}},
+		},
+		WriteConcernError: &mongodrv.WriteConcernError{Code: 64, Message: "timeout"},
+	}
+	result, err := b.convertBulkError(&mongodrv.BulkWriteResult{DeletedCount: 1}, bwe)
+	if result.RemovedCount != 1 {
+		t.Errorf("Expected results of successful operations, got %#v", result)
+	}
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) {
+		t.Fatalf("Expected *BulkError, got %#v", err)
+	}
+	cases := bulkErr.Cases()
+	if len(cases) != 3 {
+		t.Fatalf("Expected 3 error cases, got %d", len(cases))
+	}
+	for i, want := range []struct{ index, code int }{{0, 121}, {2, 11000}, {-1, 64}} {
+		qerr, ok := cases[i].Err.(*QueryError)
+		if cases[i].Index != want.index || !ok || qerr.Code != want.code {
+			t.Errorf("Case %d: expected index %d code %d, got %d %#v", i, want.index, want.code, cases[i].Index, cases[i].Err)
+		}
+	}
+}
@@ -3,42 +3,107 @@
 package mgo
 
 import (
-	"context"
+	"errors"
+	"reflect"
 	"time"
 
-	"github.com/globalsign/mgo/bson"
+	"github.com/kinfkong/modern-mgo/bson"
 	officialBson "go.mongodb.org/mongo-driver/bson"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
-// Iter executes the aggregation pipeline and returns an iterator
+// Iter executes the aggregation pipeline and returns an iterator. The session
+// aggregate timeout, or the session timeout, bounds the whole iteration.
 func (p *ModernPipe) Iter() *ModernIt {
-	ctx := context.Background()
-
-	// Convert pipeline to the correct format for the official driver
-	var pipeline interface{}
-
-	// Handle different pipeline input types
-	switch v := p.pipeline.(type) {
-	case []interface{}:
-		// Already converted, use as-is
-		pipeline = v
-	case []bson.M:
-		// Convert []bson.M to []interface{}
-		converted := make([]interface{}, len(v))
-		for i, stage := range v {
-			converted[i] = convertMGOToOfficial(stage)
+	ctx, cancel := p.collection.session.iterContext(opAggregate)
+
+	pipeline := p.stages()
+	opts := p.aggregateOptions()
+
+	coll := p.collection.readColl()
+	if p.readPref != nil {
+		var err error
+		coll, err = p.collection.coll().Clone(options.Collection().SetReadPreference(p.readPref))
+		if err != nil {
+			cancel()
+			return &ModernIt{ctx: ctx, err: err}
 		}
-		pipeline = converted
-	case []officialBson.M:
-		// Already in official format
-		pipeline = v
-	default:
+	}
+
+	sess := p.collection.session.serverSession()
+	sessCtx, opSess := sess.start(ctx)
+	var cursor *mongodrv.Cursor
+	err := p.collection.retryRead(sessCtx, func() (err error) {
+		cursor, err = coll.Aggregate(sessCtx, pipeline, opts)
+		return err
+	})
+
+	return p.collection.session.newIter(ctx, cancel, cursor, sess, opSess, err)
+}
+
+// Exec runs a pipeline ending with an $out or $merge stage, which writes its
+// results to a collection instead of returning them. The pipeline runs on the
+// primary with the write concern set by SetWriteConcern, or the session one.
+// It is bounded by the session aggregate timeout, else the session timeout,
+// or 10 minutes when neither is set; SetMaxTime bounds it on the server side.
+func (p *ModernPipe) Exec() error {
+	ctx, cancel := p.collection.session.operationContext(opAggregate, 10*time.Minute)
+	defer cancel()
+
+	coll, err := p.collection.coll().Clone(p.execOptions())
+	if err != nil {
+		return err
+	}
+	p.collection.noteWrite()
+
+	cursor, err := coll.Aggregate(ctx, p.stages(), p.aggregateOptions())
+	if errors.Is(err, mongodrv.ErrUnacknowledgedWrite) {
+		// Unacknowledged runs return no cursor
+		return nil
+	}
+	if err = convertError(err); err != nil {
+		return err
+	}
+	return convertError(cursor.Close(ctx))
+}
+
+// execOptions returns the collection options Exec runs the pipeline with,
+// sending it to the primary whatever the session mode, with the pipeline
+// write concern when set
+func (p *ModernPipe) execOptions() *options.CollectionOptions {
+	opts := options.Collection().SetReadPreference(readpref.Primary())
+	if p.wc != nil {
+		opts.SetWriteConcern(p.wc)
+	}
+	return opts
+}
+
+// stages returns the pipeline in the format expected by the official driver.
+// Any slice of stages is accepted, each stage being converted on its own, so
+// ordered stages keep their key order.
+func (p *ModernPipe) stages() []interface{} {
+	switch p.pipeline.(type) {
+	case bson.D, []bson.DocElem, officialBson.D:
+		// A single stage given as an ordered document
+		return []interface{}{convertMGOToOfficial(p.pipeline)}
+	}
+
+	val := reflect.ValueOf(p.pipeline)
+	if val.Kind() != reflect.Slice {
 		// Try to convert single stage
-		pipeline = []interface{}{convertMGOToOfficial(v)}
+		return []interface{}{convertMGOToOfficial(p.pipeline)}
+	}
+	stages := make([]interface{}, val.Len())
+	for i := range stages {
+		stages[i] = convertMGOToOfficial(val.Index(i).Interface())
 	}
+	return stages
+}
 
-	// Create aggregation options
+// aggregateOptions returns the driver options of the pipeline
+func (p *ModernPipe) aggregateOptions() *options.AggregateOptions {
 	opts := &options.AggregateOptions{}
 	if p.allowDisk {
 		opts.AllowDiskUse = &p.allowDisk
@@ -53,14 +118,13 @@ func (p *ModernPipe) Iter() *ModernIt {
 	if p.collation != nil {
 		opts.Collation = p.collation
 	}
-
-	cursor, err := p.collection.mgoColl.Aggregate(ctx, pipeline, opts)
-
-	return &ModernIt{
-		cursor: cursor,
-		ctx:    ctx,
-		err:    err,
+	if p.comment != "" {
+		opts.SetComment(p.comment)
+	}
+	if p.let != nil {
+		opts.SetLet(convertMGOToOfficial(p.let))
 	}
+	return opts
 }
 
 // All executes the pipeline and returns all results
@@ -70,7 +134,9 @@ func (p *ModernPipe) All(result interface{}) error {
 	return iter.All(result)
 }
 
-// One executes the pipeline and returns the first result
+// One executes the pipeline and returns the first result. It returns
+// ErrNotFound only when the pipeline ran and produced no documents; errors
+// running the pipeline are returned as they are.
 func (p *ModernPipe) One(result interface{}) error {
 	iter := p.Iter()
 	defer iter.Close()
@@ -78,7 +144,7 @@ func (p *ModernPipe) One(result interface{}) error {
 	if iter.Next(result) {
 		return nil
 	}
-	if err := iter.err; err != nil {
+	if err := iter.Err(); err != nil {
 		return err
 	}
 	return ErrNotFound
@@ -86,47 +152,52 @@ func (p *ModernPipe) One(result interface{}) error {
 
 // Explain returns aggregation execution statistics
 func (p *ModernPipe) Explain(result interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := p.collection.session.operationContext(opAggregate, 10*time.Second)
 	defer cancel()
 
-	// Convert pipeline to the correct format
-	var pipeline []interface{}
-
-	switch v := p.pipeline.(type) {
-	case []interface{}:
-		pipeline = v
-	case []bson.M:
-		pipeline = make([]interface{}, len(v))
-		for i, stage := range v {
-			pipeline[i] = convertMGOToOfficial(stage)
-		}
-	case []officialBson.M:
-		pipeline = make([]interface{}, len(v))
-		for i, stage := range v {
-			pipeline[i] = stage
-		}
-	default:
-		pipeline = []interface{}{convertMGOToOfficial(v)}
-	}
-
-	// Create explain command
-	explainCmd := officialBson.M{
-		"aggregate": p.collection.name,
-		"pipeline":  pipeline,
-		"explain":   true,
-	}
-
 	db := p.collection.mgoColl.Database()
-	singleResult := db.RunCommand(ctx, explainCmd)
+	var cmdOpts []*options.RunCmdOptions
+	if p.readPref != nil {
+		cmdOpts = append(cmdOpts, options.RunCmd().SetReadPreference(p.readPref))
+	}
+	singleResult := db.RunCommand(ctx, p.explainCommand(), cmdOpts...)
 
-	var doc officialBson.M
-	err := singleResult.Decode(&doc)
+	raw, err := singleResult.Raw()
 	if err != nil {
-		return err
+		return convertError(err)
 	}
+	return decodeDocument(raw, result)
+}
 
-	converted := convertOfficialToMGO(doc)
-	return mapStructToInterface(converted, result)
+// explainCommand returns the aggregate command run by Explain, ordered with
+// the command name first as the server requires. It carries the options
+// aggregateOptions gives the driver, so that the plan explained is the one
+// Iter and All run.
+func (p *ModernPipe) explainCommand() officialBson.D {
+	cmd := officialBson.D{
+		{Key: "aggregate", Value: p.collection.name},
+		{Key: "pipeline", Value: p.stages()},
+		{Key: "explain", Value: true},
+	}
+	if p.allowDisk {
+		cmd = append(cmd, officialBson.E{Key: "allowDiskUse", Value: true})
+	}
+	if p.batchSize > 0 {
+		cmd = append(cmd, officialBson.E{Key: "cursor", Value: officialBson.D{{Key: "batchSize", Value: p.batchSize}}})
+	}
+	if p.maxTimeMS > 0 {
+		cmd = append(cmd, officialBson.E{Key: "maxTimeMS", Value: p.maxTimeMS})
+	}
+	if p.collation != nil {
+		cmd = append(cmd, officialBson.E{Key: "collation", Value: p.collation.ToDocument()})
+	}
+	if p.comment != "" {
+		cmd = append(cmd, officialBson.E{Key: "comment", Value: p.comment})
+	}
+	if p.let != nil {
+		cmd = append(cmd, officialBson.E{Key: "let", Value: convertMGOToOfficial(p.let)})
+	}
+	return cmd
 }
 
 // AllowDiskUse enables writing to temporary files during aggregation
@@ -150,18 +221,40 @@ func (p *ModernPipe) SetMaxTime(d time.Duration) *ModernPipe {
 // Collation sets the collation for the aggregation
 func (p *ModernPipe) Collation(collation *Collation) *ModernPipe {
 	if collation != nil {
-		// Convert mgo Collation to official driver Collation
-		p.collation = &options.Collation{
-			Locale:          collation.Locale,
-			CaseFirst:       collation.CaseFirst,
-			Strength:        collation.Strength,
-			Alternate:       collation.Alternate,
-			MaxVariable:     collation.MaxVariable,
-			Normalization:   collation.Normalization,
-			CaseLevel:       collation.CaseLevel,
-			NumericOrdering: collation.NumericOrdering,
-			Backwards:       collation.Backwards,
-		}
+		p.collation = convertCollation(collation)
 	}
 	return p
 }
+
+// Comment attaches a comment to the aggregation, reported by the profiler,
+// currentOp and the server logs
+func (p *ModernPipe) Comment(comment string) *ModernPipe {
+	p.comment = comment
+	return p
+}
+
+// Let defines variables that pipeline stages reference as $$name, so that
+// parameterized pipelines need not be rebuilt for each set of values
+// (MongoDB 5.0+)
+func (p *ModernPipe) Let(vars bson.M) *ModernPipe {
+	p.let = vars
+	return p
+}
+
+// SetWriteConcern sets the safety mode of pipelines run with Exec, overriding
+// the session one for this pipeline only. A nil safe makes the run
+// unacknowledged.
+func (p *ModernPipe) SetWriteConcern(safe *Safe) *ModernPipe {
+	p.wc = safeWriteConcern(safe)
+	return p
+}
+
+// SetReadPreference sends the aggregation to the servers selected by mode,
+// regardless of the session mode, so that heavy pipelines can be run on
+// secondaries. The server tags and maximum staleness of the session still
+// apply. Monotonic is treated as Eventual, and pipelines run with Exec always
+// go to the primary.
+func (p *ModernPipe) SetReadPreference(mode Mode) *ModernPipe {
+	p.readPref = p.collection.session.modeReadPreference(mode)
+	return p
+}
@@ -0,0 +1,109 @@
+package mgo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kinfkong/modern-mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// TestPipeExplainCommand checks Explain runs an ordered aggregate command,
+// the command name first
+func TestPipeExplainCommand(t *testing.T) {
+	coll := &ModernColl{name: "orders"}
+	pipe := coll.Pipe([]bson.M{{"$match": bson.M{"status": "A"}}}).
+		Comment("report").Let(bson.M{"status": "A"})
+
+	cmd := pipe.explainCommand()
+	var keys []string
+	for _, elem := range cmd {
+		keys = append(keys, elem.Key)
+	}
+	expected := []string{"aggregate", "pipeline", "explain", "cursor", "comment", "let"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("Expected keys %v, got %v", expected, keys)
+	}
+	if cmd[0].Value != "orders" || cmd[2].Value != true || cmd[4].Value != "report" {
+		t.Errorf("Unexpected explain command %v", cmd)
+	}
+	if _, err := officialBson.Marshal(cmd); err != nil {
+		t.Errorf("Failed to marshal the explain command: %v", err)
+	}
+
+	// The options Iter runs the pipeline with are explained too
+	cmd = coll.Pipe([]bson.M{}).AllowDiskUse().Batch(50).SetMaxTime(2 * time.Second).
+		Collation(&Collation{Locale: "fr"}).explainCommand()
+	keys = nil
+	for _, elem := range cmd {
+		keys = append(keys, elem.Key)
+	}
+	expected = []string{"aggregate", "pipeline", "explain", "allowDiskUse", "cursor", "maxTimeMS", "collation"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("Expected keys %v, got %v", expected, keys)
+	}
+	if cmd[5].Value != int64(2000) {
+		t.Errorf("Expected maxTimeMS 2000, got %v", cmd[5].Value)
+	}
+	raw, err := officialBson.Marshal(cmd)
+	if err != nil {
+		t.Fatalf("Failed to marshal the explain command: %v", err)
+	}
+	if got := officialBson.Raw(raw).Lookup("cursor", "batchSize").Int32(); got != 50 {
+		t.Errorf("Expected batch size 50, got %d", got)
+	}
+	if got := officialBson.Raw(raw).Lookup("collation", "locale").StringValue(); got != "fr" {
+		t.Errorf("Expected the fr collation, got %q", got)
+	}
+
+	// Options are left out when unset, but for the default batch size
+	if cmd := coll.Pipe([]bson.M{}).explainCommand(); len(cmd) != 4 {
+		t.Errorf("Expected only aggregate, pipeline, explain and cursor, got %v", cmd)
+	}
+}
+
+// TestPipeExecOptions checks Exec sends pipelines to the primary whatever
+// the session mode, with the write concern of the pipeline
+func TestPipeExecOptions(t *testing.T) {
+	coll := &ModernColl{name: "orders"}
+	opts := coll.Pipe([]bson.M{}).execOptions()
+	if opts.ReadPreference == nil || opts.ReadPreference.Mode() != readpref.PrimaryMode {
+		t.Errorf("Expected Exec to run on the primary, got %v", opts.ReadPreference)
+	}
+	if opts.WriteConcern != nil {
+		t.Errorf("Expected the collection write concern by default, got %+v", opts.WriteConcern)
+	}
+
+	opts = coll.Pipe([]bson.M{}).SetWriteConcern(&Safe{W: 2}).execOptions()
+	if opts.ReadPreference == nil || opts.ReadPreference.Mode() != readpref.PrimaryMode {
+		t.Errorf("Expected Exec to run on the primary, got %v", opts.ReadPreference)
+	}
+	if opts.WriteConcern == nil || opts.WriteConcern.W != 2 {
+		t.Errorf("Expected the pipeline write concern, got %+v", opts.WriteConcern)
+	}
+}
+
+// TestPipeReadPreference checks a pipeline read preference overrides the
+// session mode but keeps its server tags
+func TestPipeReadPreference(t *testing.T) {
+	m := &ModernMGO{mode: Primary}
+	m.SelectServers(bson.D{{Name: "role", Value: "analytics"}})
+	coll := &ModernColl{session: m}
+
+	if p := coll.Pipe([]bson.M{}); p.readPref != nil {
+		t.Errorf("Expected no pipeline read preference by default, got %v", p.readPref)
+	}
+
+	rp := coll.Pipe([]bson.M{}).SetReadPreference(Secondary).readPref
+	if rp.Mode() != readpref.SecondaryMode {
+		t.Fatalf("Expected secondary mode, got %v", rp.Mode())
+	}
+	if sets := rp.TagSets(); len(sets) != 1 || !sets[0].Contains("role", "analytics") {
+		t.Errorf("Expected the session tag sets, got %v", sets)
+	}
+	if mode := m.getReadPreference().Mode(); mode != readpref.PrimaryMode {
+		t.Errorf("Expected the session mode to be unchanged, got %v", mode)
+	}
+}
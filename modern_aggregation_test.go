@@ -1,9 +1,13 @@
 package mgo_test
 
 import (
+	"errors"
 	"testing"
+	"time"
 
-	"github.com/globalsign/mgo/bson"
+	mgo "github.com/kinfkong/modern-mgo"
+	"github.com/kinfkong/modern-mgo/bson"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
 )
 
 func TestModernAggregationBasic(t *testing.T) {
@@ -292,3 +296,216 @@ func TestModernAggregationNoResults(t *testing.T) {
 	err = coll.Pipe(pipeline).One(&result)
 	AssertError(t, err, "Expected error when no documents match")
 }
+
+func TestModernAggregationCommentAndLet(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	testData := GetTestData()
+	InsertTestData(t, coll, testData.Products)
+
+	// The pipeline references $$category, bound through Let
+	pipeline := []bson.M{
+		{"$match": bson.M{"$expr": bson.M{"$eq": []interface{}{"$category", "$$category"}}}},
+	}
+
+	var results []bson.M
+	err := coll.Pipe(pipeline).Let(bson.M{"category": "Electronics"}).Comment("electronics report").All(&results)
+	AssertNoError(t, err, "Failed to execute pipeline with variables")
+	AssertEqual(t, 2, len(results), "Expected the Electronics products")
+
+	err = coll.Pipe(pipeline).Let(bson.M{"category": "Books"}).All(&results)
+	AssertNoError(t, err, "Failed to execute pipeline with variables")
+	AssertEqual(t, 1, len(results), "Expected the Books products")
+}
+
+func TestModernAggregationExplain(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	testData := GetTestData()
+	InsertTestData(t, coll, testData.Products)
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"$expr": bson.M{"$eq": []interface{}{"$category", "$$category"}}}},
+	}
+	var explain bson.M
+	err := coll.Pipe(pipeline).Let(bson.M{"category": "Books"}).Comment("explained").Explain(&explain)
+	AssertNoError(t, err, "Failed to explain pipeline")
+	AssertEqual(t, 1.0, explain["ok"], "Expected an explain reply")
+}
+
+func TestModernAggregationExec(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	testData := GetTestData()
+	InsertTestData(t, coll, testData.Products)
+
+	// $out replaces the target collection with the pipeline output
+	pipeline := []bson.M{
+		{"$group": bson.M{"_id": "$category", "count": bson.M{"$sum": 1}}},
+		{"$out": "category_counts"},
+	}
+	err := coll.Pipe(pipeline).SetWriteConcern(&mgo.Safe{WMode: "majority"}).Exec()
+	AssertNoError(t, err, "Failed to execute $out pipeline")
+
+	var counts []bson.M
+	err = tdb.C("category_counts").Find(nil).Sort("_id").All(&counts)
+	AssertNoError(t, err, "Failed to read $out results")
+	AssertEqual(t, 2, len(counts), "Expected one document per category")
+	AssertEqual(t, "Books", counts[0]["_id"], "Unexpected first category")
+
+	// $merge updates the target collection in place
+	pipeline = []bson.M{
+		{"$match": bson.M{"category": "Books"}},
+		{"$group": bson.M{"_id": "$category", "total": bson.M{"$sum": "$quantity"}}},
+		{"$merge": bson.M{"into": "category_counts", "whenMatched": "merge"}},
+	}
+	err = coll.Pipe(pipeline).Exec()
+	AssertNoError(t, err, "Failed to execute $merge pipeline")
+
+	var books bson.M
+	err = tdb.C("category_counts").FindId("Books").One(&books)
+	AssertNoError(t, err, "Failed to read $merge results")
+	if books["count"] == nil || books["total"] == nil {
+		t.Errorf("Expected merged count and total, got %v", books)
+	}
+
+	// Unacknowledged runs return no cursor and report no error
+	pipeline = []bson.M{{"$out": "unacknowledged_copy"}}
+	err = coll.Pipe(pipeline).SetWriteConcern(nil).Exec()
+	AssertNoError(t, err, "Failed to execute unacknowledged $out pipeline")
+
+	// All tolerates the empty cursor returned by $out
+	var results []bson.M
+	err = coll.Pipe([]bson.M{{"$out": "products_copy"}}).All(&results)
+	AssertNoError(t, err, "Failed to run $out pipeline with All")
+	AssertEqual(t, 0, len(results), "Expected no documents from $out")
+}
+
+func TestModernAggregationOrderedStages(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	testData := GetTestData()
+	InsertTestData(t, coll, testData.Products)
+
+	// Sort by category first, then by descending price within a category
+	pipeline := []bson.D{
+		{{Name: "$sort", Value: bson.D{{Name: "category", Value: 1}, {Name: "price", Value: -1}}}},
+		{{Name: "$project", Value: bson.D{{Name: "name", Value: 1}}}},
+	}
+
+	var results []bson.M
+	err := coll.Pipe(pipeline).All(&results)
+	AssertNoError(t, err, "Failed to execute ordered pipeline")
+	AssertEqual(t, 3, len(results), "Expected all products")
+
+	names := []string{"Product B", "Product C", "Product A"}
+	for i, name := range names {
+		AssertEqual(t, name, results[i]["name"], "Unexpected sort order")
+	}
+
+	// Stages of different document types can be mixed
+	mixed := []interface{}{
+		bson.M{"$match": bson.M{"category": "Electronics"}},
+		bson.D{{Name: "$sort", Value: bson.D{{Name: "price", Value: 1}}}},
+	}
+	err = coll.Pipe(mixed).All(&results)
+	AssertNoError(t, err, "Failed to execute mixed pipeline")
+	AssertEqual(t, 2, len(results), "Expected the Electronics products")
+	AssertEqual(t, "Product A", results[0]["name"], "Unexpected mixed sort order")
+}
+
+func TestModernAggregationInvalidPipeline(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	testData := GetTestData()
+	InsertTestData(t, coll, testData.Products)
+
+	pipeline := []bson.M{{"$bogus": bson.M{}}}
+
+	// The server error is reported rather than an empty result
+	var result bson.M
+	err := coll.Pipe(pipeline).One(&result)
+	AssertError(t, err, "Expected an error for an invalid pipeline")
+	if errors.Is(err, mgo.ErrNotFound) {
+		t.Errorf("Invalid pipeline reported as not found: %v", err)
+	}
+	if _, ok := err.(*mgo.QueryError); !ok {
+		t.Errorf("Expected a *QueryError, got %T: %v", err, err)
+	}
+
+	iter := coll.Pipe(pipeline).Iter()
+	if iter.Next(&result) {
+		t.Error("Expected no results from an invalid pipeline")
+	}
+	AssertError(t, iter.Err(), "Expected the iterator to report the pipeline error")
+	AssertError(t, iter.Close(), "Expected Close to report the pipeline error")
+
+	var results []bson.M
+	err = coll.Pipe(pipeline).All(&results)
+	AssertError(t, err, "Expected All to report the pipeline error")
+
+	// A valid pipeline without results ends without error
+	iter = coll.Pipe([]bson.M{{"$match": bson.M{"category": "None"}}}).Iter()
+	if iter.Next(&result) {
+		t.Error("Expected no results")
+	}
+	AssertNoError(t, iter.Err(), "Expected no error at the end of the results")
+	AssertNoError(t, iter.Close(), "Failed to close iterator")
+}
+
+func TestModernAggregationTimeout(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	testData := GetTestData()
+	InsertTestData(t, coll, testData.Products)
+
+	session := tdb.Session.Copy()
+	defer session.Close()
+	session.SetOperationTimeouts(mgo.OpTimeouts{Aggregate: 200 * time.Millisecond})
+	coll = session.DB(tdb.DBName).C("test_collection")
+
+	// Each document takes longer to match than the whole aggregation may
+	slow := []bson.M{{"$match": bson.M{"$expr": bson.M{"$function": bson.M{
+		"body": "function() { sleep(500); return true; }",
+		"args": []interface{}{},
+		"lang": "js",
+	}}}}}
+
+	start := time.Now()
+	var results []bson.M
+	err := coll.Pipe(slow).All(&results)
+	AssertError(t, err, "Expected the slow pipeline to time out")
+	if !mongodrv.IsTimeout(err) {
+		t.Errorf("Expected a timeout error, got %T: %v", err, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the aggregate timeout to bound the pipeline, took %v", elapsed)
+	}
+
+	iter := coll.Pipe(slow).Iter()
+	var result bson.M
+	if iter.Next(&result) {
+		t.Error("Expected no result from the slow pipeline")
+	}
+	if err := iter.Close(); !mongodrv.IsTimeout(err) {
+		t.Errorf("Expected the iterator to report a timeout, got %v", err)
+	}
+}
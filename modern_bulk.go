@@ -1,10 +1,11 @@
 package mgo
 
 import (
-	"context"
+	"errors"
+	"sort"
 	"time"
 
-	"github.com/globalsign/mgo/bson"
+	"github.com/kinfkong/modern-mgo/bson"
 	mongodrv "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -16,6 +17,18 @@ func (b *ModernBulk) Unordered() {
 	b.ordered = false
 }
 
+// SetCollation sets the collation used by the queued update and remove
+// operations when the bulk is run
+func (b *ModernBulk) SetCollation(collation *Collation) {
+	b.collation = convertCollation(collation)
+}
+
+// SetWriteConcern sets the safety mode used when the bulk is run, overriding
+// the session one for this bulk only. A nil safe makes the run unacknowledged.
+func (b *ModernBulk) SetWriteConcern(safe *Safe) {
+	b.wc = safeWriteConcern(safe)
+}
+
 // Insert queues up documents for insertion (mgo API compatible)
 func (b *ModernBulk) Insert(docs ...interface{}) {
 	for _, doc := range docs {
@@ -135,23 +148,54 @@ func (b *ModernBulk) Run() (*BulkResult, error) {
 		return &BulkResult{}, nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := b.collection.session.operationContext(opWrite, 30*time.Second)
 	defer cancel()
 
-	opts := options.BulkWrite().SetOrdered(b.ordered)
+	if b.collation != nil {
+		b.applyCollation()
+	}
 
-	result, err := b.collection.mgoColl.BulkWrite(ctx, b.operations, opts)
-	if err != nil {
-		// Convert bulk write errors to mgo format
-		if bulkErr, ok := err.(mongodrv.BulkWriteException); ok {
-			return b.convertBulkError(result, &bulkErr)
+	opts := options.BulkWrite().SetOrdered(b.ordered)
+	opts.BypassDocumentValidation = b.collection.bypassValidation()
+	coll := b.collection.coll()
+	if b.wc != nil {
+		var err error
+		coll, err = coll.Clone(options.Collection().SetWriteConcern(b.wc))
+		if err != nil {
+			return nil, err
 		}
+	}
+	b.collection.noteWrite()
+
+	result, err := coll.BulkWrite(ctx, b.operations, opts)
+	// Convert bulk write errors to mgo format
+	var bulkErr mongodrv.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		return b.convertBulkError(result, &bulkErr)
+	}
+	if err = convertError(err); err != nil {
 		return nil, err
 	}
 
 	return b.convertBulkResult(result), nil
 }
 
+// applyCollation sets the bulk collation on every queued update and delete model
+func (b *ModernBulk) applyCollation() {
+	for _, op := range b.operations {
+		switch model := op.(type) {
+		case *mongodrv.UpdateOneModel:
+			model.SetCollation(b.collation)
+		case *mongodrv.UpdateManyModel:
+			model.SetCollation(b.collation)
+		case *mongodrv.DeleteOneModel:
+			model.SetCollation(b.collation)
+		case *mongodrv.DeleteManyModel:
+			model.SetCollation(b.collation)
+		}
+	}
+}
+
 // convertBulkResult converts official driver BulkWriteResult to mgo BulkResult
 func (b *ModernBulk) convertBulkResult(result *mongodrv.BulkWriteResult) *BulkResult {
 	if result == nil {
@@ -162,17 +206,38 @@ func (b *ModernBulk) convertBulkResult(result *mongodrv.BulkWriteResult) *BulkRe
 	// - Matched: only counts documents matched by update operations (not inserts/deletes)
 	// - Modified: only counts documents actually modified by update operations
 	// - Upserts that insert new documents are NOT counted as modified
-	matched := int(result.MatchedCount)
-	modified := int(result.ModifiedCount)
-
-	return &BulkResult{
-		Matched:  matched,
-		Modified: modified,
+	bulkResult := &BulkResult{
+		Matched:       int(result.MatchedCount),
+		Modified:      int(result.ModifiedCount),
+		InsertedCount: int(result.InsertedCount),
+		RemovedCount:  int(result.DeletedCount),
+		UpsertedCount: int(result.UpsertedCount),
 	}
+	for index, id := range result.UpsertedIDs {
+		bulkResult.Upserted = append(bulkResult.Upserted, BulkUpsert{
+			Index: int(index),
+			Id:    convertOfficialToMGO(id),
+		})
+	}
+	sort.Slice(bulkResult.Upserted, func(i, j int) bool {
+		return bulkResult.Upserted[i].Index < bulkResult.Upserted[j].Index
+	})
+	return bulkResult
 }
 
-// convertBulkError converts official driver BulkWriteException to mgo BulkError
+// convertBulkError converts official driver BulkWriteException to mgo BulkError.
+// The counts of the operations that succeeded are returned alongside the error,
+// and every error case is reported at the queue position of the operation
+// that caused it.
 func (b *ModernBulk) convertBulkError(result *mongodrv.BulkWriteResult, bulkErr *mongodrv.BulkWriteException) (*BulkResult, error) {
+	return b.convertBulkResult(result), newBulkError(bulkErr)
+}
+
+// newBulkError converts the failures reported in a BulkWriteException to a
+// BulkError holding one case per failed operation, ordered by position. The
+// driver reports the index of each failure in the models it was given, even
+// when it regroups unordered operations by type.
+func newBulkError(bulkErr *mongodrv.BulkWriteException) *BulkError {
 	// Convert write errors to BulkErrorCase format
 	var ecases []BulkErrorCase
 
@@ -186,6 +251,9 @@ func (b *ModernBulk) convertBulkError(result *mongodrv.BulkWriteResult, bulkErr
 		}
 		ecases = append(ecases, ecase)
 	}
+	sort.SliceStable(ecases, func(i, j int) bool {
+		return ecases[i].Index < ecases[j].Index
+	})
 
 	// Handle write concern error if present
 	if bulkErr.WriteConcernError != nil {
@@ -199,14 +267,12 @@ func (b *ModernBulk) convertBulkError(result *mongodrv.BulkWriteResult, bulkErr
 		ecases = append(ecases, ecase)
 	}
 
-	bulkResult := b.convertBulkResult(result)
-
 	if len(ecases) > 0 {
-		return bulkResult, &BulkError{ecases: ecases}
+		return &BulkError{ecases: ecases}
 	}
 
 	// If we have a bulk write exception but no specific errors, return the general error
-	return bulkResult, &BulkError{
+	return &BulkError{
 		ecases: []BulkErrorCase{{
 			Index: -1,
 			Err: &QueryError{
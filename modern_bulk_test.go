@@ -3,8 +3,8 @@ package mgo_test
 import (
 	"testing"
 
-	"github.com/globalsign/mgo"
-	"github.com/globalsign/mgo/bson"
+	mgo "github.com/kinfkong/modern-mgo"
+	"github.com/kinfkong/modern-mgo/bson"
 )
 
 func TestModernBulkInsert(t *testing.T) {
@@ -103,6 +103,12 @@ func TestModernBulkUpsert(t *testing.T) {
 	if result.Modified != 1 {
 		t.Errorf("Expected 1 modified document, got %d", result.Modified)
 	}
+	AssertEqual(t, 1, result.UpsertedCount, "Incorrect upserted count")
+	if len(result.Upserted) != 1 {
+		t.Fatalf("Expected 1 upserted entry, got %d", len(result.Upserted))
+	}
+	AssertEqual(t, 1, result.Upserted[0].Index, "Incorrect upsert index")
+	AssertEqual(t, int32(2), result.Upserted[0].Id, "Incorrect upserted id")
 
 	// Verify final state
 	count, err := coll.Count()
@@ -145,10 +151,9 @@ func TestModernBulkRemove(t *testing.T) {
 	bulk.RemoveAll(bson.M{"category": "A"})
 
 	// Execute
-	_, err = bulk.Run()
+	result, err := bulk.Run()
 	AssertNoError(t, err, "Failed to execute bulk remove")
-
-	// Note: BulkResult doesn't have a Removed field, so we check by counting
+	AssertEqual(t, 2, result.RemovedCount, "Incorrect removed count")
 
 	// Verify removals
 	count, err := coll.Count()
@@ -251,6 +256,41 @@ func TestModernBulkUnordered(t *testing.T) {
 	}
 }
 
+func TestModernBulkUnorderedErrorIndexes(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	err := coll.Insert(bson.M{"_id": 1}, bson.M{"_id": 2})
+	AssertNoError(t, err, "Failed to insert documents")
+
+	// The failed inserts are sent apart from the other operations, yet are
+	// reported at their queue positions
+	bulk := coll.Bulk()
+	bulk.Unordered()
+	bulk.Update(bson.M{"_id": 1}, bson.M{"$set": bson.M{"n": 1}})
+	bulk.Insert(bson.M{"_id": 1})
+	bulk.Remove(bson.M{"_id": 2})
+	bulk.Insert(bson.M{"_id": 3}, bson.M{"_id": 1})
+	bulk.Update(bson.M{"_id": 3}, bson.M{"$set": bson.M{"n": 3}})
+
+	_, err = bulk.Run()
+	bulkErr, ok := err.(*mgo.BulkError)
+	if !ok {
+		t.Fatalf("Expected *mgo.BulkError, got %#v", err)
+	}
+	cases := bulkErr.Cases()
+	if len(cases) != 2 {
+		t.Fatalf("Expected 2 error cases, got %+v", cases)
+	}
+	for i, index := range []int{1, 4} {
+		if cases[i].Index != index || !mgo.IsDup(cases[i].Err) {
+			t.Errorf("Expected a duplicate key error at index %d, got %+v", index, cases[i])
+		}
+	}
+}
+
 func TestModernBulkEmptyOperations(t *testing.T) {
 	// Setup
 	tdb := NewTestDB(t)
@@ -312,3 +352,69 @@ func TestModernBulkLargeOperations(t *testing.T) {
 		t.Errorf("Expected %d modified documents, got %d", numOps, result.Modified)
 	}
 }
+
+func TestModernBulkCollation(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	docs := []interface{}{
+		bson.M{"_id": 1, "name": "Alice"},
+		bson.M{"_id": 2, "name": "BOB"},
+		bson.M{"_id": 3, "name": "carol"},
+	}
+	err := coll.Insert(docs...)
+	AssertNoError(t, err, "Failed to insert initial documents")
+
+	// Case-insensitive matching with strength 2
+	bulk := coll.Bulk()
+	bulk.SetCollation(&mgo.Collation{Locale: "en", Strength: 2})
+	bulk.Update(bson.M{"name": "alice"}, bson.M{"$set": bson.M{"seen": true}})
+	bulk.UpdateAll(bson.M{"name": "bob"}, bson.M{"$set": bson.M{"seen": true}})
+	bulk.Remove(bson.M{"name": "CAROL"})
+
+	result, err := bulk.Run()
+	AssertNoError(t, err, "Failed to execute bulk with collation")
+	AssertEqual(t, 2, result.Matched, "Incorrect matched count")
+	AssertEqual(t, 1, result.RemovedCount, "Incorrect removed count")
+
+	count, err := coll.Find(bson.M{"seen": true}).Count()
+	AssertNoError(t, err, "Failed to count updated documents")
+	AssertEqual(t, 2, count, "Collation not applied to updates")
+
+	count, err = coll.Count()
+	AssertNoError(t, err, "Failed to count documents")
+	AssertEqual(t, 2, count, "Collation not applied to remove")
+}
+
+func TestModernBulkSetWriteConcern(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	// A majority bulk on a w:1 session
+	bulk := coll.Bulk()
+	bulk.SetWriteConcern(&mgo.Safe{WMode: "majority", WTimeout: 5000})
+	bulk.Insert(bson.M{"_id": 1}, bson.M{"_id": 2})
+
+	result, err := bulk.Run()
+	AssertNoError(t, err, "Failed to execute bulk with majority write concern")
+	AssertEqual(t, 2, result.InsertedCount, "Incorrect inserted count")
+
+	// Unacknowledged bulks do not report errors
+	bulk = coll.Bulk()
+	bulk.SetWriteConcern(nil)
+	bulk.Insert(bson.M{"_id": 1})
+	_, err = bulk.Run()
+	AssertNoError(t, err, "Unacknowledged bulk should not report errors")
+
+	// The session write concern is left unchanged
+	err = coll.Insert(bson.M{"_id": 2})
+	if !mgo.IsDup(err) {
+		t.Errorf("Expected duplicate key error from session write, got %v", err)
+	}
+}
@@ -0,0 +1,784 @@
+// modern_codec.go - BSON codecs for modern MongoDB driver compatibility wrapper
+
+package mgo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kinfkong/modern-mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonoptions"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Types with an mgo representation in mgoRegistry
+var (
+	tEmpty      = reflect.TypeOf((*interface{})(nil)).Elem()
+	tObjectId   = reflect.TypeOf(bson.ObjectId(""))
+	tSymbol     = reflect.TypeOf(bson.Symbol(""))
+	tD          = reflect.TypeOf(bson.D(nil))
+	tDocElems   = reflect.TypeOf([]bson.DocElem(nil))
+	tM          = reflect.TypeOf(bson.M(nil))
+	tRaw        = reflect.TypeOf(bson.Raw{})
+	tRawD       = reflect.TypeOf(bson.RawD(nil))
+	tBinary     = reflect.TypeOf(bson.Binary{})
+	tRegEx      = reflect.TypeOf(bson.RegEx{})
+	tJavaScript = reflect.TypeOf(bson.JavaScript{})
+	tDecimal128 = reflect.TypeOf(bson.Decimal128{})
+	tDBPointer  = reflect.TypeOf(bson.DBPointer{})
+	tTimestamp  = reflect.TypeOf(bson.MongoTimestamp(0))
+	tOrderKey   = reflect.TypeOf(bson.MinKey)
+	tUndefined  = reflect.TypeOf(bson.Undefined)
+	tDuration   = reflect.TypeOf(time.Duration(0))
+	tTime       = reflect.TypeOf(time.Time{})
+	tInt        = reflect.TypeOf(0)
+	tInt64      = reflect.TypeOf(int64(0))
+	tSlice      = reflect.TypeOf([]interface{}(nil))
+	tByteSlice  = reflect.TypeOf([]byte(nil))
+	tGetter     = reflect.TypeOf((*bson.Getter)(nil)).Elem()
+	tSetter     = reflect.TypeOf((*bson.Setter)(nil)).Elem()
+	tZeroer     = reflect.TypeOf((*bsoncodec.Zeroer)(nil)).Elem()
+)
+
+// mgoRegistry encodes and decodes values with the official driver the way
+// the mgo bson package does, so that structs are stored and loaded without
+// going through intermediate documents:
+//
+//   - struct fields follow the bson tags, with omitempty omitting structs
+//     whose fields are all zero, and structs and maps are cleared before
+//     decoding
+//   - nil slices and maps are stored as empty arrays and documents
+//   - uint and uint32 values are stored as int32 when they fit, and
+//     time.Duration values as int64 milliseconds
+//   - times are loaded in UTC
+//   - the mgo bson types (ObjectId, D, M, Binary, RegEx, MongoTimestamp...)
+//     map to their BSON counterparts, and values decoded into interface{}
+//     take those types, with int32 values loaded as int and generic binary
+//     data as []byte
+//   - bson.Getter and bson.Setter implementations are honored
+var mgoRegistry = newMGORegistry()
+
+// emptyInterfaceCodec decodes the values decodeInterface leaves to the driver
+var emptyInterfaceCodec = bsoncodec.NewEmptyInterfaceCodec()
+
+func newMGORegistry() *bsoncodec.Registry {
+	reg := officialBson.NewRegistry()
+
+	structCodec, err := bsoncodec.NewStructCodec(bsoncodec.DefaultStructTagParser,
+		bsonoptions.StructCodec().SetDecodeZeroStruct(true).SetEncodeOmitDefaultStruct(true))
+	if err != nil {
+		panic(err)
+	}
+	sliceCodec := bsoncodec.NewSliceCodec(bsonoptions.SliceCodec().SetEncodeNilAsEmpty(true))
+	mapCodec := bsoncodec.NewMapCodec(bsonoptions.MapCodec().SetEncodeNilAsEmpty(true).SetDecodeZerosMap(true))
+	byteSliceCodec := bsoncodec.NewByteSliceCodec(bsonoptions.ByteSliceCodec().SetEncodeNilAsEmpty(true))
+	// ObjectIds loaded into strings keep their raw bytes, as bson.ObjectId does
+	stringCodec := bsoncodec.NewStringCodec(bsonoptions.StringCodec().SetDecodeObjectIDAsHex(false))
+
+	reg.RegisterKindEncoder(reflect.Struct, structCodec)
+	reg.RegisterKindDecoder(reflect.Struct, structCodec)
+	reg.RegisterKindEncoder(reflect.Slice, sliceCodec)
+	reg.RegisterKindDecoder(reflect.Slice, sliceCodec)
+	reg.RegisterKindEncoder(reflect.Map, mapCodec)
+	reg.RegisterKindDecoder(reflect.Map, mapCodec)
+	reg.RegisterTypeEncoder(tByteSlice, byteSliceCodec)
+	reg.RegisterTypeDecoder(tByteSlice, byteSliceCodec)
+	reg.RegisterKindDecoder(reflect.String, stringCodec)
+	reg.RegisterKindEncoder(reflect.Uint, bsoncodec.ValueEncoderFunc(encodeUint))
+	reg.RegisterKindEncoder(reflect.Uint32, bsoncodec.ValueEncoderFunc(encodeUint))
+
+	reg.RegisterTypeEncoder(tObjectId, bsoncodec.ValueEncoderFunc(encodeObjectId))
+	reg.RegisterTypeEncoder(tSymbol, bsoncodec.ValueEncoderFunc(encodeSymbol))
+	reg.RegisterTypeEncoder(tD, bsoncodec.ValueEncoderFunc(encodeDocElems))
+	reg.RegisterTypeDecoder(tD, bsoncodec.ValueDecoderFunc(decodeDocElems))
+	reg.RegisterTypeEncoder(tDocElems, bsoncodec.ValueEncoderFunc(encodeDocElems))
+	reg.RegisterTypeDecoder(tDocElems, bsoncodec.ValueDecoderFunc(decodeDocElems))
+	reg.RegisterTypeEncoder(tRaw, bsoncodec.ValueEncoderFunc(encodeRaw))
+	reg.RegisterTypeDecoder(tRaw, bsoncodec.ValueDecoderFunc(decodeRaw))
+	reg.RegisterTypeEncoder(tRawD, bsoncodec.ValueEncoderFunc(encodeRawD))
+	reg.RegisterTypeDecoder(tRawD, bsoncodec.ValueDecoderFunc(decodeRawD))
+	reg.RegisterTypeEncoder(tBinary, bsoncodec.ValueEncoderFunc(encodeBinary))
+	reg.RegisterTypeDecoder(tBinary, bsoncodec.ValueDecoderFunc(decodeBinary))
+	reg.RegisterTypeEncoder(tRegEx, bsoncodec.ValueEncoderFunc(encodeRegEx))
+	reg.RegisterTypeDecoder(tRegEx, bsoncodec.ValueDecoderFunc(decodeRegEx))
+	reg.RegisterTypeEncoder(tJavaScript, bsoncodec.ValueEncoderFunc(encodeJavaScript))
+	reg.RegisterTypeDecoder(tJavaScript, bsoncodec.ValueDecoderFunc(decodeJavaScript))
+	reg.RegisterTypeEncoder(tDecimal128, bsoncodec.ValueEncoderFunc(encodeDecimal128))
+	reg.RegisterTypeDecoder(tDecimal128, bsoncodec.ValueDecoderFunc(decodeDecimal128))
+	reg.RegisterTypeEncoder(tDBPointer, bsoncodec.ValueEncoderFunc(encodeDBPointer))
+	reg.RegisterTypeDecoder(tDBPointer, bsoncodec.ValueDecoderFunc(decodeDBPointer))
+	reg.RegisterTypeEncoder(tTimestamp, bsoncodec.ValueEncoderFunc(encodeTimestamp))
+	reg.RegisterTypeDecoder(tTimestamp, bsoncodec.ValueDecoderFunc(decodeTimestamp))
+	reg.RegisterTypeEncoder(tOrderKey, bsoncodec.ValueEncoderFunc(encodeOrderKey))
+	reg.RegisterTypeDecoder(tOrderKey, bsoncodec.ValueDecoderFunc(decodeOrderKey))
+	reg.RegisterTypeEncoder(tUndefined, bsoncodec.ValueEncoderFunc(encodeUndefined))
+	reg.RegisterTypeDecoder(tUndefined, bsoncodec.ValueDecoderFunc(decodeUndefined))
+	reg.RegisterTypeEncoder(tDuration, bsoncodec.ValueEncoderFunc(encodeDuration))
+	reg.RegisterTypeDecoder(tDuration, bsoncodec.ValueDecoderFunc(decodeDuration))
+	reg.RegisterTypeDecoder(tEmpty, bsoncodec.ValueDecoderFunc(decodeInterface))
+
+	reg.RegisterInterfaceEncoder(tGetter, bsoncodec.ValueEncoderFunc(encodeGetter))
+	reg.RegisterInterfaceDecoder(tSetter, bsoncodec.ValueDecoderFunc(decodeSetter))
+
+	// Types taken by the values decoded into interface{}
+	reg.RegisterTypeMapEntry(bsontype.EmbeddedDocument, tM)
+	reg.RegisterTypeMapEntry(bsontype.Array, tSlice)
+	reg.RegisterTypeMapEntry(bsontype.ObjectID, tObjectId)
+	reg.RegisterTypeMapEntry(bsontype.DateTime, tTime)
+	reg.RegisterTypeMapEntry(bsontype.Int32, tInt)
+	reg.RegisterTypeMapEntry(bsontype.Timestamp, tTimestamp)
+	reg.RegisterTypeMapEntry(bsontype.Regex, tRegEx)
+	reg.RegisterTypeMapEntry(bsontype.JavaScript, tJavaScript)
+	reg.RegisterTypeMapEntry(bsontype.CodeWithScope, tJavaScript)
+	reg.RegisterTypeMapEntry(bsontype.Symbol, tSymbol)
+	reg.RegisterTypeMapEntry(bsontype.Decimal128, tDecimal128)
+	reg.RegisterTypeMapEntry(bsontype.DBPointer, tDBPointer)
+	reg.RegisterTypeMapEntry(bsontype.MinKey, tOrderKey)
+	reg.RegisterTypeMapEntry(bsontype.MaxKey, tOrderKey)
+	return reg
+}
+
+// legacyEncoding reports whether the mgo bson options in effect are not
+// supported by mgoRegistry, which then leaves conversions to the bson package
+func legacyEncoding() bool {
+	return bson.JSONTagFallbackState() || bson.RespectNilValuesState()
+}
+
+// encodeStruct encodes a struct with mgoRegistry, as an officialBson.Raw
+// document or, for the mgo bson value types such as bson.Binary, as an
+// officialBson.RawValue. Structs whose omitempty fields mgoRegistry would not
+// omit as the bson package does are encoded by the bson package directly. It
+// reports false when the struct must go through the bson package instead.
+func encodeStruct(input interface{}) (interface{}, bool) {
+	if legacyEncoding() {
+		return nil, false
+	}
+	if !omitsEmptyLikeMGO(reflect.TypeOf(input)) {
+		data, err := bson.Marshal(input)
+		if err != nil {
+			return nil, false
+		}
+		return officialBson.Raw(data), true
+	}
+	switch reflect.TypeOf(input) {
+	case tRaw, tBinary, tRegEx, tJavaScript, tDecimal128, tDBPointer, tUndefined:
+		t, data, err := officialBson.MarshalValueWithRegistry(mgoRegistry, input)
+		if err != nil {
+			return nil, false
+		}
+		return officialBson.RawValue{Type: t, Value: data}, true
+	}
+	data, err := officialBson.MarshalWithRegistry(mgoRegistry, input)
+	if err != nil {
+		return nil, false
+	}
+	return officialBson.Raw(data), true
+}
+
+// omitEmptyCache holds whether mgoRegistry omits the empty fields of each
+// struct type encoded as the bson package does
+var omitEmptyCache sync.Map
+
+// omitsEmptyLikeMGO reports whether mgoRegistry omits the same empty
+// ",omitempty" fields as the bson package in values of type t. Unlike the
+// bson package, the driver omits the values whose IsZero method reports true
+// and arrays of length zero.
+func omitsEmptyLikeMGO(t reflect.Type) bool {
+	if cached, ok := omitEmptyCache.Load(t); ok {
+		return cached.(bool)
+	}
+	same := !omitEmptyDiffers(t, map[reflect.Type]bool{})
+	omitEmptyCache.Store(t, same)
+	return same
+}
+
+// omitEmptyDiffers reports whether values of type t, or the values they
+// hold, have ",omitempty" fields whose emptiness the driver and the bson
+// package may judge differently. visiting holds the struct types being
+// checked, for recursive types.
+func omitEmptyDiffers(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return omitEmptyDiffers(t.Elem(), visiting)
+	case reflect.Struct:
+		if visiting[t] {
+			return false
+		}
+		visiting[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" && !field.Anonymous {
+				continue // Private field
+			}
+			_, flags, _ := strings.Cut(field.Tag.Get("bson"), ",")
+			if hasTagFlag(flags, "omitempty") && emptinessDiffers(field.Type, map[reflect.Type]bool{}) {
+				return true
+			}
+			if omitEmptyDiffers(field.Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// emptinessDiffers reports whether the driver and the bson package may judge
+// differently whether a value of type t is empty: values with an IsZero
+// method, empty arrays, and structs holding such values
+func emptinessDiffers(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if t == tTime {
+		return false
+	}
+	if t.Implements(tZeroer) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Array:
+		return t.Len() == 0
+	case reflect.Struct:
+		if visiting[t] {
+			return false
+		}
+		visiting[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if (field.PkgPath == "" || field.Anonymous) && emptinessDiffers(field.Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeDocument decodes the document raw into result. Raw results, which
+// defer the conversion of the document until it is unmarshalled, receive a
+// copy of its bytes. Structs and bson.RawD values are decoded directly with
+// mgoRegistry; other results, and structs whose fields do not fit the
+// document, go through convertOfficialToMGO and the bson package, which skips
+// the fields it cannot set.
+func decodeDocument(raw officialBson.Raw, result interface{}) error {
+	// The driver reuses the buffers holding raw
+	switch out := result.(type) {
+	case *bson.Raw:
+		*out = bson.Raw{Kind: byte(bsontype.EmbeddedDocument), Data: append([]byte(nil), raw...)}
+		return nil
+	case *officialBson.Raw:
+		*out = append(officialBson.Raw(nil), raw...)
+		return nil
+	}
+
+	rv := reflect.ValueOf(result)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() && (rv.Elem().Kind() == reflect.Struct || rv.Elem().Type() == tRawD) &&
+		!legacyEncoding() {
+		dc := bsoncodec.DecodeContext{Registry: mgoRegistry, Truncate: true}
+		if err := officialBson.UnmarshalWithContext(dc, raw, result); err == nil {
+			return nil
+		}
+	}
+
+	var doc officialBson.M
+	if err := officialBson.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	return mapStructToInterface(convertOfficialToMGO(doc), result)
+}
+
+// encodeValue encodes value with the encoder registered for its type
+func encodeValue(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, value interface{}) error {
+	if value == nil {
+		return vw.WriteNull()
+	}
+	rv := reflect.ValueOf(value)
+	encoder, err := ec.LookupEncoder(rv.Type())
+	if err != nil {
+		return err
+	}
+	return encoder.EncodeValue(ec, vw, rv)
+}
+
+// readValue reads the next value of vr whole
+func readValue(vr bsonrw.ValueReader) (officialBson.RawValue, error) {
+	t, data, err := bsonrw.Copier{}.CopyValueToBytes(vr)
+	if t == bsontype.Type(0) {
+		// Top-level document
+		t = bsontype.EmbeddedDocument
+	}
+	return officialBson.RawValue{Type: t, Value: data}, err
+}
+
+// decodeInt decodes numbers into val, of an integer kind, with the decoder
+// of plain integers
+func decodeInt(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	decoder, err := dc.LookupDecoder(tInt64)
+	if err != nil {
+		return err
+	}
+	return decoder.DecodeValue(dc, vr, val)
+}
+
+// decodeError reports a BSON value that cannot be decoded into val
+func decodeError(t bsontype.Type, val reflect.Value) error {
+	return fmt.Errorf("cannot decode %v into a %v", t, val.Type())
+}
+
+// encodeUint stores uint and uint32 values as int32 when they fit
+func encodeUint(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	u := val.Uint()
+	switch {
+	case u <= math.MaxInt32:
+		return vw.WriteInt32(int32(u))
+	case u <= math.MaxInt64:
+		return vw.WriteInt64(int64(u))
+	}
+	return fmt.Errorf("%d overflows int64", u)
+}
+
+// encodeObjectId stores ObjectIds that are not 12 bytes long as strings, as
+// convertMGOToOfficial does
+func encodeObjectId(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	id := val.String()
+	if len(id) != 12 {
+		return vw.WriteString(id)
+	}
+	var oid primitive.ObjectID
+	copy(oid[:], id)
+	return vw.WriteObjectID(oid)
+}
+
+func encodeSymbol(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	return vw.WriteSymbol(val.String())
+}
+
+// encodeDocElems stores bson.D and []bson.DocElem values as documents
+// keeping their order
+func encodeDocElems(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	dw, err := vw.WriteDocument()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < val.Len(); i++ {
+		elem := val.Index(i).Interface().(bson.DocElem)
+		evw, err := dw.WriteDocumentElement(elem.Name)
+		if err != nil {
+			return err
+		}
+		if err := encodeValue(ec, evw, elem.Value); err != nil {
+			return err
+		}
+	}
+	return dw.WriteDocumentEnd()
+}
+
+// decodeDocElems loads documents into bson.D and []bson.DocElem values,
+// whose embedded documents are loaded as bson.D as well
+func decodeDocElems(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	switch vr.Type() {
+	case bsontype.Null:
+		val.Set(reflect.Zero(val.Type()))
+		return vr.ReadNull()
+	case bsontype.Type(0), bsontype.EmbeddedDocument:
+	default:
+		return decodeError(vr.Type(), val)
+	}
+
+	dr, err := vr.ReadDocument()
+	if err != nil {
+		return err
+	}
+	dc.Ancestor = tD
+	decoder, err := dc.LookupDecoder(tEmpty)
+	if err != nil {
+		return err
+	}
+	doc := bson.D{}
+	for {
+		name, evr, err := dr.ReadElement()
+		if errors.Is(err, bsonrw.ErrEOD) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		value := reflect.New(tEmpty).Elem()
+		if err := decoder.DecodeValue(dc, evr, value); err != nil {
+			return err
+		}
+		doc = append(doc, bson.DocElem{Name: name, Value: value.Interface()})
+	}
+	val.Set(reflect.ValueOf(doc).Convert(val.Type()))
+	return nil
+}
+
+func encodeRaw(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	raw := val.Interface().(bson.Raw)
+	kind := raw.Kind
+	if kind == 0 {
+		kind = byte(bsontype.EmbeddedDocument)
+	}
+	return bsonrw.Copier{}.CopyValueFromBytes(vw, bsontype.Type(kind), raw.Data)
+}
+
+func decodeRaw(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	value, err := readValue(vr)
+	if err != nil {
+		return err
+	}
+	val.Set(reflect.ValueOf(bson.Raw{Kind: byte(value.Type), Data: value.Value}))
+	return nil
+}
+
+// encodeRawD stores the raw elements of a bson.RawD as a document
+func encodeRawD(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	dw, err := vw.WriteDocument()
+	if err != nil {
+		return err
+	}
+	for _, elem := range val.Interface().(bson.RawD) {
+		evw, err := dw.WriteDocumentElement(elem.Name)
+		if err != nil {
+			return err
+		}
+		if err := encodeRaw(ec, evw, reflect.ValueOf(elem.Value)); err != nil {
+			return err
+		}
+	}
+	return dw.WriteDocumentEnd()
+}
+
+// decodeRawD loads the elements of a document as raw values, converted only
+// when unmarshalled
+func decodeRawD(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	switch vr.Type() {
+	case bsontype.Null:
+		val.Set(reflect.Zero(val.Type()))
+		return vr.ReadNull()
+	case bsontype.Type(0), bsontype.EmbeddedDocument:
+	default:
+		return decodeError(vr.Type(), val)
+	}
+
+	dr, err := vr.ReadDocument()
+	if err != nil {
+		return err
+	}
+	doc := bson.RawD{}
+	for {
+		name, evr, err := dr.ReadElement()
+		if errors.Is(err, bsonrw.ErrEOD) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		value, err := readValue(evr)
+		if err != nil {
+			return err
+		}
+		doc = append(doc, bson.RawDocElem{Name: name, Value: bson.Raw{Kind: byte(value.Type), Data: value.Value}})
+	}
+	val.Set(reflect.ValueOf(doc))
+	return nil
+}
+
+func encodeBinary(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	binary := val.Interface().(bson.Binary)
+	return vw.WriteBinaryWithSubtype(binary.Data, binary.Kind)
+}
+
+func decodeBinary(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	switch vr.Type() {
+	case bsontype.Null:
+		val.Set(reflect.Zero(val.Type()))
+		return vr.ReadNull()
+	case bsontype.Binary:
+		data, kind, err := vr.ReadBinary()
+		if err != nil {
+			return err
+		}
+		val.Set(reflect.ValueOf(bson.Binary{Kind: kind, Data: data}))
+		return nil
+	}
+	return decodeError(vr.Type(), val)
+}
+
+// encodeRegEx stores regular expressions with their options sorted, as the
+// server expects
+func encodeRegEx(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	regex := val.Interface().(bson.RegEx)
+	options := []rune(regex.Options)
+	sort.Slice(options, func(i, j int) bool { return options[i] < options[j] })
+	return vw.WriteRegex(regex.Pattern, string(options))
+}
+
+func decodeRegEx(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	switch vr.Type() {
+	case bsontype.Null:
+		val.Set(reflect.Zero(val.Type()))
+		return vr.ReadNull()
+	case bsontype.Regex:
+		pattern, options, err := vr.ReadRegex()
+		if err != nil {
+			return err
+		}
+		val.Set(reflect.ValueOf(bson.RegEx{Pattern: pattern, Options: options}))
+		return nil
+	}
+	return decodeError(vr.Type(), val)
+}
+
+// encodeJavaScript stores JavaScript code with its scope, if any
+func encodeJavaScript(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	js := val.Interface().(bson.JavaScript)
+	if js.Scope == nil {
+		return vw.WriteJavascript(js.Code)
+	}
+	scope, err := officialBson.MarshalWithRegistry(ec.Registry, js.Scope)
+	if err != nil {
+		return err
+	}
+	dw, err := vw.WriteCodeWithScope(js.Code)
+	if err != nil {
+		return err
+	}
+	if err := (bsonrw.Copier{}).CopyBytesToDocumentWriter(dw, scope); err != nil {
+		return err
+	}
+	return dw.WriteDocumentEnd()
+}
+
+// decodeJavaScript loads JavaScript code, with its scope as a bson.M
+func decodeJavaScript(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	switch vr.Type() {
+	case bsontype.Null:
+		val.Set(reflect.Zero(val.Type()))
+		return vr.ReadNull()
+	case bsontype.JavaScript:
+		code, err := vr.ReadJavascript()
+		if err != nil {
+			return err
+		}
+		val.Set(reflect.ValueOf(bson.JavaScript{Code: code}))
+		return nil
+	case bsontype.CodeWithScope:
+		value, err := readValue(vr)
+		if err != nil {
+			return err
+		}
+		code, scopeDoc := value.CodeWithScope()
+		scope := bson.M{}
+		if err := officialBson.UnmarshalWithContext(dc, scopeDoc, &scope); err != nil {
+			return err
+		}
+		val.Set(reflect.ValueOf(bson.JavaScript{Code: code, Scope: scope}))
+		return nil
+	}
+	return decodeError(vr.Type(), val)
+}
+
+func encodeDecimal128(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	d, err := primitive.ParseDecimal128(val.Interface().(bson.Decimal128).String())
+	if err != nil {
+		return err
+	}
+	return vw.WriteDecimal128(d)
+}
+
+func decodeDecimal128(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	switch vr.Type() {
+	case bsontype.Null:
+		val.Set(reflect.Zero(val.Type()))
+		return vr.ReadNull()
+	case bsontype.Decimal128:
+		d, err := vr.ReadDecimal128()
+		if err != nil {
+			return err
+		}
+		dec, err := bson.ParseDecimal128(d.String())
+		if err != nil {
+			return err
+		}
+		val.Set(reflect.ValueOf(dec))
+		return nil
+	}
+	return decodeError(vr.Type(), val)
+}
+
+func encodeDBPointer(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	pointer := val.Interface().(bson.DBPointer)
+	if len(pointer.Id) != 12 {
+		return fmt.Errorf("ObjectIDs must be exactly 12 bytes long (got %d)", len(pointer.Id))
+	}
+	var oid primitive.ObjectID
+	copy(oid[:], pointer.Id)
+	return vw.WriteDBPointer(pointer.Namespace, oid)
+}
+
+func decodeDBPointer(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	switch vr.Type() {
+	case bsontype.Null:
+		val.Set(reflect.Zero(val.Type()))
+		return vr.ReadNull()
+	case bsontype.DBPointer:
+		ns, oid, err := vr.ReadDBPointer()
+		if err != nil {
+			return err
+		}
+		val.Set(reflect.ValueOf(bson.DBPointer{Namespace: ns, Id: bson.ObjectId(oid[:])}))
+		return nil
+	}
+	return decodeError(vr.Type(), val)
+}
+
+func encodeTimestamp(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	ts := officialTimestamp(bson.MongoTimestamp(val.Int()))
+	return vw.WriteTimestamp(ts.T, ts.I)
+}
+
+// decodeTimestamp loads timestamps, and numbers as bson.MongoTimestamp does
+func decodeTimestamp(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if vr.Type() != bsontype.Timestamp {
+		return decodeInt(dc, vr, val)
+	}
+	t, i, err := vr.ReadTimestamp()
+	if err != nil {
+		return err
+	}
+	val.SetInt(int64(mongoTimestamp(primitive.Timestamp{T: t, I: i})))
+	return nil
+}
+
+func encodeOrderKey(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	if val.Int() == int64(bson.MaxKey) {
+		return vw.WriteMaxKey()
+	}
+	return vw.WriteMinKey()
+}
+
+func decodeOrderKey(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	switch vr.Type() {
+	case bsontype.MinKey:
+		val.Set(reflect.ValueOf(bson.MinKey))
+		return vr.ReadMinKey()
+	case bsontype.MaxKey:
+		val.Set(reflect.ValueOf(bson.MaxKey))
+		return vr.ReadMaxKey()
+	}
+	return decodeError(vr.Type(), val)
+}
+
+func encodeUndefined(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, _ reflect.Value) error {
+	return vw.WriteUndefined()
+}
+
+func decodeUndefined(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	switch vr.Type() {
+	case bsontype.Null:
+		return vr.ReadNull()
+	case bsontype.Undefined:
+		return vr.ReadUndefined()
+	}
+	return decodeError(vr.Type(), val)
+}
+
+// encodeDuration stores durations as int64 milliseconds
+func encodeDuration(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	return vw.WriteInt64(val.Int() / int64(time.Millisecond))
+}
+
+// decodeDuration loads int64 values as milliseconds and other numbers as
+// nanoseconds, as the bson package does
+func decodeDuration(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if vr.Type() != bsontype.Int64 {
+		return decodeInt(dc, vr, val)
+	}
+	ms, err := vr.ReadInt64()
+	if err != nil {
+		return err
+	}
+	val.SetInt(ms * int64(time.Millisecond))
+	return nil
+}
+
+// decodeInterface loads values into interface{} with the types the bson
+// package gives them, leaving those with a type map entry to the driver
+func decodeInterface(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if !val.CanSet() || val.Type() != tEmpty {
+		return bsoncodec.ValueDecoderError{Name: "decodeInterface", Types: []reflect.Type{tEmpty}, Received: val}
+	}
+
+	switch vr.Type() {
+	case bsontype.Null:
+		val.Set(reflect.Zero(tEmpty))
+		return vr.ReadNull()
+	case bsontype.Undefined:
+		val.Set(reflect.ValueOf(bson.Undefined))
+		return vr.ReadUndefined()
+	case bsontype.Binary:
+		data, kind, err := vr.ReadBinary()
+		if err != nil {
+			return err
+		}
+		if kind == bsontype.BinaryGeneric || kind == bsontype.BinaryBinaryOld {
+			val.Set(reflect.ValueOf(data))
+		} else {
+			val.Set(reflect.ValueOf(bson.Binary{Kind: kind, Data: data}))
+		}
+		return nil
+	}
+	return emptyInterfaceCodec.DecodeValue(dc, vr, val)
+}
+
+// encodeGetter stores the value returned by GetBSON
+func encodeGetter(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	if val.Kind() == reflect.Ptr && val.IsNil() {
+		return vw.WriteNull()
+	}
+	if !val.Type().Implements(tGetter) {
+		// Addressable value whose pointer implements bson.Getter
+		val = val.Addr()
+	}
+	value, err := val.Interface().(bson.Getter).GetBSON()
+	if err != nil {
+		return err
+	}
+	return encodeValue(ec, vw, value)
+}
+
+// decodeSetter hands the raw value to SetBSON. As with the bson package, a
+// bson.ErrSetZero result zeroes the value and a *bson.TypeError leaves it
+// unchanged.
+func decodeSetter(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	value, err := readValue(vr)
+	if err != nil {
+		return err
+	}
+	if val.Kind() == reflect.Ptr && val.IsNil() {
+		val.Set(reflect.New(val.Type().Elem()))
+	}
+	setter := val
+	if !val.Type().Implements(tSetter) {
+		// Addressable value whose pointer implements bson.Setter
+		setter = val.Addr()
+	}
+
+	err = setter.Interface().(bson.Setter).SetBSON(bson.Raw{Kind: byte(value.Type), Data: value.Value})
+	if err == bson.ErrSetZero {
+		val.Set(reflect.Zero(val.Type()))
+		return nil
+	}
+	var typeErr *bson.TypeError
+	if errors.As(err, &typeErr) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,722 @@
+package mgo
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kinfkong/modern-mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type codecInner struct {
+	Name  string `bson:"name"`
+	Count int    `bson:"count,omitempty"`
+}
+
+type codecDoc struct {
+	Id        bson.ObjectId       `bson:"_id"`
+	Name      string              `bson:"name"`
+	Int       int                 `bson:"int"`
+	Int64     int64               `bson:"int64"`
+	Small     int64               `bson:"small,minsize"`
+	Uint      uint                `bson:"uint"`
+	Uint32    uint32              `bson:"uint32"`
+	Float     float64             `bson:"float"`
+	Flag      bool                `bson:"flag"`
+	When      time.Time           `bson:"when"`
+	Times     []time.Time         `bson:"times"`
+	Timeout   time.Duration       `bson:"timeout"`
+	Tags      []string            `bson:"tags"`
+	NilTags   []string            `bson:"nilTags"`
+	NilMap    map[string]int      `bson:"nilMap"`
+	Data      []byte              `bson:"data"`
+	Inner     codecInner          `bson:"inner"`
+	Empty     codecInner          `bson:"empty,omitempty"`
+	Ptr       *codecInner         `bson:"ptr"`
+	M         bson.M              `bson:"m"`
+	D         bson.D              `bson:"d"`
+	Any       interface{}         `bson:"any"`
+	Binary    bson.Binary         `bson:"binary"`
+	RegEx     bson.RegEx          `bson:"regex"`
+	Timestamp bson.MongoTimestamp `bson:"ts"`
+	Decimal   bson.Decimal128     `bson:"decimal"`
+	Symbol    bson.Symbol         `bson:"symbol"`
+	Code      bson.JavaScript     `bson:"code"`
+	Min       interface{}         `bson:"min"`
+	Skipped   string              `bson:"-"`
+}
+
+func newCodecDoc(t *testing.T) codecDoc {
+	decimal, err := bson.ParseDecimal128("12.50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	when := time.Date(2024, 5, 6, 7, 8, 9, 10e6, time.UTC)
+	return codecDoc{
+		Id:        bson.NewObjectId(),
+		Name:      "doc",
+		Int:       42,
+		Int64:     43,
+		Small:     44,
+		Uint:      45,
+		Uint32:    46,
+		Float:     1.5,
+		Flag:      true,
+		When:      when,
+		Times:     []time.Time{when, when.Add(time.Hour)},
+		Timeout:   1500 * time.Millisecond,
+		Tags:      []string{"a", "b"},
+		Data:      []byte{1, 2, 3},
+		Inner:     codecInner{Name: "inner", Count: 2},
+		Ptr:       &codecInner{Name: "ptr"},
+		M:         bson.M{"nested": bson.M{"x": 1}},
+		D:         bson.D{{Name: "z", Value: 1}, {Name: "a", Value: bson.D{{Name: "b", Value: "c"}}}},
+		Any:       []interface{}{"x", 2, bson.M{"y": true}},
+		Binary:    bson.Binary{Kind: 0x04, Data: []byte("0123456789abcdef")},
+		RegEx:     bson.RegEx{Pattern: "^a", Options: "xi"},
+		Timestamp: bson.MongoTimestamp(7<<32 | 3),
+		Decimal:   decimal,
+		Symbol:    "sym",
+		Code:      bson.JavaScript{Code: "function() { return x }", Scope: bson.M{"x": 1}},
+		Min:       bson.MinKey,
+	}
+}
+
+// TestCodecMatchesBSONPackage checks that mgoRegistry stores and loads
+// structs exactly like the mgo bson package
+func TestCodecMatchesBSONPackage(t *testing.T) {
+	doc := newCodecDoc(t)
+	doc.Skipped = "skipped"
+
+	encoded, ok := encodeStruct(doc)
+	if !ok {
+		t.Fatal("Expected the struct to be encoded with the registry")
+	}
+	raw, ok := encoded.(officialBson.Raw)
+	if !ok {
+		t.Fatalf("Expected officialBson.Raw, got %T", encoded)
+	}
+	legacy, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, legacy) {
+		t.Errorf("Registry encoding differs from the bson package:\n%v\n%v", raw, officialBson.Raw(legacy))
+	}
+
+	var got, want codecDoc
+	if err := decodeDocument(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := bson.Unmarshal(legacy, &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Registry decoding differs from the bson package:\n%#v\n%#v", got, want)
+	}
+	if got.Skipped != "" || got.NilTags == nil || len(got.NilTags) != 0 {
+		t.Errorf("Unexpected skipped or nil fields: %q, %#v", got.Skipped, got.NilTags)
+	}
+}
+
+// TestCodecDecodesIntoInterface checks the types of values loaded into
+// interface{} fields
+func TestCodecDecodesIntoInterface(t *testing.T) {
+	id := bson.NewObjectId()
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	data, err := bson.Marshal(bson.D{
+		{Name: "int32", Value: 1},
+		{Name: "int64", Value: int64(1) << 40},
+		{Name: "double", Value: 2.5},
+		{Name: "doc", Value: bson.M{"a": 1}},
+		{Name: "array", Value: []interface{}{1, "b"}},
+		{Name: "id", Value: id},
+		{Name: "time", Value: when},
+		{Name: "zeroTime", Value: time.Time{}},
+		{Name: "generic", Value: []byte("raw")},
+		{Name: "uuid", Value: bson.Binary{Kind: 0x04, Data: []byte("0123456789abcdef")}},
+		{Name: "null", Value: nil},
+		{Name: "ts", Value: bson.MongoTimestamp(5)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Int32    interface{} `bson:"int32"`
+		Int64    interface{} `bson:"int64"`
+		Double   interface{} `bson:"double"`
+		Doc      interface{} `bson:"doc"`
+		Array    interface{} `bson:"array"`
+		Id       interface{} `bson:"id"`
+		Time     interface{} `bson:"time"`
+		ZeroTime interface{} `bson:"zeroTime"`
+		Generic  interface{} `bson:"generic"`
+		UUID     interface{} `bson:"uuid"`
+		Null     interface{} `bson:"null"`
+		Ts       interface{} `bson:"ts"`
+	}
+	doc.Null = "previous"
+	if err := decodeDocument(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"int32", doc.Int32, 1},
+		{"int64", doc.Int64, int64(1) << 40},
+		{"double", doc.Double, 2.5},
+		{"doc", doc.Doc, bson.M{"a": 1}},
+		{"array", doc.Array, []interface{}{1, "b"}},
+		{"id", doc.Id, id},
+		{"time", doc.Time, when},
+		{"zeroTime", doc.ZeroTime, time.Time{}},
+		{"generic", doc.Generic, []byte("raw")},
+		{"uuid", doc.UUID, bson.Binary{Kind: 0x04, Data: []byte("0123456789abcdef")}},
+		{"null", doc.Null, nil},
+		{"ts", doc.Ts, bson.MongoTimestamp(5)},
+	}
+	for _, check := range checks {
+		if !reflect.DeepEqual(check.got, check.want) {
+			t.Errorf("%s: expected %#v, got %#v", check.name, check.want, check.got)
+		}
+	}
+
+	// Documents nested in bson.D values are loaded as bson.D
+	var ordered struct {
+		D bson.D `bson:"d"`
+	}
+	data, err = bson.Marshal(bson.M{"d": bson.D{{Name: "b", Value: bson.D{{Name: "c", Value: 1}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := decodeDocument(data, &ordered); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ordered.D[0].Value.(bson.D); !ok {
+		t.Errorf("Expected a nested bson.D, got %T", ordered.D[0].Value)
+	}
+}
+
+type codecCelsius float64
+
+func (c codecCelsius) GetBSON() (interface{}, error) {
+	return bson.M{"celsius": float64(c)}, nil
+}
+
+func (c *codecCelsius) SetBSON(raw bson.Raw) error {
+	var doc struct {
+		Celsius *float64 `bson:"celsius"`
+	}
+	if err := raw.Unmarshal(&doc); err != nil {
+		return err
+	}
+	if doc.Celsius == nil {
+		return bson.ErrSetZero
+	}
+	*c = codecCelsius(*doc.Celsius)
+	return nil
+}
+
+type codecFailing struct{}
+
+func (codecFailing) GetBSON() (interface{}, error) {
+	return nil, errors.New("no value")
+}
+
+// TestCodecGetterSetter checks that bson.Getter and bson.Setter
+// implementations are used when storing and loading fields
+func TestCodecGetterSetter(t *testing.T) {
+	type reading struct {
+		Temp    codecCelsius  `bson:"temp"`
+		TempPtr *codecCelsius `bson:"tempPtr"`
+		Zeroed  codecCelsius  `bson:"zeroed"`
+	}
+	temp := codecCelsius(21.5)
+	encoded, ok := encodeStruct(reading{Temp: 20, TempPtr: &temp})
+	if !ok {
+		t.Fatal("Expected the struct to be encoded with the registry")
+	}
+	raw := encoded.(officialBson.Raw)
+	if value := raw.Lookup("temp", "celsius"); value.Double() != 20 {
+		t.Errorf("Expected GetBSON to provide the stored value, got %v", raw)
+	}
+
+	// Zeroed holds no celsius field once stored through a bson.M
+	data, err := bson.Marshal(bson.M{"temp": bson.M{"celsius": 18.0}, "tempPtr": bson.M{"celsius": 19.0}, "zeroed": bson.M{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := reading{Zeroed: 5}
+	if err := decodeDocument(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Temp != 18 || got.TempPtr == nil || *got.TempPtr != 19 || got.Zeroed != 0 {
+		t.Errorf("Unexpected SetBSON results: %v, %v, %v", got.Temp, got.TempPtr, got.Zeroed)
+	}
+
+	// Getter errors leave the struct to the bson package, which reports them
+	if _, ok := encodeStruct(struct {
+		F codecFailing `bson:"f"`
+	}{}); ok {
+		t.Error("Expected a failing GetBSON to prevent the registry encoding")
+	}
+}
+
+// TestDecodeDocumentFallback checks that documents whose values do not fit
+// the struct fields are loaded as the bson package does, skipping them
+func TestDecodeDocumentFallback(t *testing.T) {
+	data, err := bson.Marshal(bson.M{"name": "doc", "count": "many"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := codecInner{Count: 3}
+	if err := decodeDocument(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "doc" || got.Count != 0 {
+		t.Errorf("Expected the mismatched field to be skipped, got %+v", got)
+	}
+
+	// Non-struct results keep the bson package types
+	var m bson.M
+	if err := decodeDocument(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["name"] != "doc" || m["count"] != "many" {
+		t.Errorf("Unexpected map result: %v", m)
+	}
+}
+
+type codecRawSetter struct {
+	raw bson.Raw
+}
+
+func (s *codecRawSetter) SetBSON(raw bson.Raw) error {
+	s.raw = raw
+	return nil
+}
+
+// TestCodecRawDocuments checks that whole documents are handed to bson.Raw
+// and bson.Setter results as embedded documents
+func TestCodecRawDocuments(t *testing.T) {
+	data, err := bson.Marshal(bson.M{"a": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw bson.Raw
+	if err := decodeDocument(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw.Kind != 0x03 || !bytes.Equal(raw.Data, data) {
+		t.Errorf("Unexpected raw document: %#v", raw)
+	}
+	var setter codecRawSetter
+	if err := decodeDocument(data, &setter); err != nil {
+		t.Fatal(err)
+	}
+	if setter.raw.Kind != 0x03 || !bytes.Equal(setter.raw.Data, data) {
+		t.Errorf("Unexpected document handed to SetBSON: %#v", setter.raw)
+	}
+}
+
+// TestCodecLazyDocuments checks that raw results keep the document bytes,
+// converted only when unmarshalled
+func TestCodecLazyDocuments(t *testing.T) {
+	data, err := bson.Marshal(bson.D{
+		{Name: "status", Value: "done"},
+		{Name: "payload", Value: bson.M{"items": []int{1, 2, 3}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The driver may reuse the buffer once the document is decoded
+	buf := append([]byte(nil), data...)
+	var raw bson.Raw
+	if err := decodeDocument(buf, &raw); err != nil {
+		t.Fatal(err)
+	}
+	for i := range buf {
+		buf[i] = 0
+	}
+	var full bson.M
+	if err := raw.Unmarshal(&full); err != nil || full["status"] != "done" {
+		t.Errorf("Unexpected raw document: %v, %v", full, err)
+	}
+
+	var official officialBson.Raw
+	if err := decodeDocument(data, &official); err != nil {
+		t.Fatal(err)
+	}
+	if status := official.Lookup("status").StringValue(); status != "done" {
+		t.Errorf("Expected done, got %q", status)
+	}
+
+	var rawD bson.RawD
+	if err := decodeDocument(data, &rawD); err != nil {
+		t.Fatal(err)
+	}
+	if len(rawD) != 2 || rawD[0].Name != "status" || rawD[1].Value.Kind != 0x03 {
+		t.Fatalf("Unexpected raw elements: %v", rawD)
+	}
+	var status string
+	if err := rawD[0].Value.Unmarshal(&status); err != nil || status != "done" {
+		t.Errorf("Expected done, got %q, %v", status, err)
+	}
+
+	// Struct fields of type bson.Raw keep their value undecoded
+	var partial struct {
+		Status  string   `bson:"status"`
+		Payload bson.Raw `bson:"payload"`
+	}
+	if err := decodeDocument(data, &partial); err != nil {
+		t.Fatal(err)
+	}
+	var payload struct {
+		Items []int `bson:"items"`
+	}
+	if err := partial.Payload.Unmarshal(&payload); err != nil || len(payload.Items) != 3 {
+		t.Errorf("Unexpected payload: %v, %v", payload, err)
+	}
+
+	// Raw documents are written back unchanged
+	converted, ok := convertMGOToOfficial(rawD).(officialBson.Raw)
+	if !ok || !bytes.Equal(converted, data) {
+		t.Errorf("Expected the raw document to be written back, got %v", converted)
+	}
+}
+
+// TestCommandReplyTimestamps checks the cluster time fields of a command
+// reply come back as bson.MongoTimestamp, as Run decodes them
+func TestCommandReplyTimestamps(t *testing.T) {
+	opTime := primitive.Timestamp{T: 1700000000, I: 4}
+	raw, _ := officialBson.Marshal(officialBson.D{
+		{Key: "ok", Value: 1.0},
+		{Key: "$clusterTime", Value: officialBson.D{{Key: "clusterTime", Value: opTime}}},
+		{Key: "operationTime", Value: opTime},
+		{Key: "optimes", Value: officialBson.A{opTime}},
+	})
+	want := mongoTimestamp(opTime)
+
+	var reply bson.M
+	if err := decodeDocument(raw, &reply); err != nil {
+		t.Fatalf("Failed to decode reply: %v", err)
+	}
+	if got, ok := reply["operationTime"].(bson.MongoTimestamp); !ok || got != want {
+		t.Errorf("Expected operationTime %v, got %#v", want, reply["operationTime"])
+	}
+	if got := reply["$clusterTime"].(bson.M)["clusterTime"]; got != want {
+		t.Errorf("Expected clusterTime %v, got %#v", want, got)
+	}
+	if got := reply["optimes"].([]interface{})[0]; got != want {
+		t.Errorf("Expected %v in the array, got %#v", want, got)
+	}
+
+	var typed struct {
+		OperationTime bson.MongoTimestamp `bson:"operationTime"`
+		ClusterTime   struct {
+			Time primitive.Timestamp `bson:"clusterTime"`
+		} `bson:"$clusterTime"`
+	}
+	if err := decodeDocument(raw, &typed); err != nil {
+		t.Fatalf("Failed to decode typed reply: %v", err)
+	}
+	if typed.OperationTime != want || typed.ClusterTime.Time != opTime {
+		t.Errorf("Unexpected typed reply %+v", typed)
+	}
+
+	// The conversion is reversible for the timestamps sent back in commands
+	if back := officialTimestamp(typed.OperationTime); back != opTime {
+		t.Errorf("Expected %+v back, got %+v", opTime, back)
+	}
+}
+
+// TestCodecUUID checks UUIDs are stored as binary data of subtype 4 in
+// documents and filters, and load back into UUID fields
+func TestCodecUUID(t *testing.T) {
+	type account struct {
+		Id     bson.UUID  `bson:"_id"`
+		Parent *bson.UUID `bson:"parent"`
+		Name   string     `bson:"name"`
+	}
+	id, parent := bson.NewUUID(), bson.NewUUID()
+	encoded := convertMGOToOfficial(account{Id: id, Parent: &parent, Name: "a"})
+	raw, ok := encoded.(officialBson.Raw)
+	if !ok {
+		t.Fatalf("Expected a raw document, got %T", encoded)
+	}
+	for _, key := range []string{"_id", "parent"} {
+		subtype, data, ok := raw.Lookup(key).BinaryOK()
+		if !ok || subtype != bson.BinaryUUID || len(data) != 16 {
+			t.Errorf("Expected %s stored as a UUID, got %v", key, raw.Lookup(key))
+		}
+	}
+
+	var back account
+	if err := decodeDocument(raw, &back); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if back.Id != id || back.Parent == nil || *back.Parent != parent {
+		t.Errorf("Expected the UUIDs back, got %+v", back)
+	}
+
+	// Filters hold the driver binary value
+	filter := convertMGOToOfficial(bson.M{"_id": id}).(officialBson.M)
+	if want := (primitive.Binary{Subtype: bson.BinaryUUID, Data: id[:]}); !reflect.DeepEqual(filter["_id"], want) {
+		t.Errorf("Expected %v in the filter, got %#v", want, filter["_id"])
+	}
+}
+
+// TestLegacyTypesRoundTrip checks JavaScript, symbols and DBPointers read
+// into a bson.M keep their BSON types when the document is written back
+func TestLegacyTypesRoundTrip(t *testing.T) {
+	oid := primitive.NewObjectID()
+	raw, _ := officialBson.Marshal(officialBson.D{
+		{Key: "code", Value: primitive.JavaScript("return 1")},
+		{Key: "scoped", Value: primitive.CodeWithScope{
+			Code:  "return x",
+			Scope: officialBson.D{{Key: "x", Value: int32(1)}},
+		}},
+		{Key: "symbol", Value: primitive.Symbol("active")},
+		{Key: "pointer", Value: primitive.DBPointer{DB: "app.users", Pointer: oid}},
+		{Key: "symbols", Value: officialBson.A{primitive.Symbol("a")}},
+	})
+
+	var doc bson.M
+	if err := decodeDocument(raw, &doc); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	want := bson.M{
+		"code":    bson.JavaScript{Code: "return 1"},
+		"scoped":  bson.JavaScript{Code: "return x", Scope: bson.M{"x": 1}},
+		"symbol":  bson.Symbol("active"),
+		"pointer": bson.DBPointer{Namespace: "app.users", Id: bson.ObjectId(oid[:])},
+		"symbols": []interface{}{bson.Symbol("a")},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("Expected %#v, got %#v", want, doc)
+	}
+
+	data, err := officialBson.Marshal(convertMGOToOfficial(doc))
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	written := officialBson.Raw(data)
+	for key, kind := range map[string]bsontype.Type{
+		"code":    bsontype.JavaScript,
+		"scoped":  bsontype.CodeWithScope,
+		"symbol":  bsontype.Symbol,
+		"pointer": bsontype.DBPointer,
+	} {
+		if got := written.Lookup(key).Type; got != kind {
+			t.Errorf("Expected %s written as %v, got %v", key, kind, got)
+		}
+	}
+	if got := written.Lookup("symbols", "0").Type; got != bsontype.Symbol {
+		t.Errorf("Expected array symbols written as symbols, got %v", got)
+	}
+	if ns, ptr, _ := written.Lookup("pointer").DBPointerOK(); ns != "app.users" || ptr != oid {
+		t.Errorf("Expected the pointer back, got %s %v", ns, ptr)
+	}
+}
+
+// TestRawInputs checks raw documents can be given as filters, updates and
+// inserted documents, and that raw updates keep their operators
+func TestRawInputs(t *testing.T) {
+	data, err := bson.Marshal(bson.M{"$set": bson.M{"a": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := bson.Raw{Kind: bson.ElementDocument, Data: data}
+	converted := convertMGOToOfficial(wrapInSetOperator(update))
+	doc, ok := converted.(officialBson.Raw)
+	if !ok || !bytes.Equal(doc, data) {
+		t.Errorf("Expected the raw update unchanged, got %#v", converted)
+	}
+	// Marshalled as the driver does for filters and inserted documents
+	if _, err := officialBson.Marshal(convertMGOToOfficial(&update)); err != nil {
+		t.Errorf("Failed to marshal a raw document: %v", err)
+	}
+
+	// Raw documents without operators replace the fields they hold
+	plain, _ := bson.Marshal(bson.M{"a": 2})
+	wrapped := wrapInSetOperator(bson.Raw{Kind: bson.ElementDocument, Data: plain})
+	if _, ok := wrapped.(bson.M)["$set"]; !ok {
+		t.Errorf("Expected a plain raw document wrapped in $set, got %#v", wrapped)
+	}
+
+	// Ordered and raw documents are checked for operators like maps
+	for _, update := range []interface{}{
+		bson.D{{Name: "$inc", Value: bson.M{"n": 1}}},
+		bson.RawD{{Name: "$inc", Value: bson.Raw{Kind: bson.ElementDocument, Data: plain}}},
+		officialBson.D{{Key: "$inc", Value: officialBson.M{"n": 1}}},
+	} {
+		if !hasUpdateOperators(update) {
+			t.Errorf("Expected operators found in %#v", update)
+		}
+	}
+
+	// Raw values other than documents keep their type
+	array, _ := officialBson.Marshal(officialBson.M{"v": officialBson.A{1, 2}})
+	value := officialBson.Raw(array).Lookup("v")
+	filter := convertMGOToOfficial(bson.M{"v": bson.Raw{Kind: byte(value.Type), Data: value.Value}})
+	encoded, err := officialBson.Marshal(filter)
+	if err != nil {
+		t.Fatalf("Failed to marshal a raw array: %v", err)
+	}
+	if got := officialBson.Raw(encoded).Lookup("v").Type; got != bsontype.Array {
+		t.Errorf("Expected the raw array kept, got %v", got)
+	}
+}
+
+// TestDecodeDocumentMGOTypes checks documents decoded into bson.M and
+// interface{} hold the types the bson package loads at any depth, with no
+// value of the driver's types left
+func TestDecodeDocumentMGOTypes(t *testing.T) {
+	id := bson.NewObjectId()
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	dec, err := bson.ParseDecimal128("1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := bson.M{
+		"id":      id,
+		"time":    when,
+		"generic": []byte("raw"),
+		"uuid":    bson.Binary{Kind: 0x04, Data: []byte("0123456789abcdef")},
+		"dec":     dec,
+		"regex":   bson.RegEx{Pattern: "^a", Options: "i"},
+		"ts":      bson.MongoTimestamp(5),
+		"min":     bson.MinKey,
+		"max":     bson.MaxKey,
+		"undef":   bson.Undefined,
+		"null":    nil,
+		"int32":   1,
+		"int64":   int64(1) << 40,
+	}
+	data, err := bson.Marshal(bson.M{
+		"leaves": leaves,
+		"nested": []interface{}{[]interface{}{leaves}, bson.M{"deeper": []interface{}{leaves}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want bson.M
+	if err := bson.Unmarshal(data, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc bson.M
+	if err := decodeDocument(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("Expected %#v, got %#v", want, doc)
+	}
+
+	var value interface{}
+	if err := decodeDocument(data, &value); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(value, want) {
+		t.Errorf("Expected %#v, got %#v", want, value)
+	}
+}
+
+type codecZeroer struct {
+	Set bool `bson:"set"`
+}
+
+// IsZero reports true for set values too, which only the driver consults
+func (z codecZeroer) IsZero() bool { return true }
+
+type codecZeroValues struct {
+	Id           bson.ObjectId   `bson:"_id,omitempty"`
+	NilSlice     []string        `bson:"nilSlice"`
+	EmptySlice   []string        `bson:"emptySlice"`
+	EmptySliceOE []string        `bson:"emptySliceOE,omitempty"`
+	NilMap       map[string]int  `bson:"nilMap"`
+	EmptyMapOE   map[string]int  `bson:"emptyMapOE,omitempty"`
+	NilBytes     []byte          `bson:"nilBytes"`
+	Time         time.Time       `bson:"time"`
+	TimeOE       time.Time       `bson:"timeOE,omitempty"`
+	Ptr          *int            `bson:"ptr"`
+	PtrOE        *int            `bson:"ptrOE,omitempty"`
+	StructOE     struct{ A int } `bson:"structOE,omitempty"`
+	StrOE        string          `bson:"strOE,omitempty"`
+}
+
+type codecZeroerValues struct {
+	Name   string              `bson:"name"`
+	Zeroer codecZeroer         `bson:"zeroer,omitempty"`
+	UUID   bson.UUID           `bson:"uuid,omitempty"`
+	Empty  [0]int              `bson:"empty,omitempty"`
+	Nested []codecZeroerValues `bson:"nested"`
+}
+
+// TestZeroValueEncoding checks zero values and omitempty fields are encoded
+// as the bson package does
+func TestZeroValueEncoding(t *testing.T) {
+	inputs := []interface{}{
+		codecZeroValues{EmptySlice: []string{}, EmptySliceOE: []string{}, EmptyMapOE: map[string]int{}},
+		codecZeroerValues{Zeroer: codecZeroer{Set: true}},
+		bson.M{
+			"nilSlice": []string(nil),
+			"nilMap":   map[string]int(nil),
+			"bytes":    []byte{1, 2},
+			"nilBytes": []byte(nil),
+			"ids":      map[string]bson.ObjectId{"a": bson.NewObjectId()},
+			"time":     time.Time{},
+			"doc":      codecZeroValues{},
+		},
+	}
+	for _, input := range inputs {
+		want, err := bson.Marshal(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := officialBson.Marshal(convertMGOToOfficial(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, wantRaw := officialBson.Raw(data), officialBson.Raw(want)
+		wantElems, _ := wantRaw.Elements()
+		gotElems, _ := got.Elements()
+		if len(gotElems) != len(wantElems) {
+			t.Errorf("%T: expected %v, got %v", input, wantRaw, got)
+			continue
+		}
+		for _, elem := range wantElems {
+			if value, err := got.LookupErr(elem.Key()); err != nil || !value.Equal(elem.Value()) {
+				t.Errorf("%T: expected %s to be %v, got %v", input, elem.Key(), elem.Value(), value)
+			}
+		}
+	}
+
+	// Only structs with omitempty values the driver judges differently leave
+	// mgoRegistry
+	if !omitsEmptyLikeMGO(reflect.TypeOf(codecZeroValues{})) {
+		t.Error("Expected codecZeroValues to be encoded with mgoRegistry")
+	}
+	if omitsEmptyLikeMGO(reflect.TypeOf([]codecZeroerValues{})) {
+		t.Error("Expected codecZeroerValues to be encoded by the bson package")
+	}
+
+	// Where the bson package fails on ObjectIds not 12 bytes long, they are
+	// stored as strings
+	var noId struct {
+		Id bson.ObjectId `bson:"id"`
+	}
+	raw, ok := convertMGOToOfficial(noId).(officialBson.Raw)
+	if value, err := raw.LookupErr("id"); !ok || err != nil || value.Type != bsontype.String {
+		t.Errorf("Expected an empty ObjectId stored as a string, got %v", raw)
+	}
+}
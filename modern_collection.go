@@ -4,33 +4,67 @@ package mgo
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/globalsign/mgo/bson"
+	"github.com/kinfkong/modern-mgo/bson"
 	officialBson "go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	mongodrv "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 // Insert inserts documents (mgo API compatible)
 func (c *ModernColl) Insert(docs ...interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.session.operationContext(opWrite, 10*time.Second)
 	defer cancel()
 
+	convertedDocs := prepareInsertDocs(docs)
+	c.noteWrite()
+	if len(convertedDocs) == 1 {
+		opts := &options.InsertOneOptions{BypassDocumentValidation: c.bypassValidation()}
+		_, err := c.coll().InsertOne(ctx, convertedDocs[0], opts)
+		return convertError(err)
+	}
+	opts := &options.InsertManyOptions{BypassDocumentValidation: c.bypassValidation()}
+	_, err := c.coll().InsertMany(ctx, convertedDocs, opts)
+	return convertError(err)
+}
+
+// InsertUnordered inserts documents like Insert, but keeps inserting the
+// remaining documents when some of them fail, for example on duplicate keys.
+// The failures are reported in a *BulkError whose cases hold the position of
+// each failed document within docs.
+func (c *ModernColl) InsertUnordered(docs ...interface{}) error {
+	ctx, cancel := c.session.operationContext(opWrite, 10*time.Second)
+	defer cancel()
+
+	convertedDocs := prepareInsertDocs(docs)
+	c.noteWrite()
+	opts := options.InsertMany().SetOrdered(false)
+	opts.BypassDocumentValidation = c.bypassValidation()
+	_, err := c.coll().InsertMany(ctx, convertedDocs, opts)
+	var bulkErr mongodrv.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		return newBulkError(&bulkErr)
+	}
+	return convertError(err)
+}
+
+// prepareInsertDocs converts documents for insertion, giving an ObjectId _id
+// to those without one
+func prepareInsertDocs(docs []interface{}) []interface{} {
 	convertedDocs := make([]interface{}, len(docs))
 	for i, doc := range docs {
 		// Ensure document has a proper _id field
 		preparedDoc := ensureObjectId(doc)
 		convertedDocs[i] = convertMGOToOfficial(preparedDoc)
 	}
-	if len(convertedDocs) == 1 {
-		_, err := c.mgoColl.InsertOne(ctx, convertedDocs[0])
-		return err
-	}
-	_, err := c.mgoColl.InsertMany(ctx, convertedDocs)
-	return err
+	return convertedDocs
 }
 
 // Find creates a query (mgo API compatible)
@@ -50,28 +84,65 @@ func (c *ModernColl) Find(query interface{}) *ModernQ {
 	}
 }
 
-// Count counts documents
+// Count counts documents. Sessions with estimated counts enabled use
+// EstimatedCount instead.
 func (c *ModernColl) Count() (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if c.session.estimatedCounts() {
+		return c.EstimatedCount()
+	}
+
+	ctx, cancel := c.session.operationContext(opRead, 10*time.Second)
+	defer cancel()
+
+	var count int64
+	err := c.retryRead(ctx, func() (err error) {
+		count, err = c.readColl().CountDocuments(ctx, officialBson.M{})
+		return err
+	})
+	return int(count), err
+}
+
+// EstimatedCount returns the number of documents of the collection from its
+// metadata, without scanning it. See ModernMGO.SetEstimatedCounts for the
+// cases where the estimate may be inaccurate.
+func (c *ModernColl) EstimatedCount() (int, error) {
+	ctx, cancel := c.session.operationContext(opRead, 10*time.Second)
 	defer cancel()
 
-	count, err := c.mgoColl.CountDocuments(ctx, officialBson.M{})
+	var count int64
+	err := c.retryRead(ctx, func() (err error) {
+		count, err = c.readColl().EstimatedDocumentCount(ctx)
+		return err
+	})
 	return int(count), err
 }
 
-// Remove removes a document
+// Remove removes a document, returning ErrNotFound if none matches the
+// selector (mgo API compatible)
 func (c *ModernColl) Remove(selector interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.session.operationContext(opWrite, 10*time.Second)
 	defer cancel()
 
 	filter := convertMGOToOfficial(selector)
-	_, err := c.mgoColl.DeleteOne(ctx, filter)
-	return err
+	c.noteWrite()
+	result, err := c.coll().DeleteOne(ctx, filter)
+	if errors.Is(err, mongodrv.ErrUnacknowledgedWrite) {
+		// Unacknowledged writes report a zero count, and cannot be checked
+		return nil
+	}
+	if err = convertDeleteError(result, err); err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
-// Update updates a document
+// Update updates a document, returning ErrNotFound if none matches the
+// selector (mgo API compatible)
 func (c *ModernColl) Update(selector, update interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.session.operationContext(opWrite, 10*time.Second)
 	defer cancel()
 
 	filter := convertMGOToOfficial(selector)
@@ -79,25 +150,70 @@ func (c *ModernColl) Update(selector, update interface{}) error {
 	wrappedUpdate := wrapInSetOperator(update)
 	updateDoc := convertMGOToOfficial(wrappedUpdate)
 
-	_, err := c.mgoColl.UpdateOne(ctx, filter, updateDoc)
-	return err
+	c.noteWrite()
+	opts := &options.UpdateOptions{BypassDocumentValidation: c.bypassValidation()}
+	result, err := c.coll().UpdateOne(ctx, filter, updateDoc, opts)
+	if errors.Is(err, mongodrv.ErrUnacknowledgedWrite) {
+		// Unacknowledged writes report a zero count, and cannot be checked
+		return nil
+	}
+	if err = convertUpdateError(result, err); err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
-// EnsureIndex creates an index (mgo API compatible)
+// EnsureIndex creates an index (mgo API compatible). Indexes ensured
+// successfully are remembered by the session, and ensuring them again does
+// not reach the server until ResetIndexCache is called.
 func (c *ModernColl) EnsureIndex(index Index) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	return c.EnsureIndexes([]Index{index})
+}
 
-	// Use officialBson.D to maintain key order for index creation
-	var keys officialBson.D
-	for _, key := range index.Key {
-		order := 1
-		fieldName := key
-		if strings.HasPrefix(key, "-") {
-			order = -1
-			fieldName = key[1:]
+// EnsureIndexes creates several indexes with a single createIndexes command.
+// Indexes are cached by the session like with EnsureIndex, and only those not
+// ensured yet are sent to the server. None of them are cached on error.
+func (c *ModernColl) EnsureIndexes(indexes []Index) error {
+	cache := c.session.cachedIndexes()
+	var models []mongodrv.IndexModel
+	var cacheKeys []string
+	for _, index := range indexes {
+		model, err := newIndexModel(index)
+		if err != nil {
+			return err
+		}
+		cacheKey := c.fullName() + "\x00" + index.cacheKey()
+		if cache.has(cacheKey) {
+			continue
 		}
-		keys = append(keys, officialBson.E{Key: fieldName, Value: order})
+		models = append(models, model)
+		cacheKeys = append(cacheKeys, cacheKey)
+	}
+	if len(models) == 0 {
+		return nil
+	}
+
+	ctx, cancel := c.session.operationContext(opIndex, 30*time.Second)
+	defer cancel()
+
+	_, err := c.coll().Indexes().CreateMany(ctx, models)
+	if err = convertError(err); err != nil {
+		return err
+	}
+	for _, cacheKey := range cacheKeys {
+		cache.add(cacheKey)
+	}
+	return nil
+}
+
+// newIndexModel converts an mgo Index to the official driver IndexModel
+func newIndexModel(index Index) (mongodrv.IndexModel, error) {
+	keys, err := indexKeyDoc(index.Key)
+	if err != nil {
+		return mongodrv.IndexModel{}, err
 	}
 
 	indexOptions := &options.IndexOptions{
@@ -121,8 +237,147 @@ func (c *ModernColl) EnsureIndex(index Index) error {
 		indexModel.Options.ExpireAfterSeconds = &expireAfterSeconds
 	}
 
-	_, err := c.mgoColl.Indexes().CreateOne(ctx, indexModel)
-	return err
+	if index.Hidden {
+		indexOptions.SetHidden(true)
+	}
+	if index.Collation != nil {
+		indexOptions.SetCollation(convertCollation(index.Collation))
+	}
+	if len(index.PartialFilter) > 0 {
+		indexOptions.SetPartialFilterExpression(convertMGOToOfficial(index.PartialFilter))
+	}
+
+	// Geospatial index options; Minf and Maxf take precedence over Min and Max
+	if index.Minf != 0 || index.Maxf != 0 {
+		indexOptions.SetMin(index.Minf).SetMax(index.Maxf)
+	} else if index.Min != 0 || index.Max != 0 {
+		indexOptions.SetMin(float64(index.Min)).SetMax(float64(index.Max))
+	}
+	if index.Bits != 0 {
+		indexOptions.SetBits(int32(index.Bits))
+	}
+
+	// Text index options
+	if len(index.Weights) > 0 {
+		fields := make([]string, 0, len(index.Weights))
+		for field := range index.Weights {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		weights := officialBson.D{}
+		for _, field := range fields {
+			weights = append(weights, officialBson.E{Key: field, Value: index.Weights[field]})
+		}
+		indexOptions.Weights = weights
+	}
+	if index.DefaultLanguage != "" {
+		indexOptions.DefaultLanguage = &index.DefaultLanguage
+	}
+	if index.LanguageOverride != "" {
+		indexOptions.LanguageOverride = &index.LanguageOverride
+	}
+
+	return indexModel, nil
+}
+
+// cacheKey identifies the index specification in the session index cache
+func (index Index) cacheKey() string {
+	var collation Collation
+	if index.Collation != nil {
+		collation = *index.Collation
+	}
+	index.Collation = nil
+	return fmt.Sprintf("%#v %#v", index, collation)
+}
+
+// cachedIndexes returns the index cache shared by the session and its copies,
+// or nil for handles built outside of a session
+func (m *ModernMGO) cachedIndexes() *indexCache {
+	if m == nil {
+		return nil
+	}
+	return m.indexes
+}
+
+// has reports whether the index identified by key was ensured
+func (ic *indexCache) has(key string) bool {
+	if ic == nil {
+		return false
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	return ic.seen[key]
+}
+
+// add records the index identified by key as ensured
+func (ic *indexCache) add(key string) {
+	if ic == nil {
+		return
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if ic.seen == nil {
+		ic.seen = make(map[string]bool)
+	}
+	ic.seen[key] = true
+}
+
+// forget drops the cached indexes of collections whose full name starts with
+// prefix
+func (ic *indexCache) forget(prefix string) {
+	if ic == nil {
+		return
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	for key := range ic.seen {
+		if strings.HasPrefix(key, prefix) {
+			delete(ic.seen, key)
+		}
+	}
+}
+
+// reset drops all cached indexes
+func (ic *indexCache) reset() {
+	if ic == nil {
+		return
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ic.seen = nil
+}
+
+// indexKeyDoc converts mgo index keys to an index key document. Keys are
+// "field", "-field" for descending order, "$text:field" for the fields of a
+// text index, or "$2d:field" and "$2dsphere:field" for geospatial indexes.
+func indexKeyDoc(key []string) (officialBson.D, error) {
+	// Use officialBson.D to maintain key order for index creation
+	var keys officialBson.D
+	for _, spec := range key {
+		field := spec
+		var kind interface{} = 1
+		if strings.HasPrefix(field, "$") {
+			c := strings.Index(field, ":")
+			if c < 0 {
+				return nil, fmt.Errorf("invalid index key: want \"[$<kind>:][-]<field name>\", got %q", spec)
+			}
+			switch field[1:c] {
+			case "text", "2d", "2dsphere":
+				kind = field[1:c]
+			default:
+				return nil, fmt.Errorf("unsupported index kind %q in key %q", field[1:c], spec)
+			}
+			field = field[c+1:]
+		} else if strings.HasPrefix(field, "-") {
+			kind = -1
+			field = field[1:]
+		}
+		if field == "" {
+			return nil, fmt.Errorf("invalid index key: want \"[$<kind>:][-]<field name>\", got %q", spec)
+		}
+		keys = append(keys, officialBson.E{Key: field, Value: kind})
+	}
+	return keys, nil
 }
 
 // EnsureIndexKey ensures an index with the given key exists, creating it if necessary (mgo API compatible)
@@ -132,12 +387,12 @@ func (c *ModernColl) EnsureIndexKey(key ...string) error {
 
 // Indexes returns a list of all indexes for the collection.
 func (c *ModernColl) Indexes() ([]Index, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.session.operationContext(opRead, 10*time.Second)
 	defer cancel()
 
 	cursor, err := c.mgoColl.Indexes().List(ctx)
 	if err != nil {
-		return nil, err
+		return nil, convertError(err)
 	}
 	defer cursor.Close(ctx)
 
@@ -150,45 +405,159 @@ func (c *ModernColl) Indexes() ([]Index, error) {
 
 		indexMap := indexDoc.Map()
 
+		// Text indexes list their fields in the weights document
+		var weights map[string]int
+		var textFields []string
+		if weightsDoc, ok := indexMap["weights"].(primitive.D); ok {
+			weights = make(map[string]int, len(weightsDoc))
+			for _, elem := range weightsDoc {
+				weights[elem.Key] = numberToInt(elem.Value)
+				textFields = append(textFields, elem.Key)
+			}
+			sort.Strings(textFields)
+		}
+
 		var key []string
 		if keyVal, ok := indexMap["key"]; ok {
 			if keyDoc, ok := keyVal.(primitive.D); ok {
 				for _, elem := range keyDoc {
-					order := ""
-					if v, ok := elem.Value.(int32); ok && v == -1 {
-						order = "-"
+					switch elem.Key {
+					case "_fts":
+						for _, field := range textFields {
+							key = append(key, "$text:"+field)
+						}
+						continue
+					case "_ftsx":
+						continue
+					}
+					prefix := ""
+					// Other drivers and the shell store directions as any
+					// numeric type
+					if v, ok := elem.Value.(string); ok {
+						prefix = "$" + v + ":"
+					} else if numberToFloat(elem.Value) < 0 {
+						prefix = "-"
 					}
-					key = append(key, order+elem.Key)
+					key = append(key, prefix+elem.Key)
 				}
 			}
 		}
 
 		index := Index{
-			Name: indexMap["name"].(string),
-			Key:  key,
+			Name:    indexMap["name"].(string),
+			Key:     key,
+			Weights: weights,
+		}
+		if min, ok := indexMap["min"]; ok {
+			index.Minf = numberToFloat(min)
+			index.Min = int(index.Minf)
+		}
+		if max, ok := indexMap["max"]; ok {
+			index.Maxf = numberToFloat(max)
+			index.Max = int(index.Maxf)
+		}
+		if bits, ok := indexMap["bits"]; ok {
+			index.Bits = numberToInt(bits)
+		}
+		if collation, ok := indexMap["collation"].(primitive.D); ok {
+			index.Collation = &Collation{}
+			raw, err := officialBson.Marshal(collation)
+			if err == nil {
+				err = officialBson.Unmarshal(raw, index.Collation)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if language, ok := indexMap["default_language"].(string); ok {
+			index.DefaultLanguage = language
 		}
+		if override, ok := indexMap["language_override"].(string); ok {
+			index.LanguageOverride = override
+		}
+		// Older indexes may store these options as numbers
 		if unique, ok := indexMap["unique"]; ok {
-			index.Unique = unique.(bool)
+			index.Unique = optionToBool(unique)
 		}
 		if sparse, ok := indexMap["sparse"]; ok {
-			index.Sparse = sparse.(bool)
+			index.Sparse = optionToBool(sparse)
+		}
+		if expireAfter, ok := indexMap["expireAfterSeconds"]; ok {
+			index.ExpireAfter = time.Duration(numberToInt(expireAfter)) * time.Second
+		}
+		if hidden, ok := indexMap["hidden"].(bool); ok {
+			index.Hidden = hidden
+		}
+		if filter, ok := indexMap["partialFilterExpression"].(primitive.D); ok {
+			raw, err := officialBson.Marshal(filter)
+			if err == nil {
+				err = decodeDocument(raw, &index.PartialFilter)
+			}
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		indexes = append(indexes, index)
 	}
 
-	return indexes, cursor.Err()
+	return indexes, convertError(cursor.Err())
+}
+
+// DropIndexName removes the index with the given name (mgo API compatible)
+func (c *ModernColl) DropIndexName(name string) error {
+	ctx, cancel := c.session.operationContext(opIndex, 30*time.Second)
+	defer cancel()
+
+	c.session.cachedIndexes().forget(c.fullName() + "\x00")
+	_, err := c.coll().Indexes().DropOne(ctx, name)
+	return convertError(err)
+}
+
+// HideIndex hides the named index from the query planner without dropping
+// it, so the effect of removing it can be observed first. The index is still
+// maintained on writes and UnhideIndex restores it immediately.
+func (c *ModernColl) HideIndex(name string) error {
+	return c.setIndexHidden(name, true)
+}
+
+// UnhideIndex makes an index hidden by HideIndex visible to the query planner
+// again
+func (c *ModernColl) UnhideIndex(name string) error {
+	return c.setIndexHidden(name, false)
+}
+
+// setIndexHidden changes the hidden flag of the named index with collMod
+func (c *ModernColl) setIndexHidden(name string, hidden bool) error {
+	ctx, cancel := c.session.operationContext(opIndex, 30*time.Second)
+	defer cancel()
+
+	cmd := officialBson.D{
+		{Key: "collMod", Value: c.name},
+		{Key: "index", Value: officialBson.D{
+			{Key: "name", Value: name},
+			{Key: "hidden", Value: hidden},
+		}},
+	}
+	c.session.cachedIndexes().forget(c.fullName() + "\x00")
+	return convertError(c.mgoColl.Database().RunCommand(ctx, cmd).Err())
 }
 
 // DropCollection drops the collection
 func (c *ModernColl) DropCollection() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.session.operationContext(opWrite, 10*time.Second)
 	defer cancel()
 
-	return c.mgoColl.Drop(ctx)
+	c.session.cachedIndexes().forget(c.fullName() + "\x00")
+	return convertError(c.coll().Drop(ctx))
 }
 
-// Pipe creates an aggregation pipeline (mgo API compatible)
+// Pipe creates an aggregation pipeline (mgo API compatible). The pipeline is
+// a slice of stages of any document type, such as []bson.M, []bson.D,
+// [][]bson.DocElem, mongo.Pipeline or a mixed []interface{}; a single document
+// is run as a one-stage pipeline. Stages are always sent in slice order, and
+// the keys of ordered stages (bson.D) keep their order, which matters for
+// stages like $sort. Keys of bson.M stages have no defined order.
 func (c *ModernColl) Pipe(pipeline interface{}) *ModernPipe {
 	return &ModernPipe{
 		collection: c,
@@ -200,22 +569,46 @@ func (c *ModernColl) Pipe(pipeline interface{}) *ModernPipe {
 	}
 }
 
-// Run executes a database command on the collection's database (mgo API compatible)
+// Run executes a database command on the collection's database as
+// ModernDB.Run does (mgo API compatible)
 func (c *ModernColl) Run(cmd, result interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	db := &ModernDB{
+		mgoDB:     c.mgoColl.Database(),
+		name:      c.mgoColl.Database().Name(),
+		session:   c.session,
+		overrides: c.overrides,
+	}
+	return db.Run(cmd, result)
+}
 
-	command := convertMGOToOfficial(cmd)
-	singleResult := c.mgoColl.Database().RunCommand(ctx, command)
+// FindAndModify atomically updates the first document matching selector and
+// decodes the original or, with ReturnNew, the modified document into result,
+// which may be nil. It offers options Query.Apply does not expose; like Apply
+// it returns ErrNotFound when no document matches and no upsert happens.
+func (c *ModernColl) FindAndModify(selector, update interface{}, opts FindAndModifyOptions, result interface{}) (*ChangeInfo, error) {
+	q := c.Find(selector)
+	if len(opts.Sort) > 0 {
+		q.Sort(opts.Sort...)
+	}
+	if opts.Fields != nil {
+		q.Select(opts.Fields)
+	}
 
-	var doc officialBson.M
-	err := singleResult.Decode(&doc)
-	if err != nil {
-		return err
+	var extra officialBson.D
+	if opts.MaxTime > 0 {
+		extra = append(extra, officialBson.E{Key: "maxTimeMS", Value: opts.MaxTime.Milliseconds()})
+	}
+	if opts.Collation != nil {
+		extra = append(extra, officialBson.E{Key: "collation", Value: opts.Collation})
 	}
 
-	converted := convertOfficialToMGO(doc)
-	return mapStructToInterface(converted, result)
+	change := Change{
+		Update:       update,
+		Upsert:       opts.Upsert,
+		ReturnNew:    opts.ReturnNew,
+		ArrayFilters: opts.ArrayFilters,
+	}
+	return q.findAndModify(change, extra, result)
 }
 
 // Bulk returns a bulk operation builder (mgo API compatible)
@@ -228,9 +621,10 @@ func (c *ModernColl) Bulk() *ModernBulk {
 	}
 }
 
-// FindId finds a document by its ID (mgo API compatible)
+// FindId finds a document by its ID (mgo API compatible). With
+// Session.SetHexIds, an ObjectId may be given as its hex string.
 func (c *ModernColl) FindId(id interface{}) *ModernQ {
-	filter := convertMGOToOfficial(bson.M{"_id": id})
+	filter := convertMGOToOfficial(c.idSelector(id))
 	return &ModernQ{
 		coll:   c,
 		filter: filter,
@@ -239,27 +633,40 @@ func (c *ModernColl) FindId(id interface{}) *ModernQ {
 	}
 }
 
-// UpdateId updates a document by its ID (mgo API compatible)
+// UpdateId updates a document by its ID (mgo API compatible). With
+// Session.SetHexIds, an ObjectId may be given as its hex string.
 func (c *ModernColl) UpdateId(id, update interface{}) error {
-	return c.Update(bson.M{"_id": id}, update)
+	return c.Update(c.idSelector(id), update)
 }
 
-// RemoveId removes a document by its ID (mgo API compatible)
+// RemoveId removes a document by its ID (mgo API compatible). With
+// Session.SetHexIds, an ObjectId may be given as its hex string.
 func (c *ModernColl) RemoveId(id interface{}) error {
-	return c.Remove(bson.M{"_id": id})
+	return c.Remove(c.idSelector(id))
+}
+
+// idSelector returns the selector of the document with the given id, a hex
+// string standing for an ObjectId when the session accepts hex ids
+func (c *ModernColl) idSelector(id interface{}) bson.M {
+	if hex, ok := id.(string); ok && bson.IsObjectIdHex(hex) && c.session.acceptsHexIds() {
+		id = bson.ObjectIdHex(hex)
+	}
+	return bson.M{"_id": id}
 }
 
 // RemoveAll removes all documents matching the selector (mgo API compatible)
 func (c *ModernColl) RemoveAll(selector interface{}) (*ChangeInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.session.operationContext(opWrite, 10*time.Second)
 	defer cancel()
 
 	filter := convertMGOToOfficial(selector)
-	result, err := c.mgoColl.DeleteMany(ctx, filter)
-	if err != nil {
+	c.noteWrite()
+	result, err := c.coll().DeleteMany(ctx, filter)
+	if err = convertDeleteError(result, err); err != nil {
 		return nil, err
 	}
 
+	// Unacknowledged writes report zero counts
 	return &ChangeInfo{
 		Removed: int(result.DeletedCount),
 		Matched: int(result.DeletedCount),
@@ -268,7 +675,7 @@ func (c *ModernColl) RemoveAll(selector interface{}) (*ChangeInfo, error) {
 
 // Upsert updates a document or inserts it if it doesn't exist (mgo API compatible)
 func (c *ModernColl) Upsert(selector, update interface{}) (*ChangeInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.session.operationContext(opWrite, 10*time.Second)
 	defer cancel()
 
 	filter := convertMGOToOfficial(selector)
@@ -277,45 +684,204 @@ func (c *ModernColl) Upsert(selector, update interface{}) (*ChangeInfo, error) {
 	updateDoc := convertMGOToOfficial(wrappedUpdate)
 
 	opts := options.Update().SetUpsert(true)
-	result, err := c.mgoColl.UpdateOne(ctx, filter, updateDoc, opts)
-	if err != nil {
+	opts.BypassDocumentValidation = c.bypassValidation()
+	c.noteWrite()
+	result, err := c.coll().UpdateOne(ctx, filter, updateDoc, opts)
+	if err = convertUpdateError(result, err); err != nil {
 		return nil, err
 	}
 
-	changeInfo := &ChangeInfo{
-		Updated: int(result.ModifiedCount),
-		Matched: int(result.MatchedCount),
-	}
-
-	if result.UpsertedID != nil {
-		changeInfo.UpsertedId = convertOfficialToMGO(result.UpsertedID)
-	}
-
-	return changeInfo, nil
+	return newChangeInfo(result), nil
 }
 
 // UpdateAll updates all documents matching the selector (mgo API compatible)
 func (c *ModernColl) UpdateAll(selector, update interface{}) (*ChangeInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.session.operationContext(opWrite, 10*time.Second)
 	defer cancel()
 
 	filter := convertMGOToOfficial(selector)
 	// Wrap plain documents in $set operator for MongoDB compatibility
 	wrappedUpdate := wrapInSetOperator(update)
 	updateDoc := convertMGOToOfficial(wrappedUpdate)
-	result, err := c.mgoColl.UpdateMany(ctx, filter, updateDoc)
+	c.noteWrite()
+	opts := &options.UpdateOptions{BypassDocumentValidation: c.bypassValidation()}
+	result, err := c.coll().UpdateMany(ctx, filter, updateDoc, opts)
+	if err = convertUpdateError(result, err); err != nil {
+		return nil, err
+	}
+
+	return newChangeInfo(result), nil
+}
+
+// UpsertId updates a document by its _id or inserts it if it doesn't exist (mgo API compatible).
+// With Session.SetHexIds, an ObjectId may be given as its hex string.
+func (c *ModernColl) UpsertId(id interface{}, update interface{}) (*ChangeInfo, error) {
+	return c.Upsert(c.idSelector(id), update)
+}
+
+// UpdateWithArrayFilters updates the first document matching selector, or
+// all of them with multi set, modifying the array elements selected by
+// arrayFilters through $[identifier] in update (mgo API compatible).
+// arrayFilters is a slice holding one filter document per identifier.
+func (c *ModernColl) UpdateWithArrayFilters(selector, update, arrayFilters interface{}, multi bool) (*ChangeInfo, error) {
+	ctx, cancel := c.session.operationContext(opWrite, 10*time.Second)
+	defer cancel()
+
+	filters, err := interfaceSlice(arrayFilters)
 	if err != nil {
 		return nil, err
 	}
 
+	filter := convertMGOToOfficial(selector)
+	updateDoc := convertMGOToOfficial(wrapInSetOperator(update))
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: convertArrayFilters(filters)})
+	opts.BypassDocumentValidation = c.bypassValidation()
+	c.noteWrite()
+
+	var result *mongodrv.UpdateResult
+	if multi {
+		result, err = c.coll().UpdateMany(ctx, filter, updateDoc, opts)
+	} else {
+		result, err = c.coll().UpdateOne(ctx, filter, updateDoc, opts)
+	}
+	if err = convertUpdateError(result, err); err != nil {
+		return nil, err
+	}
+	return newChangeInfo(result), nil
+}
+
+// ReplaceOne replaces the first document matching selector with replacement,
+// whose fields are written as given instead of being wrapped in $set as
+// Update does, so fields absent from it are removed. With upsert set, the
+// replacement is inserted when no document matches.
+func (c *ModernColl) ReplaceOne(selector, replacement interface{}, upsert bool) (*ChangeInfo, error) {
+	ctx, cancel := c.session.operationContext(opWrite, 10*time.Second)
+	defer cancel()
+
+	filter := convertMGOToOfficial(selector)
+	doc := convertMGOToOfficial(replacement)
+	opts := options.Replace().SetUpsert(upsert)
+	opts.BypassDocumentValidation = c.bypassValidation()
+	c.noteWrite()
+	result, err := c.coll().ReplaceOne(ctx, filter, doc, opts)
+	if err = convertUpdateError(result, err); err != nil {
+		return nil, err
+	}
+	return newChangeInfo(result), nil
+}
+
+// newChangeInfo reports the outcome of an update the way mgo does: Matched
+// counts the existing documents selected, Updated those among them actually
+// modified, and UpsertedId holds the _id of a document inserted instead.
+func newChangeInfo(result *mongodrv.UpdateResult) *ChangeInfo {
+	// Unacknowledged writes report zero counts
 	changeInfo := &ChangeInfo{
 		Updated: int(result.ModifiedCount),
 		Matched: int(result.MatchedCount),
 	}
-	return changeInfo, nil
+	if result.UpsertedID != nil {
+		changeInfo.UpsertedId = convertOfficialToMGO(result.UpsertedID)
+	}
+	return changeInfo
 }
 
-// UpsertId updates a document by its _id or inserts it if it doesn't exist (mgo API compatible)
-func (c *ModernColl) UpsertId(id interface{}, update interface{}) (*ChangeInfo, error) {
-	return c.Upsert(bson.M{"_id": id}, update)
+// BypassDocumentValidation returns a handle to the same collection whose
+// inserts, updates, replacements, Apply calls and bulk runs skip the
+// collection's document validation, as needed by backfills writing documents
+// the current validator rejects. It requires the bypassDocumentValidation
+// privilege.
+func (c *ModernColl) BypassDocumentValidation() *ModernColl {
+	bypass := *c
+	bypass.bypass = true
+	return &bypass
+}
+
+// bypassValidation returns the BypassDocumentValidation option of writes
+// through the collection, nil to keep the server default
+func (c *ModernColl) bypassValidation() *bool {
+	if !c.bypass {
+		return nil
+	}
+	bypass := true
+	return &bypass
+}
+
+// coll returns the driver collection of an operation, with the current
+// session settings as given by collectionOptions
+func (c *ModernColl) coll() *mongodrv.Collection {
+	if c.session == nil {
+		return c.mgoColl
+	}
+	return c.cloneColl(c.session.collectionOptions(c.overrides))
+}
+
+// readColl returns the driver collection used for reads. Once a Monotonic
+// session has written, reads are sent to the primary so they observe the
+// session's own writes.
+func (c *ModernColl) readColl() *mongodrv.Collection {
+	if c.session == nil {
+		return c.mgoColl
+	}
+	return c.cloneColl(c.readOptions())
+}
+
+// readOptions returns the collection settings of readColl
+func (c *ModernColl) readOptions() *options.CollectionOptions {
+	opts := c.session.collectionOptions(c.overrides)
+	if c.session.Mode() == Monotonic && c.session.wrote.Load() {
+		opts.SetReadPreference(readpref.Primary())
+	}
+	return opts
+}
+
+// cloneColl clones the driver collection with opts, keeping it as is if
+// the options are rejected
+func (c *ModernColl) cloneColl(opts *options.CollectionOptions) *mongodrv.Collection {
+	coll, err := c.mgoColl.Clone(opts)
+	if err != nil {
+		return c.mgoColl
+	}
+	return coll
+}
+
+// fullName returns the "database.collection" name of the collection
+func (c *ModernColl) fullName() string {
+	return c.mgoColl.Database().Name() + "." + c.name
+}
+
+// noteWrite records that the session wrote through this collection
+func (c *ModernColl) noteWrite() {
+	if c.session != nil {
+		c.session.wrote.Store(true)
+	}
+}
+
+// retryRead runs a read operation, retrying it with exponential backoff as
+// configured by SetReadRetry while it fails with a retryable error. The wait
+// between attempts ends with the operation context, returning the last error.
+func (c *ModernColl) retryRead(ctx context.Context, op func() error) error {
+	attempts, backoff := c.session.readRetry()
+
+	err := op()
+	for attempt := 1; attempt < attempts && IsRetryableError(err); attempt++ {
+		if !waitBackoff(ctx, backoff) {
+			return convertError(err)
+		}
+		backoff *= 2
+		err = op()
+	}
+	return convertError(err)
+}
+
+// waitBackoff waits for backoff to elapse, reporting false when ctx is done
+// first
+func waitBackoff(ctx context.Context, backoff time.Duration) bool {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
 }
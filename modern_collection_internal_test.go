@@ -0,0 +1,193 @@
+package mgo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kinfkong/modern-mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// TestHexIds checks hex strings select ObjectIds in the *Id collection
+// methods only once enabled, and that copies keep the setting
+func TestHexIds(t *testing.T) {
+	m, err := DialModernMGO("mongodb://localhost:27017/hexids_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+
+	id := bson.NewObjectId()
+	coll := m.DB("").C("c")
+	if got := coll.idSelector(id.Hex()); got["_id"] != id.Hex() {
+		t.Errorf("Expected the hex string kept by default, got %#v", got)
+	}
+
+	m.SetHexIds(true)
+	copied := m.Copy()
+	defer copied.Close()
+	for _, coll := range []*ModernColl{m.DB("").C("c"), copied.DB("").C("c")} {
+		if got := coll.idSelector(id.Hex()); got["_id"] != id {
+			t.Errorf("Expected the ObjectId of the hex string, got %#v", got)
+		}
+		for _, other := range []interface{}{"not-hex", id, 42} {
+			if got := coll.idSelector(other); got["_id"] != other {
+				t.Errorf("Expected %#v kept, got %#v", other, got)
+			}
+		}
+		var want primitive.ObjectID
+		copy(want[:], id)
+		if filter, ok := coll.FindId(id.Hex()).filter.(officialBson.M); !ok || filter["_id"] != want {
+			t.Errorf("Expected the filter of %v, got %#v", want, coll.FindId(id.Hex()).filter)
+		}
+	}
+
+	// Handles built outside of a session keep strings
+	if got := (&ModernColl{}).idSelector(id.Hex()); got["_id"] != id.Hex() {
+		t.Errorf("Expected the hex string kept, got %#v", got)
+	}
+}
+
+// TestCollectionWriteConcern checks collection handles write with the current
+// safety mode of the session, unless overridden by ModernDB.With
+func TestCollectionWriteConcern(t *testing.T) {
+	m, err := DialModernMGO("mongodb://localhost:27017/write_concern_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+
+	db := m.DB("")
+	coll := db.C("c")
+	overridden := db.With(nil, writeconcern.Majority(), nil).C("c")
+
+	m.SetSafe(nil)
+	if wc := m.collectionOptions(coll.overrides).WriteConcern; wc.Acknowledged() {
+		t.Errorf("Expected SetSafe(nil) to apply to an earlier handle, got %+v", wc)
+	}
+	m.SetSafe(&Safe{W: 2})
+	if wc := m.collectionOptions(coll.overrides).WriteConcern; wc.W != 2 {
+		t.Errorf("Expected w=2 through an earlier handle, got %+v", wc)
+	}
+	if wc := m.collectionOptions(db.C("other").overrides).WriteConcern; wc.W != 2 {
+		t.Errorf("Expected w=2 through an earlier database handle, got %+v", wc)
+	}
+	if wc := m.collectionOptions(overridden.overrides).WriteConcern; wc.W != "majority" {
+		t.Errorf("Expected the With write concern to take precedence, got %+v", wc)
+	}
+}
+
+// TestCollectionReadSettings checks collection handles read with the current
+// mode, server selection and read concern of the session, unless overridden
+// by ModernDB.With
+func TestCollectionReadSettings(t *testing.T) {
+	m, err := DialModernMGO("mongodb://localhost:27017/read_settings_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+
+	m.SetReadConcern("majority")
+	db := m.DB("")
+	coll := db.C("c")
+	overridden := db.With(readconcern.Local(), nil, readpref.Nearest()).C("c")
+
+	m.SetMode(Secondary, false)
+	m.SelectServers(bson.D{{Name: "role", Value: "analytics"}})
+	m.SetMaxStaleness(2 * time.Minute)
+	m.SetReadConcern("available")
+
+	opts := m.collectionOptions(coll.overrides)
+	if rp := opts.ReadPreference; rp.Mode() != readpref.SecondaryMode {
+		t.Errorf("Expected SetMode to apply to an earlier handle, got %v", rp.Mode())
+	} else {
+		if sets := rp.TagSets(); len(sets) != 1 || !sets[0].Contains("role", "analytics") {
+			t.Errorf("Expected the server tags set afterwards, got %v", sets)
+		}
+		if staleness, ok := rp.MaxStaleness(); !ok || staleness != 2*time.Minute {
+			t.Errorf("Expected the max staleness set afterwards, got %v", staleness)
+		}
+	}
+	if rc := opts.ReadConcern; rc == nil || rc.Level != "available" {
+		t.Errorf("Expected the read concern set afterwards, got %v", rc)
+	}
+
+	// Clearing the read concern leaves the server default
+	m.SetReadConcern("")
+	if rc := m.collectionOptions(coll.overrides).ReadConcern; rc == nil || rc.Level != "" {
+		t.Errorf("Expected the server default read concern, got %v", rc)
+	}
+
+	opts = m.collectionOptions(overridden.overrides)
+	if mode := opts.ReadPreference.Mode(); mode != readpref.NearestMode {
+		t.Errorf("Expected the With read preference to take precedence, got %v", mode)
+	}
+	if rc := opts.ReadConcern; rc == nil || rc.Level != "local" {
+		t.Errorf("Expected the With read concern to take precedence, got %v", rc)
+	}
+}
+
+// TestIndexCache checks ensured indexes are remembered per collection and
+// index spec, shared by session copies and cleared by ResetIndexCache
+func TestIndexCache(t *testing.T) {
+	m, err := DialModernMGO("mongodb://localhost:27017/db")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+	copied := m.Copy()
+	if copied.cachedIndexes() != m.cachedIndexes() {
+		t.Fatal("Expected copies to share the index cache")
+	}
+
+	index := Index{Key: []string{"email"}, Unique: true, Collation: &Collation{Locale: "en", Strength: 2}}
+	key := "db.users\x00" + index.cacheKey()
+	m.cachedIndexes().add(key)
+	if !copied.cachedIndexes().has(key) {
+		t.Error("Expected index to be cached")
+	}
+
+	// Cached indexes are not sent to the server again
+	m.SetOperationTimeouts(OpTimeouts{Index: time.Millisecond})
+	if err := m.DB("").C("users").EnsureIndex(index); err != nil {
+		t.Errorf("Expected cached index to be skipped, got %v", err)
+	}
+
+	// Any change to the spec, including the collation, is another index
+	other := index
+	other.Collation = &Collation{Locale: "en", Strength: 1}
+	if other.cacheKey() == index.cacheKey() {
+		t.Error("Expected collation to be part of the cache key")
+	}
+	same := index
+	same.Collation = &Collation{Locale: "en", Strength: 2}
+	if same.cacheKey() != index.cacheKey() {
+		t.Error("Expected equal specs to share a cache key")
+	}
+
+	m.cachedIndexes().forget("db.other\x00")
+	if !m.cachedIndexes().has(key) {
+		t.Error("Expected other collections not to be forgotten")
+	}
+	m.cachedIndexes().forget("db.users\x00")
+	if m.cachedIndexes().has(key) {
+		t.Error("Expected dropped collection indexes to be forgotten")
+	}
+
+	m.cachedIndexes().add(key)
+	copied.ResetIndexCache()
+	if m.cachedIndexes().has(key) {
+		t.Error("Expected ResetIndexCache to clear the cache")
+	}
+
+	// Handles built outside of a session do not cache
+	var nilSession *ModernMGO
+	nilSession.cachedIndexes().add(key)
+	if nilSession.cachedIndexes().has(key) {
+		t.Error("Expected no cache without a session")
+	}
+}
@@ -1,12 +1,13 @@
 package mgo_test
 
 import (
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
 
-	"github.com/globalsign/mgo"
-	"github.com/globalsign/mgo/bson"
+	mgo "github.com/kinfkong/modern-mgo"
+	"github.com/kinfkong/modern-mgo/bson"
 )
 
 func TestModernCollectionInsert(t *testing.T) {
@@ -83,6 +84,294 @@ func TestModernCollectionFindId(t *testing.T) {
 	AssertEqual(t, "Test User", result["name"], "Incorrect name")
 }
 
+func TestModernCollectionUUIDId(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	type account struct {
+		Id   bson.UUID `bson:"_id"`
+		Name string    `bson:"name"`
+	}
+	id := bson.NewUUID()
+	err := coll.Insert(account{Id: id, Name: "Test User"})
+	AssertNoError(t, err, "Failed to insert document")
+
+	var result account
+	err = coll.FindId(id).One(&result)
+	AssertNoError(t, err, "Failed to find document by UUID")
+	AssertEqual(t, id, result.Id, "Incorrect UUID")
+
+	// The id is stored as a standard UUID, as other drivers expect
+	count, err := coll.Find(bson.M{"_id": bson.M{"$type": 5}}).Count()
+	AssertNoError(t, err, "Failed to count binary ids")
+	AssertEqual(t, 1, count, "Expected the id stored as binary data")
+}
+
+func TestModernCollectionRawDocuments(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	raw := func(doc bson.M) bson.Raw {
+		data, err := bson.Marshal(doc)
+		AssertNoError(t, err, "Failed to marshal document")
+		return bson.Raw{Kind: bson.ElementDocument, Data: data}
+	}
+
+	err := coll.Insert(raw(bson.M{"_id": 1, "name": "raw"}))
+	AssertNoError(t, err, "Failed to insert raw document")
+	err = coll.Update(raw(bson.M{"_id": 1}), raw(bson.M{"$set": bson.M{"n": 2}}))
+	AssertNoError(t, err, "Failed to update with raw documents")
+
+	// Results are decoded on demand
+	var results []bson.Raw
+	err = coll.Find(raw(bson.M{"name": "raw"})).All(&results)
+	AssertNoError(t, err, "Failed to find raw documents")
+	AssertEqual(t, 1, len(results), "Incorrect number of results")
+	var doc struct {
+		Name string `bson:"name"`
+		N    int    `bson:"n"`
+	}
+	AssertNoError(t, results[0].Unmarshal(&doc), "Failed to unmarshal raw result")
+	AssertEqual(t, "raw", doc.Name, "Incorrect name")
+	AssertEqual(t, 2, doc.N, "Incorrect updated field")
+
+	var fields bson.RawD
+	AssertNoError(t, coll.FindId(1).One(&fields), "Failed to find into bson.RawD")
+	AssertEqual(t, "_id", fields[0].Name, "Expected the fields in stored order")
+}
+
+func TestModernCollectionInlineStruct(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	type model struct {
+		Id      bson.ObjectId `bson:"_id"`
+		Created time.Time     `bson:"created"`
+	}
+	type user struct {
+		Model model  `bson:",inline"`
+		Name  string `bson:"name"`
+	}
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	err := coll.Insert(&user{Model: model{Created: created}, Name: "inline"})
+	AssertNoError(t, err, "Failed to insert document")
+
+	// The inlined fields are stored at the top level
+	var raw bson.M
+	AssertNoError(t, coll.Find(bson.M{"name": "inline"}).One(&raw), "Failed to find document")
+	if _, ok := raw["_id"].(bson.ObjectId); !ok || raw["created"] == nil || raw["model"] != nil {
+		t.Fatalf("Expected the inlined fields at the top level, got %v", raw)
+	}
+
+	var result user
+	AssertNoError(t, coll.FindId(raw["_id"]).One(&result), "Failed to find by id")
+	AssertEqual(t, raw["_id"], result.Model.Id, "Incorrect inlined id")
+	if !result.Model.Created.Equal(created) {
+		t.Errorf("Expected created %v, got %v", created, result.Model.Created)
+	}
+}
+
+func TestModernCollectionNestedStruct(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	type address struct {
+		City  string      `bson:"city"`
+		Moves []time.Time `bson:"moves"`
+	}
+	type person struct {
+		Name    string             `bson:"name"`
+		Home    address            `bson:"home"`
+		Work    *address           `bson:"work"`
+		Past    []address          `bson:"past"`
+		Holiday map[string]address `bson:"holiday"`
+	}
+	moved := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	in := person{
+		Name:    "nested",
+		Home:    address{City: "Paris", Moves: []time.Time{moved}},
+		Work:    &address{City: "Lyon"},
+		Past:    []address{{City: "Nice", Moves: []time.Time{moved}}},
+		Holiday: map[string]address{"summer": {City: "Brest"}},
+	}
+	AssertNoError(t, coll.Insert(&in), "Failed to insert document")
+
+	var out person
+	AssertNoError(t, coll.Find(bson.M{"name": "nested"}).One(&out), "Failed to find document")
+	AssertEqual(t, "Paris", out.Home.City, "Incorrect nested struct")
+	if len(out.Home.Moves) != 1 || !out.Home.Moves[0].Equal(moved) {
+		t.Errorf("Expected the nested times %v, got %v", in.Home.Moves, out.Home.Moves)
+	}
+	if out.Work == nil || out.Work.City != "Lyon" {
+		t.Errorf("Expected the nested pointer %v, got %v", in.Work, out.Work)
+	}
+	if len(out.Past) != 1 || out.Past[0].City != "Nice" || len(out.Past[0].Moves) != 1 {
+		t.Errorf("Expected the nested slice %v, got %v", in.Past, out.Past)
+	}
+	AssertEqual(t, "Brest", out.Holiday["summer"].City, "Incorrect nested map")
+}
+
+func TestModernCollectionPointerFields(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	type optional struct {
+		Name    string         `bson:"name"`
+		Deleted *time.Time     `bson:"deleted"`
+		Parent  *bson.ObjectId `bson:"parent"`
+		Rank    *int           `bson:"rank,omitempty"`
+	}
+	deleted := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	parent := bson.NewObjectId()
+	rank := 3
+	err := coll.Insert(
+		&optional{Name: "set", Deleted: &deleted, Parent: &parent, Rank: &rank},
+		&optional{Name: "unset"},
+	)
+	AssertNoError(t, err, "Failed to insert documents")
+
+	var set optional
+	AssertNoError(t, coll.Find(bson.M{"name": "set"}).One(&set), "Failed to find document")
+	if set.Deleted == nil || !set.Deleted.Equal(deleted) {
+		t.Errorf("Expected deleted %v, got %v", deleted, set.Deleted)
+	}
+	if set.Parent == nil || *set.Parent != parent {
+		t.Errorf("Expected parent %v, got %v", parent, set.Parent)
+	}
+	if set.Rank == nil || *set.Rank != rank {
+		t.Errorf("Expected rank %d, got %v", rank, set.Rank)
+	}
+
+	// Null and missing values leave the pointers nil
+	unset := optional{Rank: &rank}
+	AssertNoError(t, coll.Find(bson.M{"name": "unset"}).One(&unset), "Failed to find document")
+	if unset.Deleted != nil || unset.Parent != nil || unset.Rank != nil {
+		t.Errorf("Expected nil pointers, got %+v", unset)
+	}
+}
+
+func TestModernCollectionTypedMapFields(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	type score struct {
+		Points int       `bson:"points"`
+		At     time.Time `bson:"at"`
+	}
+	type board struct {
+		Name   string               `bson:"name"`
+		Logins map[string]time.Time `bson:"logins"`
+		Totals map[string]int64     `bson:"totals"`
+		Best   map[string]score     `bson:"best"`
+	}
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	in := board{
+		Name:   "maps",
+		Logins: map[string]time.Time{"ann": at},
+		Totals: map[string]int64{"ann": 1 << 40, "bob": 7},
+		Best:   map[string]score{"ann": {Points: 9, At: at}},
+	}
+	AssertNoError(t, coll.Insert(&in), "Failed to insert document")
+
+	var out board
+	AssertNoError(t, coll.Find(bson.M{"name": "maps"}).One(&out), "Failed to find document")
+	if !out.Logins["ann"].Equal(at) {
+		t.Errorf("Expected logins %v, got %v", in.Logins, out.Logins)
+	}
+	AssertEqual(t, int64(1<<40), out.Totals["ann"], "Incorrect total")
+	AssertEqual(t, int64(7), out.Totals["bob"], "Incorrect total")
+	if best := out.Best["ann"]; best.Points != 9 || !best.At.Equal(at) {
+		t.Errorf("Expected best %v, got %v", in.Best, out.Best)
+	}
+}
+
+func TestModernCollectionNumericFields(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	err := coll.Insert(bson.M{"name": "numbers", "count": int64(42), "ratio": 3, "total": 2.0, "small": int64(7)})
+	AssertNoError(t, err, "Failed to insert document")
+
+	var out struct {
+		Count int32   `bson:"count"`
+		Ratio float64 `bson:"ratio"`
+		Total int64   `bson:"total"`
+		Small uint8   `bson:"small"`
+	}
+	AssertNoError(t, coll.Find(bson.M{"name": "numbers"}).One(&out), "Failed to find document")
+	AssertEqual(t, int32(42), out.Count, "Incorrect narrowed int64")
+	AssertEqual(t, float64(3), out.Ratio, "Incorrect widened int")
+	AssertEqual(t, int64(2), out.Total, "Incorrect integral double")
+	AssertEqual(t, uint8(7), out.Small, "Incorrect unsigned value")
+}
+
+func TestModernCollectionNestedStructFields(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	// Nested and embedded structs without time slices decode through One
+	type Audit struct {
+		By    bson.ObjectId `bson:"by"`
+		Count int           `bson:"count"`
+	}
+	type owner struct {
+		Name    string  `bson:"name"`
+		Audit   Audit   `bson:"audit"`
+		Backup  *Audit  `bson:"backup"`
+		History []Audit `bson:"history"`
+	}
+	type account struct {
+		Audit                   // Embedded, stored as the "audit" document
+		Id     bson.ObjectId    `bson:"_id"`
+		Owner  owner            `bson:"owner"`
+		Owners []owner          `bson:"owners"`
+		Labels map[string]Audit `bson:"labels"`
+	}
+	id := bson.NewObjectId()
+	in := account{
+		Audit: Audit{By: id, Count: 1},
+		Id:    bson.NewObjectId(),
+		Owner: owner{
+			Name:    "a",
+			Audit:   Audit{By: id, Count: 2},
+			Backup:  &Audit{By: id, Count: 3},
+			History: []Audit{{By: id, Count: 4}},
+		},
+		Owners: []owner{{Name: "b", Audit: Audit{By: id, Count: 5}, History: []Audit{}}},
+		Labels: map[string]Audit{"x": {By: id, Count: 6}},
+	}
+	AssertNoError(t, coll.Insert(&in), "Failed to insert document")
+
+	var out account
+	AssertNoError(t, coll.FindId(in.Id).One(&out), "Failed to find document")
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Expected %+v, got %+v", in, out)
+	}
+}
+
 func TestModernCollectionUpdate(t *testing.T) {
 	// Setup
 	tdb := NewTestDB(t)
@@ -152,6 +441,16 @@ func TestModernCollectionUpdateAll(t *testing.T) {
 	info, err := coll.UpdateAll(bson.M{"category": "A"}, bson.M{"$set": bson.M{"status": "inactive"}})
 	AssertNoError(t, err, "Failed to update all documents")
 	AssertEqual(t, 2, info.Updated, "Incorrect number of updated documents")
+	AssertEqual(t, 2, info.Matched, "Incorrect number of matched documents")
+
+	// Matched documents left unchanged are not counted as updated
+	info, err = coll.UpdateAll(bson.M{"category": "A"}, bson.M{"$set": bson.M{"status": "inactive"}})
+	AssertNoError(t, err, "Failed to update all documents")
+	AssertEqual(t, 2, info.Matched, "Incorrect number of matched documents")
+	AssertEqual(t, 0, info.Updated, "Incorrect number of updated documents")
+	if info.UpsertedId != nil {
+		t.Fatalf("Expected no upserted ID, got %v", info.UpsertedId)
+	}
 
 	// Verify updates
 	var results []bson.M
@@ -173,11 +472,29 @@ func TestModernCollectionUpsert(t *testing.T) {
 	if info.UpsertedId == nil {
 		t.Fatal("Expected upserted ID")
 	}
+	AssertEqual(t, 0, info.Matched, "Expected no matched document on insert")
+	AssertEqual(t, 0, info.Updated, "Expected no updated document on insert")
 
 	// Upsert existing document
 	info, err = coll.Upsert(bson.M{"key": "unique1"}, bson.M{"$set": bson.M{"value": 200}})
 	AssertNoError(t, err, "Failed to upsert existing document")
 	AssertEqual(t, 1, info.Updated, "Expected one updated document")
+	AssertEqual(t, 1, info.Matched, "Expected one matched document")
+	if info.UpsertedId != nil {
+		t.Fatalf("Expected no upserted ID when updating, got %v", info.UpsertedId)
+	}
+
+	// Upsert matching a document without changing it
+	info, err = coll.Upsert(bson.M{"key": "unique1"}, bson.M{"$set": bson.M{"value": 200}})
+	AssertNoError(t, err, "Failed to upsert unchanged document")
+	AssertEqual(t, 1, info.Matched, "Expected one matched document")
+	AssertEqual(t, 0, info.Updated, "Expected no modified document")
+
+	// Upsert with an explicit _id reports it as the upserted ID
+	id := bson.NewObjectId()
+	info, err = coll.UpsertId(id, bson.M{"$set": bson.M{"value": 300}})
+	AssertNoError(t, err, "Failed to upsert by ID")
+	AssertEqual(t, id, info.UpsertedId, "Expected upserted ID to match")
 
 	// Verify result
 	var result bson.M
@@ -231,6 +548,62 @@ func TestModernCollectionRemoveId(t *testing.T) {
 	AssertError(t, err, "Expected error when finding removed document")
 }
 
+func TestModernCollectionUpdateRemoveNotFound(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	missing := bson.NewObjectId()
+
+	// Writes matching no document report ErrNotFound, as in mgo
+	err := coll.Update(bson.M{"_id": missing}, bson.M{"$set": bson.M{"name": "Nobody"}})
+	AssertEqual(t, mgo.ErrNotFound, err, "Update of missing document")
+	err = coll.UpdateId(missing, bson.M{"$set": bson.M{"name": "Nobody"}})
+	AssertEqual(t, mgo.ErrNotFound, err, "UpdateId of missing document")
+	err = coll.Remove(bson.M{"_id": missing})
+	AssertEqual(t, mgo.ErrNotFound, err, "Remove of missing document")
+	err = coll.RemoveId(missing)
+	AssertEqual(t, mgo.ErrNotFound, err, "RemoveId of missing document")
+
+	// An update matching a document without changing it still succeeds
+	id := bson.NewObjectId()
+	err = coll.Insert(bson.M{"_id": id, "name": "Same"})
+	AssertNoError(t, err, "Failed to insert document")
+	err = coll.UpdateId(id, bson.M{"$set": bson.M{"name": "Same"}})
+	AssertNoError(t, err, "Update matching an unchanged document")
+}
+
+func TestModernCollectionUnacknowledgedUpdateRemove(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	id := bson.NewObjectId()
+	err := tdb.C("test_collection").Insert(bson.M{"_id": id, "name": "Original"})
+	AssertNoError(t, err, "Failed to insert document")
+
+	session := tdb.Session.Copy()
+	defer session.Close()
+	session.SetSafe(nil)
+	coll := session.DB(tdb.DBName).C("test_collection")
+
+	// Unacknowledged writes cannot tell whether a document matched, and
+	// report no error either way
+	err = coll.Update(bson.M{"_id": id}, bson.M{"$set": bson.M{"name": "Updated"}})
+	AssertNoError(t, err, "Unacknowledged Update")
+	err = coll.UpdateId(id, bson.M{"$set": bson.M{"name": "Updated again"}})
+	AssertNoError(t, err, "Unacknowledged UpdateId")
+	err = coll.Remove(bson.M{"_id": id})
+	AssertNoError(t, err, "Unacknowledged Remove")
+
+	missing := bson.NewObjectId()
+	err = coll.UpdateId(missing, bson.M{"$set": bson.M{"name": "Nobody"}})
+	AssertNoError(t, err, "Unacknowledged UpdateId of missing document")
+	err = coll.RemoveId(missing)
+	AssertNoError(t, err, "Unacknowledged RemoveId of missing document")
+}
+
 func TestModernCollectionRemoveAll(t *testing.T) {
 	// Setup
 	tdb := NewTestDB(t)
@@ -334,7 +707,6 @@ func TestModernCollectionBulk(t *testing.T) {
 	AssertNoError(t, err, "Failed to execute bulk operation")
 
 	// Verify results
-	// Note: BulkResult only has Matched and Modified fields
 	// Matched only counts documents matched by update operations
 	if result.Matched != 1 {
 		t.Errorf("Expected 1 matched operation (from update), got %d", result.Matched)
@@ -342,6 +714,12 @@ func TestModernCollectionBulk(t *testing.T) {
 	if result.Modified != 1 {
 		t.Errorf("Expected 1 update, got %d", result.Modified)
 	}
+	if result.InsertedCount != 2 {
+		t.Errorf("Expected 2 inserted documents, got %d", result.InsertedCount)
+	}
+	if result.RemovedCount != 1 {
+		t.Errorf("Expected 1 removed document, got %d", result.RemovedCount)
+	}
 
 	// Verify final state
 	count, err := coll.Count()
@@ -372,9 +750,429 @@ func TestModernCollectionEnsureIndex(t *testing.T) {
 	AssertError(t, err, "Expected error on duplicate email")
 }
 
-// Note: DropIndex and DropIndexName methods are not implemented in the modern wrapper
+func TestModernCollectionEnsureTextIndex(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	index := mgo.Index{
+		Key:              []string{"$text:title", "$text:body"},
+		Name:             "search",
+		Weights:          map[string]int{"title": 5},
+		DefaultLanguage:  "english",
+		LanguageOverride: "lang",
+	}
+	err := coll.EnsureIndex(index)
+	AssertNoError(t, err, "Failed to ensure text index")
+
+	err = coll.Insert(
+		bson.M{"_id": 1, "title": "Gopher news", "body": "nothing"},
+		bson.M{"_id": 2, "title": "Other", "body": "a gopher appears"},
+		bson.M{"_id": 3, "title": "Unrelated", "body": "nothing"},
+	)
+	AssertNoError(t, err, "Failed to insert documents")
+
+	var docs []bson.M
+	err = coll.Find(bson.M{"$text": bson.M{"$search": "gopher"}}).All(&docs)
+	AssertNoError(t, err, "Failed to run text search")
+	AssertEqual(t, 2, len(docs), "Incorrect number of text search results")
+
+	indexes, err := coll.Indexes()
+	AssertNoError(t, err, "Failed to list indexes")
+	var found bool
+	for _, idx := range indexes {
+		if idx.Name != "search" {
+			continue
+		}
+		found = true
+		AssertEqual(t, 2, len(idx.Key), "Incorrect number of text index keys")
+		AssertEqual(t, "$text:body", idx.Key[0], "Incorrect text index key")
+		AssertEqual(t, "$text:title", idx.Key[1], "Incorrect text index key")
+		AssertEqual(t, 5, idx.Weights["title"], "Incorrect title weight")
+		AssertEqual(t, "english", idx.DefaultLanguage, "Incorrect default language")
+		AssertEqual(t, "lang", idx.LanguageOverride, "Incorrect language override")
+	}
+	if !found {
+		t.Error("Text index not listed")
+	}
+
+	err = coll.EnsureIndex(mgo.Index{Key: []string{"$text"}})
+	AssertError(t, err, "Expected error for malformed text key")
+}
+
+func TestModernCollectionEnsureGeoIndex(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	err := coll.EnsureIndex(mgo.Index{Key: []string{"$2d:loc"}, Min: -500, Max: 500, Bits: 20})
+	AssertNoError(t, err, "Failed to ensure 2d index")
+	err = coll.EnsureIndex(mgo.Index{Key: []string{"$2dsphere:geo"}})
+	AssertNoError(t, err, "Failed to ensure 2dsphere index")
+
+	err = coll.Insert(
+		bson.M{"_id": 1, "loc": []int{10, 10}, "geo": bson.M{"type": "Point", "coordinates": []float64{2.35, 48.85}}},
+		bson.M{"_id": 2, "loc": []int{400, 400}, "geo": bson.M{"type": "Point", "coordinates": []float64{-74.0, 40.71}}},
+	)
+	AssertNoError(t, err, "Failed to insert documents")
+
+	// Points outside the 2d bounds are rejected
+	err = coll.Insert(bson.M{"_id": 3, "loc": []int{600, 600}})
+	AssertError(t, err, "Expected error for point outside 2d bounds")
+
+	var doc bson.M
+	err = coll.Find(bson.M{"loc": bson.M{"$near": []int{0, 0}}}).One(&doc)
+	AssertNoError(t, err, "Failed to run 2d query")
+	AssertEqual(t, 1, doc["_id"], "Incorrect nearest document")
+
+	near := bson.M{"$nearSphere": bson.M{"$geometry": bson.M{"type": "Point", "coordinates": []float64{-73.9, 40.7}}}}
+	err = coll.Find(bson.M{"geo": near}).One(&doc)
+	AssertNoError(t, err, "Failed to run 2dsphere query")
+	AssertEqual(t, 2, doc["_id"], "Incorrect nearest document")
+
+	indexes, err := coll.Indexes()
+	AssertNoError(t, err, "Failed to list indexes")
+	var found int
+	for _, idx := range indexes {
+		switch idx.Key[0] {
+		case "$2d:loc":
+			found++
+			AssertEqual(t, -500, idx.Min, "Incorrect 2d min")
+			AssertEqual(t, 500, idx.Max, "Incorrect 2d max")
+			AssertEqual(t, 20, idx.Bits, "Incorrect 2d bits")
+		case "$2dsphere:geo":
+			found++
+		}
+	}
+	AssertEqual(t, 2, found, "Geo indexes not listed")
+}
+
+func TestModernCollectionEnsureIndexCollation(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	// Case-insensitive unique index
+	index := mgo.Index{
+		Key:       []string{"email"},
+		Name:      "email_ci",
+		Unique:    true,
+		Collation: &mgo.Collation{Locale: "en", Strength: 2},
+	}
+	err := coll.EnsureIndex(index)
+	AssertNoError(t, err, "Failed to ensure index with collation")
+
+	err = coll.Insert(bson.M{"email": "User@Example.com"})
+	AssertNoError(t, err, "Failed to insert first document")
+
+	err = coll.Insert(bson.M{"email": "user@example.com"})
+	if !mgo.IsDup(err) {
+		t.Errorf("Expected duplicate key error for differently cased email, got %v", err)
+	}
+
+	indexes, err := coll.Indexes()
+	AssertNoError(t, err, "Failed to list indexes")
+	for _, idx := range indexes {
+		if idx.Name != "email_ci" {
+			continue
+		}
+		if idx.Collation == nil {
+			t.Fatal("Expected index collation to be listed")
+		}
+		AssertEqual(t, "en", idx.Collation.Locale, "Incorrect collation locale")
+		AssertEqual(t, 2, idx.Collation.Strength, "Incorrect collation strength")
+	}
+}
+
+func TestModernCollectionHiddenIndex(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	hidden := func(name string) bool {
+		indexes, err := coll.Indexes()
+		AssertNoError(t, err, "Failed to list indexes")
+		for _, idx := range indexes {
+			if idx.Name == name {
+				return idx.Hidden
+			}
+		}
+		t.Fatalf("Index %s not listed", name)
+		return false
+	}
+
+	err := coll.EnsureIndex(mgo.Index{Key: []string{"a"}, Name: "a_hidden", Hidden: true})
+	AssertNoError(t, err, "Failed to ensure hidden index")
+	AssertEqual(t, true, hidden("a_hidden"), "Index should be created hidden")
+
+	err = coll.EnsureIndex(mgo.Index{Key: []string{"b"}, Name: "b_visible"})
+	AssertNoError(t, err, "Failed to ensure index")
+	AssertEqual(t, false, hidden("b_visible"), "Index should be visible")
+
+	err = coll.HideIndex("b_visible")
+	AssertNoError(t, err, "Failed to hide index")
+	AssertEqual(t, true, hidden("b_visible"), "Index should be hidden")
+
+	err = coll.UnhideIndex("b_visible")
+	AssertNoError(t, err, "Failed to unhide index")
+	AssertEqual(t, false, hidden("b_visible"), "Index should be visible again")
+
+	err = coll.HideIndex("missing")
+	AssertError(t, err, "Expected error hiding a missing index")
+}
+
+func TestModernCollectionEnsureIndexCache(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	hasIndex := func(name string) bool {
+		indexes, err := coll.Indexes()
+		AssertNoError(t, err, "Failed to list indexes")
+		for _, idx := range indexes {
+			if idx.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	index := mgo.Index{Key: []string{"a"}, Name: "a_cached"}
+	err := coll.EnsureIndex(index)
+	AssertNoError(t, err, "Failed to ensure index")
+
+	// Drop the index behind the session's back
+	var reply bson.M
+	err = coll.Run(bson.D{{Name: "dropIndexes", Value: "test_collection"}, {Name: "index", Value: "a_cached"}}, &reply)
+	AssertNoError(t, err, "Failed to drop index")
+
+	// The cached index is not created again
+	err = coll.EnsureIndex(index)
+	AssertNoError(t, err, "Failed to ensure cached index")
+	AssertEqual(t, false, hasIndex("a_cached"), "Cached index should not be recreated")
+
+	tdb.Session.ResetIndexCache()
+	err = coll.EnsureIndex(index)
+	AssertNoError(t, err, "Failed to ensure index after reset")
+	AssertEqual(t, true, hasIndex("a_cached"), "Index should be recreated after reset")
+
+	// Dropping the collection forgets its indexes
+	err = coll.DropCollection()
+	AssertNoError(t, err, "Failed to drop collection")
+	err = coll.EnsureIndex(index)
+	AssertNoError(t, err, "Failed to ensure index after drop")
+	AssertEqual(t, true, hasIndex("a_cached"), "Index should be recreated after drop")
+}
+
+func TestModernCollectionEnsureIndexes(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	err := coll.EnsureIndexes([]mgo.Index{
+		{Key: []string{"email"}, Unique: true},
+		{Key: []string{"-created"}, Name: "created_desc"},
+		{Key: []string{"$text:title"}},
+	})
+	AssertNoError(t, err, "Failed to ensure indexes")
+
+	indexes, err := coll.Indexes()
+	AssertNoError(t, err, "Failed to list indexes")
+	AssertEqual(t, 4, len(indexes), "Incorrect number of indexes including _id")
+
+	// Ensuring them again, with a new one, only creates the new one
+	err = coll.EnsureIndexes([]mgo.Index{
+		{Key: []string{"email"}, Unique: true},
+		{Key: []string{"status"}},
+	})
+	AssertNoError(t, err, "Failed to ensure indexes again")
+
+	indexes, err = coll.Indexes()
+	AssertNoError(t, err, "Failed to list indexes")
+	AssertEqual(t, 5, len(indexes), "Incorrect number of indexes after second call")
+
+	// Invalid keys fail before reaching the server
+	err = coll.EnsureIndexes([]mgo.Index{{Key: []string{"valid"}}, {Key: []string{"$bogus:x"}}})
+	AssertError(t, err, "Expected error for invalid index key")
+
+	err = coll.EnsureIndexes(nil)
+	AssertNoError(t, err, "Empty index list should succeed")
+}
+
+func TestModernCollectionDropIndexName(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	index := mgo.Index{Key: []string{"a"}, Name: "a_idx"}
+	err := coll.EnsureIndex(index)
+	AssertNoError(t, err, "Failed to ensure index")
+
+	err = coll.DropIndexName("a_idx")
+	AssertNoError(t, err, "Failed to drop index")
+
+	indexes, err := coll.Indexes()
+	AssertNoError(t, err, "Failed to list indexes")
+	AssertEqual(t, 1, len(indexes), "Only the _id index should remain")
+
+	// Dropping forgets the cached index
+	err = coll.EnsureIndex(index)
+	AssertNoError(t, err, "Failed to ensure index again")
+	indexes, err = coll.Indexes()
+	AssertNoError(t, err, "Failed to list indexes")
+	AssertEqual(t, 2, len(indexes), "Index should be recreated")
+
+	err = coll.DropIndexName("missing")
+	AssertError(t, err, "Expected error dropping a missing index")
+}
+
+func TestModernIndexPlan(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	users := tdb.C("users")
+	err := users.EnsureIndexes([]mgo.Index{
+		{Key: []string{"email"}, Unique: true},
+		{Key: []string{"legacy"}},
+	})
+	AssertNoError(t, err, "Failed to ensure initial indexes")
+
+	plan := mgo.NewIndexPlan().
+		Add("users", mgo.Index{Key: []string{"email"}, Unique: true}, mgo.Index{Key: []string{"-created"}}).
+		Add("events", mgo.Index{Key: []string{"ts"}, ExpireAfter: time.Hour})
+
+	// Dry run
+	changes, err := plan.Diff(tdb.DB())
+	AssertNoError(t, err, "Failed to diff plan")
+	AssertEqual(t, 2, len(changes), "Incorrect number of planned changes")
+
+	plan.DropExtra = true
+	changes, err = plan.Diff(tdb.DB())
+	AssertNoError(t, err, "Failed to diff plan")
+	AssertEqual(t, 3, len(changes), "Incorrect number of planned changes with DropExtra")
+	AssertEqual(t, mgo.IndexDrop, changes[0].Action, "Drops should come first")
+	AssertEqual(t, "legacy_1", changes[0].Index.Name, "Incorrect dropped index")
+
+	// Diff does not change anything
+	indexes, err := users.Indexes()
+	AssertNoError(t, err, "Failed to list indexes")
+	AssertEqual(t, 3, len(indexes), "Diff should not change indexes")
+
+	changes, err = plan.Apply(tdb.DB())
+	AssertNoError(t, err, "Failed to apply plan")
+	AssertEqual(t, 3, len(changes), "Incorrect number of applied changes")
+
+	indexes, err = users.Indexes()
+	AssertNoError(t, err, "Failed to list indexes")
+	AssertEqual(t, 3, len(indexes), "Incorrect number of users indexes")
+	indexes, err = tdb.C("events").Indexes()
+	AssertNoError(t, err, "Failed to list indexes")
+	AssertEqual(t, 2, len(indexes), "Incorrect number of events indexes")
+
+	// Applying again is a no-op
+	changes, err = plan.Apply(tdb.DB())
+	AssertNoError(t, err, "Failed to apply plan again")
+	AssertEqual(t, 0, len(changes), "Plan should already be applied")
+
+	// Redeclaring an index with other options replaces it
+	plan = mgo.NewIndexPlan().
+		Add("users", mgo.Index{Key: []string{"email"}, Unique: true, PartialFilter: bson.M{"active": true}})
+	changes, err = plan.Apply(tdb.DB())
+	AssertNoError(t, err, "Failed to apply plan with new options")
+	AssertEqual(t, 2, len(changes), "Expected the index to be dropped and created again")
+	AssertEqual(t, mgo.IndexDrop, changes[0].Action, "Drops should come first")
+	indexes, err = users.Indexes()
+	AssertNoError(t, err, "Failed to list indexes")
+	found := false
+	for _, index := range indexes {
+		if index.Name == "email_1" {
+			found = true
+			AssertEqual(t, true, index.PartialFilter["active"], "Expected the declared partial filter")
+		}
+	}
+	AssertEqual(t, true, found, "Expected the redeclared index")
+	changes, err = plan.Diff(tdb.DB())
+	AssertNoError(t, err, "Failed to diff plan")
+	AssertEqual(t, 0, len(changes), "Plan with options should already be applied")
+}
+
+// Note: DropIndex method is not implemented in the modern wrapper
 // Note: Create method with CollectionInfo is not implemented in the modern wrapper
 
+func TestModernIndexPlanDroppedElsewhere(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	users := tdb.C("users")
+	index := mgo.Index{Key: []string{"email"}, Unique: true}
+	err := users.EnsureIndex(index)
+	AssertNoError(t, err, "Failed to ensure index")
+
+	// Another process drops the index the session has cached
+	err = tdb.DB().Run(bson.D{{Name: "dropIndexes", Value: "users"}, {Name: "index", Value: "email_1"}}, nil)
+	AssertNoError(t, err, "Failed to drop index")
+
+	plan := mgo.NewIndexPlan().Add("users", index)
+	changes, err := plan.Apply(tdb.DB())
+	AssertNoError(t, err, "Failed to apply plan")
+	AssertEqual(t, 1, len(changes), "Expected the index to be created")
+
+	indexes, err := users.Indexes()
+	AssertNoError(t, err, "Failed to list indexes")
+	AssertEqual(t, 2, len(indexes), "Index should be created again despite the cache")
+	changes, err = plan.Diff(tdb.DB())
+	AssertNoError(t, err, "Failed to diff plan")
+	AssertEqual(t, 0, len(changes), "Plan should be applied")
+}
+
+func TestModernIndexPlanNumericKeys(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	// The shell and other drivers store directions and options as doubles
+	// or int64 values
+	err := tdb.DB().Run(bson.D{
+		{Name: "createIndexes", Value: "events"},
+		{Name: "indexes", Value: []bson.M{
+			{"key": bson.D{{Name: "x", Value: float64(-1)}}, "name": "x_-1"},
+			{"key": bson.D{{Name: "y", Value: int64(-1)}, {Name: "z", Value: float64(1)}}, "name": "y_-1_z_1"},
+			{"key": bson.D{{Name: "code", Value: int64(1)}}, "name": "code_1", "unique": 1},
+		}},
+	}, nil)
+	AssertNoError(t, err, "Failed to create indexes")
+
+	plan := mgo.NewIndexPlan().Add("events",
+		mgo.Index{Key: []string{"-x"}},
+		mgo.Index{Key: []string{"-y", "z"}},
+		mgo.Index{Key: []string{"code"}, Unique: true},
+	)
+	plan.DropExtra = true
+	changes, err := plan.Diff(tdb.DB())
+	AssertNoError(t, err, "Failed to diff plan")
+	AssertEqual(t, 0, len(changes), "Indexes with numeric directions should match the plan")
+
+	changes, err = plan.Apply(tdb.DB())
+	AssertNoError(t, err, "Failed to apply plan")
+	AssertEqual(t, 0, len(changes), "Apply should not rebuild matching indexes")
+}
+
 func TestModernCollectionDropCollection(t *testing.T) {
 	// Setup
 	tdb := NewTestDB(t)
@@ -1328,3 +2126,319 @@ func TestModernCollectionInsertDeleteAccountTimeHandling(t *testing.T) {
 		t.Error("Should find at least one recent document")
 	}
 }
+
+func TestModernCollectionFindAndModify(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	for _, name := range []string{"alpha", "Bravo", "charlie"} {
+		err := coll.Insert(bson.M{"name": name, "visits": 0, "tags": []string{"a", "b"}})
+		AssertNoError(t, err, "Failed to insert document")
+	}
+
+	// Sort with a case-insensitive collation and return the new document
+	var result bson.M
+	opts := mgo.FindAndModifyOptions{
+		Sort:      []string{"-name"},
+		Fields:    bson.M{"name": 1, "visits": 1},
+		ReturnNew: true,
+		MaxTime:   5 * time.Second,
+		Collation: &mgo.Collation{Locale: "en", Strength: 2},
+	}
+	info, err := coll.FindAndModify(bson.M{}, bson.M{"$inc": bson.M{"visits": 1}}, opts, &result)
+	AssertNoError(t, err, "Failed to find and modify")
+	AssertEqual(t, 1, info.Updated, "Expected one document updated")
+	AssertEqual(t, "charlie", result["name"], "Expected the last name in collation order")
+	AssertEqual(t, 1, result["visits"], "Expected the modified document")
+	if _, ok := result["tags"]; ok {
+		t.Fatalf("Expected projection to exclude tags, got %v", result)
+	}
+
+	// Array filters and upserts
+	var tagged struct {
+		Tags []string `bson:"tags"`
+	}
+	_, err = coll.FindAndModify(bson.M{"name": "alpha"}, bson.M{"$set": bson.M{"tags.$[t]": "z"}},
+		mgo.FindAndModifyOptions{ArrayFilters: []interface{}{bson.M{"t": "b"}}, ReturnNew: true}, &tagged)
+	AssertNoError(t, err, "Failed to find and modify with array filters")
+	AssertEqual(t, 2, len(tagged.Tags), "Unexpected number of tags")
+	AssertEqual(t, "z", tagged.Tags[1], "Array element not modified")
+
+	info, err = coll.FindAndModify(bson.M{"name": "delta"}, bson.M{"$set": bson.M{"visits": 1}},
+		mgo.FindAndModifyOptions{Upsert: true}, nil)
+	AssertNoError(t, err, "Failed to upsert with find and modify")
+	if info.UpsertedId == nil {
+		t.Fatal("Expected upserted ID")
+	}
+
+	_, err = coll.FindAndModify(bson.M{"name": "echo"}, bson.M{"$set": bson.M{"visits": 1}}, mgo.FindAndModifyOptions{}, nil)
+	AssertEqual(t, mgo.ErrNotFound, err, "Expected ErrNotFound without a match")
+}
+
+func TestModernCollectionReplaceOne(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	id := bson.NewObjectId()
+	err := coll.Insert(bson.M{"_id": id, "name": "Original", "obsolete": true})
+	AssertNoError(t, err, "Failed to insert document")
+
+	// Fields absent from the replacement are removed
+	info, err := coll.ReplaceOne(bson.M{"_id": id}, bson.M{"name": "Replaced"}, false)
+	AssertNoError(t, err, "Failed to replace document")
+	AssertEqual(t, 1, info.Matched, "Expected one matched document")
+	AssertEqual(t, 1, info.Updated, "Expected one replaced document")
+
+	var result bson.M
+	err = coll.FindId(id).One(&result)
+	AssertNoError(t, err, "Failed to find replaced document")
+	AssertEqual(t, "Replaced", result["name"], "Name not replaced")
+	if _, ok := result["obsolete"]; ok {
+		t.Fatalf("Expected obsolete field to be removed, got %v", result)
+	}
+
+	// Without upsert a missing document is left alone
+	info, err = coll.ReplaceOne(bson.M{"name": "Missing"}, bson.M{"name": "Missing"}, false)
+	AssertNoError(t, err, "Failed to replace missing document")
+	AssertEqual(t, 0, info.Matched, "Expected no matched document")
+	if info.UpsertedId != nil {
+		t.Fatalf("Expected no upserted ID, got %v", info.UpsertedId)
+	}
+
+	// With upsert it is inserted
+	info, err = coll.ReplaceOne(bson.M{"name": "Missing"}, bson.M{"name": "Missing"}, true)
+	AssertNoError(t, err, "Failed to upsert replacement")
+	if info.UpsertedId == nil {
+		t.Fatal("Expected upserted ID")
+	}
+}
+
+func TestModernCollectionUpdateWithArrayFilters(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	for i := 0; i < 2; i++ {
+		err := coll.Insert(bson.M{"group": "g", "scores": []int{40, 60, 80}})
+		AssertNoError(t, err, "Failed to insert document")
+	}
+
+	// Cap the scores above 50 in the first matching document
+	info, err := coll.UpdateWithArrayFilters(bson.M{"group": "g"},
+		bson.M{"$set": bson.M{"scores.$[high]": 50}},
+		[]bson.M{{"high": bson.M{"$gt": 50}}}, false)
+	AssertNoError(t, err, "Failed to update with array filters")
+	AssertEqual(t, 1, info.Matched, "Expected one matched document")
+	AssertEqual(t, 1, info.Updated, "Expected one updated document")
+
+	// Then in all of them
+	info, err = coll.UpdateWithArrayFilters(bson.M{"group": "g"},
+		bson.M{"$set": bson.M{"scores.$[high]": 50}},
+		[]bson.M{{"high": bson.M{"$gt": 50}}}, true)
+	AssertNoError(t, err, "Failed to update all with array filters")
+	AssertEqual(t, 2, info.Matched, "Expected two matched documents")
+	AssertEqual(t, 1, info.Updated, "Expected only the remaining document updated")
+
+	var docs []struct {
+		Scores []int `bson:"scores"`
+	}
+	err = coll.Find(bson.M{"group": "g"}).All(&docs)
+	AssertNoError(t, err, "Failed to find documents")
+	for _, doc := range docs {
+		AssertEqual(t, 3, len(doc.Scores), "Unexpected number of scores")
+		AssertEqual(t, 40, doc.Scores[0], "Low score modified")
+		AssertEqual(t, 50, doc.Scores[1], "High score not capped")
+		AssertEqual(t, 50, doc.Scores[2], "High score not capped")
+	}
+
+	// Array filters must be a slice
+	_, err = coll.UpdateWithArrayFilters(bson.M{"group": "g"}, bson.M{"$set": bson.M{"scores.$[x]": 0}}, bson.M{"x": 1}, false)
+	AssertError(t, err, "Expected error for non-slice array filters")
+}
+
+func TestModernCollectionInsertUnordered(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	existing := bson.NewObjectId()
+	err := coll.Insert(bson.M{"_id": existing, "name": "existing"})
+	AssertNoError(t, err, "Failed to insert document")
+
+	// The duplicate does not stop the documents after it
+	err = coll.InsertUnordered(
+		bson.M{"name": "first"},
+		bson.M{"_id": existing, "name": "duplicate"},
+		bson.M{"name": "third"},
+	)
+	AssertError(t, err, "Expected error for duplicate document")
+	bulkErr, ok := err.(*mgo.BulkError)
+	if !ok {
+		t.Fatalf("Expected *mgo.BulkError, got %T", err)
+	}
+	AssertEqual(t, 1, len(bulkErr.Cases()), "Expected one failed document")
+	AssertEqual(t, 1, bulkErr.Cases()[0].Index, "Expected the duplicate's position")
+	if !mgo.IsDup(err) {
+		t.Fatalf("Expected duplicate key error, got %v", err)
+	}
+
+	count, err := coll.Find(bson.M{"name": bson.M{"$in": []string{"first", "third"}}}).Count()
+	AssertNoError(t, err, "Failed to count documents")
+	AssertEqual(t, 2, count, "Expected the other documents to be inserted")
+}
+
+func TestModernCollectionBypassDocumentValidation(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	// Create a collection requiring an email on every document
+	var result bson.M
+	err := tdb.DB().Run(bson.D{
+		{Name: "create", Value: "validated"},
+		{Name: "validator", Value: bson.M{"email": bson.M{"$exists": true}}},
+	}, &result)
+	AssertNoError(t, err, "Failed to create validated collection")
+	coll := tdb.C("validated")
+
+	err = coll.Insert(bson.M{"name": "no email"})
+	AssertError(t, err, "Expected validation failure")
+
+	// Writes through the bypassing handle skip the validator
+	bypass := coll.BypassDocumentValidation()
+	id := bson.NewObjectId()
+	err = bypass.Insert(bson.M{"_id": id, "name": "no email"})
+	AssertNoError(t, err, "Failed to insert bypassing validation")
+	err = bypass.UpdateId(id, bson.M{"$set": bson.M{"name": "still no email"}})
+	AssertNoError(t, err, "Failed to update bypassing validation")
+	_, err = bypass.FindId(id).Apply(mgo.Change{Update: bson.M{"$set": bson.M{"name": "applied"}}}, nil)
+	AssertNoError(t, err, "Failed to apply bypassing validation")
+
+	bulk := bypass.Bulk()
+	bulk.Insert(bson.M{"name": "bulk without email"})
+	_, err = bulk.Run()
+	AssertNoError(t, err, "Failed to run bulk bypassing validation")
+
+	// The original handle still validates
+	err = coll.UpdateId(id, bson.M{"$set": bson.M{"name": "validated again"}})
+	AssertError(t, err, "Expected validation failure on the original handle")
+}
+
+func TestModernCollectionEstimatedCount(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	testData := GetTestData()
+	InsertTestData(t, coll, testData.Users)
+
+	count, err := coll.EstimatedCount()
+	AssertNoError(t, err, "Failed to estimate count")
+	AssertEqual(t, len(testData.Users), count, "Incorrect estimated count")
+
+	// Sessions can use estimated counts for unfiltered counts
+	session := tdb.Session.Copy()
+	defer session.Close()
+	session.SetEstimatedCounts(true)
+	coll = session.DB(tdb.DBName).C("test_collection")
+
+	count, err = coll.Count()
+	AssertNoError(t, err, "Failed to count with estimated counts")
+	AssertEqual(t, len(testData.Users), count, "Incorrect collection count")
+
+	count, err = coll.Find(nil).Count()
+	AssertNoError(t, err, "Failed to count query with estimated counts")
+	AssertEqual(t, len(testData.Users), count, "Incorrect query count")
+
+	count, err = coll.Find(nil).Limit(1).Count()
+	AssertNoError(t, err, "Failed to count limited query")
+	AssertEqual(t, 1, count, "Limited counts must not be estimated")
+}
+
+func TestModernCollectionHexIds(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	session := tdb.Session.Copy()
+	defer session.Close()
+	session.SetHexIds(true)
+	coll := session.DB(tdb.DBName).C("test_collection")
+
+	id := bson.NewObjectId()
+	AssertNoError(t, coll.Insert(bson.M{"_id": id, "name": "hex"}), "Failed to insert document")
+
+	var result bson.M
+	AssertNoError(t, coll.FindId(id.Hex()).One(&result), "Failed to find by hex id")
+	AssertEqual(t, id, result["_id"], "Incorrect document")
+
+	AssertNoError(t, coll.UpdateId(id.Hex(), bson.M{"$set": bson.M{"name": "updated"}}), "Failed to update by hex id")
+	AssertNoError(t, coll.FindId(id).One(&result), "Failed to find by id")
+	AssertEqual(t, "updated", result["name"], "Incorrect updated name")
+
+	AssertNoError(t, coll.RemoveId(id.Hex()), "Failed to remove by hex id")
+	count, err := coll.FindId(id).Count()
+	AssertNoError(t, err, "Failed to count")
+	AssertEqual(t, 0, count, "Expected the document removed")
+
+	// Sessions without the setting match hex strings as strings
+	err = tdb.C("test_collection").FindId(id.Hex()).One(&result)
+	AssertEqual(t, mgo.ErrNotFound, err, "Expected no document with a string id")
+}
+
+func TestModernCollectionZeroValues(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	type zeroes struct {
+		Id       bson.ObjectId `bson:"_id,omitempty"`
+		Name     string        `bson:"name"`
+		Tags     []string      `bson:"tags"`
+		Labels   []string      `bson:"labels,omitempty"`
+		Created  time.Time     `bson:"created"`
+		Deleted  time.Time     `bson:"deleted,omitempty"`
+		ParentId bson.ObjectId `bson:"parentId,omitempty"`
+		Extra    map[string]int
+	}
+	AssertNoError(t, coll.Insert(&zeroes{Name: "zero"}), "Failed to insert document")
+	AssertNoError(t, coll.Insert(bson.M{"name": "map", "tags": []string(nil), "data": []byte{1, 2}}), "Failed to insert map")
+
+	var doc bson.M
+	AssertNoError(t, coll.Find(bson.M{"name": "zero"}).One(&doc), "Failed to find document")
+	if tags, ok := doc["tags"].([]interface{}); !ok || len(tags) != 0 {
+		t.Errorf("Expected the nil slice stored as [], got %#v", doc["tags"])
+	}
+	if extra, ok := doc["extra"].(bson.M); !ok || len(extra) != 0 {
+		t.Errorf("Expected the nil map stored as {}, got %#v", doc["extra"])
+	}
+	if _, ok := doc["created"].(time.Time); !ok {
+		t.Errorf("Expected the zero time stored, got %#v", doc["created"])
+	}
+	for _, key := range []string{"labels", "deleted", "parentId"} {
+		if _, ok := doc[key]; ok {
+			t.Errorf("Expected the empty %s omitted, got %#v", key, doc[key])
+		}
+	}
+
+	AssertNoError(t, coll.Find(bson.M{"name": "map"}).One(&doc), "Failed to find map")
+	if tags, ok := doc["tags"].([]interface{}); !ok || len(tags) != 0 {
+		t.Errorf("Expected the nil slice stored as [], got %#v", doc["tags"])
+	}
+	if data, ok := doc["data"].([]byte); !ok || string(data) != "\x01\x02" {
+		t.Errorf("Expected the bytes stored as binary data, got %#v", doc["data"])
+	}
+}
@@ -3,13 +3,15 @@ package mgo
 import (
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	stdlog "log"
 	"time"
 
-	"github.com/globalsign/mgo/bson"
+	"github.com/kinfkong/modern-mgo/bson"
 	officialBson "go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	mongodrv "go.mongodb.org/mongo-driver/mongo"
@@ -18,102 +20,177 @@ import (
 
 // -------------------- GridFS operations --------------------
 
+// ErrChecksumMismatch is returned by VerifyChecksum when the stored content
+// of a GridFS file does not match its checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrWriteAborted is returned by Write and Close once Abort has been called
+// on a file being written
+var ErrWriteAborted = errors.New("write aborted")
+
+// SetChunkSize sets the chunk size of the files created afterwards. A size of
+// zero or less restores the default of 255KB.
+func (gfs *ModernGridFS) SetChunkSize(size int) {
+	gfs.chunkSize = size
+}
+
+// SetWriteConcern sets the safety mode used to store the files created
+// afterwards, overriding the session one. A nil safe makes their writes
+// unacknowledged.
+func (gfs *ModernGridFS) SetWriteConcern(safe *Safe) {
+	gfs.wc = safeWriteConcern(safe)
+}
+
+// EnsureIndexes creates the standard GridFS indexes, on filename and
+// uploadDate for the files collection and a unique one on files_id and n for
+// the chunks collection. Writing files creates them when first needed; like
+// with EnsureIndex, the session remembers them once created.
+func (gfs *ModernGridFS) EnsureIndexes() error {
+	err := gfs.Files.EnsureIndex(Index{Key: []string{"filename", "uploadDate"}})
+	if err != nil {
+		return err
+	}
+	return gfs.Chunks.EnsureIndex(Index{Key: []string{"files_id", "n"}, Unique: true})
+}
+
+// SetSHA256 sets whether the files created afterwards store a SHA-256
+// checksum of their content in a sha256 field, next to the md5 one
+func (gfs *ModernGridFS) SetSHA256(enabled bool) {
+	gfs.sha256 = enabled
+}
+
 // Create creates a new GridFS file for writing (mgo API compatible)
 func (gfs *ModernGridFS) Create(filename string) (*ModernGridFile, error) {
+	chunkSize := 255 * 1024 // Default chunk size
+	if gfs.chunkSize > 0 {
+		chunkSize = gfs.chunkSize
+	}
 	return &ModernGridFile{
 		id:          bson.NewObjectId(),
 		filename:    filename,
 		contentType: "",
-		chunkSize:   255 * 1024, // Default chunk size
+		chunkSize:   chunkSize,
 		length:      0,
 		uploadDate:  time.Now(),
 		gfs:         gfs,
-		chunks:      make([][]byte, 0),
 		closed:      false,
+		writing:     true,
+		wsum:        md5.New(),
+		wc:          gfs.wc,
+		wsha256:     newSHA256(gfs.sha256),
 		readPos:     0,
-		chunkIndex:  0,
-		chunkPos:    0,
 	}, nil
 }
 
 // Open opens the most recent GridFS file with the given filename for reading (mgo API compatible)
 func (gfs *ModernGridFS) Open(filename string) (*ModernGridFile, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := gfs.Files.session.operationContext(opRead, 10*time.Second)
 	defer cancel()
 
 	filter := convertMGOToOfficial(bson.M{"filename": filename})
 	opts := options.FindOne().SetSort(officialBson.D{{Key: "uploadDate", Value: -1}})
 
-	var fileDoc bson.M
-	err := gfs.Files.mgoColl.FindOne(ctx, filter, opts).Decode(&fileDoc)
-	if err != nil {
-		if err == mongodrv.ErrNoDocuments {
-			return nil, ErrNotFound
-		}
-		return nil, err
-	}
+	return gfs.openFile(ctx, filter, opts)
+}
 
-	file := &ModernGridFile{
-		gfs:        gfs,
-		closed:     false,
-		readPos:    0,
-		chunkIndex: 0,
-		chunkPos:   0,
-	}
+// OpenId opens a GridFS file by its ID for reading (mgo API compatible)
+func (gfs *ModernGridFS) OpenId(id interface{}) (*ModernGridFile, error) {
+	ctx, cancel := gfs.Files.session.operationContext(opRead, 10*time.Second)
+	defer cancel()
 
-	if id, ok := fileDoc["_id"]; ok {
-		file.id = id
-	}
-	if fn, ok := fileDoc["filename"].(string); ok {
-		file.filename = fn
-	}
-	if ct, ok := fileDoc["contentType"].(string); ok {
-		file.contentType = ct
-	}
-	if cs, ok := fileDoc["chunkSize"].(int32); ok {
-		file.chunkSize = int(cs)
-	} else if cs, ok := fileDoc["chunkSize"].(int); ok {
-		file.chunkSize = cs
-	}
-	if length, ok := fileDoc["length"].(int64); ok {
-		file.length = length
-	} else if length, ok := fileDoc["length"].(int32); ok {
-		file.length = int64(length)
+	filter := convertMGOToOfficial(bson.M{"_id": id})
+	return gfs.openFile(ctx, filter)
+}
+
+// OpenVersion opens a specific version of the files stored under filename.
+// Versions are numbered by upload date as in the GridFS specification: 0 is
+// the original file, 1 the first revision and so on, while -1 is the most
+// recent version, -2 the one before it, etc. It returns ErrNotFound when no
+// such version exists.
+func (gfs *ModernGridFS) OpenVersion(filename string, version int) (*ModernGridFile, error) {
+	ctx, cancel := gfs.Files.session.operationContext(opRead, 10*time.Second)
+	defer cancel()
+
+	order, skip := 1, version
+	if version < 0 {
+		order, skip = -1, -version-1
 	}
-	if md5str, ok := fileDoc["md5"].(string); ok {
-		file.md5 = md5str
+	filter := convertMGOToOfficial(bson.M{"filename": filename})
+	opts := options.FindOne().
+		SetSort(officialBson.D{{Key: "uploadDate", Value: order}, {Key: "_id", Value: order}}).
+		SetSkip(int64(skip))
+
+	return gfs.openFile(ctx, filter, opts)
+}
+
+// FindAllVersions opens every version of the files stored under filename for
+// reading, oldest first. It returns an empty slice when there are none.
+func (gfs *ModernGridFS) FindAllVersions(filename string) ([]*ModernGridFile, error) {
+	iter := gfs.Iter(bson.M{"filename": filename})
+	var files []*ModernGridFile
+	var file *ModernGridFile
+	for iter.Next(&file) {
+		files = append(files, file)
+		file = nil
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
 	}
-	if ud, ok := fileDoc["uploadDate"].(time.Time); ok {
-		file.uploadDate = ud
+	return files, nil
+}
+
+// Iter returns an iterator opening the files matching selector for reading,
+// in upload order. Unlike Find, it yields files rather than raw documents.
+func (gfs *ModernGridFS) Iter(selector interface{}) *ModernGridIter {
+	return &ModernGridIter{
+		gfs:  gfs,
+		iter: gfs.Files.Find(selector).Sort("uploadDate", "_id").Iter(),
 	}
-	if metadata, ok := fileDoc["metadata"]; ok {
-		file.metadata = metadata
+}
+
+// Next opens the next file of the iteration into *file, reporting false when
+// there are no more files or an error occurred. Files are independent, so the
+// previous file is left open and remains usable.
+func (it *ModernGridIter) Next(file **ModernGridFile) bool {
+	var fileDoc bson.M
+	if !it.iter.Next(&fileDoc) {
+		*file = nil
+		return false
 	}
+	*file = it.gfs.readFile(fileDoc)
+	return true
+}
 
-	return file, nil
+// Err returns the error that stopped the iteration, if any
+func (it *ModernGridIter) Err() error {
+	return it.iter.Err()
 }
 
-// OpenId opens a GridFS file by its ID for reading (mgo API compatible)
-func (gfs *ModernGridFS) OpenId(id interface{}) (*ModernGridFile, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// Close closes the iterator and returns its error, if any
+func (it *ModernGridIter) Close() error {
+	return it.iter.Close()
+}
 
-	filter := convertMGOToOfficial(bson.M{"_id": id})
-	var fileDoc bson.M
-	err := gfs.Files.mgoColl.FindOne(ctx, filter).Decode(&fileDoc)
+// openFile opens for reading the first file whose files document matches
+// filter, decoded with mgo types as query results are
+func (gfs *ModernGridFS) openFile(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*ModernGridFile, error) {
+	raw, err := gfs.Files.readColl().FindOne(ctx, filter, opts...).Raw()
 	if err != nil {
-		if err == mongodrv.ErrNoDocuments {
-			return nil, ErrNotFound
-		}
+		return nil, convertError(err)
+	}
+	var fileDoc bson.M
+	if err := decodeDocument(raw, &fileDoc); err != nil {
 		return nil, err
 	}
+	return gfs.readFile(fileDoc), nil
+}
 
+// readFile returns a file opened for reading from its files document
+func (gfs *ModernGridFS) readFile(fileDoc bson.M) *ModernGridFile {
 	file := &ModernGridFile{
-		gfs:        gfs,
-		closed:     false,
-		readPos:    0,
-		chunkIndex: 0,
-		chunkPos:   0,
+		gfs:     gfs,
+		closed:  false,
+		readPos: 0,
 	}
 
 	if id, ok := fileDoc["_id"]; ok {
@@ -138,60 +215,101 @@ func (gfs *ModernGridFS) OpenId(id interface{}) (*ModernGridFile, error) {
 	if md5str, ok := fileDoc["md5"].(string); ok {
 		file.md5 = md5str
 	}
-	if ud, ok := fileDoc["uploadDate"].(time.Time); ok {
+	if sha, ok := fileDoc["sha256"].(string); ok {
+		file.sha256 = sha
+	}
+	switch ud := fileDoc["uploadDate"].(type) {
+	case time.Time:
 		file.uploadDate = ud
+	case primitive.DateTime:
+		file.uploadDate = ud.Time()
 	}
 	if metadata, ok := fileDoc["metadata"]; ok {
 		file.metadata = metadata
 	}
-
-	return file, nil
+	return file
 }
 
 // Remove removes all GridFS files with the given filename (mgo API compatible)
 func (gfs *ModernGridFS) Remove(filename string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ids, err := gfs.fileIds(filename)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := gfs.RemoveId(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileIds returns the ids of the files with the given filename
+func (gfs *ModernGridFS) fileIds(filename string) ([]interface{}, error) {
+	ctx, cancel := gfs.Files.session.operationContext(opRead, 10*time.Second)
 	defer cancel()
 
 	filter := convertMGOToOfficial(bson.M{"filename": filename})
-	cursor, err := gfs.Files.mgoColl.Find(ctx, filter)
+	cursor, err := gfs.Files.readColl().Find(ctx, filter)
 	if err != nil {
-		return err
+		return nil, convertError(err)
 	}
 	defer cursor.Close(ctx)
 
 	var ids []interface{}
 	for cursor.Next(ctx) {
 		var doc bson.M
-		if err := cursor.Decode(&doc); err != nil {
-			continue
+		if err := decodeDocument(cursor.Current, &doc); err != nil {
+			return nil, err
 		}
 		if id, ok := doc["_id"]; ok {
 			ids = append(ids, id)
 		}
 	}
-
-	for _, id := range ids {
-		if err := gfs.RemoveId(id); err != nil {
-			return err
-		}
+	// Files left out by a failed read must not be reported as removed
+	if err := cursor.Err(); err != nil {
+		return nil, convertError(err)
 	}
-	return nil
+	return ids, nil
 }
 
 // RemoveId removes a GridFS file by its ID (mgo API compatible)
 func (gfs *ModernGridFS) RemoveId(id interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := gfs.Files.session.operationContext(opWrite, 10*time.Second)
 	defer cancel()
 
 	fileFilter := convertMGOToOfficial(bson.M{"_id": id})
-	if _, err := gfs.Files.mgoColl.DeleteOne(ctx, fileFilter); err != nil {
+	gfs.Files.noteWrite()
+	_, err := gfs.Files.coll().DeleteOne(ctx, fileFilter)
+	if err = convertError(err); err != nil {
 		return err
 	}
 
 	chunkFilter := convertMGOToOfficial(bson.M{"files_id": id})
-	_, err := gfs.Chunks.mgoColl.DeleteMany(ctx, chunkFilter)
-	return err
+	_, err = gfs.Chunks.coll().DeleteMany(ctx, chunkFilter)
+	return convertError(err)
+}
+
+// RenameId changes the filename of the stored file with the given ID, leaving
+// its content untouched. It returns ErrNotFound when no such file exists.
+func (gfs *ModernGridFS) RenameId(id interface{}, filename string) error {
+	return gfs.Files.UpdateId(id, bson.M{"$set": bson.M{"filename": filename}})
+}
+
+// SetContentTypeId changes the content type of the stored file with the
+// given ID. It returns ErrNotFound when no such file exists.
+func (gfs *ModernGridFS) SetContentTypeId(id interface{}, contentType string) error {
+	return gfs.Files.UpdateId(id, bson.M{"$set": bson.M{"contentType": contentType}})
+}
+
+// SetMetaId replaces the metadata of the stored file with the given ID, or
+// removes it when meta is nil. It returns ErrNotFound when no such file
+// exists.
+func (gfs *ModernGridFS) SetMetaId(id interface{}, meta interface{}) error {
+	if meta == nil {
+		return gfs.Files.UpdateId(id, bson.M{"$unset": bson.M{"metadata": ""}})
+	}
+	return gfs.Files.UpdateId(id, bson.M{"$set": bson.M{"metadata": meta}})
 }
 
 // Find returns a query for finding GridFS files (mgo API compatible)
@@ -211,283 +329,369 @@ func (gfs *ModernGridFS) OpenNext(iter *ModernIt, file **ModernGridFile) bool {
 		return false
 	}
 
-	f := &ModernGridFile{
-		gfs:        gfs,
-		closed:     false,
-		readPos:    0,
-		chunkIndex: 0,
-		chunkPos:   0,
-	}
-
-	if id, ok := fileDoc["_id"]; ok {
-		f.id = id
-	}
-	if fn, ok := fileDoc["filename"].(string); ok {
-		f.filename = fn
-	}
-	if ct, ok := fileDoc["contentType"].(string); ok {
-		f.contentType = ct
-	}
-	if cs, ok := fileDoc["chunkSize"].(int32); ok {
-		f.chunkSize = int(cs)
-	} else if cs, ok := fileDoc["chunkSize"].(int); ok {
-		f.chunkSize = cs
-	}
-	if length, ok := fileDoc["length"].(int64); ok {
-		f.length = length
-	} else if length, ok := fileDoc["length"].(int32); ok {
-		f.length = int64(length)
-	}
-	if md5str, ok := fileDoc["md5"].(string); ok {
-		f.md5 = md5str
-	}
-	if ud, ok := fileDoc["uploadDate"].(time.Time); ok {
-		f.uploadDate = ud
-	}
-	if metadata, ok := fileDoc["metadata"]; ok {
-		f.metadata = metadata
-	}
-
-	*file = f
+	*file = gfs.readFile(fileDoc)
 	return true
 }
 
 // -------------------- GridFile operations --------------------
 
-// Write writes data to the GridFS file (mgo API compatible)
+// gridFSBatchSize is the amount of chunk data stored by each insertMany
+// command while writing GridFS files, bounding the memory used by writes
+const gridFSBatchSize = 16 * 1024 * 1024
+
+// Write writes data to the GridFS file (mgo API compatible). Full chunks are
+// stored in batches of up to 16MB as data is written, and the file document
+// is stored by Close. Write may run in another goroutine than Close and
+// Abort, for instance under io.Copy; it returns ErrWriteAborted once the
+// write is aborted.
 func (f *ModernGridFile) Write(data []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	if f.closed {
 		return 0, errors.New("file is closed")
 	}
-
-	// Initialize chunks if needed
-	if f.chunks == nil {
-		f.chunks = make([][]byte, 0)
-		f.chunkIndex = 0
-		f.chunkPos = 0
+	if !f.writing {
+		return 0, errors.New("file not opened for writing")
+	}
+	if err := f.checkAborted(); err != nil {
+		return 0, err
 	}
 
 	totalWritten := 0
 	remainingData := data
 
 	for len(remainingData) > 0 {
-		// Create new chunk if needed
-		if f.chunkIndex >= len(f.chunks) {
-			f.chunks = append(f.chunks, make([]byte, 0, f.chunkSize))
+		// Queue the current chunk once full
+		if len(f.wbuf) >= f.chunkSize {
+			f.wpending = append(f.wpending, f.wbuf)
+			f.wbuf = nil
+			if len(f.wpending)*f.chunkSize >= gridFSBatchSize {
+				if err := f.flushChunks(); err != nil {
+					return totalWritten, err
+				}
+			}
 		}
-
-		currentChunk := f.chunks[f.chunkIndex]
-		spaceInChunk := f.chunkSize - len(currentChunk)
-
-		if spaceInChunk <= 0 {
-			// Current chunk is full, move to next
-			f.chunkIndex++
-			continue
+		if f.wbuf == nil {
+			f.wbuf = make([]byte, 0, f.chunkSize)
 		}
 
-		// Write what we can to current chunk
-		toWrite := len(remainingData)
-		if toWrite > spaceInChunk {
-			toWrite = spaceInChunk
+		// Write what fits in the current chunk
+		toWrite := f.chunkSize - len(f.wbuf)
+		if toWrite > len(remainingData) {
+			toWrite = len(remainingData)
+		}
+		f.wbuf = append(f.wbuf, remainingData[:toWrite]...)
+		f.wsum.Write(remainingData[:toWrite])
+		if f.wsha256 != nil {
+			f.wsha256.Write(remainingData[:toWrite])
 		}
-
-		// Append to current chunk
-		f.chunks[f.chunkIndex] = append(currentChunk, remainingData[:toWrite]...)
 
 		totalWritten += toWrite
 		f.length += int64(toWrite)
 		remainingData = remainingData[toWrite:]
-
-		// If chunk is full, prepare for next
-		if len(f.chunks[f.chunkIndex]) >= f.chunkSize {
-			f.chunkIndex++
-		}
 	}
 
 	return totalWritten, nil
 }
 
-// Read reads data from the GridFS file (mgo API compatible)
+// flushChunks stores the queued chunks with a single insertMany command
+func (f *ModernGridFile) flushChunks() error {
+	if err := f.checkAborted(); err != nil {
+		return err
+	}
+	if err := f.gfs.EnsureIndexes(); err != nil {
+		f.werr = err
+		return err
+	}
+
+	ctx, cancel := f.gfs.Chunks.session.operationContext(opWrite, 30*time.Second)
+	defer cancel()
+
+	docs := make([]interface{}, len(f.wpending))
+	for i, data := range f.wpending {
+		docs[i] = convertMGOToOfficial(bson.M{
+			"_id":      bson.NewObjectId(),
+			"files_id": f.id,
+			"n":        f.wn + i,
+			"data":     data,
+		})
+	}
+	// Count the chunks before inserting them, so that removeChunks cleans
+	// up after a partially failed insert
+	f.wn += len(docs)
+	f.wpending = nil
+
+	f.gfs.Chunks.noteWrite()
+	coll, err := f.writeColl(f.gfs.Chunks)
+	if err == nil {
+		_, err = coll.InsertMany(ctx, docs)
+	}
+	if err = convertError(err); err != nil {
+		f.werr = err
+		return err
+	}
+	return nil
+}
+
+// Read reads data from the GridFS file (mgo API compatible). Chunks are
+// fetched one at a time as the read position advances, so only one chunk of
+// the file is held in memory.
 func (f *ModernGridFile) Read(data []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	if f.closed {
 		return 0, errors.New("file is closed")
 	}
+	if f.writing {
+		return 0, errors.New("file not opened for reading")
+	}
 
 	// Debug logging
 	if DebugConversion {
-		stdlog.Printf("GridFS Read: readPos=%d, length=%d, chunkIndex=%d, chunks=%v",
-			f.readPos, f.length, f.chunkIndex, f.chunks != nil)
+		stdlog.Printf("GridFS Read: readPos=%d, length=%d, chunkIndex=%d, loaded=%v",
+			f.readPos, f.length, f.chunkIndex, f.chunk != nil)
 	}
 
 	// Check if we've reached EOF
 	if f.readPos >= f.length {
 		return 0, io.EOF
 	}
+	if f.chunkSize <= 0 {
+		return 0, fmt.Errorf("invalid chunk size %d", f.chunkSize)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Load chunks from database if not already loaded
-	if f.chunks == nil {
-		filter := convertMGOToOfficial(bson.M{"files_id": f.id})
-		opts := options.Find().SetSort(officialBson.D{{Key: "n", Value: 1}})
+	totalRead := 0
+	for totalRead < len(data) && f.readPos < f.length {
+		n := int(f.readPos / int64(f.chunkSize))
+		if f.chunk == nil || f.chunkIndex != n {
+			if err := f.loadChunk(n); err != nil {
+				return totalRead, err
+			}
+		}
 
-		cursor, err := f.gfs.Chunks.mgoColl.Find(ctx, filter, opts)
-		if err != nil {
-			return 0, err
+		// Read what we can from this chunk, without going past the file length
+		chunkPos := int(f.readPos - int64(n)*int64(f.chunkSize))
+		if chunkPos >= len(f.chunk) {
+			return totalRead, io.ErrUnexpectedEOF
+		}
+		end := len(f.chunk)
+		if remaining := f.length - f.readPos; int64(end-chunkPos) > remaining {
+			end = chunkPos + int(remaining)
 		}
-		defer cursor.Close(ctx)
+		copied := copy(data[totalRead:], f.chunk[chunkPos:end])
+		totalRead += copied
+		f.readPos += int64(copied)
+	}
 
-		f.chunks = make([][]byte, 0)
-		for cursor.Next(ctx) {
-			var chunkDoc bson.M
-			if err := cursor.Decode(&chunkDoc); err != nil {
-				continue
-			}
+	return totalRead, nil
+}
 
-			var chunkData []byte
-			switch dt := chunkDoc["data"].(type) {
-			case []byte:
-				chunkData = dt
-			case primitive.Binary:
-				chunkData = dt.Data
-			case primitive.A:
-				// Handle array of bytes (primitive.A)
-				chunkData = make([]byte, len(dt))
-				for i, v := range dt {
-					if b, ok := v.(byte); ok {
-						chunkData[i] = b
-					} else if n, ok := v.(int32); ok && n >= 0 && n <= 255 {
-						chunkData[i] = byte(n)
-					} else if n, ok := v.(int64); ok && n >= 0 && n <= 255 {
-						chunkData[i] = byte(n)
-					} else if n, ok := v.(float64); ok && n >= 0 && n <= 255 {
-						chunkData[i] = byte(n)
-					} else {
-						if DebugConversion {
-							stdlog.Printf("GridFS Read: Unknown type in array at index %d: %T = %v", i, v, v)
-						}
-					}
-				}
-			case []interface{}:
-				// Handle slice of interfaces
-				chunkData = make([]byte, len(dt))
-				for i, v := range dt {
-					if b, ok := v.(byte); ok {
-						chunkData[i] = b
-					} else if n, ok := v.(int32); ok && n >= 0 && n <= 255 {
-						chunkData[i] = byte(n)
-					} else if n, ok := v.(int64); ok && n >= 0 && n <= 255 {
-						chunkData[i] = byte(n)
-					} else if n, ok := v.(float64); ok && n >= 0 && n <= 255 {
-						chunkData[i] = byte(n)
-					} else {
-						if DebugConversion {
-							stdlog.Printf("GridFS Read: Unknown type in slice at index %d: %T = %v", i, v, v)
-						}
-					}
-				}
-			default:
-				if DebugConversion {
-					stdlog.Printf("GridFS Read: Unknown data type in chunk: %T", chunkDoc["data"])
-				}
-				continue
-			}
+// loadChunk fetches chunk n of the file for Read
+func (f *ModernGridFile) loadChunk(n int) error {
+	ctx, cancel := f.gfs.Chunks.session.operationContext(opRead, 10*time.Second)
+	defer cancel()
 
-			if len(chunkData) > 0 {
-				f.chunks = append(f.chunks, chunkData)
-			}
-		}
+	filter := convertMGOToOfficial(bson.M{"files_id": f.id, "n": n})
+	var chunkDoc bson.M
+	err := f.gfs.Chunks.readColl().FindOne(ctx, filter).Decode(&chunkDoc)
+	if errors.Is(err, mongodrv.ErrNoDocuments) {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return convertError(err)
+	}
+	data, ok := chunkBytes(chunkDoc["data"])
+	if !ok {
+		return fmt.Errorf("invalid data in chunk %d of file %v", n, f.id)
+	}
+	f.chunk, f.chunkIndex = data, n
+	return nil
+}
 
-		// Reset read position to beginning if loading fresh
-		f.chunkIndex = 0
-		f.chunkPos = 0
-		f.readPos = 0
+// ReadAt reads len(p) bytes of the file starting at offset off, implementing
+// io.ReaderAt. It fetches the chunks it needs and leaves the position used by
+// Read untouched, so concurrent ReadAt calls can read different parts of the
+// file.
+func (f *ModernGridFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	closed := f.closed
+	f.mu.Unlock()
+	if closed {
+		return 0, errors.New("file is closed")
+	}
+	if f.writing {
+		return 0, errors.New("file not opened for reading")
+	}
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= f.length {
+		return 0, io.EOF
+	}
+	if f.chunkSize <= 0 {
+		return 0, fmt.Errorf("invalid chunk size %d", f.chunkSize)
+	}
 
-		if DebugConversion {
-			stdlog.Printf("GridFS Read: Loaded %d chunks from database", len(f.chunks))
-		}
+	end := off + int64(len(p))
+	if end > f.length {
+		end = f.length
 	}
+	first := off / int64(f.chunkSize)
+	last := (end - 1) / int64(f.chunkSize)
 
-	totalRead := 0
-	remainingBytes := len(data)
-
-	// Read from current position
-	for f.chunkIndex < len(f.chunks) && remainingBytes > 0 {
-		currentChunk := f.chunks[f.chunkIndex]
-
-		// Calculate how many bytes we can read from current chunk
-		availableInChunk := len(currentChunk) - f.chunkPos
-		if availableInChunk <= 0 {
-			// Move to next chunk
-			f.chunkIndex++
-			f.chunkPos = 0
-			continue
-		}
+	ctx, cancel := f.gfs.Files.session.operationContext(opRead, 10*time.Second)
+	defer cancel()
 
-		// Read what we can from this chunk
-		toRead := availableInChunk
-		if toRead > remainingBytes {
-			toRead = remainingBytes
-		}
+	filter := convertMGOToOfficial(bson.M{"files_id": f.id, "n": bson.M{"$gte": first, "$lte": last}})
+	opts := options.Find().SetSort(officialBson.D{{Key: "n", Value: 1}})
+	cursor, err := f.gfs.Chunks.readColl().Find(ctx, filter, opts)
+	if err != nil {
+		return 0, convertError(err)
+	}
+	defer cursor.Close(ctx)
 
-		// Don't read past the file length
-		if f.readPos+int64(toRead) > f.length {
-			toRead = int(f.length - f.readPos)
+	read := 0
+	pos := off
+	for pos < end && cursor.Next(ctx) {
+		var chunkDoc bson.M
+		if err := cursor.Decode(&chunkDoc); err != nil {
+			return read, err
 		}
-
-		copy(data[totalRead:totalRead+toRead], currentChunk[f.chunkPos:f.chunkPos+toRead])
-
-		totalRead += toRead
-		f.chunkPos += toRead
-		f.readPos += int64(toRead)
-		remainingBytes -= toRead
-
-		// If we've read the entire chunk, move to next
-		if f.chunkPos >= len(currentChunk) {
-			f.chunkIndex++
-			f.chunkPos = 0
+		data, ok := chunkBytes(chunkDoc["data"])
+		if !ok {
+			return read, fmt.Errorf("invalid data in chunk %v of file %v", chunkDoc["n"], f.id)
 		}
-
-		// Stop if we've reached the file length
-		if f.readPos >= f.length {
-			break
+		// A missing chunk would shift the data, so chunks must be contiguous
+		chunkStart := int64(numberToInt(chunkDoc["n"])) * int64(f.chunkSize)
+		if chunkStart > pos || chunkStart+int64(len(data)) <= pos {
+			return read, io.ErrUnexpectedEOF
 		}
+		read += copy(p[read:end-off], data[pos-chunkStart:])
+		pos = off + int64(read)
 	}
+	if err := cursor.Err(); err != nil {
+		return read, convertError(err)
+	}
+	if pos < end {
+		return read, io.ErrUnexpectedEOF
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
 
-	if totalRead == 0 && f.readPos >= f.length {
-		return 0, io.EOF
+// chunkBytes returns the bytes held by the data field of a chunk document
+func chunkBytes(data interface{}) ([]byte, bool) {
+	var chunkData []byte
+	switch dt := data.(type) {
+	case []byte:
+		chunkData = dt
+	case primitive.Binary:
+		chunkData = dt.Data
+	case primitive.A:
+		// Handle array of bytes (primitive.A)
+		chunkData = bytesFromArray(dt)
+	case []interface{}:
+		// Handle slice of interfaces
+		chunkData = bytesFromArray(dt)
+	default:
+		if DebugConversion {
+			stdlog.Printf("GridFS Read: Unknown data type in chunk: %T", data)
+		}
+		return nil, false
 	}
+	return chunkData, true
+}
 
-	return totalRead, nil
+// bytesFromArray converts an array of numbers to bytes
+func bytesFromArray(values []interface{}) []byte {
+	chunkData := make([]byte, len(values))
+	for i, v := range values {
+		if b, ok := v.(byte); ok {
+			chunkData[i] = b
+		} else if n, ok := v.(int32); ok && n >= 0 && n <= 255 {
+			chunkData[i] = byte(n)
+		} else if n, ok := v.(int64); ok && n >= 0 && n <= 255 {
+			chunkData[i] = byte(n)
+		} else if n, ok := v.(float64); ok && n >= 0 && n <= 255 {
+			chunkData[i] = byte(n)
+		} else {
+			if DebugConversion {
+				stdlog.Printf("GridFS Read: Unknown type in array at index %d: %T = %v", i, v, v)
+			}
+		}
+	}
+	return chunkData
 }
 
-// Close closes the GridFS file (mgo API compatible)
+// Close closes the GridFS file (mgo API compatible). For files created with
+// Create, it stores the last chunk and the file document. When storing the
+// file fails, the chunks already stored are removed.
 func (f *ModernGridFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	if f.closed {
 		return nil
 	}
+	f.closed = true
 
-	if len(f.chunks) > 0 {
-		if err := f.saveFile(); err != nil {
-			return err
-		}
+	if !f.writing {
+		return nil
+	}
+	err := f.checkAborted()
+	if len(f.wbuf) > 0 {
+		f.wpending = append(f.wpending, f.wbuf)
+		f.wbuf = nil
+	}
+	if err == nil && len(f.wpending) > 0 {
+		err = f.flushChunks()
+	}
+	if err == nil {
+		err = f.saveFile()
 	}
+	if err != nil {
+		f.removeChunks()
+	}
+	return err
+}
 
-	f.closed = true
-	return nil
+// Abort cancels the writing of a file created with Create (mgo API
+// compatible). Close then removes the chunks already stored instead of
+// storing the file, and returns ErrWriteAborted. Abort does not wait for a
+// Write in progress in another goroutine, which stops before storing its next
+// batch of chunks.
+func (f *ModernGridFile) Abort() {
+	if f.writing {
+		f.aborted.Store(true)
+	}
 }
 
-// saveFile persists the GridFS file and its chunks to MongoDB
+// checkAborted returns the first error met while writing, recording
+// ErrWriteAborted once Abort has been called. It must be called with f.mu
+// held.
+func (f *ModernGridFile) checkAborted() error {
+	if f.werr == nil && f.aborted.Load() {
+		f.werr = ErrWriteAborted
+	}
+	return f.werr
+}
+
+// saveFile persists the GridFS file document once all chunks are stored
 func (f *ModernGridFile) saveFile() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := f.gfs.EnsureIndexes(); err != nil {
+		return err
+	}
+
+	ctx, cancel := f.gfs.Files.session.operationContext(opWrite, 30*time.Second)
 	defer cancel()
 
-	hasher := md5.New()
-	for _, chunk := range f.chunks {
-		hasher.Write(chunk)
-	}
-	f.md5 = fmt.Sprintf("%x", hasher.Sum(nil))
+	f.gfs.Files.noteWrite()
+
+	f.md5 = fmt.Sprintf("%x", f.wsum.Sum(nil))
 
 	fileDoc := bson.M{
 		"_id":         f.id,
@@ -498,30 +702,41 @@ func (f *ModernGridFile) saveFile() error {
 		"uploadDate":  f.uploadDate,
 		"md5":         f.md5,
 	}
+	if f.wsha256 != nil {
+		f.sha256 = fmt.Sprintf("%x", f.wsha256.Sum(nil))
+		fileDoc["sha256"] = f.sha256
+	}
 	if f.metadata != nil {
 		fileDoc["metadata"] = f.metadata
 	}
 
-	if _, err := f.gfs.Files.mgoColl.InsertOne(ctx, convertMGOToOfficial(fileDoc)); err != nil {
+	coll, err := f.writeColl(f.gfs.Files)
+	if err != nil {
 		return err
 	}
+	_, err = coll.InsertOne(ctx, convertMGOToOfficial(fileDoc))
+	return convertError(err)
+}
 
-	for i, data := range f.chunks {
-		chunkDoc := bson.M{
-			"_id":      bson.NewObjectId(),
-			"files_id": f.id,
-			"n":        i,
-			"data":     data,
-		}
-		if _, err := f.gfs.Chunks.mgoColl.InsertOne(ctx, convertMGOToOfficial(chunkDoc)); err != nil {
-			return err
-		}
+// writeColl returns the handle used to store the file in c, applying the
+// write concern the file was created with
+func (f *ModernGridFile) writeColl(c *ModernColl) (*mongodrv.Collection, error) {
+	if f.wc == nil {
+		return c.coll(), nil
 	}
+	return c.coll().Clone(options.Collection().SetWriteConcern(f.wc))
+}
+
+// removeChunks deletes the chunks stored for a file that could not be saved
+func (f *ModernGridFile) removeChunks() {
+	if f.wn == 0 {
+		return
+	}
+	ctx, cancel := f.gfs.Chunks.session.operationContext(opWrite, 10*time.Second)
+	defer cancel()
 
-	return f.gfs.Chunks.EnsureIndex(Index{
-		Key:    []string{"files_id", "n"},
-		Unique: true,
-	})
+	filter := convertMGOToOfficial(bson.M{"files_id": f.id})
+	f.gfs.Chunks.coll().DeleteMany(ctx, filter)
 }
 
 // Id returns the file ID
@@ -548,6 +763,49 @@ func (f *ModernGridFile) Size() int64 { return f.length }
 // MD5 returns the file checksum
 func (f *ModernGridFile) MD5() string { return f.md5 }
 
+// SHA256 returns the SHA-256 checksum of the file, or an empty string when
+// the file was stored without one
+func (f *ModernGridFile) SHA256() string { return f.sha256 }
+
+// VerifyChecksum reads the stored content of the file and checks it against
+// its SHA-256 checksum, or its MD5 one when the file has no SHA-256 checksum.
+// It returns ErrChecksumMismatch when the content does not match, and leaves
+// the position used by Read untouched.
+func (f *ModernGridFile) VerifyChecksum() error {
+	var sum hash.Hash
+	var want string
+	switch {
+	case f.sha256 != "":
+		sum, want = sha256.New(), f.sha256
+	case f.md5 != "":
+		sum, want = md5.New(), f.md5
+	default:
+		return errors.New("file has no checksum")
+	}
+
+	if f.chunkSize <= 0 {
+		return fmt.Errorf("invalid chunk size %d", f.chunkSize)
+	}
+
+	// Read a chunk at a time
+	buf := make([]byte, f.chunkSize)
+	if _, err := io.CopyBuffer(sum, io.NewSectionReader(f, 0, f.length), buf); err != nil {
+		return err
+	}
+	if fmt.Sprintf("%x", sum.Sum(nil)) != want {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// newSHA256 returns a SHA-256 hash when enabled, nil otherwise
+func newSHA256(enabled bool) hash.Hash {
+	if !enabled {
+		return nil
+	}
+	return sha256.New()
+}
+
 // UploadDate returns the upload timestamp
 func (f *ModernGridFile) UploadDate() time.Time { return f.uploadDate }
 
@@ -565,5 +823,11 @@ func (f *ModernGridFile) GetMeta(result interface{}) error {
 // SetMeta sets the metadata object
 func (f *ModernGridFile) SetMeta(meta interface{}) { f.metadata = meta }
 
-// SetChunkSize overrides the chunk size used for this file
-func (f *ModernGridFile) SetChunkSize(size int) { f.chunkSize = size }
+// SetChunkSize overrides the chunk size used for this file. It has no effect
+// once data was written, as all chunks but the last must have the same size,
+// nor for a size of zero or less, which leaves the chunk size unchanged.
+func (f *ModernGridFile) SetChunkSize(size int) {
+	if f.length == 0 && size > 0 {
+		f.chunkSize = size
+	}
+}
@@ -0,0 +1,76 @@
+package mgo
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// TestGridFileConcurrentAbort checks that a write running in another
+// goroutine stops with ErrWriteAborted once the file is aborted
+func TestGridFileConcurrentAbort(t *testing.T) {
+	gfs := &ModernGridFS{}
+	file, err := gfs.Create("upload.bin")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	written := make(chan struct{})
+	aborted := make(chan struct{})
+	var wg sync.WaitGroup
+	var firstErr, writeErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		data := make([]byte, 64)
+		_, firstErr = file.Write(data)
+		close(written)
+		<-aborted
+		_, writeErr = file.Write(data)
+	}()
+
+	<-written
+	file.Abort()
+	close(aborted)
+	wg.Wait()
+	if firstErr != nil {
+		t.Fatalf("Write failed: %v", firstErr)
+	}
+	if !errors.Is(writeErr, ErrWriteAborted) {
+		t.Errorf("Expected ErrWriteAborted from the concurrent write, got %v", writeErr)
+	}
+
+	if _, err := file.Write([]byte("more")); !errors.Is(err, ErrWriteAborted) {
+		t.Errorf("Expected ErrWriteAborted after abort, got %v", err)
+	}
+	if err := file.Close(); !errors.Is(err, ErrWriteAborted) {
+		t.Errorf("Expected ErrWriteAborted from Close, got %v", err)
+	}
+	if _, err := file.Write([]byte("more")); err == nil {
+		t.Error("Expected an error writing to a closed file")
+	}
+}
+
+// TestGridFileChunkSize checks non-positive chunk sizes are ignored, leaving
+// writes to split the data into chunks of the previous size
+func TestGridFileChunkSize(t *testing.T) {
+	gfs := &ModernGridFS{}
+	file, err := gfs.Create("upload.bin")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	file.SetChunkSize(16)
+	file.SetChunkSize(0)
+	file.SetChunkSize(-1)
+	if file.chunkSize != 16 {
+		t.Fatalf("Expected the chunk size to stay 16, got %d", file.chunkSize)
+	}
+
+	if n, err := file.Write(make([]byte, 40)); n != 40 || err != nil {
+		t.Fatalf("Expected 40 bytes written, got %d (err=%v)", n, err)
+	}
+	if len(file.wpending) != 2 || len(file.wbuf) != 8 {
+		t.Errorf("Expected 2 full chunks and 8 buffered bytes, got %d and %d",
+			len(file.wpending), len(file.wbuf))
+	}
+}
@@ -2,10 +2,17 @@ package mgo_test
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/globalsign/mgo/bson"
+	mgo "github.com/kinfkong/modern-mgo"
+	"github.com/kinfkong/modern-mgo/bson"
 )
 
 func TestModernGridFSCreate(t *testing.T) {
@@ -111,6 +118,9 @@ func TestModernGridFSOpenId(t *testing.T) {
 	if file2.Name() != "file_with_id.txt" {
 		t.Fatalf("Expected filename 'file_with_id.txt', got '%s'", file2.Name())
 	}
+	if _, ok := file2.Id().(bson.ObjectId); !ok {
+		t.Fatalf("Expected a bson.ObjectId id, got %T", file2.Id())
+	}
 }
 
 // Note: Seek is not implemented in the modern wrapper
@@ -283,6 +293,12 @@ func TestModernGridFSMetadata(t *testing.T) {
 	if metaResult["author"] != "John Doe" {
 		t.Fatalf("Expected author 'John Doe', got '%v'", metaResult["author"])
 	}
+	if _, ok := metaResult["tags"].([]interface{}); !ok {
+		t.Fatalf("Expected tags as []interface{}, got %T", metaResult["tags"])
+	}
+	if _, ok := metaResult["properties"].(bson.M); !ok {
+		t.Fatalf("Expected properties as bson.M, got %T", metaResult["properties"])
+	}
 }
 
 func TestModernGridFSMultipleFiles(t *testing.T) {
@@ -323,3 +339,461 @@ func TestModernGridFSMultipleFiles(t *testing.T) {
 		t.Fatalf("Expected 'Version 3', got '%s'", string(data[:n]))
 	}
 }
+
+func TestModernGridFSReadAt(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	gfs := tdb.DB().GridFS("fs")
+
+	// Small chunks so that reads span several of them
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	file, err := gfs.Create("readat.bin")
+	AssertNoError(t, err, "Failed to create GridFS file")
+	file.SetChunkSize(64)
+	_, err = file.Write(data)
+	AssertNoError(t, err, "Failed to write GridFS file")
+	err = file.Close()
+	AssertNoError(t, err, "Failed to close GridFS file")
+
+	file, err = gfs.Open("readat.bin")
+	AssertNoError(t, err, "Failed to open GridFS file")
+	defer file.Close()
+
+	var _ io.ReaderAt = file
+
+	buf := make([]byte, 200)
+	n, err := file.ReadAt(buf, 100)
+	AssertNoError(t, err, "Failed to read at offset")
+	AssertEqual(t, 200, n, "Incorrect number of bytes read")
+	if !bytes.Equal(buf, data[100:300]) {
+		t.Error("Data read at offset 100 does not match")
+	}
+
+	// ReadAt does not move the Read position
+	head := make([]byte, 10)
+	_, err = io.ReadFull(file, head)
+	AssertNoError(t, err, "Failed to read file head")
+	if !bytes.Equal(head, data[:10]) {
+		t.Error("Read position moved by ReadAt")
+	}
+
+	// Reads past the end return the available data and io.EOF
+	n, err = file.ReadAt(buf, 900)
+	AssertEqual(t, 100, n, "Incorrect number of bytes read at the end")
+	AssertEqual(t, io.EOF, err, "Expected io.EOF at the end")
+	if !bytes.Equal(buf[:n], data[900:]) {
+		t.Error("Data read at the end does not match")
+	}
+
+	_, err = file.ReadAt(buf, 1000)
+	AssertEqual(t, io.EOF, err, "Expected io.EOF past the end")
+
+	// Ranged reads through io.SectionReader
+	section := io.NewSectionReader(file, 500, 300)
+	got, err := io.ReadAll(section)
+	AssertNoError(t, err, "Failed to read section")
+	if !bytes.Equal(got, data[500:800]) {
+		t.Error("Section data does not match")
+	}
+}
+
+func TestModernGridFSStreamingWrite(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	gfs := tdb.DB().GridFS("fs")
+	chunks := tdb.C("fs.chunks")
+
+	file, err := gfs.Create("stream.bin")
+	AssertNoError(t, err, "Failed to create GridFS file")
+	file.SetChunkSize(10)
+
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	_, err = file.Write(data[:25])
+	AssertNoError(t, err, "Failed to write first part")
+
+	// Small chunks are batched until Close
+	count, err := chunks.Count()
+	AssertNoError(t, err, "Failed to count chunks")
+	AssertEqual(t, 0, count, "Chunks should be batched while writing")
+
+	// The chunk size cannot change once writing started
+	file.SetChunkSize(4)
+
+	_, err = file.Write(data[25:])
+	AssertNoError(t, err, "Failed to write second part")
+	err = file.Close()
+	AssertNoError(t, err, "Failed to close GridFS file")
+
+	count, err = chunks.Count()
+	AssertNoError(t, err, "Failed to count chunks")
+	AssertEqual(t, 4, count, "Incorrect number of stored chunks")
+	AssertEqual(t, fmt.Sprintf("%x", md5.Sum(data)), file.MD5(), "Incorrect MD5")
+
+	file, err = gfs.Open("stream.bin")
+	AssertNoError(t, err, "Failed to open GridFS file")
+	got, err := io.ReadAll(file)
+	AssertNoError(t, err, "Failed to read GridFS file")
+	if !bytes.Equal(got, data) {
+		t.Errorf("Expected %q, got %q", data, got)
+	}
+
+	// Batches of 16MB are stored while writing
+	large, err := gfs.Create("large.bin")
+	AssertNoError(t, err, "Failed to create GridFS file")
+	large.SetChunkSize(4 * 1024 * 1024)
+	_, err = large.Write(make([]byte, 16*1024*1024+5))
+	AssertNoError(t, err, "Failed to write large file")
+	count, err = chunks.Find(bson.M{"files_id": large.Id()}).Count()
+	AssertNoError(t, err, "Failed to count chunks")
+	AssertEqual(t, 4, count, "Full batch should be stored while writing")
+	err = large.Close()
+	AssertNoError(t, err, "Failed to close large file")
+	count, err = chunks.Find(bson.M{"files_id": large.Id()}).Count()
+	AssertNoError(t, err, "Failed to count chunks")
+	AssertEqual(t, 5, count, "Incorrect number of stored chunks")
+
+	// Files opened for reading cannot be written, nor created files read
+	_, err = file.Write(data)
+	AssertError(t, err, "Expected error writing a file opened for reading")
+	file.Close()
+
+	empty, err := gfs.Create("empty.bin")
+	AssertNoError(t, err, "Failed to create GridFS file")
+	_, err = empty.Read(make([]byte, 1))
+	AssertError(t, err, "Expected error reading a file opened for writing")
+
+	// Empty files are stored too
+	err = empty.Close()
+	AssertNoError(t, err, "Failed to close empty file")
+	empty, err = gfs.Open("empty.bin")
+	AssertNoError(t, err, "Failed to open empty file")
+	AssertEqual(t, int64(0), empty.Size(), "Empty file should have no data")
+	empty.Close()
+}
+
+func TestModernGridFSAbort(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	gfs := tdb.DB().GridFS("fs")
+
+	file, err := gfs.Create("aborted.bin")
+	AssertNoError(t, err, "Failed to create GridFS file")
+	file.SetChunkSize(4)
+	_, err = file.Write([]byte("partial upload"))
+	AssertNoError(t, err, "Failed to write GridFS file")
+
+	file.Abort()
+	_, err = file.Write([]byte("more"))
+	AssertError(t, err, "Expected error writing an aborted file")
+	err = file.Close()
+	AssertError(t, err, "Expected Close to report the aborted write")
+
+	// Neither the file nor its chunks are stored
+	_, err = gfs.Open("aborted.bin")
+	if !errors.Is(err, mgo.ErrNotFound) {
+		t.Errorf("Aborted file should not be stored, got %v", err)
+	}
+	count, err := tdb.C("fs.chunks").Count()
+	AssertNoError(t, err, "Failed to count chunks")
+	AssertEqual(t, 0, count, "Chunks of the aborted file should be removed")
+}
+
+func TestModernGridFSDefaults(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	gfs := tdb.DB().GridFS("fs")
+	gfs.SetChunkSize(8)
+	gfs.SetWriteConcern(&mgo.Safe{WMode: "majority"})
+
+	file, err := gfs.Create("defaults.txt")
+	AssertNoError(t, err, "Failed to create GridFS file")
+	_, err = file.Write([]byte("twenty bytes of data"))
+	AssertNoError(t, err, "Failed to write GridFS file")
+	err = file.Close()
+	AssertNoError(t, err, "Failed to close GridFS file")
+
+	count, err := tdb.C("fs.chunks").Count()
+	AssertNoError(t, err, "Failed to count chunks")
+	AssertEqual(t, 3, count, "Default chunk size not applied")
+
+	var doc bson.M
+	err = tdb.C("fs.files").FindId(file.Id()).One(&doc)
+	AssertNoError(t, err, "Failed to find file document")
+	AssertEqual(t, 8, doc["chunkSize"], "Incorrect stored chunk size")
+
+	// Files can still override the default
+	file, err = gfs.Create("override.txt")
+	AssertNoError(t, err, "Failed to create GridFS file")
+	file.SetChunkSize(32)
+	_, err = file.Write([]byte("twenty bytes of data"))
+	AssertNoError(t, err, "Failed to write GridFS file")
+	err = file.Close()
+	AssertNoError(t, err, "Failed to close GridFS file")
+
+	count, err = tdb.C("fs.chunks").Count()
+	AssertNoError(t, err, "Failed to count chunks")
+	AssertEqual(t, 4, count, "Per-file chunk size not applied")
+}
+
+func TestModernGridFSUpdateFile(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	gfs := tdb.DB().GridFS("fs")
+
+	file, err := gfs.Create("mislabeled.txt")
+	AssertNoError(t, err, "Failed to create GridFS file")
+	file.SetContentType("application/octet-stream")
+	file.SetMeta(bson.M{"owner": "alice"})
+	_, err = file.Write([]byte("content"))
+	AssertNoError(t, err, "Failed to write GridFS file")
+	err = file.Close()
+	AssertNoError(t, err, "Failed to close GridFS file")
+	id := file.Id()
+
+	err = gfs.RenameId(id, "report.txt")
+	AssertNoError(t, err, "Failed to rename file")
+	err = gfs.SetContentTypeId(id, "text/plain")
+	AssertNoError(t, err, "Failed to set content type")
+	err = gfs.SetMetaId(id, bson.M{"owner": "bob"})
+	AssertNoError(t, err, "Failed to set metadata")
+
+	file, err = gfs.Open("report.txt")
+	AssertNoError(t, err, "Failed to open renamed file")
+	AssertEqual(t, "text/plain", file.ContentType(), "Content type not updated")
+	var meta bson.M
+	err = file.GetMeta(&meta)
+	AssertNoError(t, err, "Failed to get metadata")
+	AssertEqual(t, "bob", meta["owner"], "Metadata not updated")
+	data, err := io.ReadAll(file)
+	AssertNoError(t, err, "Failed to read renamed file")
+	AssertEqual(t, "content", string(data), "Content changed")
+	file.Close()
+
+	// Removing the metadata
+	err = gfs.SetMetaId(id, nil)
+	AssertNoError(t, err, "Failed to remove metadata")
+	file, err = gfs.OpenId(id)
+	AssertNoError(t, err, "Failed to open file")
+	meta = nil
+	err = file.GetMeta(&meta)
+	AssertNoError(t, err, "Failed to get metadata")
+	AssertEqual(t, 0, len(meta), "Metadata not removed")
+	file.Close()
+
+	err = gfs.RenameId(bson.NewObjectId(), "missing.txt")
+	if !errors.Is(err, mgo.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for missing file, got %v", err)
+	}
+}
+
+func TestModernGridFSChunkedRead(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	gfs := tdb.DB().GridFS("fs")
+
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	file, err := gfs.Create("chunked.txt")
+	AssertNoError(t, err, "Failed to create GridFS file")
+	file.SetChunkSize(8)
+	_, err = file.Write(data)
+	AssertNoError(t, err, "Failed to write GridFS file")
+	err = file.Close()
+	AssertNoError(t, err, "Failed to close GridFS file")
+
+	// Reads smaller and larger than a chunk
+	file, err = gfs.Open("chunked.txt")
+	AssertNoError(t, err, "Failed to open GridFS file")
+	var got []byte
+	for _, size := range []int{3, 5, 13, 100} {
+		buf := make([]byte, size)
+		n, err := file.Read(buf)
+		AssertNoError(t, err, "Failed to read GridFS file")
+		got = append(got, buf[:n]...)
+	}
+	_, err = file.Read(make([]byte, 1))
+	AssertEqual(t, io.EOF, err, "Expected io.EOF at the end of the file")
+	file.Close()
+	if !bytes.Equal(got, data) {
+		t.Errorf("Expected %q, got %q", data, got)
+	}
+
+	// A missing chunk is reported instead of skipped
+	err = tdb.C("fs.chunks").Remove(bson.M{"n": 2})
+	AssertNoError(t, err, "Failed to remove chunk")
+	file, err = gfs.Open("chunked.txt")
+	AssertNoError(t, err, "Failed to open GridFS file")
+	defer file.Close()
+	_, err = io.ReadAll(file)
+	AssertEqual(t, io.ErrUnexpectedEOF, err, "Expected io.ErrUnexpectedEOF for a missing chunk")
+}
+
+func TestModernGridFSSHA256(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	gfs := tdb.DB().GridFS("fs")
+	gfs.SetSHA256(true)
+
+	data := []byte("checksummed content spanning chunks")
+	file, err := gfs.Create("checksum.txt")
+	AssertNoError(t, err, "Failed to create GridFS file")
+	file.SetChunkSize(8)
+	_, err = file.Write(data)
+	AssertNoError(t, err, "Failed to write GridFS file")
+	err = file.Close()
+	AssertNoError(t, err, "Failed to close GridFS file")
+	AssertEqual(t, fmt.Sprintf("%x", sha256.Sum256(data)), file.SHA256(), "Incorrect SHA-256")
+	AssertEqual(t, fmt.Sprintf("%x", md5.Sum(data)), file.MD5(), "MD5 should still be stored")
+
+	file, err = gfs.Open("checksum.txt")
+	AssertNoError(t, err, "Failed to open GridFS file")
+	AssertEqual(t, fmt.Sprintf("%x", sha256.Sum256(data)), file.SHA256(), "SHA-256 not loaded")
+	err = file.VerifyChecksum()
+	AssertNoError(t, err, "Checksum should match")
+	file.Close()
+
+	// Corrupted content is detected
+	err = tdb.C("fs.chunks").Update(bson.M{"n": 1}, bson.M{"$set": bson.M{"data": []byte("XXXXXXXX")}})
+	AssertNoError(t, err, "Failed to corrupt chunk")
+	file, err = gfs.Open("checksum.txt")
+	AssertNoError(t, err, "Failed to open GridFS file")
+	defer file.Close()
+	if err := file.VerifyChecksum(); !errors.Is(err, mgo.ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+
+	// Files without SHA-256 are verified against their MD5
+	gfs.SetSHA256(false)
+	plain, err := gfs.Create("plain.txt")
+	AssertNoError(t, err, "Failed to create GridFS file")
+	_, err = plain.Write(data)
+	AssertNoError(t, err, "Failed to write GridFS file")
+	err = plain.Close()
+	AssertNoError(t, err, "Failed to close GridFS file")
+	AssertEqual(t, "", plain.SHA256(), "SHA-256 should not be stored")
+	plain, err = gfs.Open("plain.txt")
+	AssertNoError(t, err, "Failed to open GridFS file")
+	defer plain.Close()
+	err = plain.VerifyChecksum()
+	AssertNoError(t, err, "MD5 checksum should match")
+}
+
+func TestModernGridFSEnsureIndexes(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	gfs := tdb.DB().GridFS("fs")
+
+	indexKeys := func(c *mgo.Collection) map[string]bool {
+		indexes, err := c.Indexes()
+		AssertNoError(t, err, "Failed to list indexes")
+		keys := make(map[string]bool)
+		for _, idx := range indexes {
+			keys[strings.Join(idx.Key, ",")] = idx.Unique
+		}
+		return keys
+	}
+
+	err := gfs.EnsureIndexes()
+	AssertNoError(t, err, "Failed to ensure GridFS indexes")
+
+	files := indexKeys(gfs.Files)
+	if _, ok := files["filename,uploadDate"]; !ok {
+		t.Errorf("Missing files index, got %v", files)
+	}
+	chunks := indexKeys(gfs.Chunks)
+	if unique, ok := chunks["files_id,n"]; !ok || !unique {
+		t.Errorf("Missing unique chunks index, got %v", chunks)
+	}
+
+	// Writing a file to a new bucket creates them too
+	other := tdb.DB().GridFS("other")
+	file, err := other.Create("file.txt")
+	AssertNoError(t, err, "Failed to create GridFS file")
+	_, err = file.Write([]byte("data"))
+	AssertNoError(t, err, "Failed to write GridFS file")
+	err = file.Close()
+	AssertNoError(t, err, "Failed to close GridFS file")
+	if _, ok := indexKeys(other.Files)["filename,uploadDate"]; !ok {
+		t.Error("Files index not created when writing")
+	}
+}
+
+func TestModernGridFSVersions(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	gfs := tdb.DB().GridFS("fs")
+
+	for _, content := range []string{"v0", "v1", "v2"} {
+		file, err := gfs.Create("versioned.txt")
+		AssertNoError(t, err, "Failed to create GridFS file")
+		_, err = file.Write([]byte(content))
+		AssertNoError(t, err, "Failed to write GridFS file")
+		err = file.Close()
+		AssertNoError(t, err, "Failed to close GridFS file")
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	readAll := func(file *mgo.ModernGridFile) string {
+		data, err := io.ReadAll(file)
+		AssertNoError(t, err, "Failed to read GridFS file")
+		return string(data)
+	}
+
+	files, err := gfs.FindAllVersions("versioned.txt")
+	AssertNoError(t, err, "Failed to find GridFS file versions")
+	AssertEqual(t, 3, len(files), "Version count mismatch")
+	for i, file := range files {
+		AssertEqual(t, fmt.Sprintf("v%d", i), readAll(file), "Version content mismatch")
+		AssertEqual(t, "versioned.txt", file.Name(), "Version name mismatch")
+	}
+
+	for version, want := range map[int]string{0: "v0", 2: "v2", -1: "v2", -3: "v0"} {
+		file, err := gfs.OpenVersion("versioned.txt", version)
+		AssertNoError(t, err, "Failed to open GridFS file version")
+		AssertEqual(t, want, readAll(file), fmt.Sprintf("Content mismatch for version %d", version))
+	}
+
+	_, err = gfs.OpenVersion("versioned.txt", 3)
+	if !errors.Is(err, mgo.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing version, got %v", err)
+	}
+	_, err = gfs.OpenVersion("versioned.txt", -4)
+	if !errors.Is(err, mgo.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing version, got %v", err)
+	}
+
+	files, err = gfs.FindAllVersions("missing.txt")
+	AssertNoError(t, err, "Failed to find versions of a missing file")
+	AssertEqual(t, 0, len(files), "Expected no versions")
+
+	// Iter yields opened files
+	iter := gfs.Iter(bson.M{"filename": "versioned.txt"})
+	var file *mgo.ModernGridFile
+	count := 0
+	for iter.Next(&file) {
+		AssertEqual(t, fmt.Sprintf("v%d", count), readAll(file), "Iterated content mismatch")
+		count++
+	}
+	AssertNoError(t, iter.Close(), "Failed to close GridFS iterator")
+	AssertEqual(t, 3, count, "Iterated file count mismatch")
+}
@@ -0,0 +1,311 @@
+// modern_index_plan.go - Declarative index management for modern MongoDB driver compatibility wrapper
+
+package mgo
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kinfkong/modern-mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+)
+
+// IndexPlan declares the indexes the collections of a database should have.
+// Diff reports the changes needed to reach the declared state without
+// applying them, and Apply performs them:
+//
+//	plan := mgo.NewIndexPlan().
+//		Add("users", mgo.Index{Key: []string{"email"}, Unique: true}).
+//		Add("events", mgo.Index{Key: []string{"-created"}, ExpireAfter: 24 * time.Hour})
+//	changes, err := plan.Apply(session.DB("app"))
+//
+// Declared indexes are matched against existing ones by their whole
+// specification: key, name when declared, and options. An existing index
+// with the name of a declared index, or else its key, but other options, such
+// as another partial filter expression or collation, would conflict with it,
+// so it is dropped and created again with the declared options. Undeclared
+// indexes of the planned collections are only dropped when DropExtra is set;
+// collections absent from the plan are never touched.
+type IndexPlan struct {
+	DropExtra bool // Drop existing indexes not declared by the plan
+
+	collections []string           // Planned collections, in declaration order
+	indexes     map[string][]Index // Declared indexes by collection name
+}
+
+// IndexAction is the kind of change reported by an IndexPlan
+type IndexAction string
+
+const (
+	IndexCreate IndexAction = "create"
+	IndexDrop   IndexAction = "drop"
+)
+
+// IndexChange is a change made, or to be made, by an IndexPlan
+type IndexChange struct {
+	Collection string
+	Action     IndexAction
+	Index      Index
+}
+
+// String describes the change, for dry-run output
+func (c IndexChange) String() string {
+	desc := fmt.Sprintf("%s index %s on %s", c.Action, strings.Join(c.Index.Key, ","), c.Collection)
+	if c.Index.Name != "" {
+		desc += fmt.Sprintf(" (%s)", c.Index.Name)
+	}
+	return desc
+}
+
+// NewIndexPlan returns an empty index plan
+func NewIndexPlan() *IndexPlan {
+	return &IndexPlan{indexes: make(map[string][]Index)}
+}
+
+// Add declares indexes for the named collection. A collection added without
+// indexes is planned to have only the _id index.
+func (p *IndexPlan) Add(collection string, indexes ...Index) *IndexPlan {
+	if _, ok := p.indexes[collection]; !ok {
+		p.collections = append(p.collections, collection)
+	}
+	p.indexes[collection] = append(p.indexes[collection], indexes...)
+	return p
+}
+
+// Diff returns the changes needed for the collections of db to match the
+// plan, without applying them
+func (p *IndexPlan) Diff(db *ModernDB) ([]IndexChange, error) {
+	var changes []IndexChange
+	for _, name := range p.collections {
+		existing, err := db.C(name).Indexes()
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, diffIndexes(name, p.indexes[name], existing, p.DropExtra)...)
+	}
+	return changes, nil
+}
+
+// Apply makes the collections of db match the plan and returns the changes
+// it made. Indexes are dropped before the missing ones are created, so that
+// an index can be redeclared with new options under the same name. When an
+// error occurs, the changes made so far are returned with it.
+func (p *IndexPlan) Apply(db *ModernDB) ([]IndexChange, error) {
+	changes, err := p.Diff(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []IndexChange
+	for _, change := range changes {
+		if change.Action != IndexDrop {
+			continue
+		}
+		if err := db.C(change.Collection).DropIndexName(change.Index.Name); err != nil {
+			return applied, err
+		}
+		applied = append(applied, change)
+	}
+
+	// Create the missing indexes of each collection with a single command
+	var creates []Index
+	for i, change := range changes {
+		if change.Action != IndexCreate {
+			continue
+		}
+		creates = append(creates, change.Index)
+		if i+1 < len(changes) && changes[i+1].Action == IndexCreate && changes[i+1].Collection == change.Collection {
+			continue
+		}
+		// The session index cache may hold indexes dropped since by another
+		// process, which EnsureIndexes would then skip
+		coll := db.C(change.Collection)
+		coll.session.cachedIndexes().forget(coll.fullName() + "\x00")
+		if err := coll.EnsureIndexes(creates); err != nil {
+			return applied, err
+		}
+		for _, index := range creates {
+			applied = append(applied, IndexChange{Collection: change.Collection, Action: IndexCreate, Index: index})
+		}
+		creates = nil
+	}
+	return applied, nil
+}
+
+// diffIndexes returns the changes turning the existing indexes of a
+// collection into the desired ones. Creations come after drops.
+func diffIndexes(collection string, desired, existing []Index, dropExtra bool) []IndexChange {
+	var drops, creates []IndexChange
+	matched := make([]bool, len(existing))
+	find := func(match func(Index) bool) int {
+		for i, have := range existing {
+			if !matched[i] && match(have) {
+				matched[i] = true
+				return i
+			}
+		}
+		return -1
+	}
+
+	var missing []Index
+	for _, want := range desired {
+		if find(func(have Index) bool { return indexMatches(want, have) }) < 0 {
+			missing = append(missing, want)
+		}
+	}
+	for _, want := range missing {
+		// An index with the name or key of the declared one, but other
+		// options, makes the server reject its creation
+		drifted := find(func(have Index) bool { return have.Name != "_id_" && sameIndexSlot(want, have) })
+		if drifted >= 0 {
+			drops = append(drops, IndexChange{Collection: collection, Action: IndexDrop, Index: existing[drifted]})
+		}
+		creates = append(creates, IndexChange{Collection: collection, Action: IndexCreate, Index: want})
+	}
+	if dropExtra {
+		for i, have := range existing {
+			if !matched[i] && have.Name != "_id_" {
+				drops = append(drops, IndexChange{Collection: collection, Action: IndexDrop, Index: have})
+			}
+		}
+	}
+	return append(drops, creates...)
+}
+
+// indexMatches reports whether an existing index satisfies a declared one,
+// the options left unset in the declared index taking their server defaults
+func indexMatches(want, have Index) bool {
+	if want.Name != "" && want.Name != have.Name {
+		return false
+	}
+	return want.Unique == have.Unique &&
+		want.Sparse == have.Sparse &&
+		want.Hidden == have.Hidden &&
+		want.ExpireAfter.Truncate(time.Second) == have.ExpireAfter &&
+		sameIndexKey(want.Key, have.Key) &&
+		samePartialFilter(want.PartialFilter, have.PartialFilter) &&
+		sameCollation(want.Collation, have.Collation) &&
+		sameTextOptions(want, have) &&
+		sameGeoOptions(want, have)
+}
+
+// sameIndexSlot reports whether an existing index has the name of a declared
+// index, or its key when the declared index has no name
+func sameIndexSlot(want, have Index) bool {
+	if want.Name != "" {
+		return want.Name == have.Name
+	}
+	return sameIndexKey(want.Key, have.Key)
+}
+
+// samePartialFilter compares partial filter expressions as stored by the
+// server, so that the Go types used to declare them do not matter
+func samePartialFilter(a, b bson.M) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	normalize := func(filter bson.M) (bson.M, error) {
+		data, err := officialBson.Marshal(convertMGOToOfficial(filter))
+		if err != nil {
+			return nil, err
+		}
+		var normalized bson.M
+		err = decodeDocument(data, &normalized)
+		return normalized, err
+	}
+	na, errA := normalize(a)
+	nb, errB := normalize(b)
+	return errA == nil && errB == nil && reflect.DeepEqual(na, nb)
+}
+
+// sameCollation compares a declared collation with the one the server
+// reports, in which the fields the declaration leaves unset hold defaults
+func sameCollation(want, have *Collation) bool {
+	if want == nil || want.Locale == "simple" {
+		return have == nil || have.Locale == "simple"
+	}
+	if have == nil {
+		return false
+	}
+	same := func(declared, reported string) bool { return declared == "" || declared == reported }
+	return want.Locale == have.Locale &&
+		same(want.CaseFirst, have.CaseFirst) &&
+		(want.Strength == 0 || want.Strength == have.Strength) &&
+		same(want.Alternate, have.Alternate) &&
+		same(want.MaxVariable, have.MaxVariable) &&
+		want.Normalization == have.Normalization &&
+		want.CaseLevel == have.CaseLevel &&
+		want.NumericOrdering == have.NumericOrdering &&
+		want.Backwards == have.Backwards
+}
+
+// sameTextOptions compares the options of text indexes. The server reports a
+// weight for every indexed field, 1 unless declared otherwise.
+func sameTextOptions(want, have Index) bool {
+	orDefault := func(value, def string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	}
+	if orDefault(want.DefaultLanguage, "english") != orDefault(have.DefaultLanguage, "english") ||
+		orDefault(want.LanguageOverride, "language") != orDefault(have.LanguageOverride, "language") {
+		return false
+	}
+	for field := range want.Weights {
+		if _, ok := have.Weights[field]; !ok {
+			return false
+		}
+	}
+	for field, weight := range have.Weights {
+		declared, ok := want.Weights[field]
+		if !ok {
+			declared = 1
+		}
+		if declared != weight {
+			return false
+		}
+	}
+	return true
+}
+
+// sameGeoOptions compares the options of 2d indexes, Minf and Maxf taking
+// precedence over Min and Max as when creating the index
+func sameGeoOptions(want, have Index) bool {
+	min, max := want.Minf, want.Maxf
+	if min == 0 && max == 0 {
+		min, max = float64(want.Min), float64(want.Max)
+	}
+	return min == have.Minf && max == have.Maxf && want.Bits == have.Bits
+}
+
+// sameIndexKey compares index key specifications. The fields of a text index
+// are listed by the server in alphabetical order, so they are compared
+// regardless of their order.
+func sameIndexKey(a, b []string) bool {
+	normalize := func(key []string) []string {
+		var plain, text []string
+		for _, field := range key {
+			if strings.HasPrefix(field, "$text:") {
+				text = append(text, field)
+			} else {
+				plain = append(plain, field)
+			}
+		}
+		sort.Strings(text)
+		return append(plain, text...)
+	}
+	a, b = normalize(a), normalize(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
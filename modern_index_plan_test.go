@@ -0,0 +1,98 @@
+package mgo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kinfkong/modern-mgo/bson"
+)
+
+// TestDiffIndexes checks the changes computed between declared and existing
+// indexes
+func TestDiffIndexes(t *testing.T) {
+	existing := []Index{
+		{Name: "_id_", Key: []string{"_id"}},
+		{Name: "email_1", Key: []string{"email"}, Unique: true},
+		{Name: "created_-1", Key: []string{"-created"}},
+		{Name: "search", Key: []string{"$text:body", "$text:title"}},
+		{Name: "old_1", Key: []string{"old"}},
+	}
+	desired := []Index{
+		{Key: []string{"email"}, Unique: true},
+		{Key: []string{"-created"}, ExpireAfter: time.Hour},
+		{Name: "search", Key: []string{"$text:title", "$text:body"}},
+		{Key: []string{"status"}},
+	}
+
+	// The index with the key of a declared one but other options is dropped
+	// before the declared one is created
+	changes := diffIndexes("users", desired, existing, false)
+	expected := []IndexChange{
+		{Collection: "users", Action: IndexDrop, Index: existing[2]},
+		{Collection: "users", Action: IndexCreate, Index: desired[1]},
+		{Collection: "users", Action: IndexCreate, Index: desired[3]},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %v, got %v", expected, changes)
+	}
+
+	// Extra indexes are dropped too, never _id_
+	changes = diffIndexes("users", desired, existing, true)
+	expected = append([]IndexChange{expected[0], {Collection: "users", Action: IndexDrop, Index: existing[4]}}, expected[1:]...)
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %v, got %v", expected, changes)
+	}
+
+	// Names must match when declared
+	changes = diffIndexes("users", []Index{{Name: "by_email", Key: []string{"email"}, Unique: true}}, existing[:2], false)
+	if len(changes) != 1 || changes[0].Action != IndexCreate {
+		t.Errorf("Expected index with another name to be created, got %v", changes)
+	}
+
+	if s := changes[0].String(); s != "create index email on users (by_email)" {
+		t.Errorf("Unexpected change description %q", s)
+	}
+}
+
+// TestIndexMatchesOptions checks every option of a declared index is
+// compared with the existing index, unset ones matching the server defaults
+func TestIndexMatchesOptions(t *testing.T) {
+	key := []string{"email"}
+	tests := []struct {
+		name  string
+		want  Index
+		have  Index
+		match bool
+	}{
+		{"partial filter", Index{Key: key, PartialFilter: bson.M{"age": bson.M{"$gt": 21}}}, Index{Key: key, PartialFilter: bson.M{"age": bson.M{"$gt": int32(21)}}}, true},
+		{"other partial filter", Index{Key: key, PartialFilter: bson.M{"age": bson.M{"$gt": 21}}}, Index{Key: key, PartialFilter: bson.M{"age": bson.M{"$gt": 18}}}, false},
+		{"missing partial filter", Index{Key: key, PartialFilter: bson.M{"age": bson.M{"$gt": 21}}}, Index{Key: key}, false},
+		{"collation defaults", Index{Key: key, Collation: &Collation{Locale: "fr"}}, Index{Key: key, Collation: &Collation{Locale: "fr", CaseFirst: "off", Strength: 3}}, true},
+		{"other collation", Index{Key: key, Collation: &Collation{Locale: "fr", Strength: 2}}, Index{Key: key, Collation: &Collation{Locale: "fr", Strength: 3}}, false},
+		{"undeclared collation", Index{Key: key}, Index{Key: key, Collation: &Collation{Locale: "fr"}}, false},
+		{"hidden", Index{Key: key}, Index{Key: key, Hidden: true}, false},
+		{"text defaults", Index{Key: []string{"$text:body"}}, Index{Key: []string{"$text:body"}, Weights: map[string]int{"body": 1}, DefaultLanguage: "english", LanguageOverride: "language"}, true},
+		{"text weights", Index{Key: []string{"$text:body"}, Weights: map[string]int{"body": 5}}, Index{Key: []string{"$text:body"}, Weights: map[string]int{"body": 1}}, false},
+		{"text language", Index{Key: []string{"$text:body"}, DefaultLanguage: "french"}, Index{Key: []string{"$text:body"}, Weights: map[string]int{"body": 1}, DefaultLanguage: "english"}, false},
+		{"2d bounds", Index{Key: []string{"$2d:loc"}, Min: -10, Max: 10}, Index{Key: []string{"$2d:loc"}, Minf: -10, Maxf: 10}, true},
+		{"2d bits", Index{Key: []string{"$2d:loc"}, Bits: 20}, Index{Key: []string{"$2d:loc"}, Bits: 26}, false},
+	}
+	for _, test := range tests {
+		if got := indexMatches(test.want, test.have); got != test.match {
+			t.Errorf("%s: expected match %v, got %v", test.name, test.match, got)
+		}
+	}
+
+	// A named index with other options is replaced, as its name is taken
+	existing := []Index{{Name: "_id_", Key: []string{"_id"}}, {Name: "adults", Key: key}}
+	declared := Index{Name: "adults", Key: key, PartialFilter: bson.M{"age": bson.M{"$gt": 21}}}
+	changes := diffIndexes("users", []Index{declared}, existing, false)
+	expected := []IndexChange{
+		{Collection: "users", Action: IndexDrop, Index: existing[1]},
+		{Collection: "users", Action: IndexCreate, Index: declared},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %v, got %v", expected, changes)
+	}
+}
@@ -3,82 +3,190 @@
 package mgo
 
 import (
-	"github.com/globalsign/mgo/bson"
-	officialBson "go.mongodb.org/mongo-driver/bson"
+	"context"
+	"fmt"
+	"reflect"
+
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
 )
 
-// Next gets next document from iterator
+// Next gets next document from iterator. Decoding into a bson.Raw or
+// bson.RawD skips the conversion of the document, which is left to their
+// Unmarshal methods; for large documents of which only a few fields are
+// needed, this defers the work to the fields actually read:
+//
+//	var doc bson.RawD
+//	for iter.Next(&doc) {
+//		for _, elem := range doc {
+//			if elem.Name == "status" {
+//				var status string
+//				elem.Value.Unmarshal(&status)
+//			}
+//		}
+//	}
+//
+// Struct fields of type bson.Raw defer the conversion of their value likewise.
 func (it *ModernIt) Next(result interface{}) bool {
+	it.mu.Lock()
+	defer it.mu.Unlock()
 	if it.err != nil {
 		return false
 	}
 
 	if it.cursor == nil {
-		it.err = ErrNotFound
 		return false
 	}
 
-	if !it.cursor.Next(it.ctx) {
+	if !it.next() {
 		// Check if there was an actual error, or just end of cursor
-		it.err = it.cursor.Err()
+		it.err = convertError(it.cursor.Err())
 		// Don't set ErrNotFound here - end of iteration is normal
 		return false
 	}
 
-	var doc officialBson.M
-	err := it.cursor.Decode(&doc)
-	if err != nil {
-		it.err = err
-		return false
-	}
-
-	converted := convertOfficialToMGO(doc)
-	it.err = mapStructToInterface(converted, result)
+	it.err = decodeDocument(it.cursor.Current, result)
 	return it.err == nil
 }
 
+// Err returns the error that stopped the iteration, such as an invalid query
+// or pipeline rejected by the server, or nil when the iteration ran to its end
+// (mgo API compatible). It lets callers tell a failed query from one without
+// results once Next returns false.
+func (it *ModernIt) Err() error {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	return it.err
+}
+
 // Close closes the iterator
 func (it *ModernIt) Close() error {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	return it.close()
+}
+
+// shutdown closes the iterator for Session.Shutdown, failing its further use
+func (it *ModernIt) shutdown() {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.err == nil {
+		it.err = ErrSessionClosed
+	}
+	it.close()
+}
+
+// close closes the cursor and releases what the iterator holds
+func (it *ModernIt) close() error {
 	if it.cursor != nil {
 		err := it.cursor.Close(it.ctx)
 		if err != nil && it.err == nil {
-			it.err = err
+			it.err = convertError(err)
 		}
 	}
+	it.releaseSession()
+	it.cursors.untrack(it)
+	it.release()
 	return it.err
 }
 
-// All gets all documents from iterator
+// All gets all documents from iterator into result, a pointer to a slice.
+// Each document is decoded straight into a new element of the slice, so
+// structs and pointers to structs are loaded without intermediate documents.
 func (it *ModernIt) All(result interface{}) error {
+	it.mu.Lock()
+	defer it.mu.Unlock()
 	if it.err != nil {
 		return it.err
 	}
 
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("result argument must be a slice address, got %T", result)
+	}
+	sliceType := resultv.Elem().Type()
 	if it.cursor == nil {
-		return ErrNotFound
+		resultv.Elem().Set(reflect.MakeSlice(sliceType, 0, 0))
+		return nil
 	}
+	elemType := sliceType.Elem()
+	structPtr := elemType.Kind() == reflect.Ptr && elemType.Elem().Kind() == reflect.Struct
 
-	// Use Next() in a loop to avoid BSON slice unmarshalling issues
-	var docs []interface{}
-
-	for {
-		var doc bson.M
-		if !it.Next(&doc) {
-			break
+	slicev := reflect.MakeSlice(sliceType, 0, it.cursor.RemainingBatchLength())
+	for it.next() {
+		var elem reflect.Value
+		if structPtr {
+			elem = reflect.New(elemType.Elem())
+		} else {
+			elem = reflect.New(elemType)
+		}
+		if err := decodeDocument(it.cursor.Current, elem.Interface()); err != nil {
+			it.err = err
+			return err
 		}
-		if it.err != nil {
-			return it.err
+		if !structPtr {
+			elem = elem.Elem()
 		}
-		docs = append(docs, doc)
+		slicev = reflect.Append(slicev, elem)
 	}
 
 	// Check for iteration errors (not end-of-cursor)
-	if it.err != nil && it.err != ErrNotFound {
+	if err := it.cursor.Err(); err != nil {
+		it.err = convertError(err)
 		return it.err
 	}
 
-	// Reset error since reaching end of cursor is expected
-	it.err = nil
+	resultv.Elem().Set(slicev)
+	return nil
+}
+
+// next advances the cursor, releasing what the iterator holds once the
+// server closed it
+func (it *ModernIt) next() bool {
+	ok := it.cursor.Next(it.ctx)
+	if !ok && it.cursor.ID() == 0 {
+		// The server closed the cursor, which no longer needs the session
+		// nor closing on shutdown
+		it.releaseSession()
+		it.cursors.untrack(it)
+		it.release()
+	}
+	return ok
+}
+
+// release cancels the context of the iterator, which has no further use for
+// it
+func (it *ModernIt) release() {
+	if it.cancel != nil {
+		it.cancel()
+	}
+}
 
-	return mapStructToInterface(docs, result)
+// releaseSession ends the driver session of the cursor, recording the times
+// it reached in the session state of the iterator
+func (it *ModernIt) releaseSession() {
+	it.sess.finish(it.opSess)
+	it.sess = nil
+	it.opSess = nil
+}
+
+// newIter returns an iterator over cursor, opened in the driver session
+// opSess of sess, tracked for Shutdown until it is closed or exhausted.
+// cancel, if not nil, releases ctx once the iterator is done with it.
+func (m *ModernMGO) newIter(ctx context.Context, cancel context.CancelFunc, cursor *mongodrv.Cursor, sess *driverSession, opSess mongodrv.Session, err error) *ModernIt {
+	it := &ModernIt{
+		cursor: cursor,
+		ctx:    ctx,
+		cancel: cancel,
+		err:    err,
+		sess:   sess,
+		opSess: opSess,
+	}
+	if cursor == nil {
+		it.releaseSession()
+		it.release()
+	} else {
+		it.cursors = m.cursorRegistry()
+		it.cursors.track(it)
+	}
+	return it
 }
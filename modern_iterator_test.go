@@ -2,8 +2,10 @@ package mgo_test
 
 import (
 	"testing"
+	"time"
 
-	"github.com/globalsign/mgo/bson"
+	mgo "github.com/kinfkong/modern-mgo"
+	"github.com/kinfkong/modern-mgo/bson"
 )
 
 func TestModernIteratorNext(t *testing.T) {
@@ -48,6 +50,9 @@ func TestModernIteratorEmpty(t *testing.T) {
 	if hasNext {
 		t.Fatal("Expected no results from iterator")
 	}
+	// An empty result is not an error, as in mgo
+	AssertNoError(t, iter.Err(), "Expected no error for an empty result")
+	AssertNoError(t, iter.Close(), "Expected no error closing an empty iterator")
 }
 
 func TestModernIteratorClose(t *testing.T) {
@@ -99,6 +104,63 @@ func TestModernIteratorAll(t *testing.T) {
 	// All method should handle closing internally
 }
 
+func TestModernIteratorAllElementTypes(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	testData := GetTestData()
+	InsertTestData(t, coll, testData.Users)
+
+	type user struct {
+		Id        bson.ObjectId `bson:"_id"`
+		Name      string        `bson:"name"`
+		Age       int           `bson:"age"`
+		CreatedAt time.Time     `bson:"createdAt"`
+	}
+
+	// Structs are decoded straight into the slice elements
+	var users []user
+	err := coll.Find(nil).Sort("age").All(&users)
+	AssertNoError(t, err, "Failed to get all users as structs")
+	AssertEqual(t, 3, len(users), "Incorrect number of users")
+	AssertEqual(t, "Jane Smith", users[0].Name, "Users not sorted by age")
+	AssertEqual(t, 35, users[2].Age, "Incorrect age")
+	if !users[0].Id.Valid() || users[0].CreatedAt.IsZero() {
+		t.Fatalf("Incomplete user: %+v", users[0])
+	}
+
+	// Pointers to structs are allocated for each document
+	var pointers []*user
+	err = coll.Find(nil).Sort("age").All(&pointers)
+	AssertNoError(t, err, "Failed to get all users as struct pointers")
+	AssertEqual(t, 3, len(pointers), "Incorrect number of user pointers")
+	AssertEqual(t, users[1].Id, pointers[1].Id, "Pointer element differs")
+
+	// Results replace the previous slice content
+	names := []struct {
+		Name string `bson:"name"`
+	}{{Name: "stale"}}
+	err = coll.Find(bson.M{"active": false}).All(&names)
+	AssertNoError(t, err, "Failed to get inactive users")
+	AssertEqual(t, 1, len(names), "Previous slice content kept")
+	AssertEqual(t, "Bob Johnson", names[0].Name, "Incorrect inactive user")
+
+	// Generic elements keep the mgo types
+	var docs []interface{}
+	err = coll.Find(nil).All(&docs)
+	AssertNoError(t, err, "Failed to get all users as interfaces")
+	if _, ok := docs[0].(bson.M); !ok {
+		t.Fatalf("Expected bson.M elements, got %T", docs[0])
+	}
+
+	// The result must be a slice address
+	var single user
+	err = coll.Find(nil).All(&single)
+	AssertError(t, err, "Expected an error for a non-slice result")
+}
+
 // Note: Timeout and Err methods are not implemented in the modern wrapper
 
 func TestModernIteratorWithLargeDataset(t *testing.T) {
@@ -165,3 +227,22 @@ func TestModernIteratorPartialIteration(t *testing.T) {
 	err := iter.Close()
 	AssertNoError(t, err, "Failed to close iterator after partial iteration")
 }
+
+// TestIterWithoutCursor checks an iterator without a cursor reads as empty,
+// leaving ErrNotFound to One
+func TestIterWithoutCursor(t *testing.T) {
+	iter := &mgo.ModernIt{}
+	if iter.Next(&bson.M{}) {
+		t.Error("Expected no document without a cursor")
+	}
+	if err := iter.Err(); err != nil {
+		t.Errorf("Expected no error without a cursor, got %v", err)
+	}
+	result := []bson.M{{"stale": true}}
+	if err := iter.All(&result); err != nil || len(result) != 0 {
+		t.Errorf("Expected All to load no documents, got %v and %v", result, err)
+	}
+	if err := iter.Close(); err != nil {
+		t.Errorf("Expected no error closing, got %v", err)
+	}
+}
@@ -0,0 +1,398 @@
+// modern_monitoring.go - Operation monitoring for modern MongoDB driver compatibility wrapper
+
+package mgo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SlowOp describes an operation that ran longer than the threshold set with
+// SetSlowOpThreshold
+type SlowOp struct {
+	Command    string        // Command name, such as "find", "update" or "aggregate"
+	Database   string        // Database the command ran against
+	Collection string        // Collection of the command, empty for database-wide aggregations
+	Filter     string        // Filter or pipeline as extended JSON, cut to maxSlowOpFilter bytes
+	Duration   time.Duration // Round trip time of the command, as measured by the driver
+	Failure    string        // Error of a failed command, empty on success
+}
+
+// maxSlowOpFilter is the length beyond which SlowOp.Filter is cut
+const maxSlowOpFilter = 256
+
+// slowOpCommands maps the commands checked against the slow operation
+// threshold to the field holding their filter. The filter of updates and
+// deletes is the one of their first statement.
+var slowOpCommands = map[string]string{
+	"find":          "filter",
+	"getMore":       "",
+	"count":         "query",
+	"distinct":      "query",
+	"findAndModify": "query",
+	"update":        "updates",
+	"delete":        "deletes",
+	"aggregate":     "pipeline",
+}
+
+// slowOpLogger holds the slow operation setting of a session
+type slowOpLogger struct {
+	threshold time.Duration
+	log       func(op SlowOp)
+}
+
+// slowOpKey is the context key of the slow operation setting of the session
+// running an operation
+type slowOpKey struct{}
+
+// slowOpCommand is a command in flight whose duration is checked
+type slowOpCommand struct {
+	logger  *slowOpLogger
+	command officialBson.Raw
+	name    string
+	db      string
+}
+
+// slowOpsPending holds the commands in flight whose duration is checked,
+// keyed by request id
+var slowOpsPending sync.Map
+
+// SetSlowOpThreshold makes the session report the finds, updates, deletes,
+// counts and aggregations running longer than d, each batch of an iterator
+// being checked on its own. logger receives the command, its collection, a
+// summary of its filter and its duration; it is called from the goroutine
+// running the operation and should return quickly. Copies made afterwards
+// inherit the setting. A zero d or nil logger stops the reporting.
+func (m *ModernMGO) SetSlowOpThreshold(d time.Duration, logger func(op SlowOp)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d <= 0 || logger == nil {
+		m.slowOps = nil
+		return
+	}
+	m.slowOps = &slowOpLogger{threshold: d, log: logger}
+}
+
+// monitorContext returns ctx carrying the monitoring settings of the
+// session, found by the command monitor of the client
+func (m *ModernMGO) monitorContext(ctx context.Context) context.Context {
+	if m == nil {
+		return ctx
+	}
+	m.mu.RLock()
+	slowOps := m.slowOps
+	m.mu.RUnlock()
+	if slowOps == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, slowOpKey{}, slowOps)
+}
+
+// monitorSlowOps installs the command monitor reporting slow operations on
+// the client options, keeping the monitor already set
+func monitorSlowOps(opts *options.ClientOptions) {
+	next := opts.Monitor
+	opts.SetMonitor(&event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			if logger, ok := ctx.Value(slowOpKey{}).(*slowOpLogger); ok {
+				if _, ok := slowOpCommands[evt.CommandName]; ok {
+					slowOpsPending.Store(evt.RequestID, &slowOpCommand{
+						logger:  logger,
+						command: evt.Command,
+						name:    evt.CommandName,
+						db:      evt.DatabaseName,
+					})
+				}
+			}
+			if next != nil && next.Started != nil {
+				next.Started(ctx, evt)
+			}
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			finishSlowOp(evt.RequestID, evt.Duration, "")
+			if next != nil && next.Succeeded != nil {
+				next.Succeeded(ctx, evt)
+			}
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			finishSlowOp(evt.RequestID, evt.Duration, evt.Failure)
+			if next != nil && next.Failed != nil {
+				next.Failed(ctx, evt)
+			}
+		},
+	})
+}
+
+// finishSlowOp reports the command of the given request if it was checked
+// and ran longer than the threshold of its session
+func finishSlowOp(requestID int64, d time.Duration, failure string) {
+	pending, ok := slowOpsPending.LoadAndDelete(requestID)
+	if !ok {
+		return
+	}
+	cmd := pending.(*slowOpCommand)
+	if d < cmd.logger.threshold {
+		return
+	}
+	cmd.logger.log(cmd.slowOp(d, failure))
+}
+
+// slowOp describes the command for its logger
+func (cmd *slowOpCommand) slowOp(d time.Duration, failure string) SlowOp {
+	op := SlowOp{
+		Command:  cmd.name,
+		Database: cmd.db,
+		Duration: d,
+		Failure:  failure,
+	}
+	if cmd.name == "getMore" {
+		op.Collection, _ = cmd.command.Lookup("collection").StringValueOK()
+		return op
+	}
+	if elems, err := cmd.command.Elements(); err == nil && len(elems) > 0 {
+		op.Collection, _ = elems[0].Value().StringValueOK()
+	}
+
+	filter, err := cmd.command.LookupErr(slowOpCommands[cmd.name])
+	if err != nil {
+		return op
+	}
+	if cmd.name == "update" || cmd.name == "delete" {
+		// Statements are documents with the filter in "q"
+		statements, ok := filter.ArrayOK()
+		if !ok {
+			return op
+		}
+		values, _ := statements.Values()
+		if len(values) == 0 || values[0].Type != bsontype.EmbeddedDocument {
+			return op
+		}
+		if filter, err = values[0].Document().LookupErr("q"); err != nil {
+			return op
+		}
+	}
+	op.Filter = filter.String()
+	if len(op.Filter) > maxSlowOpFilter {
+		op.Filter = op.Filter[:maxSlowOpFilter] + "..."
+	}
+	return op
+}
+
+// TopologyEventKind identifies the kind of a TopologyEvent
+type TopologyEventKind int
+
+const (
+	ServerOpened    TopologyEventKind = iota + 1 // A server joined the topology
+	ServerClosed                                 // A server left the topology
+	ServerChanged                                // The state of a server changed, as from secondary to primary
+	PrimaryElected                               // A server became primary
+	PrimaryLost                                  // The primary stepped down or became unreachable
+	TopologyChanged                              // The kind of the topology changed
+)
+
+// String returns the name of the event kind
+func (kind TopologyEventKind) String() string {
+	switch kind {
+	case ServerOpened:
+		return "ServerOpened"
+	case ServerClosed:
+		return "ServerClosed"
+	case ServerChanged:
+		return "ServerChanged"
+	case PrimaryElected:
+		return "PrimaryElected"
+	case PrimaryLost:
+		return "PrimaryLost"
+	case TopologyChanged:
+		return "TopologyChanged"
+	}
+	return "Unknown"
+}
+
+// TopologyEvent reports a change of the deployment seen by the driver's
+// server discovery and monitoring
+type TopologyEvent struct {
+	Kind     TopologyEventKind
+	Address  string // Server concerned, the former primary for PrimaryLost; empty for TopologyChanged
+	Previous string // Previous server or topology kind, such as "RSSecondary", for changes
+	Current  string // New server or topology kind, such as "RSPrimary", for changes
+}
+
+// topologyHub dispatches the topology events of a client to the
+// subscribers of its sessions
+type topologyHub struct {
+	mu      sync.Mutex
+	subs    map[int]*topologySubscriber
+	next    int
+	current description.Topology // Latest description of the deployment
+}
+
+// topologySubscriber delivers events to a subscriber in order, on a
+// goroutine of its own so that the driver is not held up
+type topologySubscriber struct {
+	fn      func(TopologyEvent)
+	mu      sync.Mutex
+	queue   []TopologyEvent
+	running bool // Whether a goroutine is delivering the queue
+}
+
+// SubscribeTopology registers fn to receive the topology events of the
+// deployment: servers joining and leaving it, their state changes, and
+// primary elections and losses, which let services log failovers and pause
+// writes until a new primary is elected. Events are delivered in order on a
+// goroutine separate from the driver, so fn may run operations. The
+// subscription covers the session and its copies until the returned function
+// is called. The discovery of the servers starts when dialing, so its first
+// events may precede the subscription.
+func (m *ModernMGO) SubscribeTopology(fn func(TopologyEvent)) (cancel func()) {
+	hub := m.topology
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.subs == nil {
+		hub.subs = make(map[int]*topologySubscriber)
+	}
+	id := hub.next
+	hub.next++
+	hub.subs[id] = &topologySubscriber{fn: fn}
+	return func() {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		delete(hub.subs, id)
+	}
+}
+
+// publish queues evt for every subscriber
+func (hub *topologyHub) publish(evt TopologyEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for _, sub := range hub.subs {
+		sub.deliver(evt)
+	}
+}
+
+// servers returns the servers of the latest description of the deployment
+func (hub *topologyHub) servers() []description.Server {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	return append([]description.Server(nil), hub.current.Servers...)
+}
+
+// deliver queues evt, starting a goroutine to deliver the queue if none is
+func (sub *topologySubscriber) deliver(evt TopologyEvent) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.queue = append(sub.queue, evt)
+	if !sub.running {
+		sub.running = true
+		go sub.drain()
+	}
+}
+
+// drain delivers the queued events until the queue is empty
+func (sub *topologySubscriber) drain() {
+	for {
+		sub.mu.Lock()
+		if len(sub.queue) == 0 {
+			sub.running = false
+			sub.mu.Unlock()
+			return
+		}
+		evt := sub.queue[0]
+		sub.queue = sub.queue[1:]
+		sub.mu.Unlock()
+		sub.fn(evt)
+	}
+}
+
+// monitorTopology installs the server monitor feeding hub on the client
+// options, keeping the monitor already set
+func monitorTopology(opts *options.ClientOptions, hub *topologyHub) {
+	monitor := &event.ServerMonitor{}
+	if opts.ServerMonitor != nil {
+		*monitor = *opts.ServerMonitor
+	}
+	serverOpening := monitor.ServerOpening
+	monitor.ServerOpening = func(evt *event.ServerOpeningEvent) {
+		hub.publish(TopologyEvent{Kind: ServerOpened, Address: evt.Address.String()})
+		if serverOpening != nil {
+			serverOpening(evt)
+		}
+	}
+	serverClosed := monitor.ServerClosed
+	monitor.ServerClosed = func(evt *event.ServerClosedEvent) {
+		hub.publish(TopologyEvent{Kind: ServerClosed, Address: evt.Address.String()})
+		if serverClosed != nil {
+			serverClosed(evt)
+		}
+	}
+	serverChanged := monitor.ServerDescriptionChanged
+	monitor.ServerDescriptionChanged = func(evt *event.ServerDescriptionChangedEvent) {
+		// Descriptions also change with round trip times; only state
+		// changes are reported
+		if prev, cur := evt.PreviousDescription.Kind, evt.NewDescription.Kind; prev != cur {
+			hub.publish(TopologyEvent{
+				Kind:     ServerChanged,
+				Address:  evt.Address.String(),
+				Previous: prev.String(),
+				Current:  cur.String(),
+			})
+		}
+		if serverChanged != nil {
+			serverChanged(evt)
+		}
+	}
+	topologyChanged := monitor.TopologyDescriptionChanged
+	monitor.TopologyDescriptionChanged = func(evt *event.TopologyDescriptionChangedEvent) {
+		hub.mu.Lock()
+		hub.current = evt.NewDescription
+		hub.mu.Unlock()
+		for _, change := range topologyChanges(evt.PreviousDescription, evt.NewDescription) {
+			hub.publish(change)
+		}
+		if topologyChanged != nil {
+			topologyChanged(evt)
+		}
+	}
+	opts.SetServerMonitor(monitor)
+}
+
+// topologyChanges returns the events telling a topology change: a change of
+// its kind, and the loss of its primary or the election of a new one
+func topologyChanges(prev, cur description.Topology) []TopologyEvent {
+	var changes []TopologyEvent
+	if prev.Kind != cur.Kind {
+		changes = append(changes, TopologyEvent{
+			Kind:     TopologyChanged,
+			Previous: prev.Kind.String(),
+			Current:  cur.Kind.String(),
+		})
+	}
+	prevPrimary, curPrimary := topologyPrimary(prev), topologyPrimary(cur)
+	if prevPrimary != curPrimary {
+		if prevPrimary != "" {
+			changes = append(changes, TopologyEvent{Kind: PrimaryLost, Address: prevPrimary})
+		}
+		if curPrimary != "" {
+			changes = append(changes, TopologyEvent{Kind: PrimaryElected, Address: curPrimary})
+		}
+	}
+	return changes
+}
+
+// topologyPrimary returns the address of the primary of a replica set, empty
+// when it has none
+func topologyPrimary(topology description.Topology) string {
+	for _, server := range topology.Servers {
+		if server.Kind == description.RSPrimary {
+			return server.Addr.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,177 @@
+package mgo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/address"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestSlowOpThreshold checks commands of sessions with a threshold are
+// reported when they run longer than it
+func TestSlowOpThreshold(t *testing.T) {
+	opts := options.Client()
+	monitorSlowOps(opts)
+
+	m, err := DialModernMGO("mongodb://localhost:27017/slow_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+	var logged []SlowOp
+	m.SetSlowOpThreshold(100*time.Millisecond, func(op SlowOp) {
+		logged = append(logged, op)
+	})
+
+	requestID := int64(0)
+	run := func(s *ModernMGO, cmd officialBson.D, d time.Duration, failure string) {
+		t.Helper()
+		raw, err := officialBson.Marshal(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		requestID++
+		ctx := s.monitorContext(context.Background())
+		opts.Monitor.Started(ctx, &event.CommandStartedEvent{
+			Command:      raw,
+			CommandName:  cmd[0].Key,
+			DatabaseName: "app",
+			RequestID:    requestID,
+		})
+		finished := event.CommandFinishedEvent{CommandName: cmd[0].Key, RequestID: requestID, Duration: d}
+		if failure != "" {
+			opts.Monitor.Failed(ctx, &event.CommandFailedEvent{CommandFinishedEvent: finished, Failure: failure})
+		} else {
+			opts.Monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: finished})
+		}
+	}
+
+	run(m, officialBson.D{{Key: "find", Value: "users"}, {Key: "filter", Value: officialBson.D{{Key: "age", Value: 42}}}}, 150*time.Millisecond, "")
+	run(m, officialBson.D{{Key: "find", Value: "users"}, {Key: "filter", Value: officialBson.D{}}}, 50*time.Millisecond, "")
+	run(m, officialBson.D{{Key: "update", Value: "users"}, {Key: "updates", Value: officialBson.A{
+		officialBson.D{{Key: "q", Value: officialBson.D{{Key: "name", Value: "ann"}}}, {Key: "u", Value: officialBson.D{}}},
+	}}}, time.Second, "")
+	run(m, officialBson.D{{Key: "getMore", Value: int64(7)}, {Key: "collection", Value: "events"}}, time.Second, "")
+	run(m, officialBson.D{{Key: "aggregate", Value: "users"}, {Key: "pipeline", Value: officialBson.A{
+		officialBson.D{{Key: "$match", Value: officialBson.D{{Key: "bio", Value: strings.Repeat("x", 300)}}}},
+	}}}, time.Second, "operation exceeded time limit")
+	run(m, officialBson.D{{Key: "insert", Value: "users"}}, time.Second, "")
+	run(&ModernMGO{}, officialBson.D{{Key: "find", Value: "users"}}, time.Second, "")
+
+	if len(logged) != 4 {
+		t.Fatalf("Expected 4 slow operations, got %+v", logged)
+	}
+	if op := logged[0]; op.Command != "find" || op.Database != "app" || op.Collection != "users" ||
+		op.Filter != `{"age": {"$numberInt":"42"}}` || op.Duration != 150*time.Millisecond {
+		t.Errorf("Unexpected slow find %+v", op)
+	}
+	if op := logged[1]; op.Command != "update" || op.Collection != "users" || op.Filter != `{"name": "ann"}` {
+		t.Errorf("Unexpected slow update %+v", op)
+	}
+	if op := logged[2]; op.Command != "getMore" || op.Collection != "events" || op.Filter != "" {
+		t.Errorf("Unexpected slow getMore %+v", op)
+	}
+	if op := logged[3]; op.Failure == "" || len(op.Filter) != maxSlowOpFilter+len("...") {
+		t.Errorf("Unexpected slow aggregate %+v", op)
+	}
+
+	// Copies inherit the setting, which can be removed
+	logged = nil
+	copied := m.Copy()
+	m.SetSlowOpThreshold(0, nil)
+	run(copied, officialBson.D{{Key: "count", Value: "users"}}, time.Second, "")
+	run(m, officialBson.D{{Key: "count", Value: "users"}}, time.Second, "")
+	if len(logged) != 1 || logged[0].Command != "count" {
+		t.Errorf("Expected the copy alone to report, got %+v", logged)
+	}
+}
+
+// TestSubscribeTopology checks server discovery events reach subscribers,
+// with primary changes derived from the topology descriptions
+func TestSubscribeTopology(t *testing.T) {
+	m, err := DialModernMGO("mongodb://localhost:27017/topology_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+
+	events := make(chan TopologyEvent, 16)
+	cancel := m.Copy().SubscribeTopology(func(evt TopologyEvent) {
+		events <- evt
+	})
+
+	monitor := m.clientOptions.ServerMonitor
+	a, b := address.Address("a:27017"), address.Address("b:27017")
+	topology := func(primary address.Address) description.Topology {
+		topology := description.Topology{Kind: description.ReplicaSetNoPrimary}
+		for _, addr := range []address.Address{a, b} {
+			server := description.Server{Addr: addr, Kind: description.RSSecondary}
+			if addr == primary {
+				server.Kind = description.RSPrimary
+				topology.Kind = description.ReplicaSetWithPrimary
+			}
+			topology.Servers = append(topology.Servers, server)
+		}
+		return topology
+	}
+	monitor.ServerOpening(&event.ServerOpeningEvent{Address: a})
+	monitor.ServerDescriptionChanged(&event.ServerDescriptionChangedEvent{
+		Address:             a,
+		PreviousDescription: description.Server{Addr: a, Kind: description.RSSecondary, AverageRTT: time.Millisecond},
+		NewDescription:      description.Server{Addr: a, Kind: description.RSSecondary, AverageRTT: 2 * time.Millisecond},
+	})
+	monitor.ServerDescriptionChanged(&event.ServerDescriptionChangedEvent{
+		Address:             a,
+		PreviousDescription: description.Server{Addr: a, Kind: description.RSPrimary},
+		NewDescription:      description.Server{Addr: a, Kind: description.RSSecondary},
+	})
+	monitor.TopologyDescriptionChanged(&event.TopologyDescriptionChangedEvent{
+		PreviousDescription: topology(a),
+		NewDescription:      topology(""),
+	})
+	monitor.TopologyDescriptionChanged(&event.TopologyDescriptionChangedEvent{
+		PreviousDescription: topology(""),
+		NewDescription:      topology(b),
+	})
+	monitor.ServerClosed(&event.ServerClosedEvent{Address: a})
+
+	// PingAll pings the servers of the latest description
+	servers := m.topology.servers()
+	if len(servers) != 2 || servers[1].Addr != b || servers[1].Kind != description.RSPrimary {
+		t.Errorf("Expected the servers of the latest description, got %+v", servers)
+	}
+
+	want := []TopologyEvent{
+		{Kind: ServerOpened, Address: "a:27017"},
+		{Kind: ServerChanged, Address: "a:27017", Previous: "RSPrimary", Current: "RSSecondary"},
+		{Kind: TopologyChanged, Previous: "ReplicaSetWithPrimary", Current: "ReplicaSetNoPrimary"},
+		{Kind: PrimaryLost, Address: "a:27017"},
+		{Kind: TopologyChanged, Previous: "ReplicaSetNoPrimary", Current: "ReplicaSetWithPrimary"},
+		{Kind: PrimaryElected, Address: "b:27017"},
+		{Kind: ServerClosed, Address: "a:27017"},
+	}
+	for i, expected := range want {
+		select {
+		case evt := <-events:
+			if evt != expected {
+				t.Errorf("Event %d: expected %+v, got %+v", i, expected, evt)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Event %d: expected %+v, got none", i, expected)
+		}
+	}
+
+	cancel()
+	monitor.ServerOpening(&event.ServerOpeningEvent{Address: b})
+	select {
+	case evt := <-events:
+		t.Errorf("Expected no event after cancelling, got %+v", evt)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,365 @@
+// modern_oplog.go - Oplog tailing for modern MongoDB driver compatibility wrapper
+
+package mgo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kinfkong/modern-mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Operation types of oplog entries, as found in Op.Operation
+const (
+	OplogInsert  = "i"
+	OplogUpdate  = "u"
+	OplogDelete  = "d"
+	OplogCommand = "c"
+	OplogNoop    = "n"
+)
+
+// Op is an operation read from the oplog
+type Op struct {
+	Timestamp bson.MongoTimestamp // Position of the operation in the oplog (ts)
+	Term      int64               // Election term of the primary that wrote it (t)
+	WallTime  time.Time           // Wall clock time of the operation (wall), MongoDB 4.2+
+	Operation string              // One of the Oplog* operation types (op)
+	Namespace string              // "database.collection" the operation applies to (ns)
+	Object    bson.M              // Inserted document, update, deleted _id or command (o)
+	Object2   bson.M              // Selector of the updated document (o2)
+}
+
+// Database returns the database part of the operation namespace
+func (op *Op) Database() string {
+	db, _, _ := strings.Cut(op.Namespace, ".")
+	return db
+}
+
+// Collection returns the collection part of the operation namespace, empty
+// for database commands
+func (op *Op) Collection() string {
+	_, coll, _ := strings.Cut(op.Namespace, ".")
+	if coll == "$cmd" {
+		return ""
+	}
+	return coll
+}
+
+// oplogEntry is the stored form of an oplog entry
+type oplogEntry struct {
+	Timestamp primitive.Timestamp `bson:"ts"`
+	Term      int64               `bson:"t"`
+	WallTime  primitive.DateTime  `bson:"wall"`
+	Operation string              `bson:"op"`
+	Namespace string              `bson:"ns"`
+	Object    officialBson.M      `bson:"o"`
+	Object2   officialBson.M      `bson:"o2"`
+}
+
+// op converts the entry into an Op
+func (e *oplogEntry) op() Op {
+	op := Op{
+		Timestamp: mongoTimestamp(e.Timestamp),
+		Term:      e.Term,
+		Operation: e.Operation,
+		Namespace: e.Namespace,
+	}
+	if e.WallTime != 0 {
+		op.WallTime = e.WallTime.Time()
+	}
+	if e.Object != nil {
+		op.Object = convertOfficialToMGO(e.Object).(bson.M)
+	}
+	if e.Object2 != nil {
+		op.Object2 = convertOfficialToMGO(e.Object2).(bson.M)
+	}
+	return op
+}
+
+// OplogTailer follows the oplog of a replica set member, for deployments
+// where change streams are not available. It reads local.oplog.rs with a
+// tailable cursor and resumes after the last operation read when the server
+// closes the cursor:
+//
+//	tailer := session.TailOplog(lastTs, bson.M{"ns": "app.orders"})
+//	defer tailer.Close()
+//	var op mgo.Op
+//	for {
+//		for tailer.Next(&op) {
+//			handle(op)
+//		}
+//		if err := tailer.Err(); err != nil {
+//			return err
+//		}
+//		saveResumePoint(tailer.Timestamp())
+//	}
+//
+// Update entries hold the update as written to the oplog, which is a diff
+// document ($v: 2) rather than an update operator document since MongoDB 5.0.
+type OplogTailer struct {
+	session    *ModernMGO
+	filter     bson.M
+	last       bson.MongoTimestamp // Timestamp of the last operation read
+	positioned bool                // Whether last holds the resume point
+	awaitTime  time.Duration
+	cursor     *mongodrv.Cursor
+	sess       *driverSession   // Session state advanced by the cursor once closed
+	opSess     mongodrv.Session // Driver session the cursor was opened with
+	delivered  bool             // Whether the current cursor returned operations
+	timeout    bool
+	err        error
+	stopped    bool          // Whether Close ended the tailer
+	done       chan struct{} // Closed by Close and Shutdown, ending the wait of Next
+	mu         sync.Mutex    // Serializes the use of the tailer with Session.Shutdown
+}
+
+// TailOplog returns a tailer reading the operations following since from the
+// oplog. A zero since starts at the end of the oplog, with the operations
+// written after the tailer is first used. The optional filter selects the
+// operations read, for instance by namespace; its conditions on "ts" are
+// ignored.
+func (m *ModernMGO) TailOplog(since bson.MongoTimestamp, filter bson.M) *OplogTailer {
+	return &OplogTailer{
+		session:    m,
+		filter:     filter,
+		last:       since,
+		positioned: since != 0,
+		awaitTime:  time.Second,
+	}
+}
+
+// SetAwaitTime sets how long Next waits for new operations before reporting
+// a timeout. It defaults to one second.
+func (t *OplogTailer) SetAwaitTime(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.awaitTime = d
+}
+
+// Next reads the next operation into op. It returns false when no operation
+// arrived within the await time, in which case Timeout reports true and Next
+// may be called again, or when an error occurred or the tailer was closed,
+// reported by Err.
+func (t *OplogTailer) Next(op *Op) bool {
+	ok, wait, done := t.next(op)
+	if wait > 0 {
+		t.wait(wait, done)
+	}
+	return ok
+}
+
+// next reads the next operation into op under the lock of the tailer. When
+// the server closed the cursor without returning operations, it also returns
+// how long Next should wait before the cursor is reopened, and the channel
+// ending the wait once the tailer is closed.
+func (t *OplogTailer) next(op *Op) (bool, time.Duration, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timeout = false
+	if t.err != nil {
+		return false, 0, nil
+	}
+	if t.cursor == nil {
+		if t.err = t.open(); t.err != nil {
+			return false, 0, nil
+		}
+	}
+
+	ctx := context.Background()
+	if t.tryNext(ctx) {
+		var entry oplogEntry
+		if err := t.cursor.Decode(&entry); err != nil {
+			t.err = err
+			return false, 0, nil
+		}
+		*op = entry.op()
+		t.last = op.Timestamp
+		t.delivered = true
+		return true, 0, nil
+	}
+	if err := t.cursor.Err(); err != nil {
+		t.err = convertError(err)
+		return false, 0, nil
+	}
+
+	t.timeout = true
+	if t.cursor.ID() == 0 {
+		// The server closed the cursor, which happens at once when no
+		// operation follows the resume point. Reopen it on the next call,
+		// waiting first so that callers looping on timeouts do not spin.
+		t.closeCursor(ctx)
+		t.cursor = nil
+		if !t.delivered {
+			return false, t.awaitTime, t.done
+		}
+	}
+	return false, 0, nil
+}
+
+// wait waits for d to elapse, outside the lock of the tailer so that its
+// accessors, Close and Shutdown are not held up, and ends early once done is
+// closed
+func (t *OplogTailer) wait(d time.Duration, done <-chan struct{}) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
+
+// tryNext fetches the next operation, if the cursor has one
+func (t *OplogTailer) tryNext(ctx context.Context) bool {
+	return t.cursor.TryNext(ctx)
+}
+
+// closeCursor closes the cursor and ends the driver session it was opened
+// with
+func (t *OplogTailer) closeCursor(ctx context.Context) error {
+	err := t.cursor.Close(ctx)
+	t.sess.finish(t.opSess)
+	t.sess = nil
+	t.opSess = nil
+	t.session.cursorRegistry().untrack(t)
+	return err
+}
+
+// open positions the tailer and opens its tailable cursor
+func (t *OplogTailer) open() error {
+	if t.done == nil {
+		t.done = make(chan struct{})
+	}
+	ctx, cancel := t.session.operationContext(opRead, 10*time.Second)
+	defer cancel()
+
+	coll := t.session.DB("local").C("oplog.rs").readColl()
+	if !t.positioned {
+		var entry oplogEntry
+		opts := options.FindOne().
+			SetSort(officialBson.D{{Key: "$natural", Value: -1}}).
+			SetProjection(officialBson.D{{Key: "ts", Value: 1}})
+		err := coll.FindOne(ctx, officialBson.D{}, opts).Decode(&entry)
+		if err != nil && !errors.Is(err, mongodrv.ErrNoDocuments) {
+			return convertError(err)
+		}
+		t.last = mongoTimestamp(entry.Timestamp)
+		t.positioned = true
+	}
+
+	filter := officialBson.D{{Key: "ts", Value: officialBson.M{"$gt": officialTimestamp(t.last)}}}
+	for key, value := range t.filter {
+		if key != "ts" {
+			filter = append(filter, officialBson.E{Key: key, Value: convertMGOToOfficial(value)})
+		}
+	}
+	opts := options.Find().
+		SetCursorType(options.TailableAwait).
+		SetMaxAwaitTime(t.awaitTime)
+
+	// The cursor keeps a driver session of its own until it is closed
+	sess := t.session.serverSession()
+	sessCtx, opSess := sess.start(ctx)
+	cursor, err := coll.Find(sessCtx, filter, opts)
+	if err != nil {
+		sess.finish(opSess)
+		return convertError(err)
+	}
+	t.cursor = cursor
+	t.sess = sess
+	t.opSess = opSess
+	t.session.cursorRegistry().track(t)
+	t.delivered = false
+	return nil
+}
+
+// Timeout reports whether the last call to Next returned false because no
+// operation arrived in time
+func (t *OplogTailer) Timeout() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.timeout
+}
+
+// Timestamp returns the timestamp of the last operation read, from which a
+// new tailer can resume. Before the first operation it is the starting point.
+func (t *OplogTailer) Timestamp() bson.MongoTimestamp {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// Err returns the error that stopped the tailer, if any
+func (t *OplogTailer) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Close closes the tailer and returns its error, if any. Like
+// Session.Shutdown, it ends the tailer: further calls to Next return false,
+// with Err reporting ErrSessionClosed.
+func (t *OplogTailer) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return nil
+	}
+	t.stopped = true
+	err := t.close()
+	if t.err == nil {
+		t.err = ErrSessionClosed
+	}
+	return err
+}
+
+// shutdown closes the tailer for Session.Shutdown, failing its further use
+func (t *OplogTailer) shutdown() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = ErrSessionClosed
+	}
+	t.close()
+}
+
+// close closes the cursor of the tailer and ends the wait of Next
+func (t *OplogTailer) close() error {
+	if t.done != nil && !t.closed() {
+		close(t.done)
+	}
+	if t.cursor != nil {
+		err := t.closeCursor(context.Background())
+		if err != nil && t.err == nil {
+			t.err = convertError(err)
+		}
+		t.cursor = nil
+	}
+	return t.err
+}
+
+// closed reports whether the wait of Next was ended by closing the tailer
+func (t *OplogTailer) closed() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// mongoTimestamp converts a driver timestamp to its mgo representation
+func mongoTimestamp(ts primitive.Timestamp) bson.MongoTimestamp {
+	return bson.MongoTimestamp(int64(ts.T)<<32 | int64(ts.I))
+}
+
+// officialTimestamp converts an mgo timestamp to its driver representation
+func officialTimestamp(ts bson.MongoTimestamp) primitive.Timestamp {
+	return primitive.Timestamp{T: uint32(uint64(ts) >> 32), I: uint32(ts)}
+}
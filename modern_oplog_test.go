@@ -0,0 +1,145 @@
+package mgo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kinfkong/modern-mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOplogTimestamps(t *testing.T) {
+	ts, err := bson.NewMongoTimestamp(time.Unix(1700000000, 0), 7)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	official := officialTimestamp(ts)
+	if official.T != 1700000000 || official.I != 7 {
+		t.Errorf("Unexpected driver timestamp: %+v", official)
+	}
+	if back := mongoTimestamp(official); back != ts {
+		t.Errorf("Expected %v after a round trip, got %v", ts, back)
+	}
+
+	// Timestamps in documents are converted both ways
+	doc := convertMGOToOfficial(bson.M{"ts": bson.M{"$gt": ts}}).(officialBson.M)
+	if got := doc["ts"].(officialBson.M)["$gt"]; got != official {
+		t.Errorf("Expected %+v in the converted filter, got %#v", official, got)
+	}
+	if got := convertOfficialToMGO(officialBson.M{"ts": official}).(bson.M)["ts"]; got != ts {
+		t.Errorf("Expected %v in the converted document, got %#v", ts, got)
+	}
+}
+
+func TestOplogEntry(t *testing.T) {
+	id := bson.NewObjectId()
+	wall := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entry := oplogEntry{
+		Timestamp: primitive.Timestamp{T: 1700000000, I: 3},
+		Term:      2,
+		WallTime:  primitive.NewDateTimeFromTime(wall),
+		Operation: OplogUpdate,
+		Namespace: "app.orders",
+		Object:    officialBson.M{"$set": officialBson.M{"status": "paid"}},
+		Object2:   officialBson.M{"_id": officialObjectId(id)},
+	}
+
+	op := entry.op()
+	if op.Timestamp.Time().Unix() != 1700000000 || op.Timestamp.Counter() != 3 {
+		t.Errorf("Unexpected timestamp %v", op.Timestamp)
+	}
+	if op.Term != 2 || !op.WallTime.Equal(wall) || op.Operation != OplogUpdate {
+		t.Errorf("Unexpected operation %+v", op)
+	}
+	if op.Database() != "app" || op.Collection() != "orders" {
+		t.Errorf("Unexpected namespace split %q, %q", op.Database(), op.Collection())
+	}
+	if op.Object2["_id"] != id {
+		t.Errorf("Expected selector _id %v, got %#v", id, op.Object2["_id"])
+	}
+	if set, ok := op.Object["$set"].(bson.M); !ok || set["status"] != "paid" {
+		t.Errorf("Unexpected update %v", op.Object)
+	}
+
+	cmd := Op{Namespace: "app.$cmd"}
+	if cmd.Database() != "app" || cmd.Collection() != "" {
+		t.Errorf("Unexpected command namespace split %q, %q", cmd.Database(), cmd.Collection())
+	}
+}
+
+// officialObjectId converts an mgo ObjectId to the driver type
+func officialObjectId(id bson.ObjectId) primitive.ObjectID {
+	var oid primitive.ObjectID
+	copy(oid[:], id)
+	return oid
+}
+
+// TestOplogTailerAccessors checks the state reported by a tailer is read
+// under its lock, after a call to Next in progress
+func TestOplogTailerAccessors(t *testing.T) {
+	tailer := &OplogTailer{}
+	tailer.mu.Lock()
+	read := make(chan bson.MongoTimestamp)
+	go func() {
+		if tailer.Timeout() {
+			read <- tailer.Timestamp()
+		} else {
+			read <- 0
+		}
+	}()
+	select {
+	case <-read:
+		t.Fatal("Expected the accessors to wait for Next")
+	case <-time.After(50 * time.Millisecond):
+	}
+	tailer.timeout = true
+	tailer.last = 42
+	tailer.mu.Unlock()
+	if ts := <-read; ts != 42 {
+		t.Errorf("Expected the state left by Next, got timestamp %v", ts)
+	}
+}
+
+// TestOplogTailerWait checks the wait of Next before reopening a cursor
+// leaves the tailer usable and ends once the tailer is closed for good
+func TestOplogTailerWait(t *testing.T) {
+	tailer := &OplogTailer{done: make(chan struct{}), last: 42}
+	waited := make(chan struct{})
+	go func() {
+		tailer.wait(time.Hour, tailer.done)
+		close(waited)
+	}()
+
+	accessed := make(chan bson.MongoTimestamp)
+	go func() { accessed <- tailer.Timestamp() }()
+	select {
+	case ts := <-accessed:
+		if ts != 42 {
+			t.Errorf("Expected timestamp 42, got %v", ts)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the accessors not to wait for Next")
+	}
+
+	if err := tailer.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Close to end the wait")
+	}
+	if err := tailer.Close(); err != nil {
+		t.Errorf("Expected closing twice to succeed, got %v", err)
+	}
+
+	// Closing ends the tailer, which does not open a new cursor
+	if tailer.Next(&Op{}) {
+		t.Error("Expected no operation after Close")
+	}
+	if err := tailer.Err(); err != ErrSessionClosed {
+		t.Errorf("Expected ErrSessionClosed after Close, got %v", err)
+	}
+}
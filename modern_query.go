@@ -3,18 +3,19 @@
 package mgo
 
 import (
-	"context"
 	"strings"
 	"time"
 
+	"github.com/kinfkong/modern-mgo/bson"
 	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	mongodrv "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // One finds one document (mgo API compatible)
 func (q *ModernQ) One(result interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := q.coll.session.operationContext(opRead, 10*time.Second)
 	defer cancel()
 
 	findOpts := &options.FindOneOptions{}
@@ -27,23 +28,36 @@ func (q *ModernQ) One(result interface{}) error {
 	if q.skip > 0 {
 		findOpts.Skip = &q.skip
 	}
-
-	singleResult := q.coll.mgoColl.FindOne(ctx, q.filter, findOpts)
-	if singleResult.Err() != nil {
-		if singleResult.Err() == mongodrv.ErrNoDocuments {
-			return ErrNotFound
-		}
-		return singleResult.Err()
+	if q.partial {
+		findOpts.SetAllowPartialResults(true)
+	}
+	if q.hint != nil {
+		findOpts.SetHint(q.hint)
+	}
+	if q.min != nil {
+		findOpts.SetMin(q.min)
+	}
+	if q.max != nil {
+		findOpts.SetMax(q.max)
+	}
+	if q.maxTime > 0 {
+		findOpts.SetMaxTime(q.maxTime)
 	}
 
-	var doc officialBson.M
-	err := singleResult.Decode(&doc)
+	var singleResult *mongodrv.SingleResult
+	err := q.coll.retryRead(ctx, func() error {
+		singleResult = q.coll.readColl().FindOne(ctx, q.filter, findOpts)
+		return singleResult.Err()
+	})
 	if err != nil {
 		return err
 	}
 
-	converted := convertOfficialToMGO(doc)
-	return mapStructToInterface(converted, result)
+	raw, err := singleResult.Raw()
+	if err != nil {
+		return convertError(err)
+	}
+	return decodeDocument(raw, result)
 }
 
 // All finds all documents
@@ -53,27 +67,85 @@ func (q *ModernQ) All(result interface{}) error {
 	return iter.All(result)
 }
 
-// Count counts query results
+// Count counts query results (mgo API compatible). As with mgo, the skip is
+// applied before the limit, so the count is the number of matches past the
+// skipped ones, capped by the limit. The query hint and maximum time apply.
 func (q *ModernQ) Count() (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if q.coll.session.estimatedCounts() && q.countsAll() {
+		return q.coll.EstimatedCount()
+	}
+
+	ctx, cancel := q.coll.session.operationContext(opRead, 10*time.Second)
 	defer cancel()
 
+	opts := q.countOptions()
+	var count int64
+	err := q.coll.retryRead(ctx, func() (err error) {
+		count, err = q.coll.readColl().CountDocuments(ctx, q.filter, opts)
+		return err
+	})
+	return int(count), err
+}
+
+// countsAll reports whether the query counts every document of the
+// collection, allowing an estimated count
+func (q *ModernQ) countsAll() bool {
+	if q.skip != 0 || q.limit != 0 || q.hint != nil {
+		return false
+	}
+	switch filter := q.filter.(type) {
+	case nil:
+		return true
+	case officialBson.M:
+		return len(filter) == 0
+	case officialBson.D:
+		return len(filter) == 0
+	}
+	return false
+}
+
+// countOptions returns the driver options of Count
+func (q *ModernQ) countOptions() *options.CountOptions {
 	opts := &options.CountOptions{}
 	if q.skip > 0 {
 		opts.Skip = &q.skip
 	}
-	if q.limit > 0 {
-		opts.Limit = &q.limit
+	if q.limit != 0 {
+		// A negative limit counts like the positive one
+		limit := q.limit
+		if limit < 0 {
+			limit = -limit
+		}
+		opts.Limit = &limit
+	}
+	if q.hint != nil {
+		opts.SetHint(q.hint)
 	}
-
-	count, err := q.coll.mgoColl.CountDocuments(ctx, q.filter, opts)
-	return int(count), err
+	if q.maxTime > 0 {
+		opts.SetMaxTime(q.maxTime)
+	}
+	return opts
 }
 
-// Iter returns an iterator
+// Iter returns an iterator. The session read timeout, or the session timeout,
+// bounds the whole iteration.
 func (q *ModernQ) Iter() *ModernIt {
-	ctx := context.Background()
+	ctx, cancel := q.coll.session.iterContext(opRead)
+	findOpts := q.findOptions()
+
+	sess := q.coll.session.serverSession()
+	sessCtx, opSess := sess.start(ctx)
+	var cursor *mongodrv.Cursor
+	err := q.coll.retryRead(sessCtx, func() (err error) {
+		cursor, err = q.coll.readColl().Find(sessCtx, q.filter, findOpts)
+		return err
+	})
+
+	return q.coll.session.newIter(ctx, cancel, cursor, sess, opSess, err)
+}
 
+// findOptions returns the driver options of the query
+func (q *ModernQ) findOptions() *options.FindOptions {
 	findOpts := &options.FindOptions{}
 	if q.projection != nil {
 		findOpts.Projection = q.projection
@@ -84,35 +156,56 @@ func (q *ModernQ) Iter() *ModernIt {
 	if q.skip > 0 {
 		findOpts.Skip = &q.skip
 	}
-	if q.limit > 0 {
+	if q.limit != 0 {
+		// The driver turns a negative limit into a single batch request
 		findOpts.Limit = &q.limit
 	}
-
-	cursor, err := q.coll.mgoColl.Find(ctx, q.filter, findOpts)
-
-	return &ModernIt{
-		cursor: cursor,
-		ctx:    ctx,
-		err:    err,
+	if q.noCursorTimeout {
+		findOpts.SetNoCursorTimeout(true)
 	}
+	if q.partial {
+		findOpts.SetAllowPartialResults(true)
+	}
+	if q.hint != nil {
+		findOpts.SetHint(q.hint)
+	}
+	if q.min != nil {
+		findOpts.SetMin(q.min)
+	}
+	if q.max != nil {
+		findOpts.SetMax(q.max)
+	}
+	if q.maxTime > 0 {
+		findOpts.SetMaxTime(q.maxTime)
+	}
+	return findOpts
 }
 
 // Sort sets sort order
 func (q *ModernQ) Sort(fields ...string) *ModernQ {
-	var sort officialBson.D
+	q.sort = fieldOrder(fields)
+	return q
+}
+
+// fieldOrder returns the ordered key document of fields, "-field" being
+// descending
+func fieldOrder(fields []string) officialBson.D {
+	var keys officialBson.D
 	for _, field := range fields {
 		order := 1
 		if strings.HasPrefix(field, "-") {
 			order = -1
 			field = field[1:]
 		}
-		sort = append(sort, officialBson.E{Key: field, Value: order})
+		keys = append(keys, officialBson.E{Key: field, Value: order})
 	}
-	q.sort = sort
-	return q
+	return keys
 }
 
-// Limit sets query limit
+// Limit restricts the query to at most n results (mgo API compatible). A
+// negative n also returns at most -n results, in a single batch after which
+// the server closes the cursor, as with mgo; results beyond the first batch
+// are then not returned even if fewer than -n were received.
 func (q *ModernQ) Limit(n int) *ModernQ {
 	q.limit = int64(n)
 	return q
@@ -131,120 +224,168 @@ func (q *ModernQ) Select(selector interface{}) *ModernQ {
 }
 
 // Apply applies a change to a single document and returns the old or new document (mgo API compatible)
+//
+// Like mgo, it runs a single findAndModify command, whose reply tells whether
+// an existing document was modified or a new one upserted.
 func (q *ModernQ) Apply(change Change, result interface{}) (*ChangeInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	var updateDoc interface{}
-
-	if change.Remove {
-		// For remove operations, use FindOneAndDelete
-		deleteOpts := options.FindOneAndDelete()
-
-		singleResult := q.coll.mgoColl.FindOneAndDelete(ctx, q.filter, deleteOpts)
-		if singleResult.Err() != nil {
-			if singleResult.Err() == mongodrv.ErrNoDocuments {
-				return &ChangeInfo{}, ErrNotFound
-			}
-			return nil, singleResult.Err()
-		}
+	return q.findAndModify(change, nil, result)
+}
 
-		if result != nil {
-			var doc officialBson.M
-			err := singleResult.Decode(&doc)
-			if err != nil {
-				return nil, err
-			}
-			converted := convertOfficialToMGO(doc)
-			err = mapStructToInterface(converted, result)
-			if err != nil {
-				return nil, err
-			}
-		}
+// findAndModify runs the findAndModify command for Apply and
+// Collection.FindAndModify, appending extra options to the command
+func (q *ModernQ) findAndModify(change Change, extra officialBson.D, result interface{}) (*ChangeInfo, error) {
+	ctx, cancel := q.coll.session.operationContext(opWrite, 10*time.Second)
+	defer cancel()
 
-		return &ChangeInfo{Removed: 1}, nil
+	cmd := officialBson.D{
+		{Key: "findAndModify", Value: q.coll.name},
+		{Key: "query", Value: q.filter},
 	}
-
-	// For update/upsert operations
-	// Wrap plain documents in $set operator for MongoDB compatibility
-	wrappedUpdate := wrapInSetOperator(change.Update)
-	updateDoc = convertMGOToOfficial(wrappedUpdate)
-	updateOpts := options.FindOneAndUpdate()
-	updateOpts.SetUpsert(change.Upsert)
-
-	if change.ReturnNew {
-		updateOpts.SetReturnDocument(options.After)
+	// The query's Sort picks the document to modify among several matches,
+	// and its Select limits the fields of the returned document
+	if q.sort != nil {
+		cmd = append(cmd, officialBson.E{Key: "sort", Value: q.sort})
+	}
+	if q.projection != nil {
+		cmd = append(cmd, officialBson.E{Key: "fields", Value: q.projection})
+	}
+	if change.Remove {
+		cmd = append(cmd, officialBson.E{Key: "remove", Value: true})
 	} else {
-		updateOpts.SetReturnDocument(options.Before)
-	}
-
-	// Track whether this is an upsert that creates a new document
-	var wasUpsert bool
-
-	// First, check if the document exists (to determine if it's an upsert)
-	if change.Upsert {
-		var existingDoc officialBson.M
-		findResult := q.coll.mgoColl.FindOne(ctx, q.filter)
-		findErr := findResult.Decode(&existingDoc)
-		if findErr == mongodrv.ErrNoDocuments {
-			wasUpsert = true
-		} else if findErr != nil && findErr != mongodrv.ErrNoDocuments {
-			// Some other error occurred during the check
-			// Continue anyway, as the operation might still succeed
+		// Wrap plain documents in $set operator for MongoDB compatibility
+		wrappedUpdate := wrapInSetOperator(change.Update)
+		cmd = append(cmd,
+			officialBson.E{Key: "update", Value: convertMGOToOfficial(wrappedUpdate)},
+			officialBson.E{Key: "new", Value: change.ReturnNew},
+			officialBson.E{Key: "upsert", Value: change.Upsert},
+		)
+		if len(change.ArrayFilters) > 0 {
+			cmd = append(cmd, officialBson.E{Key: "arrayFilters", Value: convertArrayFilters(change.ArrayFilters)})
 		}
 	}
-
-	singleResult := q.coll.mgoColl.FindOneAndUpdate(ctx, q.filter, updateDoc, updateOpts)
-
-	// Handle the case where upsert creates a new document but ReturnDocument is Before
-	if singleResult.Err() != nil {
-		if singleResult.Err() == mongodrv.ErrNoDocuments {
-			if change.Upsert && !change.ReturnNew && wasUpsert {
-				// This is expected: upsert created a new doc but we asked for the "before" document
-				// We need to get the new document's ID
-				// Do a quick find to get the created document
-				var newDoc officialBson.M
-				findResult := q.coll.mgoColl.FindOne(ctx, q.filter)
-				if err := findResult.Decode(&newDoc); err == nil {
-					changeInfo := &ChangeInfo{}
-					if id, ok := newDoc["_id"]; ok {
-						changeInfo.UpsertedId = convertOfficialToMGO(id)
-					}
-					// If result is requested but ReturnNew is false, we can't populate it
-					// because there was no "before" document
-					return changeInfo, nil
-				}
-			}
-			return &ChangeInfo{}, ErrNotFound
-		}
-		return nil, singleResult.Err()
+	if q.coll.bypass {
+		cmd = append(cmd, officialBson.E{Key: "bypassDocumentValidation", Value: true})
+	}
+	cmd = append(cmd, extra...)
+	if wc := q.coll.session.findAndModifyWriteConcern(); wc != nil {
+		cmd = append(cmd, officialBson.E{Key: "writeConcern", Value: wc})
 	}
 
-	var doc officialBson.M
-	err := singleResult.Decode(&doc)
+	var reply findAndModifyReply
+	q.coll.noteWrite()
+	err := q.coll.mgoColl.Database().RunCommand(ctx, cmd).Decode(&reply)
 	if err != nil {
-		return nil, err
+		return nil, convertError(err)
+	}
+	if reply.LastErrorObject.N == 0 {
+		return &ChangeInfo{}, ErrNotFound
 	}
 
-	converted := convertOfficialToMGO(doc)
-	if result != nil {
-		err = mapStructToInterface(converted, result)
-		if err != nil {
-			return nil, err
-		}
+	if err := reply.decodeValue(result); err != nil {
+		return nil, err
 	}
 
+	lerr := reply.LastErrorObject
 	changeInfo := &ChangeInfo{}
-	if wasUpsert {
-		// This was an upsert that created a new document
-		if id, ok := doc["_id"]; ok {
-			changeInfo.UpsertedId = convertOfficialToMGO(id)
-		}
-	} else {
-		// This was an update of an existing document
-		changeInfo.Updated = 1
-		changeInfo.Matched = 1
+	switch {
+	case lerr.UpdatedExisting:
+		changeInfo.Updated = lerr.N
+		changeInfo.Matched = lerr.N
+	case change.Remove:
+		changeInfo.Removed = lerr.N
+		changeInfo.Matched = lerr.N
+	case change.Upsert:
+		changeInfo.UpsertedId = convertOfficialToMGO(lerr.Upserted)
 	}
-
 	return changeInfo, nil
 }
+
+// findAndModifyWriteConcern returns the write concern document sent with
+// findAndModify commands, which are always acknowledged as in mgo. It is nil
+// for handles built outside of a session and for unacknowledged sessions,
+// leaving the server default in place.
+func (m *ModernMGO) findAndModifyWriteConcern() officialBson.D {
+	if m == nil {
+		return nil
+	}
+	safe := m.Safe()
+	if safe == nil {
+		return nil
+	}
+	wc := safeWriteConcern(safe)
+	doc := officialBson.D{{Key: "w", Value: wc.W}}
+	if wc.Journal != nil {
+		doc = append(doc, officialBson.E{Key: "j", Value: *wc.Journal})
+	}
+	if wc.WTimeout > 0 {
+		doc = append(doc, officialBson.E{Key: "wtimeout", Value: wc.WTimeout.Milliseconds()})
+	}
+	return doc
+}
+
+// findAndModifyReply is the reply of the findAndModify command
+type findAndModifyReply struct {
+	Value           officialBson.RawValue `bson:"value"`
+	LastErrorObject struct {
+		N               int         `bson:"n"`
+		UpdatedExisting bool        `bson:"updatedExisting"`
+		Upserted        interface{} `bson:"upserted"`
+	} `bson:"lastErrorObject"`
+}
+
+// decodeValue decodes the document of the reply into result, if any. The
+// value is null when an upsert inserted a document and the original one was
+// asked for, leaving result untouched.
+func (r *findAndModifyReply) decodeValue(result interface{}) error {
+	if result == nil || r.Value.Type != bsontype.EmbeddedDocument {
+		return nil
+	}
+	return decodeDocument(r.Value.Value, result)
+}
+
+// NoCursorTimeout keeps the server from closing the query cursor after its
+// default idle timeout of 10 minutes, for long-running exports that process
+// results slowly. Such cursors must be closed, or they are held by the server
+// until the end of their session.
+func (q *ModernQ) NoCursorTimeout() *ModernQ {
+	q.noCursorTimeout = true
+	return q
+}
+
+// AllowPartialResults makes queries against a sharded cluster return the
+// results of the available shards instead of failing when some are down
+func (q *ModernQ) AllowPartialResults() *ModernQ {
+	q.partial = true
+	return q
+}
+
+// Hint forces the query to use the index with the given key, each field
+// being prefixed with "-" for descending order (mgo API compatible)
+func (q *ModernQ) Hint(indexKey ...string) *ModernQ {
+	q.hint = fieldOrder(indexKey)
+	return q
+}
+
+// Min restricts the query to index entries from the given lower bound,
+// inclusive, as in {a: 1, b: "x"} for an index on a and b. The bound must
+// follow the fields of an index, which MongoDB 4.2+ requires to be named with
+// Hint.
+func (q *ModernQ) Min(bound bson.D) *ModernQ {
+	q.min = convertMGOToOfficial(bound)
+	return q
+}
+
+// Max restricts the query to index entries below the given upper bound,
+// exclusive, with the same requirements as Min
+func (q *ModernQ) Max(bound bson.D) *ModernQ {
+	q.max = convertMGOToOfficial(bound)
+	return q
+}
+
+// SetMaxTime limits the time the server spends running the query, count
+// included, failing it once d elapses (mgo API compatible). Zero removes the
+// limit.
+func (q *ModernQ) SetMaxTime(d time.Duration) *ModernQ {
+	q.maxTime = d
+	return q
+}
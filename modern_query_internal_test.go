@@ -0,0 +1,154 @@
+package mgo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kinfkong/modern-mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+)
+
+// TestQueryFindOptions checks the mapping of query modifiers to find options
+func TestQueryFindOptions(t *testing.T) {
+	opts := (&ModernQ{}).findOptions()
+	if opts.Limit != nil || opts.NoCursorTimeout != nil || opts.AllowPartialResults != nil {
+		t.Errorf("Expected default find options, got %+v", opts)
+	}
+
+	q := (&ModernQ{}).Limit(-5).NoCursorTimeout().AllowPartialResults()
+	opts = q.findOptions()
+	if opts.Limit == nil || *opts.Limit != -5 {
+		t.Errorf("Expected the negative limit to reach the driver, got %v", opts.Limit)
+	}
+	if opts.NoCursorTimeout == nil || !*opts.NoCursorTimeout {
+		t.Error("Expected NoCursorTimeout to be set")
+	}
+	if opts.AllowPartialResults == nil || !*opts.AllowPartialResults {
+		t.Error("Expected AllowPartialResults to be set")
+	}
+}
+
+// TestQueryIndexBounds checks Hint, Min and Max reach the find options in order
+func TestQueryIndexBounds(t *testing.T) {
+	q := (&ModernQ{}).
+		Hint("a", "-b").
+		Min(bson.D{{Name: "a", Value: 1}, {Name: "b", Value: "x"}}).
+		Max(bson.D{{Name: "a", Value: 5}, {Name: "b", Value: "y"}})
+	opts := q.findOptions()
+
+	hint := officialBson.D{{Key: "a", Value: 1}, {Key: "b", Value: -1}}
+	if !reflect.DeepEqual(opts.Hint, hint) {
+		t.Errorf("Expected hint %v, got %v", hint, opts.Hint)
+	}
+	min := officialBson.D{{Key: "a", Value: 1}, {Key: "b", Value: "x"}}
+	if !reflect.DeepEqual(opts.Min, min) {
+		t.Errorf("Expected min %v, got %v", min, opts.Min)
+	}
+	max := officialBson.D{{Key: "a", Value: 5}, {Key: "b", Value: "y"}}
+	if !reflect.DeepEqual(opts.Max, max) {
+		t.Errorf("Expected max %v, got %v", max, opts.Max)
+	}
+}
+
+// TestQueryCountOptions checks the options of Count, a negative limit
+// counting like the positive one
+func TestQueryCountOptions(t *testing.T) {
+	opts := (&ModernQ{}).countOptions()
+	if opts.Skip != nil || opts.Limit != nil || opts.Hint != nil || opts.MaxTime != nil {
+		t.Errorf("Expected default count options, got %+v", opts)
+	}
+
+	opts = (&ModernQ{}).Skip(2).Limit(-3).Hint("-a").SetMaxTime(time.Second).countOptions()
+	if opts.Skip == nil || *opts.Skip != 2 || opts.Limit == nil || *opts.Limit != 3 {
+		t.Errorf("Expected skip 2 and limit 3, got %v and %v", opts.Skip, opts.Limit)
+	}
+	if hint := (officialBson.D{{Key: "a", Value: -1}}); !reflect.DeepEqual(opts.Hint, hint) {
+		t.Errorf("Expected hint %v, got %v", hint, opts.Hint)
+	}
+	if opts.MaxTime == nil || *opts.MaxTime != time.Second {
+		t.Errorf("Expected a 1s max time, got %v", opts.MaxTime)
+	}
+}
+
+// TestQueryCountsAll checks which queries may use an estimated count
+func TestQueryCountsAll(t *testing.T) {
+	coll := &ModernColl{}
+	if !coll.Find(nil).countsAll() || !coll.Find(bson.M{}).countsAll() {
+		t.Error("Expected an unfiltered query to count all documents")
+	}
+	queries := []*ModernQ{
+		coll.Find(bson.M{"a": 1}),
+		coll.Find(nil).Skip(1),
+		coll.Find(nil).Limit(1),
+		coll.Find(nil).Hint("a"),
+	}
+	for i, q := range queries {
+		if q.countsAll() {
+			t.Errorf("Query %d: expected a filtered count", i)
+		}
+	}
+}
+
+// TestFindAndModifyReply checks the document of a findAndModify reply is
+// decoded, and that a null value leaves the result untouched
+func TestFindAndModifyReply(t *testing.T) {
+	decode := func(value interface{}) findAndModifyReply {
+		data, err := officialBson.Marshal(officialBson.D{
+			{Key: "lastErrorObject", Value: officialBson.D{{Key: "n", Value: 1}}},
+			{Key: "value", Value: value},
+			{Key: "ok", Value: 1},
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal the reply: %v", err)
+		}
+		var reply findAndModifyReply
+		if err := officialBson.Unmarshal(data, &reply); err != nil {
+			t.Fatalf("Failed to unmarshal the reply: %v", err)
+		}
+		return reply
+	}
+
+	// An upsert asking for the original document gets a null value
+	reply := decode(nil)
+	result := bson.M{"kept": true}
+	if err := reply.decodeValue(&result); err != nil {
+		t.Fatalf("Expected a null value to be skipped, got %v", err)
+	}
+	if len(result) != 1 || result["kept"] != true {
+		t.Errorf("Expected the result to be untouched, got %v", result)
+	}
+
+	reply = decode(officialBson.D{{Key: "name", Value: "Alice"}})
+	result = bson.M{}
+	if err := reply.decodeValue(&result); err != nil {
+		t.Fatalf("Failed to decode the value: %v", err)
+	}
+	if result["name"] != "Alice" {
+		t.Errorf("Expected the returned document, got %v", result)
+	}
+	if err := reply.decodeValue(nil); err != nil {
+		t.Errorf("Expected no error without a result, got %v", err)
+	}
+}
+
+// TestFindAndModifyWriteConcern checks the write concern sent with findAndModify
+func TestFindAndModifyWriteConcern(t *testing.T) {
+	var nilSession *ModernMGO
+	if wc := nilSession.findAndModifyWriteConcern(); wc != nil {
+		t.Errorf("Expected no write concern without a session, got %v", wc)
+	}
+
+	m := &ModernMGO{}
+	m.SetSafe(nil)
+	if wc := m.findAndModifyWriteConcern(); wc != nil {
+		t.Errorf("Expected no write concern for unacknowledged session, got %v", wc)
+	}
+
+	m.SetSafe(&Safe{WMode: "majority", WTimeout: 500, J: true})
+	wc := m.findAndModifyWriteConcern()
+	expected := officialBson.D{{Key: "w", Value: "majority"}, {Key: "j", Value: true}, {Key: "wtimeout", Value: int64(500)}}
+	if !reflect.DeepEqual(wc, expected) {
+		t.Errorf("Expected %v, got %v", expected, wc)
+	}
+}
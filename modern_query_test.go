@@ -1,11 +1,14 @@
 package mgo_test
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
-	"github.com/globalsign/mgo"
-	"github.com/globalsign/mgo/bson"
+	mgo "github.com/kinfkong/modern-mgo"
+	"github.com/kinfkong/modern-mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
 )
 
 func TestModernQueryOne(t *testing.T) {
@@ -116,6 +119,15 @@ func TestModernQueryLimit(t *testing.T) {
 	err := coll.Find(nil).Limit(2).All(&results)
 	AssertNoError(t, err, "Failed to apply limit")
 	AssertEqual(t, 2, len(results), "Incorrect number of limited results")
+
+	// A negative limit returns a single batch of at most that many results
+	err = coll.Find(nil).Limit(-2).All(&results)
+	AssertNoError(t, err, "Failed to apply negative limit")
+	AssertEqual(t, 2, len(results), "Incorrect number of results with a negative limit")
+
+	count, err := coll.Find(nil).Limit(-2).Count()
+	AssertNoError(t, err, "Failed to count with negative limit")
+	AssertEqual(t, 2, count, "Incorrect count with a negative limit")
 }
 
 func TestModernQuerySkip(t *testing.T) {
@@ -190,7 +202,8 @@ func TestModernQueryCount(t *testing.T) {
 	AssertEqual(t, 2, count, "Incorrect filtered count")
 }
 
-// Note: Explain, Hint, Batch, and SetMaxTime methods are not implemented in the modern wrapper
+// Note: Query Explain and Batch are not implemented in the modern wrapper;
+// pipelines are explained in TestModernAggregationExplain
 
 func TestModernQueryApply(t *testing.T) {
 	// Setup
@@ -229,11 +242,26 @@ func TestModernQueryApply(t *testing.T) {
 		Update: bson.M{"$set": bson.M{"value": "new"}},
 		Upsert: true,
 	}
+	result = nil
 	info, err = coll.Find(bson.M{"_id": newId}).Apply(change, &result)
 	AssertNoError(t, err, "Failed to apply upsert")
-	if info.UpsertedId == nil {
-		t.Fatal("Expected upserted ID")
+	AssertEqual(t, newId, info.UpsertedId, "Expected upserted ID")
+	AssertEqual(t, 0, info.Updated, "Expected no updated document on upsert")
+	if result != nil {
+		t.Fatalf("Expected no original document for an upsert, got %v", result)
 	}
+
+	// Upsert of an existing document returns the original
+	change.Update = bson.M{"$set": bson.M{"value": "again"}}
+	info, err = coll.Find(bson.M{"_id": newId}).Apply(change, &result)
+	AssertNoError(t, err, "Failed to apply upsert of existing document")
+	AssertEqual(t, 1, info.Updated, "Expected one document updated")
+	AssertEqual(t, 1, info.Matched, "Expected one document matched")
+	AssertEqual(t, "new", result["value"], "Expected original document")
+
+	// No matching document
+	_, err = coll.Find(bson.M{"_id": bson.NewObjectId()}).Apply(mgo.Change{Remove: true}, nil)
+	AssertEqual(t, mgo.ErrNotFound, err, "Expected ErrNotFound for missing document")
 }
 
 func TestModernQueryComplexChaining(t *testing.T) {
@@ -609,6 +637,11 @@ func TestModernQueryObjectIdHexConversion(t *testing.T) {
 	count, err = coll.Find(query2).Count()
 	AssertNoError(t, err, "Failed to count with $or and ObjectIdHex")
 	AssertEqual(t, 3, count, "Should find all 3 appointments involving user")
+
+	// ObjectIds nested in driver documents are converted too
+	count, err = coll.Find(officialBson.M{"patientUserId": id1}).Count()
+	AssertNoError(t, err, "Failed to count with an official filter")
+	AssertEqual(t, 2, count, "Should find 2 appointments for patient")
 }
 
 // TestModernQueryAppointmentListScenario tests a realistic appointment listing scenario
@@ -966,3 +999,195 @@ func TestModernQueryOneWithTimeArray(t *testing.T) {
 	AssertEqual(t, len(allResults[0].StartedAtCandidates), len(oneResult.StartedAtCandidates),
 		"All() and One() should return the same number of time candidates")
 }
+
+func TestModernQueryApplyArrayFilters(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	id := bson.NewObjectId()
+	err := coll.Insert(bson.M{"_id": id, "grades": []int{85, 95, 70, 100}})
+	AssertNoError(t, err, "Failed to insert document")
+
+	// Raise only the grades below 90
+	change := mgo.Change{
+		Update:       bson.M{"$inc": bson.M{"grades.$[low]": 5}},
+		ArrayFilters: []interface{}{bson.M{"low": bson.M{"$lt": 90}}},
+		ReturnNew:    true,
+	}
+	var result struct {
+		Grades []int `bson:"grades"`
+	}
+	info, err := coll.FindId(id).Apply(change, &result)
+	AssertNoError(t, err, "Failed to apply change with array filters")
+	AssertEqual(t, 1, info.Updated, "Expected one document updated")
+	AssertEqual(t, 4, len(result.Grades), "Unexpected number of grades")
+	AssertEqual(t, 90, result.Grades[0], "Low grade not raised")
+	AssertEqual(t, 95, result.Grades[1], "High grade modified")
+	AssertEqual(t, 75, result.Grades[2], "Low grade not raised")
+	AssertEqual(t, 100, result.Grades[3], "High grade modified")
+}
+
+func TestModernQueryApplySortSelect(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+
+	for i := 1; i <= 3; i++ {
+		err := coll.Insert(bson.M{"job": i, "status": "queued", "payload": "data"})
+		AssertNoError(t, err, "Failed to insert job")
+	}
+
+	// Pop the oldest queued job, returning only its number
+	change := mgo.Change{
+		Update:    bson.M{"$set": bson.M{"status": "running"}},
+		ReturnNew: true,
+	}
+	var result bson.M
+	_, err := coll.Find(bson.M{"status": "queued"}).Sort("job").Select(bson.M{"job": 1}).Apply(change, &result)
+	AssertNoError(t, err, "Failed to pop oldest job")
+	AssertEqual(t, 1, result["job"], "Expected the oldest job")
+	if _, ok := result["payload"]; ok {
+		t.Fatalf("Expected projection to exclude payload, got %v", result)
+	}
+
+	// Removing the newest job honours a descending sort
+	result = nil
+	_, err = coll.Find(bson.M{"status": "queued"}).Sort("-job").Apply(mgo.Change{Remove: true}, &result)
+	AssertNoError(t, err, "Failed to remove newest job")
+	AssertEqual(t, 3, result["job"], "Expected the newest job")
+}
+
+func TestModernQueryCursorOptions(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	testData := GetTestData()
+	InsertTestData(t, coll, testData.Products)
+
+	var results []bson.M
+	err := coll.Find(nil).NoCursorTimeout().AllowPartialResults().All(&results)
+	AssertNoError(t, err, "Failed to query with cursor options")
+	AssertEqual(t, len(testData.Products), len(results), "Incorrect number of results")
+
+	var result bson.M
+	err = coll.Find(bson.M{"name": "Product A"}).AllowPartialResults().One(&result)
+	AssertNoError(t, err, "Failed to find one with partial results allowed")
+}
+
+func TestModernQueryMinMax(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	for i := 0; i < 10; i++ {
+		err := coll.Insert(bson.M{"group": i % 2, "seq": i})
+		AssertNoError(t, err, "Failed to insert document")
+	}
+	err := coll.EnsureIndex(mgo.Index{Key: []string{"group", "seq"}})
+	AssertNoError(t, err, "Failed to create index")
+
+	// Scan group 0 from seq 2 (inclusive) to seq 8 (exclusive)
+	var results []bson.M
+	err = coll.Find(nil).
+		Hint("group", "seq").
+		Min(bson.D{{Name: "group", Value: 0}, {Name: "seq", Value: 2}}).
+		Max(bson.D{{Name: "group", Value: 0}, {Name: "seq", Value: 8}}).
+		All(&results)
+	AssertNoError(t, err, "Failed to query with index bounds")
+	AssertEqual(t, 3, len(results), "Incorrect number of results within bounds")
+	for i, result := range results {
+		AssertEqual(t, 2+2*i, result["seq"], "Unexpected document within bounds")
+	}
+}
+
+func TestModernQueryCountOptions(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	for i := 0; i < 10; i++ {
+		err := coll.Insert(bson.M{"seq": i})
+		AssertNoError(t, err, "Failed to insert document")
+	}
+	err := coll.EnsureIndex(mgo.Index{Key: []string{"seq"}})
+	AssertNoError(t, err, "Failed to create index")
+
+	// The skip applies before the limit
+	cases := []struct {
+		skip, limit, expected int
+	}{
+		{0, 0, 10},
+		{3, 0, 7},
+		{0, 4, 4},
+		{3, 4, 4},
+		{8, 4, 2},
+		{12, 4, 0},
+		{3, -4, 4},
+	}
+	for _, c := range cases {
+		count, err := coll.Find(nil).Skip(c.skip).Limit(c.limit).Count()
+		AssertNoError(t, err, "Failed to count")
+		AssertEqual(t, c.expected, count, fmt.Sprintf("Incorrect count with skip %d and limit %d", c.skip, c.limit))
+	}
+
+	count, err := coll.Find(bson.M{"seq": bson.M{"$gte": 5}}).Hint("seq").SetMaxTime(5 * time.Second).Count()
+	AssertNoError(t, err, "Failed to count with hint and max time")
+	AssertEqual(t, 5, count, "Incorrect count with hint")
+
+	_, err = coll.Find(nil).Hint("missing").Count()
+	AssertError(t, err, "Expected an error hinting a missing index")
+}
+
+func TestModernQueryTimeout(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	coll := tdb.C("test_collection")
+	testData := GetTestData()
+	InsertTestData(t, coll, testData.Users)
+
+	session := tdb.Session.Copy()
+	defer session.Close()
+	session.SetOperationTimeouts(mgo.OpTimeouts{Read: 200 * time.Millisecond})
+	coll = session.DB(tdb.DBName).C("test_collection")
+
+	// Each document takes longer to match than the whole query may
+	slow := bson.M{"$where": "function() { sleep(500); return true; }"}
+
+	start := time.Now()
+	var results []bson.M
+	err := coll.Find(slow).All(&results)
+	AssertError(t, err, "Expected the slow query to time out")
+	if !mongodrv.IsTimeout(err) {
+		t.Errorf("Expected a timeout error, got %T: %v", err, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the read timeout to bound the query, took %v", elapsed)
+	}
+
+	// The timeout also bounds the batches fetched after the first one, which
+	// holds 101 documents by default
+	many := session.DB(tdb.DBName).C("many")
+	for i := 0; i < 150; i++ {
+		AssertNoError(t, many.Insert(bson.M{"n": i}), "Failed to insert document")
+	}
+	iter := many.Find(nil).Iter()
+	var result bson.M
+	AssertEqual(t, true, iter.Next(&result), "Expected the first batch")
+	time.Sleep(300 * time.Millisecond)
+	for iter.Next(&result) {
+	}
+	if err := iter.Close(); !mongodrv.IsTimeout(err) {
+		t.Errorf("Expected the iterator to report a timeout, got %v", err)
+	}
+}
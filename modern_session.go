@@ -4,28 +4,71 @@ package mgo
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/kinfkong/modern-mgo/bson"
 	officialBson "go.mongodb.org/mongo-driver/bson"
 	mongodrv "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/tag"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/session"
 )
 
+// ErrSessionConnected is returned when changing a setting that can only be
+// applied before the session is first used.
+var ErrSessionConnected = errors.New("session is already connected")
+
+// ErrSessionClosed is returned by operations through a copy of a session
+// after its Close, and by iterators of a copy still open when it was closed
+// or refreshed.
+var ErrSessionClosed = errors.New("session already closed")
+
 // DialModernMGO connects to MongoDB using the official driver but provides mgo API (mgo API compatible)
+//
+// Connection-level settings such as SetMinPoolSize may still be applied right
+// after dialing: until the session is first used, they connect a client with
+// the new settings, which replaces the dialed one once the session is used.
 func DialModernMGO(mongoURL string) (*ModernMGO, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	clientOptions := options.Client().ApplyURI(mongoURL)
+
+	return newModernMGO(mongoURL, clientOptions, &Safe{
+		W:        1,
+		WTimeout: 0,
+		FSync:    false,
+		J:        false,
+	})
+}
 
-	// Disable retryable writes to avoid "Retryable writes are not supported" error
-	clientOptions := options.Client().ApplyURI(mongoURL).SetRetryWrites(false)
+// newModernMGO connects a client with the given options and returns the
+// original session using it
+func newModernMGO(mongoURL string, clientOptions *options.ClientOptions, safe *Safe) (*ModernMGO, error) {
+	// Disable retryable writes to avoid "Retryable writes are not supported"
+	// errors on standalone servers, unless the URI asks for them explicitly
+	if clientOptions.RetryWrites == nil {
+		clientOptions.SetRetryWrites(false)
+	}
+	// Feed the statistics returned by GetStats, the slow operation loggers
+	// and the topology subscribers of the sessions
+	topology := &topologyHub{}
+	monitorStats(clientOptions)
+	monitorSlowOps(clientOptions)
+	monitorTopology(clientOptions, topology)
 
-	client, err := mongodrv.Connect(ctx, clientOptions)
+	client, err := connectClient(clientOptions)
 	if err != nil {
 		return nil, err
 	}
+	updateStats(func(s *Stats) { s.Clusters++ })
 
 	// Parse database name from URL
 	dbName := "test" // Default database name
@@ -36,88 +79,1003 @@ func DialModernMGO(mongoURL string) (*ModernMGO, error) {
 		}
 	}
 
-	return &ModernMGO{
-		client: client,
-		dbName: dbName,
-		mode:   Primary,
-		safe: &Safe{
-			W:        1,
-			WTimeout: 0,
-			FSync:    false,
-			J:        false,
-		},
-		isOriginal: true, // Mark as original session
-	}, nil
+	session := &ModernMGO{
+		client:        client,
+		clientOptions: clientOptions,
+		dbName:        dbName,
+		mode:          Primary,
+		safe:          safe,
+		indexes:       &indexCache{},
+		buildInfo:     &buildInfoCache{},
+		topology:      topology,
+		cursors:       &cursorRegistry{},
+		isOriginal:    true, // Mark as original session
+	}
+	// The timeoutMS URI option sets the default operation timeout
+	if clientOptions.Timeout != nil {
+		session.timeout = *clientOptions.Timeout
+	}
+	return session, nil
 }
 
-// Close closes the modern MGO session
-func (m *ModernMGO) Close() {
-	// Only close the client if this is the original session
-	if m.isOriginal && m.client != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+// connectClient connects a client with the given options within their
+// connect timeout
+func connectClient(clientOptions *options.ClientOptions) (*mongodrv.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout(clientOptions))
+	defer cancel()
+	return mongodrv.Connect(ctx, clientOptions)
+}
+
+// connectTimeout returns the connect timeout of the given options, 10s by
+// default, as mgo bounds dialing
+func connectTimeout(clientOptions *options.ClientOptions) time.Duration {
+	if clientOptions.ConnectTimeout != nil && *clientOptions.ConnectTimeout > 0 {
+		return *clientOptions.ConnectTimeout
+	}
+	return 10 * time.Second
+}
+
+// useClient returns the client of the session, which can no longer be
+// reconfigured once used. The first use switches to the client built by
+// reconfigure and disconnects the dialed one in its favour.
+func (m *ModernMGO) useClient() *mongodrv.Client {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+	if !m.used && m.pending != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), connectTimeout(m.clientOptions))
 		m.client.Disconnect(ctx)
+		cancel()
+		m.client = m.pending
+		m.pending = nil
 	}
+	m.used = true
+	return m.client
 }
 
-// Copy creates a copy of the session (mgo API compatible)
-func (m *ModernMGO) Copy() *ModernMGO {
-	return &ModernMGO{
-		client:     m.client, // Reuse the same client connection
-		dbName:     m.dbName,
-		mode:       m.mode,
-		safe:       m.safe,
-		isOriginal: false, // Mark as copy
+// reconfigure applies a change to the client options, failing with
+// ErrSessionConnected once the session has been used. A client is connected
+// with the new options, so that the setter reports the errors of doing so,
+// and replaces the one of a previous change, so that several settings changed
+// in a row leave a single reconfigured client, used from the first use on.
+func (m *ModernMGO) reconfigure(apply func(*options.ClientOptions)) error {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+
+	if m.used {
+		return ErrSessionConnected
+	}
+
+	clientOptions := options.MergeClientOptions(m.clientOptions)
+	apply(clientOptions)
+	client, err := connectClient(clientOptions)
+	if err != nil {
+		return err
+	}
+	if m.pending != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), connectTimeout(m.clientOptions))
+		m.pending.Disconnect(ctx)
+		cancel()
+	}
+	m.pending = client
+	m.clientOptions = clientOptions
+	return nil
+}
+
+// SetAppName sets the application name sent to the servers, which record it
+// in their logs and profiling data. It must be called before the session is
+// first used.
+func (m *ModernMGO) SetAppName(name string) error {
+	return m.reconfigure(func(opts *options.ClientOptions) {
+		opts.SetAppName(name)
+	})
+}
+
+// SetPoolLimit sets the maximum number of connections kept in the pool for
+// each server. It must be called before the session is first used.
+func (m *ModernMGO) SetPoolLimit(limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("invalid pool limit %d", limit)
+	}
+	return m.reconfigure(func(opts *options.ClientOptions) {
+		opts.SetMaxPoolSize(uint64(limit))
+	})
+}
+
+// SetMinPoolSize sets the number of connections the driver keeps warm in the
+// pool for each server. It must be called before the session is first used.
+func (m *ModernMGO) SetMinPoolSize(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid minimum pool size %d", n)
+	}
+	return m.reconfigure(func(opts *options.ClientOptions) {
+		opts.SetMinPoolSize(uint64(n))
+	})
+}
+
+// SetPoolTimeout sets how long an operation may wait to obtain a connection.
+// The official driver has no dedicated wait queue timeout: obtaining a
+// connection is server selection followed by a pool checkout bounded by the
+// operation context, so the timeout is applied to server selection. It must be
+// called before the session is first used.
+func (m *ModernMGO) SetPoolTimeout(timeout time.Duration) error {
+	if timeout <= 0 {
+		return fmt.Errorf("invalid pool timeout %v", timeout)
+	}
+	return m.reconfigure(func(opts *options.ClientOptions) {
+		opts.SetServerSelectionTimeout(timeout)
+	})
+}
+
+// SetRetryWrites enables or disables retryable writes, which are off by
+// default unless the URI sets retryWrites=true. Retryable writes require a
+// replica set or sharded cluster. It must be called before the session is
+// first used.
+func (m *ModernMGO) SetRetryWrites(retry bool) error {
+	return m.reconfigure(func(opts *options.ClientOptions) {
+		opts.SetRetryWrites(retry)
+	})
+}
+
+// Login sets the credentials the session authenticates with, replacing any
+// given in the URI. The official driver authenticates each connection when
+// it is established, so Login must be called before the session is first
+// used and otherwise fails with ErrSessionConnected.
+func (m *ModernMGO) Login(cred *Credential) error {
+	return m.reconfigure(func(opts *options.ClientOptions) {
+		opts.SetAuth(cred.clientCredential("admin"))
+	})
+}
+
+// SetTLSConfig enables TLS with the given configuration for connections to
+// the servers, as an alternative to the tls URI options. It must be called
+// before the session is first used.
+func (m *ModernMGO) SetTLSConfig(config *tls.Config) error {
+	return m.reconfigure(func(opts *options.ClientOptions) {
+		opts.SetTLSConfig(config)
+	})
+}
+
+// SetTimeout sets the client-side operation timeout: the deadline covering
+// each operation end to end, including server selection, connection checkout
+// and server execution. Query and pipeline iterators are bounded as a whole,
+// from opening the cursor to fetching its last batch. It defaults to the
+// timeoutMS URI option. Classes of operations given their own timeout with
+// SetOperationTimeouts keep it. A zero timeout restores the built-in
+// per-operation defaults.
+func (m *ModernMGO) SetTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timeout = timeout
+}
+
+// Timeout returns the client-side operation timeout, or zero when the
+// built-in per-operation defaults apply
+func (m *ModernMGO) Timeout() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.timeout
+}
+
+// SetOperationTimeouts sets the default timeouts of reads, writes, index
+// builds and aggregations made through the session. They take precedence
+// over the timeout set with SetTimeout, so that index builds, say, can be
+// given minutes while other operations are cut at seconds. Zero fields leave
+// their class to the session timeout, or to its built-in default.
+func (m *ModernMGO) SetOperationTimeouts(timeouts OpTimeouts) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.opTimeouts = timeouts
+}
+
+// OperationTimeouts returns the per-class default operation timeouts
+func (m *ModernMGO) OperationTimeouts() OpTimeouts {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.opTimeouts
+}
+
+// SetEstimatedCounts makes counts of all the documents of a collection, by
+// Collection.Count or an unfiltered Query.Count without skip or limit, use
+// the document count kept in the collection metadata instead of scanning the
+// collection. Such counts are fast but may be inaccurate after an unclean
+// shutdown, or on sharded clusters with orphaned documents or chunk
+// migrations in progress.
+func (m *ModernMGO) SetEstimatedCounts(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.estimate = enabled
+}
+
+// estimatedCounts reports whether unfiltered counts are estimated, false for
+// handles built outside of a session
+func (m *ModernMGO) estimatedCounts() bool {
+	if m == nil {
+		return false
 	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.estimate
+}
+
+// SetHexIds makes the FindId, UpdateId, UpsertId and RemoveId methods of
+// collections accept an ObjectId as its 24 digit hex string, as held by API
+// layers, converting it with bson.ObjectIdHex. Other strings, including ids
+// stored as strings that happen to be 24 hex digits, are no longer matched
+// by these methods once enabled.
+func (m *ModernMGO) SetHexIds(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hexIds = enabled
 }
 
-// Clone creates a clone of the session (mgo API compatible)
+// acceptsHexIds reports whether hex strings stand for ObjectIds in the *Id
+// collection methods, false for handles built outside of a session
+func (m *ModernMGO) acceptsHexIds() bool {
+	if m == nil {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.hexIds
+}
+
+// operationContext returns the context bounding a single operation of the
+// given class. The timeout set for the class takes precedence over the
+// session timeout, which takes precedence over def, the default for the
+// operation.
+// A nil session, as held by handles built outside of a session, uses def.
+//
+// For a copy, the context carries a driver session of the operation, which
+// the returned function ends after recording the times the operation reached.
+func (m *ModernMGO) operationContext(class opClass, def time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(m.monitorContext(context.Background()), m.operationTimeout(class, def))
+	sess := m.serverSession()
+	ctx, opSess := sess.start(ctx)
+	var once sync.Once
+	return ctx, func() {
+		cancel()
+		once.Do(func() { sess.finish(opSess) })
+	}
+}
+
+// iterContext returns the context of an iterator over the results of an
+// operation of the given class. When the session or the class has a timeout,
+// it bounds the whole iteration, from opening the cursor to fetching its last
+// batch; otherwise iterations are not bounded, as with mgo. The cancel
+// function releases the context once the iterator is closed or exhausted.
+func (m *ModernMGO) iterContext(class opClass) (context.Context, context.CancelFunc) {
+	ctx := m.monitorContext(context.Background())
+	if timeout := m.operationTimeout(class, 0); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// operationTimeout returns the timeout of an operation of the given class:
+// the timeout of the class if set, else the session timeout, else def
+func (m *ModernMGO) operationTimeout(class opClass, def time.Duration) time.Duration {
+	if m == nil {
+		return def
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if t := m.opTimeouts.forClass(class); t > 0 {
+		return t
+	}
+	if m.timeout > 0 {
+		return m.timeout
+	}
+	return def
+}
+
+// SetRetryReads enables or disables the driver's retryable reads, which
+// retry a failed read once on another suitable server. They are on by
+// default. It must be called before the session is first used.
+func (m *ModernMGO) SetRetryReads(retry bool) error {
+	return m.reconfigure(func(opts *options.ClientOptions) {
+		opts.SetRetryReads(retry)
+	})
+}
+
+// SetReadRetry makes queries, counts and aggregations failing with a
+// transient error (network errors, "not master" and other replica set state
+// changes) retry up to attempts times in total, waiting backoff before the
+// first retry and doubling the wait for each subsequent one. Attempts below
+// two disable these retries, which come on top of the driver's retryable
+// reads.
+func (m *ModernMGO) SetReadRetry(attempts int, backoff time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readAttempts = attempts
+	m.readBackoff = backoff
+}
+
+// readRetry returns the read retry settings, a single attempt for handles
+// built outside of a session
+func (m *ModernMGO) readRetry() (attempts int, backoff time.Duration) {
+	if m == nil {
+		return 1, 0
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.readAttempts, m.readBackoff
+}
+
+// Close closes the session (mgo API compatible). Closing the original
+// session disconnects the client it shares with its copies. Closing a copy
+// or clone leaves its clones and open iterators working; later operations
+// through the closed session fail with ErrSessionClosed.
+func (m *ModernMGO) Close() {
+	if !m.isOriginal {
+		m.replaceSession(true)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	m.disconnect(ctx)
+}
+
+// disconnect disconnects the client
+func (m *ModernMGO) disconnect(ctx context.Context) error {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+	if m.pending != nil {
+		m.pending.Disconnect(ctx)
+		m.pending = nil
+	}
+	if m.client == nil {
+		return nil
+	}
+	err := m.client.Disconnect(ctx)
+	if err == nil {
+		updateStats(func(s *Stats) { s.Clusters-- })
+	}
+	return err
+}
+
+// Shutdown closes the iterators and oplog tailers left open through the
+// session and its copies, so that their cursors do not linger on the
+// servers, then disconnects the client they share, for the graceful
+// shutdown of a process. Iterators in use by other goroutines are closed
+// once their current call returns; their further use fails with
+// ErrSessionClosed. Cursors not closed when ctx is done are left to the
+// servers, and the context error is returned after disconnecting.
+func (m *ModernMGO) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for _, cursor := range m.cursors.open() {
+			wg.Add(1)
+			go func(cursor trackedCursor) {
+				defer wg.Done()
+				cursor.shutdown()
+			}(cursor)
+		}
+		wg.Wait()
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if derr := m.disconnect(ctx); err == nil {
+		err = derr
+	}
+	return err
+}
+
+// cursorRegistry returns the registry of the cursors open through the
+// session, nil for handles built outside of a session
+func (m *ModernMGO) cursorRegistry() *cursorRegistry {
+	if m == nil {
+		return nil
+	}
+	return m.cursors
+}
+
+// track adds an open cursor to the registry
+func (r *cursorRegistry) track(cursor trackedCursor) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cursors == nil {
+		r.cursors = make(map[trackedCursor]struct{})
+	}
+	r.cursors[cursor] = struct{}{}
+}
+
+// untrack removes a closed cursor from the registry
+func (r *cursorRegistry) untrack(cursor trackedCursor) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cursors, cursor)
+}
+
+// open returns the cursors of the registry
+func (r *cursorRegistry) open() []trackedCursor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cursors := make([]trackedCursor, 0, len(r.cursors))
+	for cursor := range r.cursors {
+		cursors = append(cursors, cursor)
+	}
+	return cursors
+}
+
+// Copy creates a copy of the session (mgo API compatible). The copy starts
+// with the settings the session has at the time of the call, such as its
+// mode, safety, timeouts and server selection; changing them afterwards on
+// either session does not affect the other. Copies share the client, its
+// connection pool and the cache of ensured indexes.
+//
+// As mgo copies reserved their own socket, the operations of a copy are
+// causally consistent with each other, running in driver sessions of the
+// copy, and Close and Refresh of the copy do not affect other sessions. Like
+// any session, a copy may be used by several goroutines at once, its
+// operations running in parallel.
+func (m *ModernMGO) Copy() *ModernMGO {
+	return m.derive(false)
+}
+
+// Clone creates a clone of the session (mgo API compatible). Like a copy,
+// the clone starts with the settings of the session, but as mgo clones
+// reused the socket of their session, it shares the causal consistency of the
+// session it was cloned from, so that work spread over clones, in parallel or
+// not, observes its own writes. Clones of the original session use the
+// implicit sessions of the driver, as the original session does.
 func (m *ModernMGO) Clone() *ModernMGO {
-	return m.Copy() // In our implementation, Clone behaves like Copy
+	return m.derive(true)
 }
 
-// SetMode sets the session mode for read preference (mgo API compatible)
+// derive returns a session with the settings of m, sharing the driver
+// session of m when shared is true and with a new one otherwise
+func (m *ModernMGO) derive(shared bool) *ModernMGO {
+	client := m.useClient()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	derived := &ModernMGO{
+		client:        client, // Reuse the same client connection
+		clientOptions: m.clientOptions,
+		used:          true,
+		dbName:        m.dbName,
+		mode:          m.mode,
+		safe:          m.safe,
+		timeout:       m.timeout,
+		opTimeouts:    m.opTimeouts,
+		readConcern:   m.readConcern,
+		readAttempts:  m.readAttempts,
+		readBackoff:   m.readBackoff,
+		tags:          m.tags,
+		maxStaleness:  m.maxStaleness,
+		indexes:       m.indexes,
+		buildInfo:     m.buildInfo,
+		topology:      m.topology,
+		cursors:       m.cursors,
+		estimate:      m.estimate,
+		hexIds:        m.hexIds,
+		slowOps:       m.slowOps,
+		isOriginal:    false, // Mark as copy
+	}
+	if shared {
+		derived.sess = m.sess
+		derived.closed = m.closed
+	} else {
+		derived.sess = newDriverSession(client)
+	}
+	return derived
+}
+
+// ResetIndexCache clears the cache of indexes ensured through the session and
+// its copies, so that the next EnsureIndex calls reach the server again, for
+// example after indexes were dropped by another process (mgo API compatible)
+func (m *ModernMGO) ResetIndexCache() {
+	m.cachedIndexes().reset()
+}
+
+// SetMode sets the session mode for read preference (mgo API compatible).
+// When refresh is true, a Monotonic session that switched to the primary
+// after a write goes back to reading from secondaries.
 func (m *ModernMGO) SetMode(mode Mode, refresh bool) {
+	m.mu.Lock()
 	m.mode = mode
-	// Note: refresh parameter is for mgo compatibility but not used in modern driver
+	m.mu.Unlock()
+	if refresh {
+		m.Refresh()
+	}
+}
+
+// Refresh resets the Monotonic switch to the primary, so reads go back to
+// secondaries until the next write (mgo API compatible). A copy or clone
+// also restarts the causal consistency of its operations, with fresh
+// guarantees; its clones and open iterators keep the previous one.
+func (m *ModernMGO) Refresh() {
+	m.wrote.Store(false)
+	if !m.isOriginal {
+		m.replaceSession(false)
+	}
+}
+
+// replaceSession replaces the driver session of a copy or clone with a new
+// one, or with an ended one when closing the session. Its clones and open
+// iterators keep the previous one.
+func (m *ModernMGO) replaceSession(closing bool) {
+	m.mu.Lock()
+	prev := m.sess
+	if m.closed || prev == nil {
+		m.mu.Unlock()
+		return
+	}
+	m.sess = newDriverSession(prev.client)
+	if closing {
+		m.sess.ended = true
+		m.closed = true
+	}
+	m.mu.Unlock()
+}
+
+// serverSession returns the driver session of a copy or clone, nil for the
+// original session and handles built outside of a session
+func (m *ModernMGO) serverSession() *driverSession {
+	if m == nil {
+		return nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sess
+}
+
+// newDriverSession returns the driver session state of a copy, with no
+// operation done yet
+func newDriverSession(client *mongodrv.Client) *driverSession {
+	return &driverSession{client: client}
+}
+
+// start returns ctx carrying a new driver session for one operation, causally
+// consistent with the operations done before it, and that driver session,
+// which finish ends once the operation and its cursor are done. A nil
+// driverSession leaves the operation to the implicit sessions of the driver.
+func (s *driverSession) start(ctx context.Context) (context.Context, mongodrv.Session) {
+	if s == nil {
+		return ctx, nil
+	}
+	sess, err := s.client.StartSession()
+	if err != nil {
+		// The client is disconnected, which the operation reports
+		return ctx, nil
+	}
+	s.mu.Lock()
+	if s.clusterTime != nil {
+		sess.AdvanceClusterTime(s.clusterTime)
+	}
+	if s.operationTime != nil {
+		sess.AdvanceOperationTime(s.operationTime)
+	}
+	ended := s.ended
+	s.mu.Unlock()
+	if ended {
+		// Ended at once, so that the operation fails like any other after
+		// Close
+		sess.EndSession(ctx)
+	}
+	return mongodrv.NewSessionContext(ctx, sess), sess
+}
+
+// finish advances the cluster and operation times of s to those reached by
+// the operation run in sess, then ends sess
+func (s *driverSession) finish(sess mongodrv.Session) {
+	if s == nil || sess == nil {
+		return
+	}
+	s.mu.Lock()
+	s.clusterTime = session.MaxClusterTime(s.clusterTime, sess.ClusterTime())
+	if opTime := sess.OperationTime(); opTime != nil && (s.operationTime == nil || opTime.After(*s.operationTime)) {
+		s.operationTime = opTime
+	}
+	s.mu.Unlock()
+	sess.EndSession(context.Background())
 }
 
 // Mode returns the current session mode
 func (m *ModernMGO) Mode() Mode {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return m.mode
 }
 
+// SetSafe changes the session safety mode used for subsequent write
+// operations. A nil safe makes writes unacknowledged, so errors such as
+// duplicate keys go unreported (mgo API compatible)
+func (m *ModernMGO) SetSafe(safe *Safe) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if safe == nil {
+		m.safe = nil
+		return
+	}
+	copied := *safe
+	m.safe = &copied
+}
+
+// Safe returns the current safety mode for the session, or nil when writes
+// are unacknowledged (mgo API compatible)
+func (m *ModernMGO) Safe() *Safe {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.safe == nil {
+		return nil
+	}
+	copied := *m.safe
+	return &copied
+}
+
+// EnsureSafe compares the provided safety parameters with the ones currently
+// in use by the session and picks the most conservative choice for each
+// setting, so the write concern is only ever strengthened (mgo API compatible)
+func (m *ModernMGO) EnsureSafe(safe *Safe) {
+	if safe == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.safe == nil {
+		copied := *safe
+		m.safe = &copied
+		return
+	}
+
+	ensured := *m.safe
+	if safe.WMode != "" {
+		ensured.WMode = safe.WMode
+	} else if ensured.WMode == "" && safe.W > ensured.W {
+		ensured.W = safe.W
+	}
+	if safe.WTimeout > 0 && safe.WTimeout < ensured.WTimeout {
+		ensured.WTimeout = safe.WTimeout
+	}
+	if safe.FSync {
+		ensured.FSync = true
+		ensured.J = false
+	} else if safe.J && !ensured.FSync {
+		ensured.J = true
+	}
+	m.safe = &ensured
+}
+
+// getWriteConcern converts the session Safe settings to an official driver
+// WriteConcern.
+func (m *ModernMGO) getWriteConcern() *writeconcern.WriteConcern {
+	return safeWriteConcern(m.Safe())
+}
+
+// safeWriteConcern converts Safe settings to an official driver WriteConcern,
+// a nil safe meaning unacknowledged writes. FSync has no equivalent in modern
+// servers and is honoured by waiting for the journal instead, as mgo does
+// against MongoDB 2.6+.
+func safeWriteConcern(safe *Safe) *writeconcern.WriteConcern {
+	if safe == nil {
+		return writeconcern.Unacknowledged()
+	}
+
+	wc := &writeconcern.WriteConcern{
+		WTimeout: time.Duration(safe.WTimeout) * time.Millisecond,
+	}
+	switch {
+	case safe.WMode != "":
+		wc.W = safe.WMode
+	case safe.W > 0:
+		wc.W = safe.W
+	default:
+		wc.W = 1
+	}
+	if safe.J || safe.FSync {
+		journal := true
+		wc.Journal = &journal
+	}
+	return wc
+}
+
+// SetReadConcern sets the read concern level ("local", "majority",
+// "snapshot", "linearizable" or "available") of the reads through the
+// session, including those through collection handles obtained before. An
+// empty level falls back to Safe.RMode, or to the server default when that is
+// unset too.
+func (m *ModernMGO) SetReadConcern(level string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readConcern = level
+}
+
+// getReadConcern returns the official driver ReadConcern for the session, or
+// nil when the server default applies
+func (m *ModernMGO) getReadConcern() *readconcern.ReadConcern {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	level := m.readConcern
+	if level == "" && m.safe != nil {
+		level = m.safe.RMode
+	}
+	if level == "" {
+		return nil
+	}
+	return &readconcern.ReadConcern{Level: level}
+}
+
+// databaseOptions returns the options applied to every database handle
+// obtained from the session
+func (m *ModernMGO) databaseOptions() *options.DatabaseOptions {
+	opts := options.Database().
+		SetWriteConcern(m.getWriteConcern()).
+		SetReadPreference(m.getReadPreference())
+	if rc := m.getReadConcern(); rc != nil {
+		opts.SetReadConcern(rc)
+	}
+	return opts
+}
+
+// collectionOptions returns the options of an operation through a collection
+// handle: the current session settings, so that those changed after the
+// handle was obtained apply as with mgo, unless overridden by ModernDB.With
+func (m *ModernMGO) collectionOptions(overrides *handleOverrides) *options.CollectionOptions {
+	opts := options.Collection().
+		SetWriteConcern(m.getWriteConcern()).
+		SetReadPreference(m.getReadPreference())
+	if rc := m.getReadConcern(); rc != nil {
+		opts.SetReadConcern(rc)
+	} else {
+		// Reset a read concern set when the handle was obtained
+		opts.SetReadConcern(m.clientReadConcern())
+	}
+	if overrides != nil {
+		if overrides.writeConcern != nil {
+			opts.SetWriteConcern(overrides.writeConcern)
+		}
+		if overrides.readPref != nil {
+			opts.SetReadPreference(overrides.readPref)
+		}
+		if overrides.readConcern != nil {
+			opts.SetReadConcern(overrides.readConcern)
+		}
+	}
+	return opts
+}
+
+// clientReadConcern returns the read concern of the client, set with the
+// readConcernLevel URI option, or an empty one leaving the server default
+func (m *ModernMGO) clientReadConcern() *readconcern.ReadConcern {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+	if m.clientOptions != nil && m.clientOptions.ReadConcern != nil {
+		return m.clientOptions.ReadConcern
+	}
+	return &readconcern.ReadConcern{}
+}
+
+// SelectServers restricts reads to servers configured with the given tags.
+// Each tag set must be fully matched by a server; when several sets are
+// provided they are tried in order. Tags are combined with the session mode
+// and ignored in Primary mode, where only the primary is eligible
+// (mgo API compatible)
+//
+//	session.SelectServers(bson.D{{Name: "disk", Value: "ssd"}, {Name: "rack", Value: 1}})
+func (m *ModernMGO) SelectServers(tags ...bson.D) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tags = append([]bson.D(nil), tags...)
+}
+
+// SetMaxStaleness excludes secondaries lagging behind the primary by more
+// than d from reads in non-primary modes. The server requires at least 90
+// seconds; zero removes the limit.
+func (m *ModernMGO) SetMaxStaleness(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxStaleness = d
+}
+
 // getReadPreference converts mgo Mode to official driver ReadPreference
 func (m *ModernMGO) getReadPreference() *readpref.ReadPref {
-	switch m.mode {
+	return m.modeReadPreference(m.Mode())
+}
+
+// modeReadPreference returns the read preference of mode, restricted by the
+// server tags and maximum staleness of the session
+func (m *ModernMGO) modeReadPreference(mode Mode) *readpref.ReadPref {
+	var opts []readpref.Option
+	if m != nil {
+		m.mu.RLock()
+		if len(m.tags) > 0 {
+			opts = append(opts, readpref.WithTagSets(convertTagSets(m.tags)...))
+		}
+		if m.maxStaleness > 0 {
+			opts = append(opts, readpref.WithMaxStaleness(m.maxStaleness))
+		}
+		m.mu.RUnlock()
+	}
+
+	switch mode {
 	case Primary:
 		return readpref.Primary()
+	case Eventual, Monotonic:
+		// Monotonic reads move to the primary after the first write, see
+		// ModernColl.readColl
+		return readpref.SecondaryPreferred(opts...)
 	case PrimaryPreferred:
-		return readpref.PrimaryPreferred()
+		return readpref.PrimaryPreferred(opts...)
 	case Secondary:
-		return readpref.Secondary()
+		return readpref.Secondary(opts...)
 	case SecondaryPreferred:
-		return readpref.SecondaryPreferred()
+		return readpref.SecondaryPreferred(opts...)
 	case Nearest:
-		return readpref.Nearest()
+		return readpref.Nearest(opts...)
 	default:
 		return readpref.Primary()
 	}
 }
 
+// convertTagSets converts mgo tag documents into official driver tag sets.
+// Server tags are strings, so non-string values are formatted as text.
+func convertTagSets(tags []bson.D) []tag.Set {
+	sets := make([]tag.Set, 0, len(tags))
+	for _, doc := range tags {
+		set := make(tag.Set, 0, len(doc))
+		for _, elem := range doc {
+			value, ok := elem.Value.(string)
+			if !ok {
+				value = fmt.Sprint(elem.Value)
+			}
+			set = append(set, tag.Tag{Name: elem.Name, Value: value})
+		}
+		sets = append(sets, set)
+	}
+	return sets
+}
+
 // Ping tests the connection
 func (m *ModernMGO) Ping() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	return m.PingMode(Primary)
+}
+
+// PingMode tests the connection to a server selected by mode, restricted by
+// the tag sets and maximum staleness of the session, so that health checks
+// can verify that secondaries are reachable. Ping is PingMode(Primary).
+func (m *ModernMGO) PingMode(mode Mode) error {
+	client := m.useClient()
+
+	ctx, cancel := m.operationContext(opCommand, 10*time.Second)
 	defer cancel()
-	return m.client.Ping(ctx, readpref.Primary())
+	return convertError(client.Ping(ctx, m.modeReadPreference(mode)))
+}
+
+// ServerPing is the outcome of pinging one server with PingAll
+type ServerPing struct {
+	Address string
+	Kind    string        // Server kind seen by the driver, such as "RSPrimary" or "RSSecondary"
+	RTT     time.Duration // Round trip time of the ping, zero if it failed
+	Err     error         // Error of an unreachable server, nil if it answered
 }
 
-// BuildInfo gets server build information (mgo API compatible)
+// PingAll pings every server of the deployment known to the driver on a
+// direct connection of its own, reporting each one ordered by address. The
+// error is the one of the first server that failed, or the error of reaching
+// the deployment at all.
+func (m *ModernMGO) PingAll() ([]ServerPing, error) {
+	client := m.useClient()
+
+	// Reaching any server makes sure the others were discovered
+	ctx, cancel := m.operationContext(opCommand, 10*time.Second)
+	err := client.Ping(ctx, readpref.Nearest())
+	cancel()
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	servers := m.topology.servers()
+	pings := make([]ServerPing, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		pings[i] = ServerPing{Address: server.Addr.String(), Kind: server.Kind.String()}
+		wg.Add(1)
+		go func(ping *ServerPing) {
+			defer wg.Done()
+			ping.RTT, ping.Err = m.pingServer(ping.Address)
+		}(&pings[i])
+	}
+	wg.Wait()
+
+	sort.Slice(pings, func(i, j int) bool { return pings[i].Address < pings[j].Address })
+	for _, ping := range pings {
+		if ping.Err != nil {
+			return pings, fmt.Errorf("ping %s: %v", ping.Address, ping.Err)
+		}
+	}
+	return pings, nil
+}
+
+// pingServer pings the server at addr on a direct connection made with the
+// credentials and TLS settings of the session, returning the round trip time
+func (m *ModernMGO) pingServer(addr string) (time.Duration, error) {
+	m.connMu.Lock()
+	base := m.clientOptions
+	m.connMu.Unlock()
+
+	// The options are built afresh, as a direct connection cannot reuse an SRV
+	// connection string, and the monitors of the session must not see it
+	opts := options.Client().SetHosts([]string{addr}).SetDirect(true)
+	opts.AppName = base.AppName
+	opts.Auth = base.Auth
+	opts.TLSConfig = base.TLSConfig
+	opts.Dialer = base.Dialer
+	opts.ConnectTimeout = base.ConnectTimeout
+	opts.ServerAPIOptions = base.ServerAPIOptions
+	opts.SetMaxPoolSize(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.operationTimeout(opCommand, 10*time.Second))
+	defer cancel()
+	client, err := mongodrv.Connect(ctx, opts)
+	if err != nil {
+		return 0, convertError(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	start := time.Now()
+	if err := client.Ping(ctx, readpref.Nearest()); err != nil {
+		return 0, convertError(err)
+	}
+	return time.Since(start), nil
+}
+
+// BuildInfo gets server build information (mgo API compatible). It is
+// fetched on the first call and cached for the session and its copies;
+// RefreshBuildInfo fetches it again, as after a server upgrade.
 func (m *ModernMGO) BuildInfo() (BuildInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	cache := m.buildInfo
+	if cache == nil {
+		return m.fetchBuildInfo()
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.info == nil {
+		info, err := m.fetchBuildInfo()
+		if err != nil {
+			return BuildInfo{}, err
+		}
+		cache.info = &info
+	}
+	return cache.info.clone(), nil
+}
+
+// RefreshBuildInfo fetches the server build information again, replacing
+// the one cached by BuildInfo for the session and its copies. The cache is
+// kept when the command fails.
+func (m *ModernMGO) RefreshBuildInfo() (BuildInfo, error) {
+	cache := m.buildInfo
+	if cache == nil {
+		return m.fetchBuildInfo()
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	info, err := m.fetchBuildInfo()
+	if err != nil {
+		return BuildInfo{}, err
+	}
+	cache.info = &info
+	return info.clone(), nil
+}
+
+// fetchBuildInfo runs the buildInfo command
+func (m *ModernMGO) fetchBuildInfo() (BuildInfo, error) {
+	ctx, cancel := m.operationContext(opCommand, 10*time.Second)
 	defer cancel()
 
-	db := m.client.Database("admin")
+	db := m.useClient().Database("admin")
 
 	var result struct {
 		Version        string `bson:"version"`
@@ -132,7 +1090,7 @@ func (m *ModernMGO) BuildInfo() (BuildInfo, error) {
 
 	err := db.RunCommand(ctx, officialBson.M{"buildInfo": 1}).Decode(&result)
 	if err != nil {
-		return BuildInfo{}, err
+		return BuildInfo{}, convertError(err)
 	}
 
 	return BuildInfo{
@@ -147,23 +1105,141 @@ func (m *ModernMGO) BuildInfo() (BuildInfo, error) {
 	}, nil
 }
 
+// ServerStatus runs the serverStatus command, decoding the connection and
+// operation counts of the server and its replica set membership
+func (m *ModernMGO) ServerStatus() (*ServerStatus, error) {
+	ctx, cancel := m.operationContext(opCommand, 10*time.Second)
+	defer cancel()
+
+	var status ServerStatus
+	cmd := officialBson.D{{Key: "serverStatus", Value: 1}}
+	err := m.useClient().Database("admin").RunCommand(ctx, cmd).Decode(&status)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return &status, nil
+}
+
+// ReplSetStatus runs the replSetGetStatus command, decoding the state of the
+// replica set members and the replication lag of the secondaries. It fails
+// against a server not running as part of a replica set.
+func (m *ModernMGO) ReplSetStatus() (*ReplSetStatus, error) {
+	ctx, cancel := m.operationContext(opCommand, 10*time.Second)
+	defer cancel()
+
+	var status ReplSetStatus
+	cmd := officialBson.D{{Key: "replSetGetStatus", Value: 1}}
+	err := m.useClient().Database("admin").RunCommand(ctx, cmd).Decode(&status)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	status.setLag()
+	return &status, nil
+}
+
+// setLag computes the replication lag of the secondaries from the last
+// operation applied by the primary
+func (s *ReplSetStatus) setLag() {
+	var primary *ReplSetMember
+	for i := range s.Members {
+		if s.Members[i].StateStr == "PRIMARY" {
+			primary = &s.Members[i]
+		}
+	}
+	if primary == nil {
+		return
+	}
+	for i := range s.Members {
+		member := &s.Members[i]
+		if member.StateStr == "SECONDARY" && member.OptimeDate.Before(primary.OptimeDate) {
+			member.Lag = primary.OptimeDate.Sub(member.OptimeDate)
+		}
+	}
+}
+
+// clone returns a copy of bi not sharing its version array
+func (bi *BuildInfo) clone() BuildInfo {
+	c := *bi
+	c.VersionArray = append([]int(nil), bi.VersionArray...)
+	return c
+}
+
 // DB returns a database handle
 func (m *ModernMGO) DB(name string) *ModernDB {
 	if name == "" {
 		name = m.dbName
 	}
 	return &ModernDB{
-		mgoDB: m.client.Database(name),
-		name:  name,
+		mgoDB:   m.useClient().Database(name, m.databaseOptions()),
+		name:    name,
+		session: m,
 	}
 }
 
+// FindRef returns a query that looks for the document in the provided
+// reference. The reference must have its Database field set since the session
+// cannot otherwise resolve it (mgo API compatible)
+func (m *ModernMGO) FindRef(ref *DBRef) *ModernQ {
+	if ref.Database == "" {
+		panic(fmt.Errorf("Can't resolve database for %#v", ref))
+	}
+	return m.DB(ref.Database).C(ref.Collection).FindId(ref.Id)
+}
+
 // C returns a collection handle
 func (db *ModernDB) C(name string) *ModernColl {
 	return &ModernColl{
-		mgoColl: db.mgoDB.Collection(name),
-		name:    name,
+		mgoColl:   db.mgoDB.Collection(name),
+		name:      name,
+		session:   db.session,
+		overrides: db.overrides,
+	}
+}
+
+// With returns a copy of the database handle whose read concern, write concern
+// and read preference are overridden by the non-nil arguments. Collections
+// obtained from the derived handle inherit the overrides, while the original
+// handle is left untouched. The settings not overridden follow the session.
+func (db *ModernDB) With(rc *readconcern.ReadConcern, wc *writeconcern.WriteConcern, rp *readpref.ReadPref) *ModernDB {
+	overrides := &handleOverrides{}
+	if db.overrides != nil {
+		*overrides = *db.overrides
+	}
+	opts := options.Database().
+		SetReadConcern(db.mgoDB.ReadConcern()).
+		SetWriteConcern(db.mgoDB.WriteConcern()).
+		SetReadPreference(db.mgoDB.ReadPreference())
+	if rc != nil {
+		opts.SetReadConcern(rc)
+		overrides.readConcern = rc
+	}
+	if wc != nil {
+		opts.SetWriteConcern(wc)
+		overrides.writeConcern = wc
+	}
+	if rp != nil {
+		opts.SetReadPreference(rp)
+		overrides.readPref = rp
 	}
+	return &ModernDB{
+		mgoDB:     db.mgoDB.Client().Database(db.name, opts),
+		name:      db.name,
+		session:   db.session,
+		overrides: overrides,
+	}
+}
+
+// FindRef returns a query that looks for the document in the provided
+// reference. If the reference includes the DB field, the document will be
+// retrieved from the respective database (mgo API compatible)
+func (db *ModernDB) FindRef(ref *DBRef) *ModernQ {
+	var c *ModernColl
+	if ref.Database == "" {
+		c = db.C(ref.Collection)
+	} else {
+		c = db.session.DB(ref.Database).C(ref.Collection)
+	}
+	return c.FindId(ref.Id)
 }
 
 // GridFS returns a GridFS handle (mgo API compatible)
@@ -175,24 +1251,96 @@ func (db *ModernDB) GridFS(prefix string) *ModernGridFS {
 	}
 }
 
-// Run executes a database command (mgo API compatible)
+// Run executes a database command (mgo API compatible). The server takes the
+// first key of the document as the command name, so commands with arguments
+// must be given as a bson.D, built for example with Cmd: a bson.M has no key
+// order and only suits commands without arguments. A string runs the command
+// of that name, as {name: 1}. A nil result discards the reply.
 func (db *ModernDB) Run(cmd interface{}, result interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := db.session.operationContext(opCommand, 30*time.Second)
+	defer cancel()
+
+	reply := db.mgoDB.RunCommand(ctx, commandDocument(cmd))
+	if result == nil {
+		return convertError(reply.Err())
+	}
+	// Decoding as the queries do gives mgo types, such as the
+	// bson.MongoTimestamp of the cluster time fields
+	raw, err := reply.Raw()
+	if err != nil {
+		return convertError(err)
+	}
+	return decodeDocument(raw, result)
+}
+
+// Stats returns storage statistics for the database by running dbStats
+func (db *ModernDB) Stats() (*DBStats, error) {
+	ctx, cancel := db.session.operationContext(opCommand, 30*time.Second)
+	defer cancel()
+
+	var stats DBStats
+	err := db.mgoDB.RunCommand(ctx, officialBson.D{{Key: "dbStats", Value: 1}}).Decode(&stats)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return &stats, nil
+}
+
+// SetProfilingLevel sets the profiling level of the database: 0 turns the
+// profiler off, 1 records the operations slower than slow and 2 records all
+// operations in the system.profile collection. A zero slow keeps the current
+// threshold of the server.
+func (db *ModernDB) SetProfilingLevel(level int, slow time.Duration) error {
+	ctx, cancel := db.session.operationContext(opCommand, 30*time.Second)
 	defer cancel()
 
-	command := convertMGOToOfficial(cmd)
-	return db.mgoDB.RunCommand(ctx, command).Decode(result)
+	cmd := officialBson.D{{Key: "profile", Value: level}}
+	if slow > 0 {
+		cmd = append(cmd, officialBson.E{Key: "slowms", Value: slow.Milliseconds()})
+	}
+	return convertError(db.mgoDB.RunCommand(ctx, cmd).Err())
+}
+
+// ProfilingLevel returns the profiling level of the database and the
+// threshold above which level 1 records operations
+func (db *ModernDB) ProfilingLevel() (level int, slow time.Duration, err error) {
+	ctx, cancel := db.session.operationContext(opCommand, 30*time.Second)
+	defer cancel()
+
+	var reply struct {
+		Was    int   `bson:"was"`
+		SlowMS int64 `bson:"slowms"`
+	}
+	// Level -1 reads the settings without changing them
+	cmd := officialBson.D{{Key: "profile", Value: -1}}
+	if err := db.mgoDB.RunCommand(ctx, cmd).Decode(&reply); err != nil {
+		return 0, 0, convertError(err)
+	}
+	return reply.Was, time.Duration(reply.SlowMS) * time.Millisecond, nil
+}
+
+// ProfileEntries reads the operations recorded by the profiler matching
+// filter, newest first. A nil filter matches all of them and a zero limit
+// returns them all.
+func (db *ModernDB) ProfileEntries(filter interface{}, limit int) ([]ProfileEntry, error) {
+	var entries []ProfileEntry
+	err := db.C("system.profile").Find(filter).Sort("-ts").Limit(limit).All(&entries)
+	return entries, err
 }
 
 // DropDatabase removes the entire database including all of its collections (mgo API compatible)
 func (db *ModernDB) DropDatabase() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := db.session.operationContext(opCommand, 30*time.Second)
 	defer cancel()
 
-	return db.mgoDB.Drop(ctx)
+	db.session.cachedIndexes().forget(db.name + ".")
+	return convertError(db.mgoDB.Drop(ctx))
 }
 
-// Run executes a database command (mgo API compatible with 3-parameter interface)
+// Run executes a database command (mgo API compatible with 3-parameter
+// interface), against the admin database unless adminFlag is false. The
+// command follows the rules of ModernDB.Run: a bson.D, as built by Cmd, for
+// commands with arguments, or a string for those without.
 func (m *ModernMGO) Run(adminFlag interface{}, cmd interface{}, result interface{}) error {
 	// First parameter determines which database to use
 	// If true or admin-like, use admin database; otherwise use default database
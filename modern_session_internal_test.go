@@ -0,0 +1,761 @@
+package mgo
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kinfkong/modern-mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// TestSafeToWriteConcern checks the mapping from mgo Safe settings to driver write concerns
+func TestSafeToWriteConcern(t *testing.T) {
+	m := &ModernMGO{}
+
+	m.SetSafe(nil)
+	if wc := m.getWriteConcern(); wc.Acknowledged() {
+		t.Errorf("Expected unacknowledged write concern for nil safe, got %+v", wc)
+	}
+
+	m.SetSafe(&Safe{})
+	if wc := m.getWriteConcern(); wc.W != 1 || wc.Journal != nil {
+		t.Errorf("Expected w=1 for empty safe, got %+v", wc)
+	}
+
+	m.SetSafe(&Safe{W: 2, WTimeout: 500})
+	if wc := m.getWriteConcern(); wc.W != 2 || wc.WTimeout != 500*time.Millisecond {
+		t.Errorf("Expected w=2 wtimeout=500ms, got %+v", wc)
+	}
+
+	m.SetSafe(&Safe{W: 2, WMode: "majority"})
+	if wc := m.getWriteConcern(); wc.W != "majority" {
+		t.Errorf("Expected WMode to take precedence over W, got %+v", wc)
+	}
+
+	m.SetSafe(&Safe{FSync: true})
+	if wc := m.getWriteConcern(); wc.Journal == nil || !*wc.Journal {
+		t.Errorf("Expected FSync to request journaling, got %+v", wc)
+	}
+}
+
+// TestEnsureSafe checks that EnsureSafe only ever strengthens the safety mode
+func TestEnsureSafe(t *testing.T) {
+	m := &ModernMGO{}
+
+	// From unacknowledged, the requested mode is adopted as is
+	m.EnsureSafe(&Safe{W: 1, WTimeout: 200})
+	if safe := m.Safe(); safe == nil || safe.W != 1 || safe.WTimeout != 200 {
+		t.Fatalf("Expected W=1 WTimeout=200, got %+v", safe)
+	}
+
+	// A nil or weaker mode changes nothing
+	m.EnsureSafe(nil)
+	m.EnsureSafe(&Safe{W: 0, WTimeout: 500})
+	if safe := m.Safe(); safe.W != 1 || safe.WTimeout != 200 {
+		t.Fatalf("Expected safe mode to be unchanged, got %+v", safe)
+	}
+
+	// Stronger settings are picked up individually
+	m.EnsureSafe(&Safe{W: 3, WTimeout: 100, J: true})
+	if safe := m.Safe(); safe.W != 3 || safe.WTimeout != 100 || !safe.J {
+		t.Fatalf("Expected W=3 WTimeout=100 J=true, got %+v", safe)
+	}
+
+	// WMode always wins over a numeric W and is never replaced by one
+	m.EnsureSafe(&Safe{WMode: "majority"})
+	m.EnsureSafe(&Safe{W: 5})
+	if safe := m.Safe(); safe.WMode != "majority" || safe.W != 3 {
+		t.Fatalf("Expected WMode=majority to be kept, got %+v", safe)
+	}
+
+	// FSync supersedes J
+	m.EnsureSafe(&Safe{FSync: true})
+	if safe := m.Safe(); !safe.FSync || safe.J {
+		t.Fatalf("Expected FSync=true J=false, got %+v", safe)
+	}
+	m.EnsureSafe(&Safe{J: true})
+	if safe := m.Safe(); safe.J {
+		t.Fatalf("Expected J to stay unset while FSync is on, got %+v", safe)
+	}
+}
+
+// TestDialConnects checks dialing connects the client and reports the errors
+// of doing so, as mgo does
+func TestDialConnects(t *testing.T) {
+	if _, err := DialModernMGO("mongodb://localhost:27017/dial_test?authMechanism=UNKNOWN"); err == nil {
+		t.Error("Expected an unsupported mechanism to fail dialing")
+	}
+	if _, err := DialWithTimeout("mongodb://localhost:27017,localhost:27018/dial_test?directConnection=true", time.Second); err == nil {
+		t.Error("Expected conflicting options to fail dialing")
+	}
+
+	m, err := DialWithTimeout("mongodb://localhost:27017/dial_test", time.Second)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+	sess, err := m.client.StartSession()
+	if err != nil {
+		t.Fatalf("Expected a connected client, got %v", err)
+	}
+	sess.EndSession(context.Background())
+
+	// Settings changed before first use connect a client replacing the one
+	// of the previous change, used in place of the dialed one on first use
+	dialed := m.client
+	if err := m.SetAppName("dial"); err != nil {
+		t.Fatalf("Failed to set app name: %v", err)
+	}
+	replaced := m.pending
+	if err := m.SetPoolLimit(5); err != nil {
+		t.Fatalf("Failed to set pool limit: %v", err)
+	}
+	if m.client != dialed || m.pending == nil || m.pending == replaced {
+		t.Fatal("Expected the settings to wait for the first use")
+	}
+	if err := replaced.Disconnect(context.Background()); err != mongodrv.ErrClientDisconnected {
+		t.Errorf("Expected the replaced client to be disconnected, got %v", err)
+	}
+	pending := m.pending
+	if client := m.useClient(); client != pending || m.pending != nil {
+		t.Fatal("Expected the first use to switch to the reconfigured client")
+	}
+	sess, err = m.client.StartSession()
+	if err != nil {
+		t.Fatalf("Expected a connected client after reconfiguring, got %v", err)
+	}
+	sess.EndSession(context.Background())
+	if err := dialed.Disconnect(context.Background()); err != mongodrv.ErrClientDisconnected {
+		t.Errorf("Expected the dialed client to be disconnected, got %v", err)
+	}
+}
+
+// TestPoolSettings checks pool settings are applied before the session is used and rejected after
+func TestPoolSettings(t *testing.T) {
+	m, err := DialModernMGO("mongodb://localhost:27017/pool_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+
+	if err := m.SetMinPoolSize(5); err != nil {
+		t.Fatalf("Failed to set min pool size: %v", err)
+	}
+	if err := m.SetPoolLimit(20); err != nil {
+		t.Fatalf("Failed to set pool limit: %v", err)
+	}
+	if err := m.SetPoolTimeout(2 * time.Second); err != nil {
+		t.Fatalf("Failed to set pool timeout: %v", err)
+	}
+	if err := m.SetMinPoolSize(-1); err == nil {
+		t.Error("Expected error for negative min pool size")
+	}
+
+	opts := m.clientOptions
+	if opts.MinPoolSize == nil || *opts.MinPoolSize != 5 {
+		t.Errorf("Expected MinPoolSize=5, got %v", opts.MinPoolSize)
+	}
+	if opts.MaxPoolSize == nil || *opts.MaxPoolSize != 20 {
+		t.Errorf("Expected MaxPoolSize=20, got %v", opts.MaxPoolSize)
+	}
+	if opts.ServerSelectionTimeout == nil || *opts.ServerSelectionTimeout != 2*time.Second {
+		t.Errorf("Expected ServerSelectionTimeout=2s, got %v", opts.ServerSelectionTimeout)
+	}
+	if opts.RetryWrites == nil || *opts.RetryWrites {
+		t.Error("Expected URI-derived options to be preserved")
+	}
+
+	// Using the session fixes the pool settings
+	m.DB("")
+	if err := m.SetMinPoolSize(1); err != ErrSessionConnected {
+		t.Errorf("Expected ErrSessionConnected, got %v", err)
+	}
+	if err := m.Copy().SetPoolTimeout(time.Second); err != ErrSessionConnected {
+		t.Errorf("Expected ErrSessionConnected on copy, got %v", err)
+	}
+}
+
+// TestSelectServers checks tag sets are translated into the read preference
+func TestSelectServers(t *testing.T) {
+	m := &ModernMGO{mode: SecondaryPreferred}
+	m.SelectServers(
+		bson.D{{Name: "dc", Value: "east"}, {Name: "rack", Value: 1}},
+		bson.D{{Name: "dc", Value: "west"}},
+	)
+
+	rp := m.getReadPreference()
+	if rp.Mode() != readpref.SecondaryPreferredMode {
+		t.Fatalf("Expected secondaryPreferred mode, got %v", rp.Mode())
+	}
+	sets := rp.TagSets()
+	if len(sets) != 2 {
+		t.Fatalf("Expected 2 tag sets, got %v", sets)
+	}
+	if !sets[0].Contains("dc", "east") || !sets[0].Contains("rack", "1") || !sets[1].Contains("dc", "west") {
+		t.Errorf("Unexpected tag sets: %v", sets)
+	}
+
+	// Tags do not apply to the primary
+	m.SetMode(Primary, true)
+	if rp := m.getReadPreference(); len(rp.TagSets()) != 0 {
+		t.Errorf("Expected no tag sets in Primary mode, got %v", rp.TagSets())
+	}
+
+	// Clearing the selection removes the tags
+	m.SetMode(Nearest, true)
+	m.SelectServers()
+	if rp := m.getReadPreference(); len(rp.TagSets()) != 0 {
+		t.Errorf("Expected no tag sets after reset, got %v", rp.TagSets())
+	}
+}
+
+// TestMonotonicMode checks Monotonic reads switch to the primary after a write
+func TestMonotonicMode(t *testing.T) {
+	m, err := DialModernMGO("mongodb://localhost:27017/monotonic_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+
+	m.SetMode(Eventual, true)
+	if mode := m.getReadPreference().Mode(); mode != readpref.SecondaryPreferredMode {
+		t.Errorf("Expected Eventual to read from secondaries, got %v", mode)
+	}
+
+	// Before writing, reads use the secondaryPreferred mode of the session
+	m.SetMode(Monotonic, true)
+	if mode := m.getReadPreference().Mode(); mode != readpref.SecondaryPreferredMode {
+		t.Errorf("Expected Monotonic to read from secondaries, got %v", mode)
+	}
+	coll := m.DB("").C("monotonic")
+	if mode := coll.readOptions().ReadPreference.Mode(); mode != readpref.SecondaryPreferredMode {
+		t.Fatalf("Expected Monotonic to read from secondaries before writing, got %v", mode)
+	}
+
+	// After writing, reads go to the primary
+	coll.noteWrite()
+	if mode := coll.readOptions().ReadPreference.Mode(); mode != readpref.PrimaryMode {
+		t.Fatalf("Expected Monotonic to switch to the primary after writing, got %v", mode)
+	}
+
+	// Copies start over, while Refresh resets the switch on the session itself
+	copied := m.Copy().DB("").C("monotonic")
+	if mode := copied.readOptions().ReadPreference.Mode(); mode != readpref.SecondaryPreferredMode {
+		t.Errorf("Expected copy to read from secondaries, got %v", mode)
+	}
+	m.Refresh()
+	if mode := coll.readOptions().ReadPreference.Mode(); mode != readpref.SecondaryPreferredMode {
+		t.Errorf("Expected Refresh to reset the switch, got %v", mode)
+	}
+
+	// Strong is an alias of Primary
+	m.SetMode(Strong, true)
+	if mode := m.getReadPreference().Mode(); mode != readpref.PrimaryMode {
+		t.Errorf("Expected Strong to read from the primary, got %v", mode)
+	}
+}
+
+// TestSetMaxStaleness checks max staleness is carried by non-primary read preferences
+func TestSetMaxStaleness(t *testing.T) {
+	m, err := DialModernMGO("mongodb://localhost:27017/staleness_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+
+	m.SetMode(SecondaryPreferred, true)
+	if _, ok := m.getReadPreference().MaxStaleness(); ok {
+		t.Error("Expected no max staleness by default")
+	}
+
+	m.SetMaxStaleness(2 * time.Minute)
+	if d, ok := m.getReadPreference().MaxStaleness(); !ok || d != 2*time.Minute {
+		t.Errorf("Expected max staleness of 2m, got %v", d)
+	}
+	if d, ok := m.Copy().getReadPreference().MaxStaleness(); !ok || d != 2*time.Minute {
+		t.Errorf("Expected copy to keep max staleness, got %v", d)
+	}
+
+	m.SetMode(Primary, true)
+	if _, ok := m.getReadPreference().MaxStaleness(); ok {
+		t.Error("Expected no max staleness in Primary mode")
+	}
+}
+
+// TestSetReadConcern checks the session read concern reaches database options
+func TestSetReadConcern(t *testing.T) {
+	m := &ModernMGO{safe: &Safe{W: 1}}
+	if rc := m.databaseOptions().ReadConcern; rc != nil {
+		t.Errorf("Expected server default read concern, got %+v", rc)
+	}
+
+	// Safe.RMode provides the mgo way of configuring the read concern
+	m.SetSafe(&Safe{W: 1, RMode: "local"})
+	if rc := m.databaseOptions().ReadConcern; rc == nil || rc.Level != "local" {
+		t.Errorf("Expected read concern from RMode, got %+v", rc)
+	}
+
+	// An explicit level takes precedence
+	m.SetReadConcern("majority")
+	if rc := m.databaseOptions().ReadConcern; rc == nil || rc.Level != "majority" {
+		t.Errorf("Expected majority read concern, got %+v", rc)
+	}
+}
+
+// TestRetryWrites checks retryable writes default to off but honour the URI and setter
+func TestRetryWrites(t *testing.T) {
+	m, err := DialModernMGO("mongodb://localhost:27017/retry_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+	if rw := m.clientOptions.RetryWrites; rw == nil || *rw {
+		t.Errorf("Expected retryable writes to be disabled by default, got %v", rw)
+	}
+	if err := m.SetRetryWrites(true); err != nil {
+		t.Fatalf("Failed to enable retryable writes: %v", err)
+	}
+	if rw := m.clientOptions.RetryWrites; rw == nil || !*rw {
+		t.Errorf("Expected retryable writes to be enabled, got %v", rw)
+	}
+
+	fromURI, err := DialWithTimeout("mongodb://localhost:27017/retry_test?retryWrites=true", time.Second)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer fromURI.Close()
+	if rw := fromURI.clientOptions.RetryWrites; rw == nil || !*rw {
+		t.Errorf("Expected retryWrites=true in the URI to be honoured, got %v", rw)
+	}
+}
+
+// TestReadRetry checks transient read errors are retried as configured
+func TestReadRetry(t *testing.T) {
+	m := &ModernMGO{}
+	coll := &ModernColl{session: m}
+	notMaster := mongodrv.CommandError{Code: 10107, Message: "not master"}
+
+	calls := 0
+	failing := func() error {
+		calls++
+		return notMaster
+	}
+
+	// Retries are off by default
+	if err := coll.retryRead(context.Background(), failing); err == nil || calls != 1 {
+		t.Fatalf("Expected a single attempt, got %d (err=%v)", calls, err)
+	}
+
+	// Transient errors are retried until attempts run out
+	m.SetReadRetry(3, time.Millisecond)
+	calls = 0
+	if err := coll.retryRead(context.Background(), failing); err == nil || calls != 3 {
+		t.Fatalf("Expected 3 attempts, got %d (err=%v)", calls, err)
+	}
+
+	// A successful retry ends the loop
+	calls = 0
+	err := coll.retryRead(context.Background(), func() error {
+		calls++
+		if calls == 1 {
+			return notMaster
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("Expected success on the second attempt, got %d (err=%v)", calls, err)
+	}
+
+	// Other errors are returned immediately, translated to mgo errors
+	calls = 0
+	err = coll.retryRead(context.Background(), func() error {
+		calls++
+		return mongodrv.ErrNoDocuments
+	})
+	if err != ErrNotFound || calls != 1 {
+		t.Fatalf("Expected no retry for ErrNoDocuments, got %d (err=%v)", calls, err)
+	}
+
+	// The wait between attempts ends with the operation context
+	m.SetReadRetry(3, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls = 0
+	start := time.Now()
+	err = coll.retryRead(ctx, failing)
+	if err == nil || calls != 1 || time.Since(start) > 5*time.Second {
+		t.Fatalf("Expected the backoff to end with the context, got %d attempts in %v (err=%v)",
+			calls, time.Since(start), err)
+	}
+}
+
+// TestOperationTimeout checks the session timeout overrides per-operation defaults
+func TestOperationTimeout(t *testing.T) {
+	deadlineIn := func(m *ModernMGO, def time.Duration) time.Duration {
+		ctx, cancel := m.operationContext(opRead, def)
+		defer cancel()
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			t.Fatal("Expected operation context to have a deadline")
+		}
+		return time.Until(deadline).Round(time.Second)
+	}
+
+	// Handles without a session and sessions without timeout use the default
+	if d := deadlineIn(nil, 10*time.Second); d != 10*time.Second {
+		t.Errorf("Expected 10s default for nil session, got %v", d)
+	}
+
+	m, err := DialModernMGO("mongodb://localhost:27017/timeout_test?timeoutMS=5000")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+
+	// The timeoutMS URI option sets the session timeout
+	if m.Timeout() != 5*time.Second {
+		t.Fatalf("Expected timeoutMS to set a 5s timeout, got %v", m.Timeout())
+	}
+	if d := deadlineIn(m, 30*time.Second); d != 5*time.Second {
+		t.Errorf("Expected 5s session timeout, got %v", d)
+	}
+
+	// The session timeout can be overridden, and copies inherit it
+	m.SetTimeout(2 * time.Second)
+	if d := deadlineIn(m.Copy(), 10*time.Second); d != 2*time.Second {
+		t.Errorf("Expected 2s session timeout on copy, got %v", d)
+	}
+	m.SetTimeout(0)
+	if d := deadlineIn(m, 10*time.Second); d != 10*time.Second {
+		t.Errorf("Expected 10s default after reset, got %v", d)
+	}
+}
+
+// TestOperationTimeouts checks per-class timeouts and their precedence
+func TestOperationTimeouts(t *testing.T) {
+	m, err := DialModernMGO("mongodb://localhost:27017/timeout_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+
+	deadlineIn := func(m *ModernMGO, class opClass, def time.Duration) time.Duration {
+		ctx, cancel := m.operationContext(class, def)
+		defer cancel()
+		deadline, _ := ctx.Deadline()
+		return time.Until(deadline).Round(time.Second)
+	}
+
+	m.SetOperationTimeouts(OpTimeouts{Read: 5 * time.Second, Index: 5 * time.Minute})
+	if d := deadlineIn(m, opRead, 10*time.Second); d != 5*time.Second {
+		t.Errorf("Expected 5s read timeout, got %v", d)
+	}
+	if d := deadlineIn(m, opIndex, 30*time.Second); d != 5*time.Minute {
+		t.Errorf("Expected 5m index timeout, got %v", d)
+	}
+
+	// Classes without a timeout, and commands, keep the built-in default
+	if d := deadlineIn(m, opWrite, 10*time.Second); d != 10*time.Second {
+		t.Errorf("Expected 10s default write timeout, got %v", d)
+	}
+	if d := deadlineIn(m, opCommand, 30*time.Second); d != 30*time.Second {
+		t.Errorf("Expected 30s default command timeout, got %v", d)
+	}
+
+	// Copies inherit the timeouts, which take precedence over the session
+	// timeout; classes without one get the session timeout
+	c := m.Copy()
+	if c.OperationTimeouts() != m.OperationTimeouts() {
+		t.Errorf("Expected copy to keep operation timeouts, got %+v", c.OperationTimeouts())
+	}
+	c.SetTimeout(10 * time.Second)
+	if d := deadlineIn(c, opIndex, 30*time.Second); d != 5*time.Minute {
+		t.Errorf("Expected the index timeout to take precedence, got %v", d)
+	}
+	if d := deadlineIn(c, opWrite, 30*time.Second); d != 10*time.Second {
+		t.Errorf("Expected the session timeout for writes, got %v", d)
+	}
+	if d := deadlineIn(c, opCommand, 30*time.Second); d != 10*time.Second {
+		t.Errorf("Expected the session timeout for commands, got %v", d)
+	}
+}
+
+// TestAppName checks the application name set through DialInfo and the session
+func TestAppName(t *testing.T) {
+	info := &DialInfo{Addrs: []string{"localhost:27017"}, AppName: "billing"}
+	if opts := info.clientOptions(); opts.AppName == nil || *opts.AppName != "billing" {
+		t.Errorf("Expected DialInfo app name billing, got %v", opts.AppName)
+	}
+
+	m, err := DialModernMGO("mongodb://localhost:27017/appname_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+	if err := m.SetAppName("reports"); err != nil {
+		t.Fatalf("Failed to set app name: %v", err)
+	}
+	if m.clientOptions.AppName == nil || *m.clientOptions.AppName != "reports" {
+		t.Errorf("Expected session app name reports, got %v", m.clientOptions.AppName)
+	}
+
+	m.useClient()
+	if err := m.SetAppName("late"); err != ErrSessionConnected {
+		t.Errorf("Expected ErrSessionConnected after first use, got %v", err)
+	}
+}
+
+// TestConcurrentSessionSettings checks settings can be changed while other
+// goroutines copy the session and use it, copies keeping their own settings
+func TestConcurrentSessionSettings(t *testing.T) {
+	m, err := DialModernMGO("mongodb://localhost:27017/concurrent_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+
+	modes := []Mode{Primary, SecondaryPreferred, Monotonic}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.SetMode(modes[j%len(modes)], false)
+				m.SetSafe(&Safe{W: i + 1})
+				m.EnsureSafe(&Safe{J: true})
+				m.SetTimeout(time.Duration(j) * time.Millisecond)
+				m.SelectServers(bson.D{{Name: "dc", Value: i}})
+				m.SetReadRetry(i+1, time.Millisecond)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				copied := m.Copy()
+				copied.getReadPreference()
+				copied.getWriteConcern()
+				copied.DB("").C("items").readColl()
+				m.findAndModifyWriteConcern()
+				m.readRetry()
+				_, cancel := m.operationContext(opRead, time.Second)
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Copies snapshot the settings, which are then independent
+	m.SetMode(Primary, false)
+	m.SetSafe(&Safe{W: 2})
+	copied := m.Copy()
+	copied.SetMode(Eventual, false)
+	copied.SetSafe(nil)
+	if m.Mode() != Primary || m.Safe().W != 2 {
+		t.Errorf("Expected copy changes not to reach the session, got %v %+v", m.Mode(), m.Safe())
+	}
+	m.SetSafe(&Safe{W: 3})
+	if copied.Safe() != nil {
+		t.Errorf("Expected session changes not to reach the copy, got %+v", copied.Safe())
+	}
+}
+
+// TestCopyDriverSession checks the operations of a copy run in parallel in
+// driver sessions of their own, causally consistent with each other, which
+// Refresh restarts and Close ends
+func TestCopyDriverSession(t *testing.T) {
+	m, err := DialModernMGO("mongodb://localhost:27017/copy_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+
+	sessionOf := func(s *ModernMGO) mongodrv.Session {
+		ctx, cancel := s.operationContext(opRead, time.Second)
+		defer cancel()
+		return mongodrv.SessionFromContext(ctx)
+	}
+	if sessionOf(m) != nil {
+		t.Error("Expected the original session to use implicit sessions")
+	}
+
+	copied := m.Copy()
+	if sessionOf(copied) == nil {
+		t.Fatal("Expected the copy to use driver sessions")
+	}
+
+	// Operations run at once, each in its own driver session, the later ones
+	// starting from the times the earlier ones reached
+	firstCtx, cancelFirst := copied.operationContext(opWrite, time.Second)
+	secondCtx, cancelSecond := copied.operationContext(opRead, time.Second)
+	first := mongodrv.SessionFromContext(firstCtx)
+	if first == mongodrv.SessionFromContext(secondCtx) {
+		t.Error("Expected concurrent operations to use their own driver sessions")
+	}
+	opTime := &primitive.Timestamp{T: 100, I: 1}
+	clusterTime, err := officialBson.Marshal(officialBson.D{{Key: "$clusterTime", Value: officialBson.D{{Key: "clusterTime", Value: *opTime}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.AdvanceOperationTime(opTime)
+	first.AdvanceClusterTime(clusterTime)
+	cancelFirst()
+	cancelSecond()
+	next := sessionOf(copied)
+	if next.OperationTime() == nil || !next.OperationTime().Equal(*opTime) {
+		t.Errorf("Expected the next operation to start at %v, got %v", opTime, next.OperationTime())
+	}
+	if !reflect.DeepEqual(next.ClusterTime(), officialBson.Raw(clusterTime)) {
+		t.Errorf("Expected the next operation to start at cluster time %v, got %v", clusterTime, next.ClusterTime())
+	}
+	if sessionOf(m.Copy()).OperationTime() != nil {
+		t.Error("Expected each copy to have its own causal consistency")
+	}
+
+	// Refresh restarts the causal consistency of the next operations
+	copied.Refresh()
+	if refreshed := sessionOf(copied); refreshed == nil || refreshed.OperationTime() != nil {
+		t.Error("Expected Refresh to restart the causal consistency")
+	}
+
+	// Close ends it without affecting the original session
+	copied.Close()
+	closed, ok := sessionOf(copied).(mongodrv.XSession)
+	if !ok {
+		t.Fatal("Expected a driver session after Close")
+	}
+	if err := convertError(closed.ClientSession().UpdateUseTime()); err != ErrSessionClosed {
+		t.Errorf("Expected ErrSessionClosed after Close, got %v", err)
+	}
+	if sessionOf(m) != nil {
+		t.Error("Expected closing a copy to leave the original session alone")
+	}
+}
+
+// blockingCursor is a tracked cursor whose shutdown waits for release
+type blockingCursor struct {
+	release chan struct{}
+}
+
+func (c *blockingCursor) shutdown() {
+	<-c.release
+}
+
+// TestShutdown checks Shutdown closes the iterators of the session and its
+// copies before disconnecting, giving up on them when the context is done
+func TestShutdown(t *testing.T) {
+	m, err := DialModernMGO("mongodb://localhost:27017/shutdown_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	copied := m.Copy()
+
+	iter := &ModernIt{cursors: copied.cursorRegistry()}
+	iter.cursors.track(iter)
+	if n := len(m.cursors.open()); n != 1 {
+		t.Fatalf("Expected copies to share the cursor registry, got %d cursors", n)
+	}
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+	if n := len(m.cursors.open()); n != 0 {
+		t.Errorf("Expected Shutdown to close the iterators, got %d open", n)
+	}
+	if iter.Next(&bson.M{}) || iter.Err() != ErrSessionClosed {
+		t.Errorf("Expected the iterator to fail with ErrSessionClosed, got %v", iter.Err())
+	}
+	if err := m.Ping(); err == nil {
+		t.Error("Expected Shutdown to disconnect the client")
+	}
+
+	// Cursors still closing when the context is done are left behind
+	m, err = DialModernMGO("mongodb://localhost:27017/shutdown_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	m.useClient()
+	blocked := &blockingCursor{release: make(chan struct{})}
+	defer close(blocked.release)
+	m.cursors.track(blocked)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the context error, got %v", err)
+	}
+}
+
+// TestBuildInfoCache checks BuildInfo answers from the cache shared with the
+// copies of the session, which RefreshBuildInfo keeps when it fails
+func TestBuildInfoCache(t *testing.T) {
+	m, err := DialModernMGO("mongodb://localhost:27017/buildinfo_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer m.Close()
+	if err := m.SetPoolTimeout(50 * time.Millisecond); err != nil {
+		t.Fatalf("Failed to set the pool timeout: %v", err)
+	}
+	m.buildInfo.info = &BuildInfo{Version: "7.0.2", VersionArray: []int{7, 0, 2, 0}}
+
+	copied := m.Copy()
+	defer copied.Close()
+	info, err := copied.BuildInfo()
+	if err != nil || info.Version != "7.0.2" || !info.VersionAtLeast(7) {
+		t.Fatalf("Expected the cached build information, got %+v, %v", info, err)
+	}
+	info.VersionArray[0] = 3
+	if info, _ := m.BuildInfo(); info.VersionArray[0] != 7 {
+		t.Errorf("Expected callers not to change the cache, got %v", info.VersionArray)
+	}
+
+	if _, err := m.RefreshBuildInfo(); err == nil {
+		t.Skip("A server is running, the refresh succeeded")
+	}
+	if info, err := copied.BuildInfo(); err != nil || info.Version != "7.0.2" {
+		t.Errorf("Expected a failed refresh to keep the cache, got %+v, %v", info, err)
+	}
+}
+
+// TestIterContext checks iterations are bounded by the timeout of their class
+// or the session timeout only when one is set
+func TestIterContext(t *testing.T) {
+	m := &ModernMGO{}
+	ctx, cancel := m.iterContext(opAggregate)
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("Expected no deadline without timeouts")
+	}
+	cancel()
+	if ctx.Err() == nil {
+		t.Error("Expected cancel to release the context")
+	}
+
+	m.SetOperationTimeouts(OpTimeouts{Aggregate: time.Minute})
+	ctx, cancel = m.iterContext(opAggregate)
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Minute {
+		t.Errorf("Expected the aggregate timeout as deadline, got %v, %v", deadline, ok)
+	}
+	ctx, cancel = m.iterContext(opRead)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("Expected no deadline for reads without a read timeout")
+	}
+
+	m.SetTimeout(time.Second)
+	ctx, cancel = m.iterContext(opRead)
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Second {
+		t.Errorf("Expected the session timeout as deadline, got %v, %v", deadline, ok)
+	}
+	ctx, cancel = m.iterContext(opAggregate)
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) <= time.Second {
+		t.Errorf("Expected the aggregate timeout to take precedence, got %v, %v", deadline, ok)
+	}
+}
@@ -1,11 +1,15 @@
 package mgo_test
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
-	"github.com/globalsign/mgo"
-	"github.com/globalsign/mgo/bson"
+	mgo "github.com/kinfkong/modern-mgo"
+	"github.com/kinfkong/modern-mgo/bson"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 func TestModernSessionDB(t *testing.T) {
@@ -78,6 +82,28 @@ func TestModernSessionPing(t *testing.T) {
 	AssertNoError(t, err, "Failed to ping server")
 }
 
+func TestModernSessionPingMode(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	// Any server will do, the test deployment may have no secondary
+	err := tdb.Session.PingMode(mgo.SecondaryPreferred)
+	AssertNoError(t, err, "Failed to ping a secondary or the primary")
+
+	// Every server answers on its own connection
+	pings, err := tdb.Session.PingAll()
+	AssertNoError(t, err, "Failed to ping all servers")
+	if len(pings) == 0 {
+		t.Fatal("Expected at least one server to be pinged")
+	}
+	for _, ping := range pings {
+		if ping.Address == "" || ping.Err != nil {
+			t.Errorf("Unexpected ping result %+v", ping)
+		}
+	}
+}
+
 func TestModernSessionClone(t *testing.T) {
 	// Setup
 	tdb := NewTestDB(t)
@@ -92,6 +118,56 @@ func TestModernSessionClone(t *testing.T) {
 	AssertNoError(t, err, "Failed to use cloned session")
 }
 
+func TestModernSessionCloneOfCopy(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	copied := tdb.Session.Copy()
+	defer copied.Close()
+	cloned := copied.Clone()
+	defer cloned.Close()
+
+	// Clones share the causal consistency of the copy, so each reads the
+	// writes of the other
+	id := bson.NewObjectId()
+	err := cloned.DB(tdb.DBName).C("test_collection").Insert(bson.M{"_id": id, "value": "from_clone"})
+	AssertNoError(t, err, "Failed to insert through the clone")
+	var result bson.M
+	err = copied.DB(tdb.DBName).C("test_collection").FindId(id).One(&result)
+	AssertNoError(t, err, "Failed to read the write of the clone through the copy")
+
+	// Work spread over clones runs in parallel
+	slow := bson.M{"_id": id, "$where": "function() { sleep(500); return true; }"}
+	done := make(chan error)
+	start := time.Now()
+	for _, s := range []*mgo.Session{copied.Clone(), copied.Clone()} {
+		go func(s *mgo.Session) {
+			defer s.Close()
+			_, err := s.DB(tdb.DBName).C("test_collection").Find(slow).Count()
+			done <- err
+		}(s)
+	}
+	for i := 0; i < 2; i++ {
+		AssertNoError(t, <-done, "Failed to run the slow query")
+	}
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("Expected clones to run their queries in parallel, took %v", elapsed)
+	}
+
+	// Closing the clone leaves the copy working, and the other way round
+	other := copied.Clone()
+	cloned.Close()
+	_, err = cloned.DB(tdb.DBName).C("test_collection").Find(nil).Count()
+	AssertEqual(t, mgo.ErrSessionClosed, err, "Expected the closed clone to fail")
+	_, err = copied.DB(tdb.DBName).C("test_collection").Find(nil).Count()
+	AssertNoError(t, err, "Failed to use the copy after closing its clone")
+	copied.Close()
+	_, err = other.DB(tdb.DBName).C("test_collection").Find(nil).Count()
+	AssertNoError(t, err, "Failed to use the clone after closing its copy")
+	other.Close()
+}
+
 func TestModernSessionCopy(t *testing.T) {
 	// Setup
 	tdb := NewTestDB(t)
@@ -106,6 +182,71 @@ func TestModernSessionCopy(t *testing.T) {
 	AssertNoError(t, err, "Failed to use copied session")
 }
 
+func TestModernSessionCopyConcurrent(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	copied := tdb.Session.Copy()
+	defer copied.Close()
+	coll := copied.DB(tdb.DBName).C("test_collection")
+
+	// Goroutines sharing the copy run their operations in parallel
+	done := make(chan error)
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			for j := 0; j < 20; j++ {
+				if err := coll.Insert(bson.M{"worker": i, "n": j}); err != nil {
+					done <- err
+					return
+				}
+			}
+			// Each goroutine reads its own writes
+			n, err := coll.Find(bson.M{"worker": i}).Count()
+			if err == nil && n != 20 {
+				err = fmt.Errorf("worker %d counted %d documents", i, n)
+			}
+			done <- err
+		}(i)
+	}
+	for i := 0; i < 8; i++ {
+		select {
+		case err := <-done:
+			AssertNoError(t, err, "Failed to use the copy concurrently")
+		case <-time.After(30 * time.Second):
+			t.Fatal("Concurrent operations on the copy did not complete")
+		}
+	}
+
+	// A slow operation does not hold up the others
+	slow := bson.M{"worker": 0, "n": 0, "$where": "function() { sleep(500); return true; }"}
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := coll.Find(slow).Count()
+			done <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		AssertNoError(t, <-done, "Failed to run the slow query")
+	}
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("Expected the slow queries to run in parallel, took %v", elapsed)
+	}
+
+	// Operations nested in an iteration run on the same copy
+	iter := coll.Find(bson.M{"worker": 1}).Iter()
+	var doc bson.M
+	for iter.Next(&doc) {
+		err := coll.Update(bson.M{"_id": doc["_id"]}, bson.M{"$set": bson.M{"seen": true}})
+		AssertNoError(t, err, "Failed to update during the iteration")
+	}
+	AssertNoError(t, iter.Close(), "Failed to iterate")
+	n, err := coll.Find(bson.M{"seen": true}).Count()
+	AssertNoError(t, err, "Failed to count updated documents")
+	AssertEqual(t, 20, n, "Expected every iterated document to be updated")
+}
+
 func TestModernSessionRun(t *testing.T) {
 	// Setup
 	tdb := NewTestDB(t)
@@ -127,6 +268,35 @@ func TestModernSessionRun(t *testing.T) {
 	AssertNoError(t, err, "Failed to run ping command on default database")
 }
 
+func TestModernSessionRunOrderedCommand(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	db := tdb.Session.DB(tdb.DBName)
+	AssertNoError(t, db.C("ordered").Insert(bson.M{"n": 1}, bson.M{"n": 2}), "Failed to insert")
+
+	// The arguments would take the place of the command name in a map
+	var result struct {
+		N int `bson:"n"`
+	}
+	cmd := mgo.Cmd("count", bson.M{"count": "ordered", "query": bson.M{"n": 2}})
+	AssertNoError(t, db.Run(cmd, &result), "Failed to run count")
+	if result.N != 1 {
+		t.Errorf("Expected a count of 1, got %d", result.N)
+	}
+
+	// Commands without arguments run by name, discarding the reply
+	AssertNoError(t, tdb.Session.Run(true, "ping", nil), "Failed to run ping by name")
+
+	// Collections run commands on their database the same way
+	coll := db.C("ordered")
+	AssertNoError(t, coll.Run("ping", nil), "Failed to run ping by name on a collection")
+	result.N = 0
+	AssertNoError(t, coll.Run(cmd, &result), "Failed to run count on a collection")
+	AssertEqual(t, 1, result.N, "Expected a count of 1 through the collection")
+}
+
 func TestModernSessionBuildInfo(t *testing.T) {
 	// Setup
 	tdb := NewTestDB(t)
@@ -147,6 +317,73 @@ func TestModernSessionBuildInfo(t *testing.T) {
 	}
 }
 
+func TestModernSessionRefreshBuildInfo(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	cached, err := tdb.Session.BuildInfo()
+	AssertNoError(t, err, "Failed to get build info")
+
+	copied := tdb.Session.Copy()
+	defer copied.Close()
+	refreshed, err := copied.RefreshBuildInfo()
+	AssertNoError(t, err, "Failed to refresh build info")
+	if refreshed.Version != cached.Version || refreshed.GitVersion != cached.GitVersion {
+		t.Errorf("Expected the same server build, got %+v and %+v", cached, refreshed)
+	}
+}
+
+func TestModernSessionServerStatus(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	status, err := tdb.Session.ServerStatus()
+	AssertNoError(t, err, "Failed to get server status")
+	if status.Version == "" || status.Connections.Current == 0 {
+		t.Errorf("Unexpected server status %+v", status)
+	}
+
+	// The test deployment may be a standalone server
+	if status.Repl == nil {
+		t.Skip("Not a replica set, skipping replSetGetStatus")
+	}
+	rs, err := tdb.Session.ReplSetStatus()
+	AssertNoError(t, err, "Failed to get replica set status")
+	if rs.Set != status.Repl.SetName || len(rs.Members) == 0 {
+		t.Errorf("Unexpected replica set status %+v", rs)
+	}
+}
+
+func TestModernDatabaseProfiling(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	db := tdb.Session.DB(tdb.DBName)
+	err := db.SetProfilingLevel(2, 50*time.Millisecond)
+	AssertNoError(t, err, "Failed to enable profiling")
+	defer db.SetProfilingLevel(0, 100*time.Millisecond)
+
+	level, slow, err := db.ProfilingLevel()
+	AssertNoError(t, err, "Failed to get profiling level")
+	if level != 2 || slow != 50*time.Millisecond {
+		t.Errorf("Expected level 2 with a 50ms threshold, got %d and %v", level, slow)
+	}
+
+	coll := db.C("profiled")
+	AssertNoError(t, coll.Insert(bson.M{"n": 1}), "Failed to insert")
+	var doc bson.M
+	AssertNoError(t, coll.Find(bson.M{"n": 1}).One(&doc), "Failed to find")
+
+	entries, err := db.ProfileEntries(bson.M{"ns": tdb.DBName + ".profiled", "op": "query"}, 10)
+	AssertNoError(t, err, "Failed to read profile entries")
+	if len(entries) == 0 || entries[0].Command["find"] != "profiled" {
+		t.Errorf("Expected the find to be profiled, got %+v", entries)
+	}
+}
+
 func TestModernSessionWithTransaction(t *testing.T) {
 	// Note: Transactions require MongoDB 4.0+ with replica set
 	// This test will be skipped if transactions are not supported
@@ -384,3 +621,288 @@ func TestModernSessionEmptyDatabaseName(t *testing.T) {
 		}
 	}
 }
+
+func TestModernDBFindRef(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	db := tdb.DB()
+	id := bson.NewObjectId()
+	err := db.C("referenced").Insert(bson.M{"_id": id, "name": "target"})
+	AssertNoError(t, err, "Failed to insert referenced document")
+
+	// Reference without a database resolves against the receiving database
+	var result bson.M
+	err = db.FindRef(&mgo.DBRef{Collection: "referenced", Id: id}).One(&result)
+	AssertNoError(t, err, "Failed to resolve DBRef without database")
+	AssertEqual(t, "target", result["name"], "Incorrect document resolved")
+
+	// Reference with a database resolves against that database
+	otherName := tdb.DBName + "_ref"
+	other := tdb.Session.DB(otherName)
+	defer other.DropDatabase()
+
+	err = other.C("referenced").Insert(bson.M{"_id": id, "name": "other"})
+	AssertNoError(t, err, "Failed to insert document in other database")
+
+	err = db.FindRef(&mgo.DBRef{Collection: "referenced", Id: id, Database: otherName}).One(&result)
+	AssertNoError(t, err, "Failed to resolve DBRef with database")
+	AssertEqual(t, "other", result["name"], "DBRef did not honour its database")
+}
+
+func TestModernSessionFindRef(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	id := bson.NewObjectId()
+	err := tdb.C("referenced").Insert(bson.M{"_id": id, "name": "target"})
+	AssertNoError(t, err, "Failed to insert referenced document")
+
+	var result bson.M
+	ref := &mgo.DBRef{Collection: "referenced", Id: id, Database: tdb.DBName}
+	err = tdb.Session.FindRef(ref).One(&result)
+	AssertNoError(t, err, "Failed to resolve DBRef through session")
+	AssertEqual(t, "target", result["name"], "Incorrect document resolved")
+
+	// Storing and reading back a DBRef must round-trip
+	type holder struct {
+		Id  bson.ObjectId `bson:"_id"`
+		Ref mgo.DBRef     `bson:"ref"`
+	}
+	err = tdb.C("holders").Insert(holder{Id: bson.NewObjectId(), Ref: *ref})
+	AssertNoError(t, err, "Failed to insert document holding a DBRef")
+
+	var h holder
+	err = tdb.C("holders").Find(nil).One(&h)
+	AssertNoError(t, err, "Failed to read document holding a DBRef")
+	AssertEqual(t, "referenced", h.Ref.Collection, "DBRef collection mismatch")
+	AssertEqual(t, tdb.DBName, h.Ref.Database, "DBRef database mismatch")
+	AssertEqual(t, id, h.Ref.Id, "DBRef id mismatch")
+
+	// A reference without a database cannot be resolved by the session
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected FindRef to panic for a DBRef without database")
+		}
+	}()
+	tdb.Session.FindRef(&mgo.DBRef{Collection: "referenced", Id: id})
+}
+
+func TestModernDBStats(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	db := tdb.DB()
+	for i := 0; i < 10; i++ {
+		err := db.C("stats_collection").Insert(bson.M{"n": i, "payload": "some data"})
+		AssertNoError(t, err, "Failed to insert test document")
+	}
+
+	stats, err := db.Stats()
+	AssertNoError(t, err, "Failed to get database stats")
+
+	AssertEqual(t, tdb.DBName, stats.DB, "Incorrect database name in stats")
+	if stats.Collections < 1 {
+		t.Fatalf("Expected at least one collection, got %d", stats.Collections)
+	}
+	if stats.Objects != 10 {
+		t.Fatalf("Expected 10 objects, got %d", stats.Objects)
+	}
+	if stats.DataSize <= 0 || stats.AvgObjSize <= 0 {
+		t.Fatalf("Expected positive data sizes, got %+v", stats)
+	}
+}
+
+func TestModernDBWith(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	db := tdb.DB()
+	writer := db.With(nil, writeconcern.Majority(), nil)
+	reader := db.With(readconcern.Available(), nil, readpref.SecondaryPreferred())
+
+	doc := bson.M{"_id": bson.NewObjectId(), "value": "with"}
+	err := writer.C("with_collection").Insert(doc)
+	AssertNoError(t, err, "Failed to insert through majority write concern handle")
+
+	var result bson.M
+	err = reader.C("with_collection").FindId(doc["_id"]).One(&result)
+	AssertNoError(t, err, "Failed to read through available read concern handle")
+	AssertEqual(t, "with", result["value"], "Incorrect value read through derived handle")
+
+	// The original handle keeps working with its own settings
+	err = db.C("with_collection").FindId(doc["_id"]).One(&result)
+	AssertNoError(t, err, "Failed to read through original handle")
+}
+
+func TestModernSessionSetSafe(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	session := tdb.Session.Copy()
+	defer session.Close()
+
+	// Default sessions acknowledge writes
+	safe := session.Safe()
+	if safe == nil || safe.W != 1 {
+		t.Fatalf("Expected default safe mode with W=1, got %+v", safe)
+	}
+
+	// Safe returns a copy that cannot alter the session
+	safe.W = 5
+	AssertEqual(t, 1, session.Safe().W, "Safe() leaked the session state")
+
+	coll := session.DB(tdb.DBName).C("safe_collection")
+	id := bson.NewObjectId()
+	err := coll.Insert(bson.M{"_id": id})
+	AssertNoError(t, err, "Failed to insert document")
+
+	// Acknowledged writes report duplicate keys
+	err = coll.Insert(bson.M{"_id": id})
+	if !mgo.IsDup(err) {
+		t.Fatalf("Expected duplicate key error, got %v", err)
+	}
+
+	// Unacknowledged writes do not, even through handles obtained before
+	session.SetSafe(nil)
+	if session.Safe() != nil {
+		t.Fatal("Expected nil safe mode after SetSafe(nil)")
+	}
+	err = coll.Insert(bson.M{"_id": id})
+	AssertNoError(t, err, "Unacknowledged insert through an earlier handle should not report errors")
+	coll = session.DB(tdb.DBName).C("safe_collection")
+	err = coll.Insert(bson.M{"_id": id})
+	AssertNoError(t, err, "Unacknowledged insert should not report errors")
+
+	// Journaled acknowledgement works against a standalone server
+	session.SetSafe(&mgo.Safe{W: 1, J: true, WTimeout: 1000})
+	coll = session.DB(tdb.DBName).C("safe_collection")
+	err = coll.Insert(bson.M{"_id": bson.NewObjectId()})
+	AssertNoError(t, err, "Failed to insert with journaled write concern")
+	AssertEqual(t, true, session.Safe().J, "Safe mode not updated")
+
+	// The original session is unaffected by the copy
+	AssertEqual(t, 1, tdb.Session.Safe().W, "Copy changed the original safe mode")
+}
+
+func TestModernSessionPoolSettings(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	// Pool settings are accepted until the session is first used
+	err := tdb.Session.SetMinPoolSize(2)
+	AssertNoError(t, err, "Failed to set min pool size")
+	err = tdb.Session.SetPoolTimeout(5 * time.Second)
+	AssertNoError(t, err, "Failed to set pool timeout")
+
+	err = tdb.Session.Ping()
+	AssertNoError(t, err, "Failed to ping with tuned pool")
+
+	err = tdb.Session.SetMinPoolSize(4)
+	AssertEqual(t, mgo.ErrSessionConnected, err, "Expected error after connection")
+}
+
+func TestModernSessionSelectServers(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	session := tdb.Session.Copy()
+	defer session.Close()
+
+	doc := bson.M{"_id": bson.NewObjectId(), "value": "tagged"}
+	err := session.DB(tdb.DBName).C("tags_collection").Insert(doc)
+	AssertNoError(t, err, "Failed to insert test document")
+
+	// A standalone server is always eligible, tags only restrict replica set members
+	session.SetMode(mgo.SecondaryPreferred, true)
+	session.SelectServers(bson.D{{Name: "use", Value: "analytics"}})
+
+	var result bson.M
+	err = session.DB(tdb.DBName).C("tags_collection").FindId(doc["_id"]).One(&result)
+	AssertNoError(t, err, "Failed to read with tag sets")
+	AssertEqual(t, "tagged", result["value"], "Incorrect value read with tag sets")
+}
+
+func TestModernSessionSetReadConcern(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	session := tdb.Session.Copy()
+	defer session.Close()
+
+	doc := bson.M{"_id": bson.NewObjectId(), "value": "concern"}
+	err := session.DB(tdb.DBName).C("concern_collection").Insert(doc)
+	AssertNoError(t, err, "Failed to insert test document")
+
+	for _, level := range []string{"local", "majority"} {
+		session.SetReadConcern(level)
+
+		var result bson.M
+		err = session.DB(tdb.DBName).C("concern_collection").FindId(doc["_id"]).One(&result)
+		AssertNoError(t, err, "Failed to read with "+level+" read concern")
+		AssertEqual(t, "concern", result["value"], "Incorrect value read with "+level+" read concern")
+	}
+}
+
+func TestModernSessionTailOplog(t *testing.T) {
+	// Setup
+	tdb := NewTestDB(t)
+	defer tdb.Close(t)
+
+	// The oplog only exists on replica set members
+	n, err := tdb.Session.DB("local").C("oplog.rs").Find(nil).Limit(1).Count()
+	if err != nil || n == 0 {
+		t.Skip("No oplog available, skipping oplog test")
+	}
+
+	coll := tdb.C("oplog_test")
+	tailer := tdb.Session.TailOplog(0, bson.M{"ns": tdb.DBName + ".oplog_test"})
+	defer tailer.Close()
+	tailer.SetAwaitTime(200 * time.Millisecond)
+
+	// Position the tailer at the end of the oplog before writing
+	var op mgo.Op
+	if tailer.Next(&op) {
+		t.Fatalf("Unexpected operation before writing: %+v", op)
+	}
+	AssertNoError(t, tailer.Err(), "Failed to start tailing")
+
+	id := bson.NewObjectId()
+	AssertNoError(t, coll.Insert(bson.M{"_id": id, "value": 1}), "Failed to insert document")
+	AssertNoError(t, coll.UpdateId(id, bson.M{"$set": bson.M{"value": 2}}), "Failed to update document")
+	AssertNoError(t, coll.RemoveId(id), "Failed to remove document")
+
+	var ops []mgo.Op
+	deadline := time.Now().Add(10 * time.Second)
+	for len(ops) < 3 && time.Now().Before(deadline) {
+		for tailer.Next(&op) {
+			ops = append(ops, op)
+		}
+		AssertNoError(t, tailer.Err(), "Failed to tail oplog")
+	}
+	if len(ops) != 3 {
+		t.Fatalf("Expected 3 operations, got %+v", ops)
+	}
+	AssertEqual(t, mgo.OplogInsert, ops[0].Operation, "Expected an insert first")
+	AssertEqual(t, mgo.OplogUpdate, ops[1].Operation, "Expected an update second")
+	AssertEqual(t, mgo.OplogDelete, ops[2].Operation, "Expected a delete last")
+	AssertEqual(t, "oplog_test", ops[0].Collection(), "Unexpected collection")
+	AssertEqual(t, id, ops[0].Object["_id"], "Unexpected inserted document")
+	AssertEqual(t, ops[2].Timestamp, tailer.Timestamp(), "Expected the resume point after the delete")
+
+	// A new tailer resumes after a given operation
+	resumed := tdb.Session.TailOplog(ops[0].Timestamp, bson.M{"ns": tdb.DBName + ".oplog_test"})
+	defer resumed.Close()
+	if !resumed.Next(&op) {
+		t.Fatalf("Expected an operation after resuming, err %v", resumed.Err())
+	}
+	AssertEqual(t, mgo.OplogUpdate, op.Operation, "Expected to resume with the update")
+}
@@ -0,0 +1,185 @@
+// modern_stats.go - Connection and operation statistics for modern MongoDB driver compatibility wrapper
+
+package mgo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Stats holds the counters collected while statistics are enabled with
+// SetStats (mgo API compatible). They cover all sessions of the process and
+// are fed by the command and connection pool monitors of the driver.
+//
+// MasterConns, SlaveConns, SocketRefs, TimesWaitedForSocket and
+// TotalTimeWaitedForSocket are kept for compatibility with mgo; the driver
+// does not report them and they stay zero.
+type Stats struct {
+	Clusters     int // Clients connected by original sessions
+	MasterConns  int
+	SlaveConns   int
+	SentOps      int // Commands sent to the servers
+	ReceivedOps  int // Replies received, including failed commands
+	ReceivedDocs int // Documents received, counting the batches of cursors
+	SocketsAlive int // Open connections
+	SocketsInUse int // Connections checked out of the pools by operations
+	SocketRefs   int
+
+	TimesSocketAcquired      int // Connections checked out of the pools
+	TimesWaitedForSocket     int
+	TotalTimeWaitedForSocket time.Duration
+	PoolTimeouts             int // Checkouts that timed out waiting for a connection
+}
+
+var (
+	stats      *Stats
+	statsMutex sync.Mutex
+)
+
+// SetStats enables or disables the collection of statistics (mgo API
+// compatible). Disabling them drops the counters collected so far.
+func SetStats(enabled bool) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	if enabled {
+		if stats == nil {
+			stats = &Stats{}
+		}
+	} else {
+		stats = nil
+	}
+}
+
+// GetStats returns a snapshot of the statistics, zero when they are
+// disabled (mgo API compatible). It can back an expvar variable:
+//
+//	mgo.SetStats(true)
+//	expvar.Publish("mgo", expvar.Func(func() interface{} { return mgo.GetStats() }))
+func GetStats() (snapshot Stats) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	if stats != nil {
+		snapshot = *stats
+	}
+	return
+}
+
+// ResetStats zeroes the counters of the statistics, keeping the gauges of
+// clusters and connections (mgo API compatible)
+func ResetStats() {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	if stats == nil {
+		return
+	}
+	old := stats
+	stats = &Stats{
+		Clusters:     old.Clusters,
+		SocketsAlive: old.SocketsAlive,
+		SocketsInUse: old.SocketsInUse,
+	}
+}
+
+// updateStats applies change to the statistics when they are enabled
+func updateStats(change func(s *Stats)) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	if stats != nil {
+		change(stats)
+	}
+}
+
+// statsEnabled reports whether statistics are being collected
+func statsEnabled() bool {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	return stats != nil
+}
+
+// monitorStats installs the monitors feeding the statistics on the client
+// options, keeping the monitors already set
+func monitorStats(opts *options.ClientOptions) {
+	opts.SetMonitor(statsCommandMonitor(opts.Monitor))
+	opts.SetPoolMonitor(statsPoolMonitor(opts.PoolMonitor))
+}
+
+// statsCommandMonitor returns a command monitor counting operations and
+// received documents, forwarding the events to next when set
+func statsCommandMonitor(next *event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			updateStats(func(s *Stats) { s.SentOps++ })
+			if next != nil && next.Started != nil {
+				next.Started(ctx, evt)
+			}
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			if statsEnabled() {
+				docs := replyDocs(evt.Reply)
+				updateStats(func(s *Stats) {
+					s.ReceivedOps++
+					s.ReceivedDocs += docs
+				})
+			}
+			if next != nil && next.Succeeded != nil {
+				next.Succeeded(ctx, evt)
+			}
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			updateStats(func(s *Stats) { s.ReceivedOps++ })
+			if next != nil && next.Failed != nil {
+				next.Failed(ctx, evt)
+			}
+		},
+	}
+}
+
+// replyDocs returns the number of documents carried by a command reply: the
+// batch of a cursor reply, or the reply itself
+func replyDocs(reply officialBson.Raw) int {
+	for _, batch := range []string{"firstBatch", "nextBatch"} {
+		value, err := reply.LookupErr("cursor", batch)
+		if err != nil {
+			continue
+		}
+		if docs, ok := value.ArrayOK(); ok {
+			values, _ := docs.Values()
+			return len(values)
+		}
+	}
+	return 1
+}
+
+// statsPoolMonitor returns a pool monitor tracking connections, forwarding
+// the events to next when set
+func statsPoolMonitor(next *event.PoolMonitor) *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(evt *event.PoolEvent) {
+			updateStats(func(s *Stats) {
+				switch evt.Type {
+				case event.ConnectionCreated:
+					s.SocketsAlive++
+				case event.ConnectionClosed:
+					s.SocketsAlive--
+				case event.GetSucceeded:
+					s.SocketsInUse++
+					s.TimesSocketAcquired++
+				case event.ConnectionReturned:
+					s.SocketsInUse--
+				case event.GetFailed:
+					if evt.Reason == event.ReasonTimedOut {
+						s.PoolTimeouts++
+					}
+				}
+			})
+			if next != nil && next.Event != nil {
+				next.Event(evt)
+			}
+		},
+	}
+}
@@ -0,0 +1,101 @@
+package mgo
+
+import (
+	"context"
+	"testing"
+
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestStats checks the counters fed by the driver monitors
+func TestStats(t *testing.T) {
+	SetStats(true)
+	defer SetStats(false)
+	ResetStats()
+
+	var forwarded []string
+	opts := options.Client()
+	opts.SetMonitor(&event.CommandMonitor{
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			forwarded = append(forwarded, evt.CommandName)
+		},
+	})
+	monitorStats(opts)
+
+	ctx := context.Background()
+	reply := func(doc interface{}) officialBson.Raw {
+		raw, err := officialBson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	opts.Monitor.Started(ctx, &event.CommandStartedEvent{CommandName: "find"})
+	opts.Monitor.Succeeded(ctx, &event.CommandSucceededEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "find"},
+		Reply:                reply(officialBson.M{"ok": 1, "cursor": officialBson.M{"id": int64(1), "firstBatch": officialBson.A{officialBson.M{}, officialBson.M{}, officialBson.M{}}}}),
+	})
+	opts.Monitor.Started(ctx, &event.CommandStartedEvent{CommandName: "getMore"})
+	opts.Monitor.Succeeded(ctx, &event.CommandSucceededEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "getMore"},
+		Reply:                reply(officialBson.M{"ok": 1, "cursor": officialBson.M{"id": int64(0), "nextBatch": officialBson.A{officialBson.M{}}}}),
+	})
+	opts.Monitor.Started(ctx, &event.CommandStartedEvent{CommandName: "insert"})
+	opts.Monitor.Failed(ctx, &event.CommandFailedEvent{})
+	opts.Monitor.Started(ctx, &event.CommandStartedEvent{CommandName: "ping"})
+	opts.Monitor.Succeeded(ctx, &event.CommandSucceededEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "ping"},
+		Reply:                reply(officialBson.M{"ok": 1}),
+	})
+
+	for _, typ := range []string{event.ConnectionCreated, event.ConnectionCreated, event.GetSucceeded, event.GetSucceeded, event.ConnectionReturned, event.ConnectionClosed} {
+		opts.PoolMonitor.Event(&event.PoolEvent{Type: typ})
+	}
+	opts.PoolMonitor.Event(&event.PoolEvent{Type: event.GetFailed, Reason: event.ReasonTimedOut})
+	opts.PoolMonitor.Event(&event.PoolEvent{Type: event.GetFailed, Reason: event.ReasonPoolClosed})
+
+	want := Stats{
+		SentOps:             4,
+		ReceivedOps:         4,
+		ReceivedDocs:        5,
+		SocketsAlive:        1,
+		SocketsInUse:        1,
+		TimesSocketAcquired: 2,
+		PoolTimeouts:        1,
+	}
+	if got := GetStats(); got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if len(forwarded) != 3 || forwarded[0] != "find" || forwarded[2] != "ping" {
+		t.Errorf("Expected events to reach the existing monitor, got %v", forwarded)
+	}
+
+	// Resetting keeps the gauges
+	ResetStats()
+	if got := GetStats(); got != (Stats{SocketsAlive: 1, SocketsInUse: 1}) {
+		t.Errorf("Expected only gauges after reset, got %+v", got)
+	}
+
+	// Clusters count the clients started by original sessions
+	m, err := DialModernMGO("mongodb://localhost:27017/stats_test")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	m.Copy().Close()
+	if got := GetStats().Clusters; got != 1 {
+		t.Errorf("Expected 1 cluster, got %d", got)
+	}
+	m.Close()
+	if got := GetStats().Clusters; got != 0 {
+		t.Errorf("Expected no cluster after Close, got %d", got)
+	}
+
+	// Disabled statistics are zero and not collected
+	SetStats(false)
+	opts.Monitor.Started(ctx, &event.CommandStartedEvent{})
+	if got := GetStats(); got != (Stats{}) {
+		t.Errorf("Expected zero statistics when disabled, got %+v", got)
+	}
+}
@@ -4,31 +4,250 @@ package mgo
 
 import (
 	"context"
+	"hash"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/kinfkong/modern-mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	mongodrv "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 // ModernMGO provides the mgo API using the official MongoDB driver
 type ModernMGO struct {
-	client     *mongodrv.Client
-	dbName     string
-	mode       Mode
-	safe       *Safe
-	isOriginal bool // Track if this is the original session or a copy
+	client        *mongodrv.Client
+	clientOptions *options.ClientOptions
+	connMu        sync.Mutex
+	pending       *mongodrv.Client // Client reconfigured before first use, switched to by useClient
+	used          bool             // Whether the client has been used, after which it cannot be reconfigured
+	dbName        string
+	// Settings changed through the session methods and the driver session,
+	// guarded by mu. Copies take a snapshot of the settings and are then
+	// configured independently.
+	mu           sync.RWMutex
+	mode         Mode
+	safe         *Safe
+	timeout      time.Duration  // Client-side operation timeout bounding each operation end to end
+	opTimeouts   OpTimeouts     // Default timeouts per operation class
+	readConcern  string         // Read concern level applied to derived handles
+	readAttempts int            // Attempts made by reads failing with transient errors
+	readBackoff  time.Duration  // Delay before the first read retry, doubled for each retry
+	tags         []bson.D       // Tag sets restricting server selection for reads
+	maxStaleness time.Duration  // Maximum replication lag of secondaries eligible for reads
+	estimate     bool           // Whether unfiltered counts use the collection metadata
+	hexIds       bool           // Whether the *Id collection methods take hex strings for ObjectIds
+	slowOps      *slowOpLogger  // Reporting of slow operations, nil when disabled
+	sess         *driverSession // Driver session of a copy or clone, nil for the original session
+	closed       bool           // Whether a copy or clone was closed
+
+	wrote      atomic.Bool     // Whether a write happened, switching Monotonic reads to the primary
+	indexes    *indexCache     // Indexes ensured through the session and its copies
+	buildInfo  *buildInfoCache // Server build information fetched through the session and its copies
+	topology   *topologyHub    // Subscribers to the topology events of the client
+	cursors    *cursorRegistry // Cursors open through the session and its copies
+	isOriginal bool            // Track if this is the original session or a copy
+}
+
+// driverSession holds the causal consistency of a copy of a session, shared
+// with its clones. Driver sessions are not safe for concurrent use, so each
+// operation runs in a driver session of its own, started from the cluster and
+// operation times reached by the operations before it, which it advances once
+// done. mu only guards these times, so operations run in parallel.
+type driverSession struct {
+	mu            sync.Mutex
+	client        *mongodrv.Client
+	clusterTime   officialBson.Raw     // Latest cluster time seen by the operations
+	operationTime *primitive.Timestamp // Operation time of the latest operation
+	ended         bool                 // Whether the session was ended, failing further operations
+}
+
+// cursorRegistry tracks the iterators and oplog tailers open through a
+// session and its copies, for Shutdown to close them
+type cursorRegistry struct {
+	mu      sync.Mutex
+	cursors map[trackedCursor]struct{}
+}
+
+// trackedCursor is an iterator or oplog tailer closed by Shutdown
+type trackedCursor interface {
+	shutdown()
+}
+
+// indexCache remembers the indexes ensured through a session, so repeated
+// EnsureIndex calls for the same index skip the createIndexes round trip
+type indexCache struct {
+	mu   sync.Mutex
+	seen map[string]bool // Keyed by collection full name and index spec
+}
+
+// buildInfoCache holds the server build information, fetched once for a
+// session and its copies since code branching on the server version asks
+// for it repeatedly
+type buildInfoCache struct {
+	mu   sync.Mutex
+	info *BuildInfo // Nil until fetched
 }
 
 // ModernDB wraps the modern database
 type ModernDB struct {
-	mgoDB *mongodrv.Database
-	name  string
+	mgoDB     *mongodrv.Database
+	name      string
+	session   *ModernMGO
+	overrides *handleOverrides // Settings overridden by With, nil for none
+}
+
+// handleOverrides holds the settings overridden by ModernDB.With for a
+// database and its collections. Nil fields follow the session settings.
+type handleOverrides struct {
+	readConcern  *readconcern.ReadConcern
+	writeConcern *writeconcern.WriteConcern
+	readPref     *readpref.ReadPref
+}
+
+// DBStats holds the storage statistics reported by the dbStats command
+type DBStats struct {
+	DB          string  `bson:"db"`
+	Collections int     `bson:"collections"`
+	Views       int     `bson:"views"`
+	Objects     int64   `bson:"objects"`
+	AvgObjSize  float64 `bson:"avgObjSize"`
+	DataSize    int64   `bson:"dataSize"`
+	StorageSize int64   `bson:"storageSize"`
+	Indexes     int     `bson:"indexes"`
+	IndexSize   int64   `bson:"indexSize"`
+	TotalSize   int64   `bson:"totalSize"`   // MongoDB 4.4+
+	FsUsedSize  int64   `bson:"fsUsedSize"`  // MongoDB 3.6+
+	FsTotalSize int64   `bson:"fsTotalSize"` // MongoDB 3.6+
+}
+
+// ProfileEntry holds an operation recorded in the system.profile collection
+// of a database by the profiler
+type ProfileEntry struct {
+	Op             string    `bson:"op"` // Operation type, such as "query", "update" or "command"
+	Ns             string    `bson:"ns"`
+	Command        bson.M    `bson:"command"`
+	Ts             time.Time `bson:"ts"`
+	Millis         int64     `bson:"millis"`
+	DocsExamined   int64     `bson:"docsExamined"`
+	KeysExamined   int64     `bson:"keysExamined"`
+	NReturned      int64     `bson:"nreturned"`
+	ResponseLength int64     `bson:"responseLength"`
+	PlanSummary    string    `bson:"planSummary"`
+	Client         string    `bson:"client"`
+	User           string    `bson:"user"`
+}
+
+// ServerStatus holds the main fields reported by the serverStatus command
+type ServerStatus struct {
+	Host        string            `bson:"host"`
+	Version     string            `bson:"version"`
+	Process     string            `bson:"process"`
+	Uptime      float64           `bson:"uptime"` // Seconds since the server started
+	LocalTime   time.Time         `bson:"localTime"`
+	Connections ServerConnections `bson:"connections"`
+	Opcounters  ServerOpcounters  `bson:"opcounters"`
+	Repl        *ServerRepl       `bson:"repl"` // Nil for a standalone server
+}
+
+// ServerConnections holds the connection counts of ServerStatus
+type ServerConnections struct {
+	Current      int64 `bson:"current"`
+	Available    int64 `bson:"available"`
+	TotalCreated int64 `bson:"totalCreated"`
+}
+
+// ServerOpcounters holds the operation counts of ServerStatus since the
+// server started
+type ServerOpcounters struct {
+	Insert  int64 `bson:"insert"`
+	Query   int64 `bson:"query"`
+	Update  int64 `bson:"update"`
+	Delete  int64 `bson:"delete"`
+	GetMore int64 `bson:"getmore"`
+	Command int64 `bson:"command"`
+}
+
+// ServerRepl holds the replica set membership of ServerStatus
+type ServerRepl struct {
+	SetName string   `bson:"setName"`
+	Primary string   `bson:"primary"` // Address of the primary, empty when there is none
+	Me      string   `bson:"me"`
+	Hosts   []string `bson:"hosts"`
+}
+
+// ReplSetStatus holds the main fields reported by the replSetGetStatus command
+type ReplSetStatus struct {
+	Set     string          `bson:"set"`
+	Date    time.Time       `bson:"date"`
+	MyState int             `bson:"myState"`
+	Members []ReplSetMember `bson:"members"`
+}
+
+// ReplSetMember holds the state of a replica set member in ReplSetStatus
+type ReplSetMember struct {
+	Id             int           `bson:"_id"`
+	Name           string        `bson:"name"`
+	Health         int           `bson:"health"` // 1 when the member is up, 0 when it is down
+	State          int           `bson:"state"`
+	StateStr       string        `bson:"stateStr"` // State name, such as "PRIMARY" or "SECONDARY"
+	Uptime         int64         `bson:"uptime"`   // Seconds the member has been up
+	OptimeDate     time.Time     `bson:"optimeDate"`
+	LastHeartbeat  time.Time     `bson:"lastHeartbeat"`
+	PingMs         int64         `bson:"pingMs"`
+	SyncSourceHost string        `bson:"syncSourceHost"`
+	Self           bool          `bson:"self"`
+	Lag            time.Duration `bson:"-"` // Replication lag of a secondary behind the primary, zero for others
+}
+
+// OpTimeouts holds the default timeouts of each class of operation, which
+// take precedence over the session timeout. A zero field leaves operations of
+// that class to the session timeout, or to the built-in default.
+type OpTimeouts struct {
+	Read      time.Duration // Queries, counts and GridFS reads
+	Write     time.Duration // Inserts, updates, removes, bulk runs and GridFS writes
+	Index     time.Duration // Index builds
+	Aggregate time.Duration // Aggregation pipelines
+}
+
+// opClass identifies the class of an operation when choosing its timeout
+type opClass int
+
+const (
+	opCommand opClass = iota // Database commands, governed by the session timeout only
+	opRead
+	opWrite
+	opIndex
+	opAggregate
+)
+
+// forClass returns the timeout configured for class, or zero if none is
+func (t OpTimeouts) forClass(class opClass) time.Duration {
+	switch class {
+	case opRead:
+		return t.Read
+	case opWrite:
+		return t.Write
+	case opIndex:
+		return t.Index
+	case opAggregate:
+		return t.Aggregate
+	}
+	return 0
 }
 
 // ModernColl wraps the modern collection
 type ModernColl struct {
-	mgoColl *mongodrv.Collection
-	name    string
+	mgoColl   *mongodrv.Collection
+	name      string
+	session   *ModernMGO
+	overrides *handleOverrides // Settings overridden by ModernDB.With, nil for none
+	bypass    bool             // Whether writes skip document validation
 }
 
 // ModernQ wraps query state
@@ -39,13 +258,36 @@ type ModernQ struct {
 	skip       int64
 	limit      int64
 	projection interface{}
+
+	noCursorTimeout bool          // Whether the server keeps the idle cursor open
+	partial         bool          // Whether unavailable shards are skipped
+	hint            interface{}   // Key of the index the query must use
+	min             interface{}   // Inclusive lower index bound
+	max             interface{}   // Exclusive upper index bound
+	maxTime         time.Duration // Server-side time limit, zero for none
+}
+
+// FindAndModifyOptions holds the options of Collection.FindAndModify
+type FindAndModifyOptions struct {
+	Sort         []string      // Fields ordering the matches, "-field" for descending; the first is modified
+	Fields       interface{}   // Projection applied to the returned document
+	Upsert       bool          // Insert a document if none matches the selector
+	ReturnNew    bool          // Return the modified rather than the original document
+	ArrayFilters []interface{} // Filters selecting the array elements modified through $[identifier]
+	MaxTime      time.Duration // Server-side execution time limit, zero for none
+	Collation    *Collation    // Collation used to match and sort documents
 }
 
 // ModernIt wraps cursor iteration
 type ModernIt struct {
-	cursor *mongodrv.Cursor
-	ctx    context.Context
-	err    error
+	cursor  *mongodrv.Cursor
+	ctx     context.Context
+	cancel  context.CancelFunc // Releases ctx once the iterator is closed or exhausted, if set
+	err     error
+	sess    *driverSession   // Session state advanced by the cursor once done
+	opSess  mongodrv.Session // Driver session the cursor was opened with, ended by Close
+	mu      sync.Mutex       // Serializes the use of the iterator with Session.Shutdown
+	cursors *cursorRegistry  // Registry tracking the cursor until it is closed or exhausted
 }
 
 // ModernPipe wraps aggregation pipeline state
@@ -56,6 +298,10 @@ type ModernPipe struct {
 	batchSize  int32
 	maxTimeMS  int64
 	collation  *options.Collation
+	comment    string                     // Comment attached to the aggregate command
+	let        bson.M                     // Variables available to the stages as $$name
+	wc         *writeconcern.WriteConcern // Write concern of Exec, nil for the session one
+	readPref   *readpref.ReadPref         // Read preference overriding the session mode, nil for none
 }
 
 // ModernBulk provides bulk operations using the official MongoDB driver
@@ -64,13 +310,24 @@ type ModernBulk struct {
 	operations []mongodrv.WriteModel
 	ordered    bool
 	opcount    int
+	collation  *options.Collation
+	wc         *writeconcern.WriteConcern // Overrides the session write concern when set
 }
 
 // ModernGridFS provides GridFS operations using the official MongoDB driver
 type ModernGridFS struct {
-	Files  *ModernColl
-	Chunks *ModernColl
-	prefix string
+	Files     *ModernColl
+	Chunks    *ModernColl
+	prefix    string
+	chunkSize int                        // Chunk size of new files, 0 for the default
+	sha256    bool                       // Whether new files store a SHA-256 checksum
+	wc        *writeconcern.WriteConcern // Write concern of new files, nil for the session one
+}
+
+// ModernGridIter iterates over GridFS files, opening each for reading
+type ModernGridIter struct {
+	gfs  *ModernGridFS
+	iter *ModernIt
 }
 
 // ModernGridFile wraps GridFS file operations
@@ -81,13 +338,24 @@ type ModernGridFile struct {
 	chunkSize   int
 	length      int64
 	md5         string
+	sha256      string
 	uploadDate  time.Time
 	metadata    interface{}
 	gfs         *ModernGridFS
-	chunks      [][]byte
+	mu          sync.Mutex // Serializes Write, Read and Close across goroutines
 	closed      bool
+	// Write state of files created with Create
+	writing  bool                       // Whether the file was created for writing
+	wbuf     []byte                     // Data of the chunk being filled
+	wpending [][]byte                   // Full chunks waiting to be stored
+	wn       int                        // Number of chunks sent for storage
+	wsum     hash.Hash                  // Running MD5 of the data written
+	wsha256  hash.Hash                  // Running SHA-256 of the data written, nil when disabled
+	werr     error                      // First error met while storing chunks
+	aborted  atomic.Bool                // Set by Abort without waiting for a Write in progress
+	wc       *writeconcern.WriteConcern // Write concern overriding the session one
 	// Read position tracking
-	readPos    int64 // Current position in the file
-	chunkIndex int   // Current chunk being read
-	chunkPos   int   // Position within current chunk
+	readPos    int64  // Current position in the file
+	chunk      []byte // Data of the chunk last read, nil until a chunk is loaded
+	chunkIndex int    // Number (n) of the chunk last read
 }
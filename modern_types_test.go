@@ -0,0 +1,108 @@
+package mgo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kinfkong/modern-mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+)
+
+// TestStatusDecoding checks the replies of serverStatus and replSetGetStatus
+// decode into their typed helpers, with the lag of the secondaries
+func TestStatusDecoding(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	reply, _ := officialBson.Marshal(officialBson.D{
+		{Key: "host", Value: "db1:27017"},
+		{Key: "version", Value: "7.0.2"},
+		{Key: "uptime", Value: 3600.0},
+		{Key: "connections", Value: officialBson.D{
+			{Key: "current", Value: int32(12)},
+			{Key: "available", Value: int32(838848)},
+			{Key: "totalCreated", Value: int64(40)},
+		}},
+		{Key: "opcounters", Value: officialBson.D{
+			{Key: "insert", Value: int64(5)},
+			{Key: "query", Value: int32(7)},
+			{Key: "getmore", Value: int32(2)},
+		}},
+		{Key: "repl", Value: officialBson.D{
+			{Key: "setName", Value: "rs0"},
+			{Key: "primary", Value: "db1:27017"},
+		}},
+		{Key: "ok", Value: 1.0},
+	})
+	var status ServerStatus
+	if err := officialBson.Unmarshal(reply, &status); err != nil {
+		t.Fatalf("Failed to decode serverStatus: %v", err)
+	}
+	if status.Connections.Current != 12 || status.Connections.TotalCreated != 40 ||
+		status.Opcounters.Query != 7 || status.Opcounters.GetMore != 2 {
+		t.Errorf("Unexpected counts %+v, %+v", status.Connections, status.Opcounters)
+	}
+	if status.Repl == nil || status.Repl.SetName != "rs0" {
+		t.Errorf("Expected the replica set membership, got %+v", status.Repl)
+	}
+
+	member := func(name, state string, optime time.Time) officialBson.D {
+		return officialBson.D{
+			{Key: "name", Value: name},
+			{Key: "health", Value: 1.0},
+			{Key: "stateStr", Value: state},
+			{Key: "optimeDate", Value: optime},
+		}
+	}
+	reply, _ = officialBson.Marshal(officialBson.D{
+		{Key: "set", Value: "rs0"},
+		{Key: "myState", Value: int32(1)},
+		{Key: "members", Value: officialBson.A{
+			member("db1:27017", "PRIMARY", now),
+			member("db2:27017", "SECONDARY", now.Add(-3*time.Second)),
+			member("db3:27017", "ARBITER", time.Time{}),
+		}},
+	})
+	var rs ReplSetStatus
+	if err := officialBson.Unmarshal(reply, &rs); err != nil {
+		t.Fatalf("Failed to decode replSetGetStatus: %v", err)
+	}
+	rs.setLag()
+	if len(rs.Members) != 3 || rs.Members[0].Health != 1 {
+		t.Fatalf("Unexpected members %+v", rs.Members)
+	}
+	for i, lag := range []time.Duration{0, 3 * time.Second, 0} {
+		if rs.Members[i].Lag != lag {
+			t.Errorf("Member %s: expected a lag of %v, got %v", rs.Members[i].Name, lag, rs.Members[i].Lag)
+		}
+	}
+}
+
+// TestProfileEntryDecoding checks a system.profile document decodes into a
+// ProfileEntry, its command as mgo types
+func TestProfileEntryDecoding(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	raw, _ := officialBson.Marshal(officialBson.D{
+		{Key: "op", Value: "query"},
+		{Key: "ns", Value: "app.users"},
+		{Key: "command", Value: officialBson.D{
+			{Key: "find", Value: "users"},
+			{Key: "filter", Value: officialBson.D{{Key: "age", Value: officialBson.D{{Key: "$gt", Value: int32(30)}}}}},
+		}},
+		{Key: "keysExamined", Value: int32(0)},
+		{Key: "docsExamined", Value: int32(1200)},
+		{Key: "nreturned", Value: int32(40)},
+		{Key: "millis", Value: int32(153)},
+		{Key: "planSummary", Value: "COLLSCAN"},
+		{Key: "ts", Value: ts},
+	})
+	var entry ProfileEntry
+	if err := decodeDocument(raw, &entry); err != nil {
+		t.Fatalf("Failed to decode profile entry: %v", err)
+	}
+	if entry.Op != "query" || entry.Millis != 153 || entry.DocsExamined != 1200 ||
+		entry.PlanSummary != "COLLSCAN" || !entry.Ts.Equal(ts) {
+		t.Errorf("Unexpected profile entry %+v", entry)
+	}
+	if filter, ok := entry.Command["filter"].(bson.M); !ok || filter["age"] == nil {
+		t.Errorf("Expected the command as mgo documents, got %#v", entry.Command)
+	}
+}
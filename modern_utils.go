@@ -3,14 +3,22 @@
 package mgo
 
 import (
+	"errors"
+	"fmt"
 	stdlog "log"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/globalsign/mgo/bson"
+	"github.com/kinfkong/modern-mgo/bson"
 	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/session"
 )
 
 // Debug flag to enable conversion debugging
@@ -33,6 +41,9 @@ func convertMGOToOfficial(input interface{}) interface{} {
 
 	switch v := input.(type) {
 	case bson.M:
+		if isDBRefMap(v) {
+			return convertDBRefMap(v)
+		}
 		result := officialBson.M{}
 		for key, value := range v {
 			result[key] = convertMGOToOfficial(value)
@@ -48,6 +59,53 @@ func convertMGOToOfficial(input interface{}) interface{} {
 			})
 		}
 		return result
+	case []bson.DocElem:
+		return convertMGOToOfficial(bson.D(v))
+	case bson.Raw:
+		// Raw values are passed as is, documents being usable as filters,
+		// updates and inserted documents
+		if v.Kind == bson.ElementDocument {
+			return officialBson.Raw(v.Data)
+		}
+		return officialBson.RawValue{Type: bsontype.Type(v.Kind), Value: v.Data}
+	case bson.RawD:
+		// Raw documents read from the server are written back unchanged
+		data, err := officialBson.MarshalWithRegistry(mgoRegistry, v)
+		if err != nil {
+			return v
+		}
+		return officialBson.Raw(data)
+	case officialBson.M:
+		// Official documents may nest mgo values, as in a filter holding an
+		// ObjectId, so their values are converted like those of bson.M
+		result := make(officialBson.M, len(v))
+		for key, value := range v {
+			result[key] = convertMGOToOfficial(value)
+		}
+		return result
+	case officialBson.A:
+		result := make(officialBson.A, len(v))
+		for i, item := range v {
+			result[i] = convertMGOToOfficial(item)
+		}
+		return result
+	case []officialBson.M:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = convertMGOToOfficial(item)
+		}
+		return result
+	case officialBson.Raw, officialBson.RawValue:
+		// Encoded values are passed through
+		return v
+	case officialBson.D:
+		// Keep official ordered documents ordered while converting their
+		// values, as pipelines often mix them with mgo stage bodies
+		result := make(officialBson.D, len(v))
+		for i, elem := range v {
+			result[i] = officialBson.E{Key: elem.Key, Value: convertMGOToOfficial(elem.Value)}
+		}
+		return result
 	case []bson.M:
 		// Handle []bson.M specifically for $or, $and, etc. query operators
 		result := make([]interface{}, len(v))
@@ -61,6 +119,10 @@ func convertMGOToOfficial(input interface{}) interface{} {
 			result[i] = convertMGOToOfficial(item)
 		}
 		return result
+	case []byte:
+		// Binary data, nil slices being stored as empty binary data as mgo
+		// does
+		return primitive.Binary{Data: v}
 	case []bson.ObjectId:
 		result := make([]interface{}, len(v))
 		for i, item := range v {
@@ -93,6 +155,16 @@ func convertMGOToOfficial(input interface{}) interface{} {
 			result[key] = convertMGOToOfficial(value)
 		}
 		return result
+	case DBRef:
+		// DBRef fields must keep the $ref, $id, $db order expected by the server
+		ref := officialBson.D{
+			{Key: "$ref", Value: v.Collection},
+			{Key: "$id", Value: convertMGOToOfficial(v.Id)},
+		}
+		if v.Database != "" {
+			ref = append(ref, officialBson.E{Key: "$db", Value: v.Database})
+		}
+		return ref
 	case bson.ObjectId:
 		if len(v) == 12 {
 			objID := primitive.ObjectID{}
@@ -103,7 +175,39 @@ func convertMGOToOfficial(input interface{}) interface{} {
 	case time.Time:
 		// Convert time.Time to primitive.DateTime
 		return primitive.NewDateTimeFromTime(v)
+	case bson.MongoTimestamp:
+		return officialTimestamp(v)
+	case bson.UUID:
+		return primitive.Binary{Subtype: bson.BinaryUUID, Data: v[:]}
+	case bson.Symbol:
+		return primitive.Symbol(v)
+	case bson.JavaScript:
+		if v.Scope == nil {
+			return primitive.JavaScript(v.Code)
+		}
+		return primitive.CodeWithScope{Code: primitive.JavaScript(v.Code), Scope: convertMGOToOfficial(v.Scope)}
+	case bson.DBPointer:
+		var oid primitive.ObjectID
+		copy(oid[:], v.Id)
+		return primitive.DBPointer{DB: v.Namespace, Pointer: oid}
 	default:
+		// Maps with string keys of any value type are stored as documents
+		// holding converted values, nil maps being stored as empty documents
+		// as mgo does
+		if val.Kind() == reflect.Map && val.Type().Key().Kind() == reflect.String && !val.Type().Implements(tGetter) {
+			result := officialBson.M{}
+			iter := val.MapRange()
+			for iter.Next() {
+				result[iter.Key().String()] = convertMGOToOfficial(iter.Value().Interface())
+			}
+			return result
+		}
+
+		// Named byte slice types hold binary data too
+		if val.Kind() == reflect.Slice && val.Type().Elem().Kind() == reflect.Uint8 && !val.Type().Implements(tGetter) {
+			return primitive.Binary{Data: val.Bytes()}
+		}
+
 		// Check if it's a slice using reflection to handle any slice type
 		if val.Kind() == reflect.Slice {
 			// Handle any type of slice generically
@@ -114,7 +218,8 @@ func convertMGOToOfficial(input interface{}) interface{} {
 			return result
 		}
 
-		// Handle structs by marshaling/unmarshaling with bson tags
+		// Handle structs by encoding them with mgoRegistry, or with the bson
+		// package when the registry does not apply
 		if val.Kind() == reflect.Struct || (val.Kind() == reflect.Ptr && val.Elem().Kind() == reflect.Struct) {
 			// Skip converting structs that are already official BSON types
 			typeName := val.Type().String()
@@ -122,6 +227,10 @@ func convertMGOToOfficial(input interface{}) interface{} {
 				return input
 			}
 
+			if encoded, ok := encodeStruct(input); ok {
+				return encoded
+			}
+
 			// Marshal to bson, then unmarshal to map to respect bson tags
 			data, err := bson.Marshal(input)
 			if err != nil {
@@ -138,6 +247,32 @@ func convertMGOToOfficial(input interface{}) interface{} {
 	}
 }
 
+// isDBRefMap reports whether the map holds a DBRef, which happens when a struct
+// embedding a DBRef is flattened through bson.Marshal/Unmarshal
+func isDBRefMap(m bson.M) bool {
+	_, hasRef := m["$ref"]
+	_, hasId := m["$id"]
+	return hasRef && hasId
+}
+
+// convertDBRefMap converts a DBRef held in a map into an ordered document, as
+// the server expects $ref, $id and $db to lead in that order
+func convertDBRefMap(m bson.M) officialBson.D {
+	result := officialBson.D{
+		{Key: "$ref", Value: convertMGOToOfficial(m["$ref"])},
+		{Key: "$id", Value: convertMGOToOfficial(m["$id"])},
+	}
+	if db, ok := m["$db"]; ok {
+		result = append(result, officialBson.E{Key: "$db", Value: convertMGOToOfficial(db)})
+	}
+	for key, value := range m {
+		if key != "$ref" && key != "$id" && key != "$db" {
+			result = append(result, officialBson.E{Key: key, Value: convertMGOToOfficial(value)})
+		}
+	}
+	return result
+}
+
 func convertOfficialToMGO(input interface{}) interface{} {
 	if input == nil {
 		return nil
@@ -165,6 +300,19 @@ func convertOfficialToMGO(input interface{}) interface{} {
 			result[i] = convertOfficialToMGO(item)
 		}
 		return result
+	case officialBson.A:
+		// Arrays decoded by the driver
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = convertOfficialToMGO(item)
+		}
+		return result
+	case []officialBson.M:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = convertOfficialToMGO(item)
+		}
+		return result
 	case map[string]interface{}:
 		result := bson.M{}
 		for key, value := range v {
@@ -182,22 +330,163 @@ func convertOfficialToMGO(input interface{}) interface{} {
 	case primitive.DateTime:
 		// Convert primitive.DateTime to time.Time
 		return v.Time()
+	case primitive.Timestamp:
+		return mongoTimestamp(v)
+	case primitive.Symbol:
+		return bson.Symbol(v)
+	case primitive.JavaScript:
+		return bson.JavaScript{Code: string(v)}
+	case primitive.CodeWithScope:
+		// Scopes are loaded as bson.M, as the bson package does
+		scope := convertOfficialToMGO(v.Scope)
+		if d, ok := scope.(bson.D); ok {
+			scope = d.Map()
+		}
+		return bson.JavaScript{Code: string(v.Code), Scope: scope}
+	case primitive.DBPointer:
+		return bson.DBPointer{Namespace: v.DB, Id: bson.ObjectId(v.Pointer[:])}
+	case primitive.Binary:
+		// Generic binary data is loaded as []byte, as the bson package does
+		if v.Subtype == bsontype.BinaryGeneric || v.Subtype == bsontype.BinaryBinaryOld {
+			return v.Data
+		}
+		return bson.Binary{Kind: v.Subtype, Data: v.Data}
+	case primitive.Decimal128:
+		if d, err := bson.ParseDecimal128(v.String()); err == nil {
+			return d
+		}
+		return v
+	case primitive.Regex:
+		return bson.RegEx{Pattern: v.Pattern, Options: v.Options}
+	case primitive.Undefined:
+		return bson.Undefined
+	case primitive.MinKey:
+		return bson.MinKey
+	case primitive.MaxKey:
+		return bson.MaxKey
+	case primitive.Null:
+		return nil
 	default:
 		return v
 	}
 }
 
+// convertArrayFilters converts the filters selecting the array elements an
+// update modifies to the official driver's representation
+func convertArrayFilters(filters []interface{}) []interface{} {
+	converted := make([]interface{}, len(filters))
+	for i, filter := range filters {
+		converted[i] = convertMGOToOfficial(filter)
+	}
+	return converted
+}
+
+// interfaceSlice returns the elements of a slice of any type, such as the
+// []bson.M commonly used for array filters
+func interfaceSlice(slice interface{}) ([]interface{}, error) {
+	if items, ok := slice.([]interface{}); ok {
+		return items, nil
+	}
+	v := reflect.ValueOf(slice)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("expected a slice, got %T", slice)
+	}
+	items := make([]interface{}, v.Len())
+	for i := range items {
+		items[i] = v.Index(i).Interface()
+	}
+	return items, nil
+}
+
+// convertError translates an error of the official driver into the error
+// mgo reports for the same condition: write errors become *LastError, command
+// errors *QueryError and a missing document ErrNotFound. The error reported
+// for writes sent with an unacknowledged write concern, which mgo treats as
+// success, is dropped. Other errors are returned unchanged.
+func convertError(err error) error {
+	if err == nil || errors.Is(err, mongodrv.ErrUnacknowledgedWrite) {
+		return nil
+	}
+	if err == mongodrv.ErrNoDocuments {
+		return ErrNotFound
+	}
+	if errors.Is(err, mongodrv.ErrNoDocuments) {
+		// Keep the context attached to the driver error while matching both
+		// ErrNotFound and mongo.ErrNoDocuments with errors.Is
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	if errors.Is(err, session.ErrSessionEnded) {
+		return ErrSessionClosed
+	}
+
+	var we mongodrv.WriteException
+	if errors.As(err, &we) {
+		if len(we.WriteErrors) > 0 {
+			return &LastError{Err: we.WriteErrors[0].Message, Code: we.WriteErrors[0].Code, err: err}
+		}
+		if we.WriteConcernError != nil {
+			return writeConcernLastError(we.WriteConcernError, err)
+		}
+	}
+	var bwe mongodrv.BulkWriteException
+	if errors.As(err, &bwe) {
+		if len(bwe.WriteErrors) > 0 {
+			return &LastError{Err: bwe.WriteErrors[0].Message, Code: bwe.WriteErrors[0].Code, err: err}
+		}
+		if bwe.WriteConcernError != nil {
+			return writeConcernLastError(bwe.WriteConcernError, err)
+		}
+	}
+	var ce mongodrv.CommandError
+	if errors.As(err, &ce) {
+		return &QueryError{Code: int(ce.Code), Message: ce.Message, err: err}
+	}
+	return err
+}
+
+// convertUpdateError translates the error of an update like convertError,
+// reporting the outcome of the update on a resulting *LastError as mgo did
+func convertUpdateError(result *mongodrv.UpdateResult, err error) error {
+	err = convertError(err)
+	if lastErr, ok := err.(*LastError); ok && result != nil {
+		lastErr.N = int(result.MatchedCount + result.UpsertedCount)
+		lastErr.UpdatedExisting = result.MatchedCount > 0
+		if result.UpsertedID != nil {
+			lastErr.UpsertedId = convertOfficialToMGO(result.UpsertedID)
+		}
+	}
+	return err
+}
+
+// convertDeleteError translates the error of a removal like convertError,
+// reporting the number of removed documents on a resulting *LastError
+func convertDeleteError(result *mongodrv.DeleteResult, err error) error {
+	err = convertError(err)
+	if lastErr, ok := err.(*LastError); ok && result != nil {
+		lastErr.N = int(result.DeletedCount)
+	}
+	return err
+}
+
+// writeConcernLastError reports a write concern failure as mgo did, flagging
+// acknowledgements that timed out
+func writeConcernLastError(wce *mongodrv.WriteConcernError, err error) *LastError {
+	return &LastError{
+		Err:      wce.Message,
+		Code:     wce.Code,
+		WTimeout: wce.Code == 64, // WriteConcernFailed, reported when wtimeout expires
+		err:      err,
+	}
+}
+
 // convertSliceWithReflect converts a slice of interfaces to a target slice type using reflection
 func convertSliceWithReflect(srcSlice []interface{}, dst interface{}) error {
 	dstValue := reflect.ValueOf(dst)
-	if dstValue.Kind() != reflect.Ptr {
-		return ErrNotFound
+	if dstValue.Kind() != reflect.Ptr || dstValue.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("result argument must be a slice address, got %T", dst)
 	}
 
 	dstSlice := dstValue.Elem()
-	if dstSlice.Kind() != reflect.Slice {
-		return ErrNotFound
-	}
 
 	elementType := dstSlice.Type().Elem()
 	newSlice := reflect.MakeSlice(dstSlice.Type(), 0, len(srcSlice))
@@ -226,9 +515,14 @@ func convertSliceWithReflect(srcSlice []interface{}, dst interface{}) error {
 	return nil
 }
 
+// mapStructToInterface decodes src, a document or slice produced by
+// convertOfficialToMGO, into dst. A nil src, such as a null array element,
+// leaves dst unchanged. As with the bson package, struct fields are reset
+// first: pointer fields are allocated for the values present and left nil
+// for null or missing ones.
 func mapStructToInterface(src, dst interface{}) error {
 	if src == nil {
-		return ErrNotFound
+		return nil
 	}
 
 	// Handle slice conversion specifically
@@ -237,19 +531,11 @@ func mapStructToInterface(src, dst interface{}) error {
 		return convertSliceWithReflect(srcSlice, dst)
 	}
 
-	// Handle bson.M conversion to struct - need to preprocess time fields
-	if srcMap, ok := src.(bson.M); ok {
-		// Get the destination struct type to check field types
-		dstValue := reflect.ValueOf(dst)
-		if dstValue.Kind() == reflect.Ptr && dstValue.Elem().Kind() == reflect.Struct {
-			dstType := dstValue.Elem().Type()
-
-			// Create a copy and preprocess any time slice fields
-			processedMap := bson.M{}
-			for key, value := range srcMap {
-				processedMap[key] = preprocessTimeSlicesForStruct(value, key, dstType)
-			}
-			src = processedMap
+	// Handle bson.M conversion to struct - need to preprocess time fields,
+	// in nested documents too
+	if _, ok := src.(bson.M); ok {
+		if dstValue := reflect.ValueOf(dst); dstValue.Kind() == reflect.Ptr && !dstValue.IsNil() {
+			src = preprocessTimeSlices(src, dstValue.Elem().Type())
 		}
 	}
 
@@ -261,15 +547,64 @@ func mapStructToInterface(src, dst interface{}) error {
 	return bson.Unmarshal(data, dst)
 }
 
-// preprocessTimeSlicesForStruct converts []interface{} containing timestamps to []time.Time
-// only if the target struct field is expecting []time.Time
+// preprocessTimeSlices prepares value, decoded for a value of type t, by
+// converting the timestamps held for []time.Time values. It descends into
+// the documents and arrays decoded for structs, and for slices, arrays and
+// maps of them, at any depth.
+func preprocessTimeSlices(value interface{}, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if t.Elem() == tTime {
+			return convertTimeSlice(value)
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return value
+		}
+		converted := make([]interface{}, len(items))
+		for i, item := range items {
+			converted[i] = preprocessTimeSlices(item, t.Elem())
+		}
+		return converted
+	case reflect.Map:
+		doc, ok := value.(bson.M)
+		if !ok {
+			return value
+		}
+		converted := bson.M{}
+		for key, item := range doc {
+			converted[key] = preprocessTimeSlices(item, t.Elem())
+		}
+		return converted
+	case reflect.Struct:
+		doc, ok := value.(bson.M)
+		if !ok || !cachedStructFields(t).timeSlices {
+			return value
+		}
+		converted := bson.M{}
+		for key, item := range doc {
+			converted[key] = preprocessTimeSlicesForStruct(item, key, t)
+		}
+		return converted
+	}
+	return value
+}
+
+// preprocessTimeSlicesForStruct prepares the value of the field of
+// structType matching fieldName, when it holds a []time.Time
 func preprocessTimeSlicesForStruct(value interface{}, fieldName string, structType reflect.Type) interface{} {
-	// Find the field in the struct
 	field, found := findStructFieldByBSONTag(structType, fieldName)
-	if !found || field.Type.Kind() != reflect.Slice || field.Type.Elem() != reflect.TypeOf(time.Time{}) {
+	if !found {
 		return value
 	}
+	return preprocessTimeSlices(value, field.Type)
+}
 
+// convertTimeSlice converts []interface{} containing timestamps to []time.Time
+func convertTimeSlice(value interface{}) interface{} {
 	// Handle different slice types
 	var slice []interface{}
 	switch v := value.(type) {
@@ -318,24 +653,132 @@ func preprocessTimeSlicesForStruct(value interface{}, fieldName string, structTy
 	return timeSlice
 }
 
-// findStructFieldByBSONTag finds a struct field by its BSON tag name
-func findStructFieldByBSONTag(structType reflect.Type, bsonFieldName string) (reflect.StructField, bool) {
+// structFields holds the fields of a struct type by the keys
+// findStructFieldByBSONTag matches, so that struct tags are parsed once per
+// type rather than for every decoded document. As with mgo, the fields of
+// structs tagged ",inline" are mapped as fields of the outer struct, and the
+// json tags of fields without a bson tag are used when
+// bson.SetJSONTagFallback is enabled.
+type structFields struct {
+	paths      [][]int        // Index paths of the fields in declaration order, inlined fields included
+	byTag      map[string]int // Position in paths of the first field whose tag names the key
+	byName     map[string]int // Position in paths of the first field with the lowercased name
+	timeSlices bool           // Whether a field holds a []time.Time, directly or in nested structs
+}
+
+// structFieldsKey identifies the fields of a struct type read with or
+// without the json tag fallback
+type structFieldsKey struct {
+	structType   reflect.Type
+	jsonFallback bool
+}
+
+// structFieldsCache holds the *structFields of each structFieldsKey seen
+var structFieldsCache sync.Map
+
+// cachedStructFields returns the fields of structType, reading them on first use
+func cachedStructFields(structType reflect.Type) *structFields {
+	key := structFieldsKey{structType, bson.JSONTagFallbackState()}
+	if cached, ok := structFieldsCache.Load(key); ok {
+		return cached.(*structFields)
+	}
+
+	info := &structFields{byTag: map[string]int{}, byName: map[string]int{}}
+	info.add(structType, nil)
+	info.timeSlices = holdsTimeSlices(structType, map[reflect.Type]bool{})
+	cached, _ := structFieldsCache.LoadOrStore(key, info)
+	return cached.(*structFields)
+}
+
+// add records the fields of structType found at the index path prefix,
+// descending into inlined structs
+func (info *structFields) add(structType reflect.Type, prefix []int) {
 	for i := 0; i < structType.NumField(); i++ {
 		field := structType.Field(i)
-		bsonTag := field.Tag.Get("bson")
+		// Parse the tag (format: "fieldname" or "fieldname,flag,...")
+		tag := fieldTag(field)
+		if tag == "-" {
+			continue
+		}
+		name, flags, _ := strings.Cut(tag, ",")
+		path := append(append([]int(nil), prefix...), i)
+		if field.Type.Kind() == reflect.Struct && hasTagFlag(flags, "inline") {
+			info.add(field.Type, path)
+			continue
+		}
 
-		// Parse the bson tag (format: "fieldname" or "fieldname,omitempty")
-		tagParts := strings.Split(bsonTag, ",")
-		if len(tagParts) > 0 && tagParts[0] == bsonFieldName {
-			return field, true
+		pos := len(info.paths)
+		info.paths = append(info.paths, path)
+		if _, ok := info.byTag[name]; !ok {
+			info.byTag[name] = pos
+		}
+		lower := strings.ToLower(field.Name)
+		if _, ok := info.byName[lower]; !ok {
+			info.byName[lower] = pos
 		}
+	}
+}
 
-		// Also check if the field name matches (case-insensitive)
-		if strings.ToLower(field.Name) == strings.ToLower(bsonFieldName) {
-			return field, true
+// holdsTimeSlices reports whether values of type t hold a []time.Time, in t
+// itself or in the fields of the structs it holds. visiting holds the struct
+// types being checked, for recursive types.
+func holdsTimeSlices(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Map:
+		return holdsTimeSlices(t.Elem(), visiting)
+	case reflect.Slice, reflect.Array:
+		return t.Elem() == tTime || holdsTimeSlices(t.Elem(), visiting)
+	case reflect.Struct:
+		if visiting[t] {
+			return false
 		}
+		visiting[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if fieldTag(field) != "-" && holdsTimeSlices(field.Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fieldTag returns the bson tag of field or, when it has none and
+// bson.SetJSONTagFallback is enabled, its json tag, as the bson package does
+func fieldTag(field reflect.StructField) string {
+	tag := field.Tag.Get("bson")
+	if tag == "" && bson.JSONTagFallbackState() {
+		tag = field.Tag.Get("json")
 	}
-	return reflect.StructField{}, false
+	return tag
+}
+
+// hasTagFlag reports whether flags, the comma separated options following
+// the key of a bson tag, hold flag
+func hasTagFlag(flags, flag string) bool {
+	for flags != "" {
+		var f string
+		f, flags, _ = strings.Cut(flags, ",")
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// findStructFieldByBSONTag finds a struct field by its BSON tag name (or JSON
+// tag name, with bson.SetJSONTagFallback), or by its name compared
+// case-insensitively, the first matching field winning
+func findStructFieldByBSONTag(structType reflect.Type, bsonFieldName string) (reflect.StructField, bool) {
+	info := cachedStructFields(structType)
+	pos, found := info.byTag[bsonFieldName]
+	if i, ok := info.byName[strings.ToLower(bsonFieldName)]; ok && (!found || i < pos) {
+		pos, found = i, true
+	}
+	if !found {
+		return reflect.StructField{}, false
+	}
+	return structType.FieldByIndex(info.paths[pos]), true
 }
 
 // ensureObjectId ensures that a document has a proper _id field
@@ -369,13 +812,11 @@ func ensureObjectId(doc interface{}) interface{} {
 				idField = val.FieldByName("ID")
 			}
 			if !idField.IsValid() {
-				// Look for bson:"_id" tag
-				for i := 0; i < val.NumField(); i++ {
-					field := val.Type().Field(i)
-					if tag := field.Tag.Get("bson"); tag == "_id" || tag == "_id,omitempty" {
-						idField = val.Field(i)
-						break
-					}
+				// Look for bson:"_id" tag, in inlined structs too, or
+				// json:"_id" with the json tag fallback
+				info := cachedStructFields(val.Type())
+				if pos, ok := info.byTag["_id"]; ok {
+					idField = val.FieldByIndex(info.paths[pos])
 				}
 			}
 
@@ -420,3 +861,88 @@ func ConvertMGOToOfficialDebug(input interface{}) interface{} {
 	defer func() { DebugConversion = false }()
 	return convertMGOToOfficialWithDebug(input, 0)
 }
+
+// convertCollation converts an mgo Collation to the official driver Collation
+func convertCollation(collation *Collation) *options.Collation {
+	if collation == nil {
+		return nil
+	}
+	return &options.Collation{
+		Locale:          collation.Locale,
+		CaseFirst:       collation.CaseFirst,
+		Strength:        collation.Strength,
+		Alternate:       collation.Alternate,
+		MaxVariable:     collation.MaxVariable,
+		Normalization:   collation.Normalization,
+		CaseLevel:       collation.CaseLevel,
+		NumericOrdering: collation.NumericOrdering,
+		Backwards:       collation.Backwards,
+	}
+}
+
+// numberToInt converts a numeric BSON value to an int, returning 0 for
+// non-numeric values
+func numberToInt(value interface{}) int {
+	switch v := value.(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// numberToFloat converts a numeric BSON value to a float64, returning 0 for
+// non-numeric values
+func numberToFloat(value interface{}) float64 {
+	if v, ok := value.(float64); ok {
+		return v
+	}
+	return float64(numberToInt(value))
+}
+
+// optionToBool converts a BSON boolean option to a bool, numbers standing for
+// true unless zero
+func optionToBool(value interface{}) bool {
+	if v, ok := value.(bool); ok {
+		return v
+	}
+	return numberToFloat(value) != 0
+}
+
+// commandDocument returns the driver document of a command given to Run
+func commandDocument(cmd interface{}) interface{} {
+	if name, ok := cmd.(string); ok {
+		return officialBson.D{{Key: name, Value: 1}}
+	}
+	return convertMGOToOfficial(cmd)
+}
+
+// Cmd builds the document of the command name with the given arguments,
+// the name first as the server requires and the arguments after it in key
+// order. The value of the command name is taken from args when it holds the
+// name, as in Cmd("count", bson.M{"count": "users", "query": q}), and is 1
+// otherwise.
+func Cmd(name string, args bson.M) bson.D {
+	var value interface{} = 1
+	keys := make([]string, 0, len(args))
+	for key, arg := range args {
+		if key == name {
+			value = arg
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	cmd := make(bson.D, 0, len(keys)+1)
+	cmd = append(cmd, bson.DocElem{Name: name, Value: value})
+	for _, key := range keys {
+		cmd = append(cmd, bson.DocElem{Name: key, Value: args[key]})
+	}
+	return cmd
+}
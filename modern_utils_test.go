@@ -1,11 +1,17 @@
 package mgo
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
-	"github.com/globalsign/mgo/bson"
+	"github.com/kinfkong/modern-mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
 )
 
 // TestConvertMGOToOfficialTimeHandling tests time.Time conversion in various contexts
@@ -365,3 +371,794 @@ func TestConvertMGOToOfficialDeleteAccountScenario(t *testing.T) {
 		t.Errorf("Converted document cannot be marshaled to BSON: %v", err)
 	}
 }
+
+// TestConvertMGOToOfficialDBRef ensures DBRefs keep the field order required by the server
+func TestConvertMGOToOfficialDBRef(t *testing.T) {
+	id := bson.NewObjectId()
+	converted := convertMGOToOfficial(bson.M{"ref": &DBRef{Collection: "users", Id: id, Database: "app"}}).(primitive.M)
+
+	ref, ok := converted["ref"].(primitive.D)
+	if !ok {
+		t.Fatalf("Expected DBRef to convert to primitive.D, got %T", converted["ref"])
+	}
+	if len(ref) != 3 || ref[0].Key != "$ref" || ref[1].Key != "$id" || ref[2].Key != "$db" {
+		t.Fatalf("Unexpected DBRef layout: %v", ref)
+	}
+	if _, ok := ref[1].Value.(primitive.ObjectID); !ok {
+		t.Errorf("Expected $id to be primitive.ObjectID, got %T", ref[1].Value)
+	}
+
+	// $db is omitted when no database is set
+	ref = convertMGOToOfficial(DBRef{Collection: "users", Id: id}).(primitive.D)
+	if len(ref) != 2 {
+		t.Errorf("Expected $db to be omitted, got %v", ref)
+	}
+}
+
+// TestConvertMGOToOfficialDBRefStruct ensures DBRefs nested in structs keep their field order
+func TestConvertMGOToOfficialDBRefStruct(t *testing.T) {
+	type holder struct {
+		Ref DBRef `bson:"ref"`
+	}
+	converted := convertMGOToOfficial(holder{Ref: DBRef{Collection: "users", Id: bson.NewObjectId()}}).(officialBson.Raw)
+
+	ref, err := converted.Lookup("ref").Document().Elements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ref) != 2 || ref[0].Key() != "$ref" || ref[1].Key() != "$id" {
+		t.Errorf("Unexpected DBRef layout: %v", ref)
+	}
+	if ref[1].Value().Type != bsontype.ObjectID {
+		t.Errorf("Expected $id to be an ObjectID, got %v", ref[1].Value().Type)
+	}
+}
+
+// TestConvertError checks the translation of driver errors into mgo errors
+func TestConvertError(t *testing.T) {
+	if err := convertError(nil); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+	if err := convertError(mongodrv.ErrUnacknowledgedWrite); err != nil {
+		t.Errorf("Expected unacknowledged writes to succeed, got %v", err)
+	}
+	if err := convertError(mongodrv.ErrNoDocuments); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// Write errors become *LastError, keeping the driver error reachable
+	we := mongodrv.WriteException{WriteErrors: mongodrv.WriteErrors{{Code: 11000, Message: "E11000 duplicate key error"}}}
+	err := convertError(we)
+	lastErr, ok := err.(*LastError)
+	if !ok || lastErr.Code != 11000 || lastErr.Err != "E11000 duplicate key error" {
+		t.Fatalf("Expected *LastError with code 11000, got %#v", err)
+	}
+	if !errors.As(err, &we) || !IsDup(err) {
+		t.Errorf("Expected driver error to stay reachable, got %v", err)
+	}
+
+	bwe := mongodrv.BulkWriteException{WriteErrors: []mongodrv.BulkWriteError{{WriteError: mongodrv.WriteError{Code: 121, Message: "Document failed validation"}}}}
+	if lastErr, ok := convertError(bwe).(*LastError); !ok || lastErr.Code != 121 {
+		t.Errorf("Expected *LastError with code 121, got %#v", convertError(bwe))
+	}
+
+	wce := mongodrv.WriteException{WriteConcernError: &mongodrv.WriteConcernError{Code: 64, Message: "waiting for replication timed out"}}
+	if lastErr, ok := convertError(wce).(*LastError); !ok || !lastErr.WTimeout {
+		t.Errorf("Expected *LastError with WTimeout, got %#v", convertError(wce))
+	}
+
+	// Command errors become *QueryError, also when wrapped
+	ce := mongodrv.CommandError{Code: 13, Message: "not authorized"}
+	queryErr, ok := convertError(fmt.Errorf("run: %w", ce)).(*QueryError)
+	if !ok || queryErr.Code != 13 || queryErr.Message != "not authorized" {
+		t.Errorf("Expected *QueryError with code 13, got %#v", queryErr)
+	}
+
+	// Other errors are returned unchanged
+	plain := errors.New("boom")
+	if err := convertError(plain); err != plain {
+		t.Errorf("Expected error unchanged, got %v", err)
+	}
+}
+
+// TestConvertWriteResultErrors checks write outcomes are reported on *LastError
+func TestConvertWriteResultErrors(t *testing.T) {
+	wce := mongodrv.WriteException{WriteConcernError: &mongodrv.WriteConcernError{Code: 64, Message: "waiting for replication timed out"}}
+
+	err := convertUpdateError(&mongodrv.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, wce)
+	lastErr, ok := err.(*LastError)
+	if !ok || lastErr.N != 1 || !lastErr.UpdatedExisting || lastErr.UpsertedId != nil {
+		t.Errorf("Expected LastError for updated existing document, got %#v", err)
+	}
+
+	id := primitive.NewObjectID()
+	err = convertUpdateError(&mongodrv.UpdateResult{UpsertedCount: 1, UpsertedID: id}, wce)
+	lastErr, ok = err.(*LastError)
+	if !ok || lastErr.N != 1 || lastErr.UpdatedExisting || lastErr.UpsertedId != bson.ObjectId(id[:]) {
+		t.Errorf("Expected LastError for upserted document, got %#v", err)
+	}
+
+	err = convertDeleteError(&mongodrv.DeleteResult{DeletedCount: 3}, wce)
+	if lastErr, ok := err.(*LastError); !ok || lastErr.N != 3 {
+		t.Errorf("Expected LastError with N=3, got %#v", err)
+	}
+
+	// Successful writes and results of failed writes without a result
+	if err := convertUpdateError(&mongodrv.UpdateResult{MatchedCount: 1}, nil); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+	if _, ok := convertDeleteError(nil, wce).(*LastError); !ok {
+		t.Errorf("Expected LastError without result, got %#v", convertDeleteError(nil, wce))
+	}
+
+	// LastError decodes from a getLastError reply
+	var reply LastError
+	raw, _ := bson.Marshal(bson.M{"n": 2, "updatedExisting": true, "err": nil})
+	if err := bson.Unmarshal(raw, &reply); err != nil || reply.N != 2 || !reply.UpdatedExisting {
+		t.Errorf("Expected decoded getLastError reply, got %#v (err=%v)", reply, err)
+	}
+}
+
+// TestNotFoundErrors checks not-found conditions match ErrNotFound and decode
+// failures do not
+func TestNotFoundErrors(t *testing.T) {
+	wrapped := fmt.Errorf("load user: %w", mongodrv.ErrNoDocuments)
+	err := convertError(wrapped)
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, mongodrv.ErrNoDocuments) {
+		t.Errorf("Expected wrapped ErrNoDocuments to match ErrNotFound, got %v", err)
+	}
+	if !errors.Is(fmt.Errorf("user 42: %w", ErrNotFound), ErrNotFound) {
+		t.Error("Expected ErrNotFound to match once wrapped")
+	}
+
+	// Decoding into an invalid destination is not a missing document
+	var notSlice bson.M
+	err = mapStructToInterface([]interface{}{bson.M{"a": 1}}, &notSlice)
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected decode error for non-slice destination, got %v", err)
+	}
+	err = mapStructToInterface([]interface{}{bson.M{"a": 1}}, []bson.M{})
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected decode error for non-pointer destination, got %v", err)
+	}
+
+	// Null array elements decode to zero values
+	var docs []*struct{ A int }
+	if err := mapStructToInterface([]interface{}{nil, bson.M{"a": 1}}, &docs); err != nil {
+		t.Fatalf("Failed to decode slice with null element: %v", err)
+	}
+	if len(docs) != 2 || docs[0] != nil || docs[1].A != 1 {
+		t.Errorf("Expected nil then decoded element, got %+v", docs)
+	}
+}
+
+// TestIndexKeyDoc checks mgo index key specifications are converted to index
+// key documents
+func TestIndexKeyDoc(t *testing.T) {
+	keys, err := indexKeyDoc([]string{"a", "-b", "$text:title", "$text:body"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := officialBson.D{
+		{Key: "a", Value: 1},
+		{Key: "b", Value: -1},
+		{Key: "title", Value: "text"},
+		{Key: "body", Value: "text"},
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %v, got %v", expected, keys)
+	}
+
+	keys, err = indexKeyDoc([]string{"$2d:loc", "$2dsphere:geo", "-ts"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = officialBson.D{
+		{Key: "loc", Value: "2d"},
+		{Key: "geo", Value: "2dsphere"},
+		{Key: "ts", Value: -1},
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %v, got %v", expected, keys)
+	}
+
+	for _, key := range []string{"$text", "$text:", "-", "$unknown:a"} {
+		if _, err := indexKeyDoc([]string{key}); err == nil {
+			t.Errorf("Expected error for key %q", key)
+		}
+	}
+}
+
+func TestPipeStages(t *testing.T) {
+	sortStage := officialBson.D{{Key: "$sort", Value: officialBson.D{{Key: "b", Value: -1}, {Key: "a", Value: 1}}}}
+	matchStage := officialBson.M{"$match": officialBson.M{"x": 1}}
+
+	pipelines := []interface{}{
+		[]bson.D{
+			{{Name: "$sort", Value: bson.D{{Name: "b", Value: -1}, {Name: "a", Value: 1}}}},
+			{{Name: "$match", Value: bson.M{"x": 1}}},
+		},
+		[][]bson.DocElem{
+			{{Name: "$sort", Value: bson.D{{Name: "b", Value: -1}, {Name: "a", Value: 1}}}},
+			{{Name: "$match", Value: bson.M{"x": 1}}},
+		},
+		[]interface{}{
+			bson.D{{Name: "$sort", Value: bson.D{{Name: "b", Value: -1}, {Name: "a", Value: 1}}}},
+			bson.M{"$match": bson.M{"x": 1}},
+		},
+		[]officialBson.D{sortStage, {{Key: "$match", Value: officialBson.M{"x": 1}}}},
+	}
+	for i, pipeline := range pipelines {
+		stages := (&ModernPipe{pipeline: pipeline}).stages()
+		if len(stages) != 2 {
+			t.Fatalf("Pipeline %d: expected 2 stages, got %v", i, stages)
+		}
+		if !reflect.DeepEqual(stages[0], sortStage) {
+			t.Errorf("Pipeline %d: expected ordered %v, got %#v", i, sortStage, stages[0])
+		}
+		match, ok := stages[1].(officialBson.M)
+		if !ok {
+			if d, isD := stages[1].(officialBson.D); isD {
+				match = d.Map()
+			}
+		}
+		if !reflect.DeepEqual(match, matchStage) {
+			t.Errorf("Pipeline %d: expected %v, got %#v", i, matchStage, stages[1])
+		}
+	}
+
+	// A single ordered document is a one-stage pipeline
+	stages := (&ModernPipe{pipeline: bson.D{{Name: "$sort", Value: bson.D{{Name: "b", Value: -1}, {Name: "a", Value: 1}}}}}).stages()
+	if len(stages) != 1 || !reflect.DeepEqual(stages[0], sortStage) {
+		t.Errorf("Expected a single ordered stage, got %#v", stages)
+	}
+}
+
+// TestFindStructFieldByBSONTag checks field matching by tag and by name
+// through the per-type field cache
+func TestFindStructFieldByBSONTag(t *testing.T) {
+	type doc struct {
+		Name    string      `bson:"title,omitempty"`
+		Title   string      `bson:"other"`
+		Created []time.Time `bson:"created"`
+		Count   int
+	}
+	typ := reflect.TypeOf(doc{})
+
+	for i := 0; i < 2; i++ {
+		// The first field matching by tag or by name wins
+		if field, ok := findStructFieldByBSONTag(typ, "title"); !ok || field.Name != "Name" {
+			t.Errorf("Expected title to match Name, got %v, %v", field.Name, ok)
+		}
+		if field, ok := findStructFieldByBSONTag(typ, "COUNT"); !ok || field.Name != "Count" {
+			t.Errorf("Expected COUNT to match Count, got %v, %v", field.Name, ok)
+		}
+		if _, ok := findStructFieldByBSONTag(typ, "missing"); ok {
+			t.Error("Expected missing not to match")
+		}
+	}
+	if !cachedStructFields(typ).timeSlices {
+		t.Error("Expected the []time.Time field to be recorded")
+	}
+
+	// Timestamps are still converted for []time.Time fields
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var got doc
+	err := mapStructToInterface(bson.M{"created": []interface{}{when.UnixNano() / 1e6}}, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Created) != 1 || !got.Created[0].Equal(when) {
+		t.Errorf("Expected the timestamp to be converted, got %v", got.Created)
+	}
+}
+
+// TestConvertOfficialValuesPassThrough checks that driver values are passed
+// through to the driver and converted back to mgo types in results
+func TestConvertOfficialValuesPassThrough(t *testing.T) {
+	oid := primitive.NewObjectID()
+	m := officialBson.M{"a": officialBson.A{1, oid}}
+	a := officialBson.A{officialBson.M{"b": 2}}
+	ms := []officialBson.M{{"c": 3}}
+	raw, err := officialBson.Marshal(officialBson.M{"d": 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, value := range []interface{}{m, a, officialBson.Raw(raw)} {
+		if converted := convertMGOToOfficial(value); !reflect.DeepEqual(converted, value) {
+			t.Errorf("Expected %#v to pass through, got %#v", value, converted)
+		}
+	}
+	if converted := convertMGOToOfficial(ms); !reflect.DeepEqual(converted, []interface{}{officialBson.M{"c": 3}}) {
+		t.Errorf("Expected %#v to convert to []interface{}, got %#v", ms, converted)
+	}
+	if converted := convertMGOToOfficial(bson.M{"m": m}).(officialBson.M); !reflect.DeepEqual(converted["m"], m) {
+		t.Errorf("Expected nested %#v to pass through, got %#v", m, converted["m"])
+	}
+
+	// Driver arrays do not leak into mgo results
+	result := convertOfficialToMGO(officialBson.M{"a": officialBson.A{oid, officialBson.A{primitive.NewDateTimeFromTime(time.Unix(0, 0))}}})
+	arr, ok := result.(bson.M)["a"].([]interface{})
+	if !ok {
+		t.Fatalf("Expected []interface{}, got %T", result.(bson.M)["a"])
+	}
+	if id, ok := arr[0].(bson.ObjectId); !ok || string(id) != string(oid[:]) {
+		t.Errorf("Expected bson.ObjectId, got %#v", arr[0])
+	}
+	if inner, ok := arr[1].([]interface{}); !ok || !inner[0].(time.Time).Equal(time.Unix(0, 0)) {
+		t.Errorf("Expected nested []interface{} of time.Time, got %#v", arr[1])
+	}
+	if docs, ok := convertOfficialToMGO(ms).([]interface{}); !ok || !reflect.DeepEqual(docs[0], bson.M{"c": 3}) {
+		t.Errorf("Expected []interface{} of bson.M, got %#v", convertOfficialToMGO(ms))
+	}
+}
+
+// TestConvertOfficialValuesNestingMGOValues checks mgo values nested in
+// official documents and arrays are converted, so that filters such as
+// officialBson.M{"_id": id} match ObjectIds
+func TestConvertOfficialValuesNestingMGOValues(t *testing.T) {
+	id := bson.NewObjectId()
+	var oid primitive.ObjectID
+	copy(oid[:], []byte(id))
+
+	filter, ok := convertMGOToOfficial(officialBson.M{"_id": id}).(officialBson.M)
+	if !ok || filter["_id"] != oid {
+		t.Errorf("Expected the ObjectId to convert to %v, got %#v", oid, filter)
+	}
+	in, ok := convertMGOToOfficial(officialBson.M{"_id": officialBson.M{"$in": officialBson.A{id}}}).(officialBson.M)
+	if !ok || !reflect.DeepEqual(in["_id"], officialBson.M{"$in": officialBson.A{oid}}) {
+		t.Errorf("Expected the nested ObjectId to convert to %v, got %#v", oid, in)
+	}
+	or, ok := convertMGOToOfficial([]officialBson.M{{"_id": id}}).([]interface{})
+	if !ok || !reflect.DeepEqual(or[0], officialBson.M{"_id": oid}) {
+		t.Errorf("Expected the ObjectId in []officialBson.M to convert to %v, got %#v", oid, or)
+	}
+}
+
+type inlineBase struct {
+	Id      bson.ObjectId `bson:"_id"`
+	Created []time.Time   `bson:"created"`
+	Version int64         `bson:"version,minsize"`
+}
+
+type inlineDoc struct {
+	Base   inlineBase             `bson:",inline"`
+	Name   string                 `bson:"name,omitempty"`
+	Secret string                 `bson:"-"`
+	Extra  map[string]interface{} `bson:",inline"`
+}
+
+// TestInlineStructs checks the fields of ",inline" structs are mapped as
+// fields of the outer struct, as mgo does
+func TestInlineStructs(t *testing.T) {
+	typ := reflect.TypeOf(inlineDoc{})
+	if field, ok := findStructFieldByBSONTag(typ, "created"); !ok || field.Name != "Created" {
+		t.Errorf("Expected created to match the inlined Created, got %v, %v", field.Name, ok)
+	}
+	if _, ok := findStructFieldByBSONTag(typ, "secret"); ok {
+		t.Error("Expected the field tagged - to be skipped")
+	}
+	if !cachedStructFields(typ).timeSlices {
+		t.Error("Expected the inlined []time.Time field to be recorded")
+	}
+
+	// Inserted documents get an id in the inlined struct
+	doc := &inlineDoc{Base: inlineBase{Version: 3}, Extra: map[string]interface{}{"tag": "x"}}
+	ensureObjectId(doc)
+	if !doc.Base.Id.Valid() {
+		t.Fatalf("Expected an id set in the inlined struct, got %q", doc.Base.Id)
+	}
+
+	// Encoding follows the bson package: inlined fields, omitempty, minsize.
+	// The bson package writes the inlined map first, the driver last.
+	want, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := convertMGOToOfficial(doc).(officialBson.Raw)
+	wantElems, _ := officialBson.Raw(want).Elements()
+	gotElems, _ := got.Elements()
+	if !ok || len(gotElems) != len(wantElems) {
+		t.Fatalf("Expected %v, got %v", officialBson.Raw(want), got)
+	}
+	for _, elem := range wantElems {
+		if value := got.Lookup(elem.Key()); !value.Equal(elem.Value()) {
+			t.Errorf("Expected %s to be %v, got %v", elem.Key(), elem.Value(), value)
+		}
+	}
+
+	// Timestamps of inlined []time.Time fields are converted when decoding
+	// goes through the bson package
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var decoded inlineDoc
+	err = mapStructToInterface(bson.M{"created": []interface{}{when.UnixNano() / 1e6}, "name": "n"}, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Base.Created) != 1 || !decoded.Base.Created[0].Equal(when) || decoded.Name != "n" {
+		t.Errorf("Unexpected decoded document %+v", decoded)
+	}
+}
+
+type nestedStop struct {
+	Times []time.Time `bson:"times"`
+}
+
+type nestedRoute struct {
+	Name  string                `bson:"name"`
+	First nestedStop            `bson:"first"`
+	Last  *nestedStop           `bson:"last"`
+	Stops []nestedStop          `bson:"stops"`
+	ByDay map[string]nestedStop `bson:"byDay"`
+	Next  *nestedRoute          `bson:"next"`
+}
+
+// TestNestedStructs checks timestamps decoded for []time.Time fields of
+// nested structs are converted at any depth
+func TestNestedStructs(t *testing.T) {
+	typ := reflect.TypeOf(nestedRoute{})
+	if !cachedStructFields(typ).timeSlices {
+		t.Error("Expected the nested []time.Time fields to be recorded")
+	}
+	if cachedStructFields(reflect.TypeOf(DBStats{})).timeSlices {
+		t.Error("Expected no []time.Time field in DBStats")
+	}
+
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	times := func() []interface{} { return []interface{}{when.UnixNano() / 1e6} }
+	src := bson.M{
+		"name":  "r",
+		"first": bson.M{"times": times()},
+		"last":  bson.M{"times": times()},
+		"stops": []interface{}{bson.M{"times": times()}},
+		"byDay": bson.M{"mon": bson.M{"times": times()}},
+		"next":  bson.M{"name": "n", "first": bson.M{"times": times()}},
+	}
+	var route nestedRoute
+	if err := mapStructToInterface(src, &route); err != nil {
+		t.Fatal(err)
+	}
+	check := func(name string, stop *nestedStop) {
+		if stop == nil || len(stop.Times) != 1 || !stop.Times[0].Equal(when) {
+			t.Errorf("Unexpected %s: %+v", name, stop)
+		}
+	}
+	check("first", &route.First)
+	check("last", route.Last)
+	if len(route.Stops) != 1 {
+		t.Fatalf("Unexpected stops %+v", route.Stops)
+	}
+	check("stops", &route.Stops[0])
+	mon := route.ByDay["mon"]
+	check("byDay", &mon)
+	if route.Next == nil || route.Next.Name != "n" {
+		t.Fatalf("Unexpected next %+v", route.Next)
+	}
+	check("next.first", &route.Next.First)
+}
+
+type NestedAudit struct {
+	By    bson.ObjectId `bson:"by"`
+	Count int           `bson:"count"`
+}
+
+type nestedOwner struct {
+	Name    string       `bson:"name"`
+	Active  bool         `bson:"active"`
+	Audit   NestedAudit  `bson:"audit"`
+	Backup  *NestedAudit `bson:"backup"`
+	History []NestedAudit
+}
+
+type nestedAccount struct {
+	NestedAudit                        // Embedded without inline: a "nestedaudit" document
+	Owner       nestedOwner            `bson:"owner"`
+	Owners      []nestedOwner          `bson:"owners"`
+	Labels      map[string]NestedAudit `bson:"labels"`
+	Flat        nestedStop             `bson:",inline"`
+}
+
+// TestNestedStructFields checks nested and embedded struct fields holding no
+// time slices decode at any depth, whether decoded with mgoRegistry or
+// through the bson package
+func TestNestedStructFields(t *testing.T) {
+	id := bson.NewObjectId()
+	in := nestedAccount{
+		NestedAudit: NestedAudit{By: id, Count: 1},
+		Owner: nestedOwner{
+			Name:    "a",
+			Active:  true,
+			Audit:   NestedAudit{By: id, Count: 2},
+			Backup:  &NestedAudit{By: id, Count: 3},
+			History: []NestedAudit{{By: id, Count: 4}},
+		},
+		Owners: []nestedOwner{{Name: "b", Audit: NestedAudit{By: id, Count: 5}, History: []NestedAudit{}}},
+		Labels: map[string]NestedAudit{"x": {By: id, Count: 6}},
+		Flat:   nestedStop{Times: []time.Time{}},
+	}
+	data, err := bson.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, legacy := range []bool{false, true} {
+		bson.SetJSONTagFallback(legacy)
+		var out nestedAccount
+		err := decodeDocument(data, &out)
+		bson.SetJSONTagFallback(false)
+		if err != nil {
+			t.Fatalf("legacy %v: %v", legacy, err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("legacy %v: expected %+v, got %+v", legacy, in, out)
+		}
+	}
+}
+
+type pointerFields struct {
+	When  *time.Time     `bson:"when"`
+	Ref   *bson.ObjectId `bson:"ref"`
+	N     *int           `bson:"n"`
+	Times *[]time.Time   `bson:"times"`
+	Null  *int           `bson:"null"`
+	Gone  *string        `bson:"gone"`
+}
+
+// TestPointerFields checks pointer fields are allocated for present values
+// and left nil for null or missing ones, on both decoding paths
+func TestPointerFields(t *testing.T) {
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ref := bson.NewObjectId()
+	check := func(name string, doc pointerFields) {
+		if doc.When == nil || !doc.When.Equal(when) {
+			t.Errorf("%s: unexpected when %v", name, doc.When)
+		}
+		if doc.Ref == nil || *doc.Ref != ref {
+			t.Errorf("%s: unexpected ref %v", name, doc.Ref)
+		}
+		if doc.N == nil || *doc.N != 5 {
+			t.Errorf("%s: unexpected n %v", name, doc.N)
+		}
+		if doc.Times == nil || len(*doc.Times) != 1 || !(*doc.Times)[0].Equal(when) {
+			t.Errorf("%s: unexpected times %v", name, doc.Times)
+		}
+		if doc.Null != nil || doc.Gone != nil {
+			t.Errorf("%s: expected nil for null and missing values, got %v, %v", name, doc.Null, doc.Gone)
+		}
+	}
+
+	raw, err := officialBson.Marshal(convertMGOToOfficial(bson.M{
+		"when": when, "ref": ref, "n": 5, "times": []time.Time{when}, "null": nil,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := 1
+	decoded := pointerFields{Null: &stale}
+	if err := decodeDocument(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	check("decodeDocument", decoded)
+
+	// Timestamps decoded as milliseconds are converted behind the pointer too
+	mapped := pointerFields{Null: &stale}
+	src := bson.M{"when": when, "ref": ref, "n": 5, "times": []interface{}{when.UnixNano() / 1e6}, "null": nil}
+	if err := mapStructToInterface(src, &mapped); err != nil {
+		t.Fatal(err)
+	}
+	check("mapStructToInterface", mapped)
+}
+
+type typedMapFields struct {
+	Seen   map[string]time.Time     `bson:"seen"`
+	Counts map[string]int64         `bson:"counts"`
+	Stops  map[string]nestedStop    `bson:"stops"`
+	Refs   map[string]*nestedStop   `bson:"refs"`
+	Ids    map[string]bson.ObjectId `bson:"ids"`
+}
+
+// TestTypedMapFields checks map fields with typed values are decoded with
+// their values converted, on both decoding paths
+func TestTypedMapFields(t *testing.T) {
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	id := bson.NewObjectId()
+	raw, err := officialBson.Marshal(convertMGOToOfficial(bson.M{
+		"seen":   bson.M{"a": when},
+		"counts": bson.M{"a": int32(4), "b": int64(1) << 40},
+		"stops":  bson.M{"a": bson.M{"times": []time.Time{when}}},
+		"refs":   bson.M{"a": bson.M{"times": []time.Time{when}}, "b": nil},
+		"ids":    bson.M{"a": id},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(name string, doc typedMapFields) {
+		if !doc.Seen["a"].Equal(when) {
+			t.Errorf("%s: unexpected seen %v", name, doc.Seen)
+		}
+		if doc.Counts["a"] != 4 || doc.Counts["b"] != 1<<40 {
+			t.Errorf("%s: unexpected counts %v", name, doc.Counts)
+		}
+		if stop := doc.Stops["a"]; len(stop.Times) != 1 || !stop.Times[0].Equal(when) {
+			t.Errorf("%s: unexpected stops %v", name, doc.Stops)
+		}
+		if ref, ok := doc.Refs["b"]; doc.Refs["a"] == nil || !ok || ref != nil {
+			t.Errorf("%s: unexpected refs %v", name, doc.Refs)
+		}
+		if doc.Ids["a"] != id {
+			t.Errorf("%s: unexpected ids %v", name, doc.Ids)
+		}
+	}
+
+	var decoded typedMapFields
+	if err := decodeDocument(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	check("decodeDocument", decoded)
+
+	var doc bson.M
+	if err := decodeDocument(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	var mapped typedMapFields
+	if err := mapStructToInterface(doc, &mapped); err != nil {
+		t.Fatal(err)
+	}
+	check("mapStructToInterface", mapped)
+
+	// Typed maps are results too
+	var counts map[string]int64
+	if err := mapStructToInterface(doc["counts"], &counts); err != nil || counts["a"] != 4 {
+		t.Errorf("Unexpected counts %v, %v", counts, err)
+	}
+}
+
+type numericFields struct {
+	I32 int32   `bson:"i32"`
+	I   int     `bson:"i"`
+	I8  int8    `bson:"i8"`
+	U   uint    `bson:"u"`
+	U16 uint16  `bson:"u16"`
+	F32 float32 `bson:"f32"`
+	F   float64 `bson:"f"`
+	B   bool    `bson:"b"`
+	P   *int32  `bson:"p"`
+}
+
+// TestNumericConversions checks numbers are widened and narrowed into
+// numeric fields of other types as the bson package does, on both decoding
+// paths
+func TestNumericConversions(t *testing.T) {
+	docs := []officialBson.M{
+		{"i32": int64(5), "i": 2.0, "i8": int32(7), "u": int64(9), "u16": 3.0, "f32": int64(3), "f": int32(4), "b": int32(1), "p": int64(6)},
+		{"i32": 2.5, "i": -2.7, "i8": int64(300), "u": -1, "f": int64(1) << 60, "b": 0.0, "p": 1.5},
+		{"i32": int64(1) << 40, "i": true, "f": true, "b": int64(2)},
+	}
+	for _, doc := range docs {
+		raw, err := officialBson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want numericFields
+		if err := bson.Unmarshal(raw, &want); err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded numericFields
+		if err := decodeDocument(raw, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, want) {
+			t.Errorf("decodeDocument(%v): expected %+v, got %+v", doc, want, decoded)
+		}
+
+		var m bson.M
+		if err := decodeDocument(raw, &m); err != nil {
+			t.Fatal(err)
+		}
+		var mapped numericFields
+		if err := mapStructToInterface(m, &mapped); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(mapped, want) {
+			t.Errorf("mapStructToInterface(%v): expected %+v, got %+v", doc, want, mapped)
+		}
+	}
+}
+
+type jsonTagged struct {
+	Id     bson.ObjectId `json:"_id"`
+	Name   string        `json:"full_name"`
+	Stamps []time.Time   `json:"stamps"`
+	Secret string        `json:"-"`
+	Rank   int           `json:"rank,omitempty"`
+	Plain  int
+}
+
+// TestJSONTagFallback checks the json tags of fields without a bson tag are
+// used, with bson.SetJSONTagFallback, when finding fields, generating ids,
+// encoding and decoding
+func TestJSONTagFallback(t *testing.T) {
+	typ := reflect.TypeOf(jsonTagged{})
+	if _, ok := findStructFieldByBSONTag(typ, "full_name"); ok {
+		t.Error("Expected json tags to be ignored by default")
+	}
+
+	bson.SetJSONTagFallback(true)
+	defer bson.SetJSONTagFallback(false)
+
+	if field, ok := findStructFieldByBSONTag(typ, "full_name"); !ok || field.Name != "Name" {
+		t.Errorf("Expected full_name to match Name, got %v, %v", field.Name, ok)
+	}
+	if _, ok := findStructFieldByBSONTag(typ, "secret"); ok {
+		t.Error("Expected the field tagged json:\"-\" to be skipped")
+	}
+	if field, ok := findStructFieldByBSONTag(typ, "plain"); !ok || field.Name != "Plain" {
+		t.Errorf("Expected plain to match the untagged Plain, got %v, %v", field.Name, ok)
+	}
+
+	doc := &jsonTagged{Name: "n", Secret: "s", Plain: 2}
+	ensureObjectId(doc)
+	if !doc.Id.Valid() {
+		t.Fatalf("Expected an id set in the field tagged json:\"_id\", got %q", doc.Id)
+	}
+	data, err := officialBson.Marshal(convertMGOToOfficial(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := officialBson.Raw(data)
+	for _, key := range []string{"_id", "full_name", "plain"} {
+		if _, err := raw.LookupErr(key); err != nil {
+			t.Errorf("Expected %s in %v", key, raw)
+		}
+	}
+	for _, key := range []string{"secret", "rank", "name"} {
+		if _, err := raw.LookupErr(key); err == nil {
+			t.Errorf("Unexpected %s in %v", key, raw)
+		}
+	}
+
+	var decoded jsonTagged
+	if err := decodeDocument(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Id != doc.Id || decoded.Name != "n" || decoded.Plain != 2 || decoded.Secret != "" {
+		t.Errorf("Unexpected decoded document %+v", decoded)
+	}
+
+	// Timestamps of []time.Time fields found by their json tag are converted
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var mapped jsonTagged
+	if err := mapStructToInterface(bson.M{"stamps": []interface{}{when.UnixNano() / 1e6}}, &mapped); err != nil {
+		t.Fatal(err)
+	}
+	if len(mapped.Stamps) != 1 || !mapped.Stamps[0].Equal(when) {
+		t.Errorf("Unexpected stamps %v", mapped.Stamps)
+	}
+}
+
+// TestCmd checks Cmd puts the command name first, followed by the arguments
+// in key order, and that Run accepts a command name alone
+func TestCmd(t *testing.T) {
+	cmd := Cmd("count", bson.M{"query": bson.M{"n": 2}, "count": "users", "limit": 5})
+	want := bson.D{
+		{Name: "count", Value: "users"},
+		{Name: "limit", Value: 5},
+		{Name: "query", Value: bson.M{"n": 2}},
+	}
+	if !reflect.DeepEqual(cmd, want) {
+		t.Errorf("Expected %v, got %v", want, cmd)
+	}
+	if cmd := Cmd("ping", nil); !reflect.DeepEqual(cmd, bson.D{{Name: "ping", Value: 1}}) {
+		t.Errorf("Expected the name with 1 for a command without arguments, got %v", cmd)
+	}
+
+	doc := commandDocument(cmd)
+	if d, ok := doc.(officialBson.D); !ok || len(d) != 3 || d[0].Key != "count" {
+		t.Errorf("Expected an ordered driver document, got %#v", doc)
+	}
+	if doc := commandDocument("ping"); !reflect.DeepEqual(doc, officialBson.D{{Key: "ping", Value: 1}}) {
+		t.Errorf("Expected {ping: 1}, got %#v", doc)
+	}
+}